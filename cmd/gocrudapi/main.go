@@ -1,12 +1,64 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+
 	"github.com/dranih/go-crud-api/pkg/apiserver"
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 func main() {
 	config := apiserver.ReadConfig()
 	config.Init()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reencrypt":
+			if err := apiserver.Reencrypt(config); err != nil {
+				utils.Log.Fatal(os.Args[1]+" failed", "error", err)
+			}
+		case "validate":
+			if errs := apiserver.ValidateConfig(config); len(errs) > 0 {
+				for _, err := range errs {
+					fmt.Fprintf(os.Stderr, "Error : %s\n", err.Error())
+				}
+				os.Exit(1)
+			}
+			fmt.Println("Configuration is valid")
+		case "config":
+			if err := apiserver.WriteConfig(os.Stdout, config); err != nil {
+				utils.Log.Fatal(os.Args[1]+" failed", "error", err)
+			}
+		case "openapi":
+			flags := flag.NewFlagSet("openapi", flag.ExitOnError)
+			format := flags.String("format", "json", "Format of the document : json or yaml")
+			server := flags.String("server", "", "Url of the server in the document")
+			parseExportFlags(flags, config)
+			if err := apiserver.ExportOpenApi(os.Stdout, config, *format, *server); err != nil {
+				utils.Log.Fatal(os.Args[1]+" failed", "error", err)
+			}
+		case "schema":
+			flags := flag.NewFlagSet("schema", flag.ExitOnError)
+			parseExportFlags(flags, config)
+			if err := apiserver.ExportSchema(os.Stdout, config); err != nil {
+				utils.Log.Fatal(os.Args[1]+" failed", "error", err)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command '%s', usage : %s [reencrypt|validate|config|openapi|schema]\n", os.Args[1], os.Args[0])
+			os.Exit(2)
+		}
+		return
+	}
 	api := apiserver.NewApi(config)
 	api.Handle(nil)
 }
+
+// parseExportFlags parses the command arguments, the tables and controllers overriding the configuration ones
+func parseExportFlags(flags *flag.FlagSet, config *apiserver.Config) {
+	tables := flags.String("tables", config.Api.Tables, "Comma separated list of the tables to export, all if empty")
+	controllers := flags.String("controllers", config.Api.Controllers, "Comma separated list of the controllers to document")
+	flags.Parse(os.Args[2:])
+	config.Api.Tables = *tables
+	config.Api.Controllers = *controllers
+}
@@ -0,0 +1,101 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+func TestAdminApi(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	// the tests sharing the https server create their database only when no config file is set
+	defer os.Unsetenv("GCA_CONFIG_FILE")
+	config := ReadConfig()
+	config.Init()
+	config.Api.Address = db_path
+	config.Api.Controllers = "records,admin"
+	config.Api.AdminUsers = "basicAuth:user1,jwtAuth:username1"
+	delete(config.Api.Middlewares, "sslRedirect")
+	api := NewApi(config)
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "admin_authentication_required",
+			Method:     http.MethodGet,
+			Uri:        "/admin",
+			Want:       `{"code":1011,"message":"Authentication required"}`,
+			StatusCode: http.StatusUnauthorized,
+		},
+		// username1 is an admin when authenticated by jwtAuth only
+		{
+			Name:       "admin_forbidden",
+			Method:     http.MethodGet,
+			Uri:        "/admin",
+			AuthMethod: "basicauth",
+			Username:   "username1",
+			Password:   "password1",
+			Want:       `{"code":1014,"message":"Operation forbidden"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "admin_read_table",
+			Method:     http.MethodGet,
+			Uri:        "/records/categories/1",
+			Want:       `{"icon":null,"id":1,"name":"announcement"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "admin_info",
+			Method:     http.MethodGet,
+			Uri:        "/admin",
+			AuthMethod: "basicauth",
+			Username:   "user1",
+			Password:   "MyPwd01",
+			WantRegex: `^{"cache":{"keys":\[.*-ReflectedTable\(categories\)".*\],"type":"TempFile"},` +
+				`"config":{"api":{.*"middlewares":{.*"apiKeyAuth":{"keys":"<redacted>","mode":"optional"}.*},` +
+				`"middlewares":\["cors","xml","json","apiKeyAuth","apiKeyDbAuth","dbAuth","jwtAuth","basicAuth","authorization",` +
+				`"sanitation","validation","ipAddress","multiTenancy","pageLimits","joinLimits","customization","saveSession"\],` +
+				`"reflection":{"cacheTtl":10,"loadedAt":"[^"]+","loadedTables":\[.*"categories".*\],"tables":\[.*"categories".*\]}}$`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "admin_purge_table",
+			Method:     http.MethodPost,
+			Uri:        "/admin/cache/purge/categories",
+			AuthMethod: "basicauth",
+			Username:   "user1",
+			Password:   "MyPwd01",
+			Want:       `true`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "admin_purge_unknown_table",
+			Method:     http.MethodPost,
+			Uri:        "/admin/cache/purge/unknowns",
+			AuthMethod: "basicauth",
+			Username:   "user1",
+			Password:   "MyPwd01",
+			Want:       `{"code":1001,"message":"Table 'unknowns' not found"}`,
+			StatusCode: http.StatusNotFound,
+		},
+		{
+			Name:       "admin_reload_reflection",
+			Method:     http.MethodPost,
+			Uri:        "/admin/reflection/reload",
+			AuthMethod: "basicauth",
+			Username:   "user1",
+			Password:   "MyPwd01",
+			WantRegex:  `^{"cacheTtl":10,"loadedAt":"[^"]+","loadedTables":\[\],"tables":\[.*"categories".*\]}$`,
+			StatusCode: http.StatusOK,
+		},
+	})
+	api.getHandler().db.PDO().CloseConn()
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
@@ -3,17 +3,19 @@ package apiserver
 import (
 	"context"
 	"crypto/tls"
-	"encoding/gob"
 	"fmt"
-	"log"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/dranih/go-crud-api/pkg/cache"
 	"github.com/dranih/go-crud-api/pkg/controller"
@@ -26,13 +28,15 @@ import (
 )
 
 type Api struct {
-	router *mux.Router
-	config *Config
+	handler     atomic.Value
+	config      *Config
+	reloads     *controller.ReloadStatus
+	reloadMutex sync.Mutex
+	tracing     bool
 }
 
-//todo : cache
-func NewApi(globalConfig *Config) *Api {
-	config := globalConfig.Api
+// newGenericDB returns the database of the api configuration
+func newGenericDB(config *ApiConfig) (*database.GenericDB, error) {
 	db := database.NewGenericDB(
 		config.Driver,
 		config.Address,
@@ -42,105 +46,208 @@ func NewApi(globalConfig *Config) *Api {
 		config.Mapping,
 		config.Username,
 		config.Password)
+	db.SetTransactionOptions(config.TransactionIsolation, config.TransactionRetries, config.TransactionBackoff)
+	db.SetNumberFormats(config.NumberFormats)
+	db.SetKeyGenerators(config.KeyGenerators)
+	db.SetAutoColumns(config.AutoColumns)
+	db.SetSlowQueryThreshold(config.SlowQueryThreshold)
+	encryptor, err := config.Encryption.newColumnEncryptor()
+	if err != nil {
+		// sensitive values must never be written in clear
+		db.PDO().CloseConn()
+		return nil, fmt.Errorf("invalid encryption configuration : %s", err.Error())
+	}
+	db.SetEncryptor(encryptor)
+	return db, nil
+}
+
+// hideInternalTables hides the tables used by the server : the sessions of the database session store
+// and the refresh tokens of the dbAuth token mode
+func hideInternalTables(db *database.GenericDB, globalConfig *Config) {
+	if strings.ToLower(globalConfig.Server.Session.Store) == "database" {
+		db.HideTable(globalConfig.Server.Session.Table)
+	}
+	if properties, exists := globalConfig.Api.Middlewares["dbAuth"]; exists && fmt.Sprint(properties["loginMode"]) == "token" {
+		tableName := "refresh_tokens"
+		if value, exists := properties["refreshTokensTable"]; exists && fmt.Sprint(value) != "" {
+			tableName = fmt.Sprint(value)
+		}
+		db.HideTable(tableName)
+	}
+}
+
+//todo : cache
+func NewApi(globalConfig *Config) *Api {
+	a := &Api{config: globalConfig, reloads: controller.NewReloadStatus()}
+	// the session store and the tracer are set from the server block, which is not reloaded
+	if err := initSessionStore(globalConfig.Server.Session, globalConfig.Api); err != nil {
+		utils.Log.Fatal("unable to start the api", "error", err)
+	}
+	a.tracing = initTracing(globalConfig.Server.Tracing)
+	handler, err := a.newApiHandler(globalConfig)
+	if err != nil {
+		utils.Log.Fatal("unable to start the api", "error", err)
+	}
+	a.handler.Store(handler)
+	return a
+}
+
+// newApiHandler builds the router with the middlewares and the controllers of the configuration
+func (a *Api) newApiHandler(globalConfig *Config) (*apiHandler, error) {
+	config := globalConfig.Api
+	db, err := newGenericDB(config)
+	if err != nil {
+		return nil, err
+	}
+	hideInternalTables(db, globalConfig)
 	prefix := fmt.Sprintf("gocrudapi-%d-", os.Getpid())
 	cache := cache.Create(config.CacheType, prefix, config.CachePath)
+	// closers release the resources of the handler once its requests are done
+	closers := []func(){func() { cache.Close() }}
 	reflection := database.NewReflectionService(db, cache, config.CacheTime)
 	responder := controller.NewJsonResponder(config.Debug)
 	router := mux.NewRouter()
+	var status *controller.StatusController
+	chain := []string{}
+	// use adds a traced middleware, the chain being returned by the admin controller
+	use := func(name string, process func(http.Handler) http.Handler) {
+		chain = append(chain, name)
+		router.Use(middleware.TraceMiddleware(name, process))
+	}
 	//Consistent middle order :
-	//sslRedirect,cors,firewall,xsrf,ajaxOnly,xml,json,reconnect,apiKeyAuth,apiKeyDbAuth,dbAuth,jwtAuth,basicAuth,authorization,sanitation,validation,ipAddress,multiTenancy,pageLimits,joinLimits,customization
+	//tracing,metrics,accessLog,sslRedirect,cors,firewall,rateLimit,xsrf,ajaxOnly,xml,json,reconnect,clientCertAuth,apiKeyAuth,apiKeyDbAuth,dbAuth,jwtAuth,basicAuth,authorization,rbac,masking,sanitation,validation,ipAddress,autoColumns,multiTenancy,pageLimits,joinLimits,customization
+	if a.tracing {
+		tracingMiddle := middleware.NewTracingMiddleware(responder, nil)
+		router.Use(tracingMiddle.Process)
+		chain = append(chain, "tracing")
+	}
+	if config.GetControllers()["status"] {
+		metricsMiddle := middleware.NewMetricsMiddleware(responder, nil, reflection, config.GetControllers())
+		use("metrics", metricsMiddle.Process)
+	}
+	if properties, exists := config.Middlewares["accessLog"]; exists {
+		accessLogMiddle := middleware.NewAccessLogMiddleware(responder, properties)
+		use("accessLog", accessLogMiddle.Process)
+	}
 	if properties, exists := config.Middlewares["sslRedirect"]; exists {
 		sslMiddle := middleware.NewSslRedirectMiddleware(responder, properties, globalConfig.Server.HttpsPort)
-		router.Use(sslMiddle.Process)
+		use("sslRedirect", sslMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["cors"]; exists {
 		router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}).Methods("OPTIONS")
 		corsMiddleware := middleware.NewCorsMiddleware(responder, properties, config.Debug)
-		router.Use(corsMiddleware.Process)
+		use("cors", corsMiddleware.Process)
 	}
 	if properties, exists := config.Middlewares["firewall"]; exists {
 		fwMiddleware := middleware.NewFirewallMiddleware(responder, properties)
-		router.Use(fwMiddleware.Process)
+		use("firewall", fwMiddleware.Process)
+	}
+	var rateLimitMiddle *middleware.RateLimitMiddleware
+	if properties, exists := config.Middlewares["rateLimit"]; exists {
+		rateLimitMiddle = middleware.NewRateLimitMiddleware(responder, properties, cache)
+		closers = append(closers, rateLimitMiddle.Close)
+		if !rateLimitMiddle.KeyedByUser() {
+			use("rateLimit", rateLimitMiddle.Process)
+		}
 	}
 	if properties, exists := config.Middlewares["xsrf"]; exists {
 		xMiddleware := middleware.NewXsrfMiddleware(responder, properties)
-		router.Use(xMiddleware.Process)
+		use("xsrf", xMiddleware.Process)
 	}
 	if properties, exists := config.Middlewares["ajaxOnly"]; exists {
 		aoMiddleware := middleware.NewAjaxOnlyMiddleware(responder, properties)
-		router.Use(aoMiddleware.Process)
+		use("ajaxOnly", aoMiddleware.Process)
 	}
 	if properties, exists := config.Middlewares["xml"]; exists {
 		xmlMiddle := middleware.NewXmlMiddleware(responder, properties)
-		router.Use(xmlMiddle.Process)
+		use("xml", xmlMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["json"]; exists {
 		jsonMiddle := middleware.NewJsonMiddleware(responder, properties)
-		router.Use(jsonMiddle.Process)
+		use("json", jsonMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["reconnect"]; exists {
 		reconnectMiddle := middleware.NewReconnectMiddleware(responder, properties, reflection, db)
-		router.Use(reconnectMiddle.Process)
+		use("reconnect", reconnectMiddle.Process)
+	}
+	if properties, exists := config.Middlewares["clientCertAuth"]; exists {
+		ccamMiddle := middleware.NewClientCertAuth(responder, properties)
+		use("clientCertAuth", ccamMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["apiKeyAuth"]; exists {
 		akamMiddle := middleware.NewApiKeyAuth(responder, properties)
-		router.Use(akamMiddle.Process)
+		use("apiKeyAuth", akamMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["apiKeyDbAuth"]; exists {
 		akdamMiddle := middleware.NewApiKeyDbAuth(responder, properties, reflection, db)
-		router.Use(akdamMiddle.Process)
+		use("apiKeyDbAuth", akdamMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["dbAuth"]; exists {
-		damMiddle := middleware.NewDbAuth(responder, properties, reflection, db)
-		router.Use(damMiddle.Process)
+		damMiddle := middleware.NewDbAuth(responder, properties, reflection, db, cache)
+		use("dbAuth", damMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["jwtAuth"]; exists {
-		gob.Register(map[string]interface{}{})
 		jaMiddle := middleware.NewJwtAuth(responder, properties)
-		router.Use(jaMiddle.Process)
+		closers = append(closers, jaMiddle.Close)
+		use("jwtAuth", jaMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["basicAuth"]; exists {
 		bamMiddle := middleware.NewBasicAuth(responder, properties)
-		router.Use(bamMiddle.Process)
+		use("basicAuth", bamMiddle.Process)
+	}
+	// the users are known once authenticated
+	if rateLimitMiddle != nil && rateLimitMiddle.KeyedByUser() {
+		use("rateLimit", rateLimitMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["authorization"]; exists {
 		authMiddle := middleware.NewAuthorizationMiddleware(responder, properties, reflection)
-		router.Use(authMiddle.Process)
+		use("authorization", authMiddle.Process)
+	}
+	if properties, exists := config.Middlewares["rbac"]; exists {
+		rbacMiddle := middleware.NewRbacMiddleware(responder, properties, reflection)
+		use("rbac", rbacMiddle.Process)
+	}
+	if properties, exists := config.Middlewares["masking"]; exists {
+		maskingMiddle := middleware.NewMaskingMiddleware(responder, properties, reflection)
+		use("masking", maskingMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["sanitation"]; exists {
 		sanitationMiddle := middleware.NewSanitationMiddleware(responder, properties, reflection)
-		router.Use(sanitationMiddle.Process)
+		use("sanitation", sanitationMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["validation"]; exists {
 		validationMiddle := middleware.NewValidationMiddleware(responder, properties, reflection)
-		router.Use(validationMiddle.Process)
+		use("validation", validationMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["ipAddress"]; exists {
 		ipAddressMiddle := middleware.NewIpAddressMiddleware(responder, properties, reflection)
-		router.Use(ipAddressMiddle.Process)
+		use("ipAddress", ipAddressMiddle.Process)
+	}
+	if len(config.AutoColumns) > 0 {
+		autoColumnsMiddle := middleware.NewAutoColumnsMiddleware(responder, nil, reflection)
+		use("autoColumns", autoColumnsMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["multiTenancy"]; exists {
 		multiTenancyMiddle := middleware.NewMultiTenancyMiddleware(responder, properties, reflection)
-		router.Use(multiTenancyMiddle.Process)
+		use("multiTenancy", multiTenancyMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["pageLimits"]; exists {
 		pageLimitsMiddle := middleware.NewPageLimitsMiddleware(responder, properties, reflection)
-		router.Use(pageLimitsMiddle.Process)
+		use("pageLimits", pageLimitsMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["joinLimits"]; exists {
 		joinLimitsMiddle := middleware.NewJoinLimitsMiddleware(responder, properties, reflection)
-		router.Use(joinLimitsMiddle.Process)
+		use("joinLimits", joinLimitsMiddle.Process)
 	}
 	if properties, exists := config.Middlewares["customization"]; exists {
-		gob.Register(map[string]interface{}{})
 		customizationMiddle := middleware.NewCustomizationMiddleware(responder, properties, reflection)
-		router.Use(customizationMiddle.Process)
+		use("customization", customizationMiddle.Process)
 	}
 
 	//Save session after all middlewares
 	//Session should not be altered by the controllers
 	saveSessionMiddle := middleware.NewSaveSession(responder, nil)
-	router.Use(saveSessionMiddle.Process)
+	use("saveSession", saveSessionMiddle.Process)
 
 	for ctrl := range config.GetControllers() {
 		switch ctrl {
@@ -159,8 +266,23 @@ func NewApi(globalConfig *Config) *Api {
 			records := record.NewRecordService(db, reflection)
 			geoJson := geojson.NewGeoJsonService(reflection, records)
 			controller.NewGeoJsonController(router, responder, geoJson)
+		case "admin":
+			adminConfig, err := redactedConfigMap(globalConfig)
+			if err != nil {
+				db.PDO().CloseConn()
+				for _, close := range closers {
+					close()
+				}
+				return nil, err
+			}
+			controller.NewAdminController(router, responder, reflection, cache, config.GetAdminUsers(), config.AdminUserColumn, adminConfig, chain, config.CacheType)
 		case "status":
-			controller.NewStatusController(router, responder, cache, db)
+			status = controller.NewStatusController(router, responder, cache, db)
+			status.SetReloadStatus(a.reloads)
+			status.SetCheckTimeout(time.Duration(config.HealthTimeout) * time.Millisecond)
+			status.AddCheck("reflection", func(ctx context.Context) error {
+				return reflection.Check()
+			})
 		}
 	}
 
@@ -168,106 +290,88 @@ func NewApi(globalConfig *Config) *Api {
 		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
 	}).Methods("OPTIONS", "GET", "PUT", "POST", "DELETE", "PATCH")
 
-	return &Api{router, globalConfig}
+	return &apiHandler{router: router, db: db, status: status, closers: closers}, nil
 }
 
 func (a *Api) Handle(wg *sync.WaitGroup) {
 	config := a.config.Server
 	//From https://golangexample.com/a-powerful-http-router-and-url-matcher-for-building-go-web-servers/
-	var srvHttp, srvHttps *http.Server
-	if config.Http {
-		srvHttp = &http.Server{
-			Addr: fmt.Sprintf("%s:%d", config.Address, config.HttpPort),
-			// Good practice to set timeouts to avoid Slowloris attacks.
-			WriteTimeout: time.Second * time.Duration(config.WriteTimeout),
-			ReadTimeout:  time.Second * time.Duration(config.ReadTimeout),
-			IdleTimeout:  time.Second * time.Duration(config.IdleTimeout),
-			Handler:      a.router, // Pass our instance of gorilla/mux in.
-		}
-		if wg != nil {
-			wg.Add(1)
-		}
-		// Run our server in a goroutine so that it doesn't block.
-		go func() {
-			addr := srvHttp.Addr
-			if addr == "" {
-				addr = ":http"
-			}
-			ln, err := net.Listen("tcp", addr)
-			if err != nil {
-				log.Fatal(err)
-			}
-			log.Printf("Started http server at %s", addr)
-			if wg != nil {
-				wg.Done()
-			}
-			if err := srvHttp.Serve(ln); err != nil {
-				log.Fatal(err)
-			}
-		}()
+	listeners, err := listen(config)
+	if err != nil {
+		utils.Log.Fatal("unable to start the server", "error", err)
 	}
-
-	if config.Https {
-		var serverTLSConf *tls.Config
-		if config.HttpsCertFile == "" || config.HttpsKeyFile == "" {
-			var err error
-			serverTLSConf, _, err = utils.CertSetup(config.Address)
-
-			if err != nil {
-				log.Fatal(err)
+	var srvHttp, srvHttps *http.Server
+	for _, listener := range listeners {
+		var srv *http.Server
+		if listener.tls {
+			if srvHttps == nil {
+				serverTLSConf, err := newServerTLSConfig(config)
+				if err != nil {
+					utils.Log.Fatal("unable to start the server", "error", err)
+				}
+				srvHttps = a.newServer(config, a)
+				srvHttps.TLSConfig = serverTLSConf
 			}
+			srv = srvHttps
 		} else {
-			serverCert, err := tls.LoadX509KeyPair(config.HttpsCertFile, config.HttpsKeyFile)
-			if err != nil {
-				log.Fatal(err)
-			}
-			serverTLSConf = &tls.Config{
-				Certificates: []tls.Certificate{serverCert},
+			if srvHttp == nil {
+				var handler http.Handler = a
+				// Cleartext HTTP/2 for the clients knowing the server supports it, as there is no tls negotiation
+				if config.H2c {
+					handler = h2c.NewHandler(a, &http2.Server{IdleTimeout: time.Second * time.Duration(config.IdleTimeout)})
+				}
+				srvHttp = a.newServer(config, handler)
 			}
-		}
-
-		srvHttps = &http.Server{
-			Addr: fmt.Sprintf("%s:%d", config.Address, config.HttpsPort),
-			// Good practice to set timeouts to avoid Slowloris attacks.
-			WriteTimeout: time.Second * time.Duration(config.WriteTimeout),
-			ReadTimeout:  time.Second * time.Duration(config.ReadTimeout),
-			IdleTimeout:  time.Second * time.Duration(config.IdleTimeout),
-			TLSConfig:    serverTLSConf,
-			Handler:      a.router, // Pass our instance of gorilla/mux in.
-		}
-		if wg != nil {
-			wg.Add(1)
+			srv = srvHttp
 		}
 		// Run our server in a goroutine so that it doesn't block.
-		go func() {
-			addr := srvHttps.Addr
-			if addr == "" {
-				addr = ":https"
-			}
-			ln, err := net.Listen("tcp", addr)
-			if err != nil {
-				log.Fatal(err)
-			}
-			log.Printf("Started https server at %s", addr)
-			if wg != nil {
-				wg.Done()
+		go func(srv *http.Server, listener serverListener) {
+			utils.Log.Info("started", "listener", listener.name)
+			var err error
+			if listener.tls {
+				err = srv.ServeTLS(listener.listener, "", "")
+			} else {
+				err = srv.Serve(listener.listener)
 			}
-			if err := srvHttps.ServeTLS(ln, "", ""); err != nil {
-				log.Fatal(err)
+			if err != nil && err != http.ErrServerClosed {
+				utils.Log.Fatal("server failed", "listener", listener.name, "error", err)
 			}
-		}()
+		}(srv, listener)
 	}
 
 	if wg != nil {
 		wg.Done()
 	}
 	c := make(chan os.Signal, 1)
-	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
-	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
-	signal.Notify(c, os.Interrupt)
+	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C) or SIGTERM (systemd, docker, kubernetes)
+	// SIGKILL or SIGQUIT (Ctrl+/) will not be caught.
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	// The configuration is reloaded on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	if config.WatchConfig {
+		a.watchConfig()
+	}
 
 	// Block until we receive our signal.
-	<-c
+wait:
+	for {
+		select {
+		case <-hup:
+			a.Reload()
+		case <-c:
+			break wait
+		}
+	}
+
+	// The readiness fails during the shutdown delay, for the load balancers to stop sending requests
+	if status := a.getHandler().status; status != nil {
+		status.SetShuttingDown()
+	}
+	if config.ShutdownDelay > 0 {
+		utils.Log.Info("shutting down", "delaySeconds", config.ShutdownDelay)
+		time.Sleep(time.Second * time.Duration(config.ShutdownDelay))
+	}
 
 	// Create a deadline to wait for.
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(config.GracefulTimeout))
@@ -287,6 +391,64 @@ func (a *Api) Handle(wg *sync.WaitGroup) {
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
 	// <-ctx.Done() if your application should wait for other services
 	// to finalize based on context cancellation.
-	log.Println("shutting down")
+	utils.ShutdownTracer()
+	utils.Log.Info("shut down")
 	os.Exit(0)
 }
+
+// newServer returns a server of the handler, the listeners being served by the same server for http and for https
+func (a *Api) newServer(config *ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		// Good practice to set timeouts to avoid Slowloris attacks.
+		WriteTimeout: time.Second * time.Duration(config.WriteTimeout),
+		ReadTimeout:  time.Second * time.Duration(config.ReadTimeout),
+		IdleTimeout:  time.Second * time.Duration(config.IdleTimeout),
+		Handler:      handler, // Pass our instance of gorilla/mux in, swapped on reload.
+	}
+}
+
+// newServerTLSConfig returns the tls configuration of the https server, its certificate being reloaded when the files change.
+// A self-signed certificate is generated if no certificate is set.
+func newServerTLSConfig(config *ServerConfig) (*tls.Config, error) {
+	minVersion, exists := tlsVersions[config.TlsMinVersion]
+	if !exists {
+		return nil, fmt.Errorf("invalid tls min version '%s'", config.TlsMinVersion)
+	}
+	cipherSuites, err := tlsCipherSuites(config.TlsCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	serverTLSConf := &tls.Config{MinVersion: minVersion, CipherSuites: cipherSuites}
+	certFile, keyFile := config.HttpsCertFile, config.HttpsKeyFile
+	if certFile == "" || keyFile == "" {
+		if config.SelfSignedCertDir == "" {
+			selfSignedTLSConf, _, err := utils.CertSetup(config.Address)
+			if err != nil {
+				return nil, err
+			}
+			serverTLSConf.Certificates = selfSignedTLSConf.Certificates
+		} else if certFile, keyFile, err = selfSignedCertFiles(config.SelfSignedCertDir, config.Address); err != nil {
+			return nil, err
+		}
+	}
+	if len(serverTLSConf.Certificates) == 0 {
+		reloader, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := reloader.watch(); err != nil {
+			utils.Log.Warning("the tls certificate will not be reloaded", "error", err)
+		}
+		serverTLSConf.GetCertificate = reloader.GetCertificate
+	}
+	//The client certificates are verified if given, the clientCertAuth middleware requiring them or not
+	if config.ClientCaFile != "" {
+		clientCAs, err := utils.LoadCertPool(config.ClientCaFile)
+		if err != nil {
+			return nil, err
+		}
+		serverTLSConf.ClientCAs = clientCAs
+		serverTLSConf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return serverTLSConf, nil
+}
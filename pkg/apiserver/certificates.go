@@ -0,0 +1,153 @@
+package apiserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/fsnotify/fsnotify"
+)
+
+// certReloader serves the certificate of the https server, loaded again when its files change
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload loads the certificate and its key, the current certificate being kept on error
+// (ex : when the certificate is written before its key)
+func (cr *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if cr.cert != nil && bytes.Equal(cr.cert.Certificate[0], cert.Certificate[0]) {
+		return nil
+	}
+	if cr.cert != nil {
+		utils.Log.Info("tls certificate reloaded", "file", cr.certFile)
+	}
+	cr.cert = &cert
+	return nil
+}
+
+// GetCertificate returns the current certificate, for the tls configuration of the server
+func (cr *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.cert, nil
+}
+
+// watch reloads the certificate when its files change. The directories are watched as the files are usually replaced,
+// ex : the kubernetes secrets mounted as volumes are updated by swapping a symbolic link
+func (cr *certReloader) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := map[string]bool{filepath.Dir(cr.certFile): true, filepath.Dir(cr.keyFile): true}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(500*time.Millisecond, func() {
+					if err := cr.reload(); err != nil {
+						utils.Log.Error("unable to reload the tls certificate, keeping the current one", "error", err)
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				utils.Log.Warning("tls certificate watch error", "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// selfSignedCertFiles returns the files of the self-signed certificate kept in dir, generated if they do not exist
+// so that the certificate stays the same between restarts
+func selfSignedCertFiles(dir, address string) (string, string, error) {
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return certFile, keyFile, nil
+	}
+	certPEM, keyPEM, _, err := utils.GenerateSelfSignedCert(address)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return "", "", err
+	}
+	utils.Log.Info("self-signed certificate written", "file", certFile)
+	return certFile, keyFile, nil
+}
+
+// tlsVersions are the values of server.tlsMinVersion
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCipherSuites returns the ids of the comma separated cipher suite names, the insecure ones being refused.
+// The cipher suites of TLS 1.3 are not configurable.
+func tlsCipherSuites(names string) ([]uint16, error) {
+	if names == "" {
+		return nil, nil
+	}
+	suites := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	ids := []uint16{}
+	for _, name := range strings.Split(names, ",") {
+		id, exists := suites[strings.TrimSpace(name)]
+		if !exists {
+			return nil, fmt.Errorf("unknown or insecure tls cipher suite '%s'", strings.TrimSpace(name))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,64 @@
+package apiserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+func TestServerTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := selfSignedCertFiles(dir, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstPEM, _ := os.ReadFile(certFile)
+	// the self-signed certificate is kept between restarts
+	if _, _, err := selfSignedCertFiles(dir, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if secondPEM, _ := os.ReadFile(certFile); !bytes.Equal(firstPEM, secondPEM) {
+		t.Errorf("Want the persisted self-signed certificate to be reused")
+	}
+
+	config := &ServerConfig{HttpsCertFile: certFile, HttpsKeyFile: keyFile, TlsMinVersion: "1.3",
+		TlsCipherSuites: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}
+	serverTLSConf, err := newServerTLSConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverTLSConf.MinVersion != tls.VersionTLS13 || len(serverTLSConf.CipherSuites) != 2 || serverTLSConf.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Want tls 1.3 min version and 2 cipher suites, got %d %v", serverTLSConf.MinVersion, serverTLSConf.CipherSuites)
+	}
+	first, _ := serverTLSConf.GetCertificate(nil)
+
+	// the certificate is reloaded when rotated
+	certPEM, keyPEM, _, err := utils.GenerateSelfSignedCert("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(keyFile, keyPEM, 0600)
+	os.WriteFile(certFile, certPEM, 0644)
+	reloaded := false
+	for i := 0; i < 50 && !reloaded; i++ {
+		time.Sleep(100 * time.Millisecond)
+		current, _ := serverTLSConf.GetCertificate(nil)
+		reloaded = !bytes.Equal(current.Certificate[0], first.Certificate[0])
+	}
+	if !reloaded {
+		t.Errorf("Want the certificate to be reloaded after its rotation")
+	}
+
+	for _, invalid := range []*ServerConfig{
+		{HttpsCertFile: certFile, HttpsKeyFile: keyFile, TlsMinVersion: "1.4"},
+		{HttpsCertFile: certFile, HttpsKeyFile: keyFile, TlsMinVersion: "1.2", TlsCipherSuites: "TLS_RSA_WITH_RC4_128_SHA"},
+	} {
+		if _, err := newServerTLSConfig(invalid); err == nil {
+			t.Errorf("Want error for tls min version '%s' and cipher suites '%s'", invalid.TlsMinVersion, invalid.TlsCipherSuites)
+		}
+	}
+}
@@ -598,8 +598,8 @@ func TestColumnsApi(t *testing.T) {
 			Method:     http.MethodPut,
 			Uri:        "/records/types/1",
 			Body:       `{"decimal":1.2300}`,
-			WantJson:   `{"code":1013,"message":"Input validation failed for 'types'","details":{"decimal":"invalid decimal"}}`,
-			StatusCode: http.StatusUnprocessableEntity,
+			Want:       `1`,
+			StatusCode: http.StatusOK,
 		},
 		{
 			Name:       "015_update_types_table_Q",
@@ -1099,6 +1099,54 @@ func TestColumnsApi(t *testing.T) {
 			Want:       `<root><columns><name>bin</name><type>blob</type></columns><columns><length>255</length><name>hex</name><type>varchar</type></columns><columns><name>id</name><pk>true</pk><type>integer</type></columns><columns><length>15</length><name>ip_address</name><nullable>true</nullable><type>varchar</type></columns><columns><fk>products</fk><name>product_id</name><type>integer</type></columns><name>barcodes</name><type>table</type></root>`,
 			StatusCode: http.StatusOK,
 		},
+		{
+			Name:       "018_create_generated_keys_table_A",
+			Method:     http.MethodPost,
+			Uri:        "/columns",
+			Body:       `{"name":"generated_keys","type":"table","columns":[{"name":"id","type":"varchar","length":36,"pk":true,"generator":"uuidv7"},{"name":"name","type":"varchar","length":255}]}`,
+			Want:       `true`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "018_create_generated_keys_table_B",
+			Method:     http.MethodPost,
+			Uri:        "/records/generated_keys",
+			Body:       `{"name":"generated"}`,
+			WantRegex:  `^"[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}"$`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "018_create_generated_keys_table_C",
+			Method:     http.MethodPost,
+			Uri:        "/records/generated_keys",
+			Body:       `{"id":"0b5c7ad5-6a9c-4e47-9e5a-2a1f0c3d4e5f","name":"provided"}`,
+			Want:       `"0b5c7ad5-6a9c-4e47-9e5a-2a1f0c3d4e5f"`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "018_create_generated_keys_table_D",
+			Method:     http.MethodGet,
+			Uri:        "/records/generated_keys/0b5c7ad5-6a9c-4e47-9e5a-2a1f0c3d4e5f",
+			Body:       ``,
+			WantRegex:  `(?i)^{"id":"0b5c7ad5-6a9c-4e47-9e5a-2a1f0c3d4e5f","name":"provided"}$`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "018_create_generated_keys_table_E",
+			Method:     http.MethodDelete,
+			Uri:        "/columns/generated_keys",
+			Body:       ``,
+			Want:       `true`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "018_create_generated_keys_table_F",
+			Method:     http.MethodPost,
+			Uri:        "/columns",
+			Body:       `{"name":"unconfigured_keys","type":"table","columns":[{"name":"id","type":"varchar","length":26,"pk":true,"generator":"ulid"}]}`,
+			Want:       `false`,
+			StatusCode: http.StatusOK,
+		},
 	}
 	utils.RunTests(t, serverUrlHttps, tt)
 	if db_path != "" {
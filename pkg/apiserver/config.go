@@ -6,11 +6,14 @@ import (
 	"strings"
 
 	"github.com/spf13/viper"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type Config struct {
-	Api    *ApiConfig
-	Server *ServerConfig
+	Api     *ApiConfig
+	Server  *ServerConfig
+	readErr error
 }
 
 type ApiConfig struct {
@@ -32,23 +35,98 @@ type ApiConfig struct {
 	Debug                 bool
 	BasePath              string
 	OpenApiBase           map[string]interface{}
+	TransactionIsolation  string
+	TransactionRetries    int
+	TransactionBackoff    int
+	NumberFormats         map[string]string
+	KeyGenerators         map[string]string
+	AutoColumns           map[string]string
+	Encryption            EncryptionConfig
+	SlowQueryThreshold    int
+	HealthTimeout         int
+	AdminUsers            string
+	AdminUserColumn       string
+}
+
+// EncryptionConfig lists the encrypted columns as "table.column" => blind index column ("" for none) and
+// the keys by key id, given as "env:VARIABLE", "file:/path/to/key" or as is, base64 encoded
+type EncryptionConfig struct {
+	Keys       map[string]string
+	CurrentKey string
+	IndexKey   string
+	Columns    map[string]string
 }
 
 type ServerConfig struct {
-	Address         string
-	Http            bool
-	HttpPort        int
-	Https           bool
-	HttpsPort       int
-	HttpsCertFile   string
-	HttpsKeyFile    string
-	GracefulTimeout int
-	WriteTimeout    int
-	ReadTimeout     int
-	IdleTimeout     int
+	Address           string
+	Http              bool
+	HttpPort          int
+	Https             bool
+	HttpsPort         int
+	HttpsCertFile     string
+	HttpsKeyFile      string
+	SelfSignedCertDir string
+	TlsMinVersion     string
+	TlsCipherSuites   string
+	UnixSocket        string
+	UnixSocketMode    string
+	H2c               bool
+	ClientCaFile      string
+	GracefulTimeout   int
+	ShutdownDelay     int
+	WatchConfig       bool
+	WriteTimeout      int
+	ReadTimeout       int
+	IdleTimeout       int
+	Session           SessionConfig
+	Tracing           TracingConfig
+	Log               LogConfig
+}
+
+// LogConfig sets the format of the logs : "text", "json" or "logfmt", and the minimum level
+type LogConfig struct {
+	Format string
+	Level  string
+}
+
+// TracingConfig sets the exporter of the spans : "stdout", "otlp" (to the collector at Endpoint) or "none"
+type TracingConfig struct {
+	Exporter    string
+	Endpoint    string
+	ServiceName string
+	SampleRatio float64
+}
+
+type SessionConfig struct {
+	Name     string
+	Store    string
+	Keys     []SessionKeyConfig
+	Domain   string
+	Path     string
+	SameSite string
+	Secure   bool
+	HttpOnly bool
+	MaxAge   int
+	Table    string
+}
+
+// SessionKeyConfig is a pair of keys given as is, or as "env:VARIABLE" or "file:/path/to/key"
+type SessionKeyConfig struct {
+	HashKey  string
+	BlockKey string
 }
 
 func ReadConfig(configPaths ...string) *Config {
+	config, err := readConfig(configPaths...)
+	if err != nil {
+		utils.Log.Error("unable to read the config file", "error", err)
+		config.readErr = err
+	}
+	return config
+}
+
+// readConfig returns the configuration and the error reading the configuration file, if any
+func readConfig(configPaths ...string) (*Config, error) {
 	for _, configPath := range configPaths {
 		viper.AddConfigPath(configPath)
 	}
@@ -63,18 +141,19 @@ func ReadConfig(configPaths ...string) *Config {
 	var config Config
 
 	var read bool
+	var readErr error
 	//Read config file if set in env variable GCA_CONFIG_FILE
 	if configFile, ok := os.LookupEnv("GCA_CONFIG_FILE"); ok {
 		if file, err := os.Open(configFile); err == nil {
 			defer file.Close()
-			if err := viper.ReadConfig(file); err == nil {
+			if readErr = viper.ReadConfig(file); readErr == nil {
 				read = true
 			}
 		}
 	}
 	if !read {
-		if err := viper.ReadInConfig(); err != nil {
-			fmt.Printf("Error reading config file, %s", err)
+		if err := viper.ReadInConfig(); err != nil && readErr == nil {
+			readErr = err
 		}
 	}
 
@@ -83,22 +162,40 @@ func ReadConfig(configPaths ...string) *Config {
 	viper.SetDefault("api.controllers", "records,geojson,openapi,status")
 	viper.SetDefault("api.cachetype", "TempFile")
 	viper.SetDefault("api.cachetime", 10)
+	viper.SetDefault("api.transactionretries", 3)
+	viper.SetDefault("api.transactionbackoff", 50)
+	viper.SetDefault("api.healthtimeout", 2000)
+	viper.SetDefault("api.adminusercolumn", "username")
 	viper.SetDefault("api.openapibase", map[string]map[string]string{"info": {"title": "GO-CRUD-API", "version": "0.0.1"}})
 	viper.SetDefault("server.http", true)
 	viper.SetDefault("server.httpport", 8080)
 	viper.SetDefault("server.https", false)
 	viper.SetDefault("server.httpsport", 8443)
+	viper.SetDefault("server.tlsminversion", "1.2")
+	viper.SetDefault("server.unixsocketmode", "0660")
 	viper.SetDefault("server.gracefultimeout", 15)
+	viper.SetDefault("server.shutdowndelay", 0)
 	viper.SetDefault("server.writetimeout", 15)
 	viper.SetDefault("server.readtimeout", 15)
 	viper.SetDefault("server.idletimeout", 60)
+	viper.SetDefault("server.session.name", "session")
+	viper.SetDefault("server.session.store", "cookie")
+	viper.SetDefault("server.session.path", "/")
+	viper.SetDefault("server.session.httponly", true)
+	viper.SetDefault("server.session.maxage", 86400*30)
+	viper.SetDefault("server.session.table", "sessions")
+	viper.SetDefault("server.tracing.exporter", "none")
+	viper.SetDefault("server.tracing.servicename", "go-crud-api")
+	viper.SetDefault("server.tracing.sampleratio", 1)
+	viper.SetDefault("server.log.format", "text")
+	viper.SetDefault("server.log.level", "info")
 
 	err := viper.Unmarshal(&config)
 	if err != nil {
 		panic(fmt.Sprintf("Unable to decode into struct, %v", err))
 	}
 
-	return &config
+	return &config, readErr
 }
 
 func (ac *ApiConfig) getDefaultPort(driver string) int {
@@ -150,12 +247,18 @@ func (ac *ApiConfig) setDriverDefaults() {
 }
 
 func (c *Config) Init() {
-	c.Api.setDriverDefaults()
-	c.Api.initMiddlewares()
+	c.Api.init()
+	initLogger(c.Server.Log)
+}
+
+// init sets the defaults of the api block, the one changed by a reload
+func (ac *ApiConfig) init() {
+	ac.setDriverDefaults()
+	ac.initMiddlewares()
 }
 
 func (ac *ApiConfig) initMiddlewares() {
-	defaultMiddlewares := "cors,errors"
+	defaultMiddlewares := "cors"
 	if ac.Middlewares == nil {
 		ac.Middlewares = map[string]map[string]interface{}{}
 	}
@@ -222,6 +325,21 @@ func (ac *ApiConfig) GetControllers() map[string]bool {
 	return controllersMap
 }
 
+// adminUserSources are the middlewares authenticating the admin users, see utils.SessionIdentity
+const adminUserSources = "dbAuth,apiKeyDbAuth,jwtAuth,basicAuth,clientCertAuth"
+
+// GetAdminUsers returns the users allowed to use the admin controller, given as "middleware:name"
+// so that a user authenticated by another middleware with the same name is not an admin
+func (ac *ApiConfig) GetAdminUsers() map[string]bool {
+	usersMap := map[string]bool{}
+	for _, user := range strings.Split(ac.AdminUsers, ",") {
+		if source, name, found := strings.Cut(strings.TrimSpace(user), ":"); found && name != "" && listContains(adminUserSources, source) {
+			usersMap[source+":"+name] = true
+		}
+	}
+	return usersMap
+}
+
 func (ac *ApiConfig) GetCustomControllers() map[string]bool {
 	controllersMap := map[string]bool{}
 	for _, controller := range strings.Split(ac.CustomControllers, ",") {
@@ -0,0 +1,74 @@
+package apiserver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// newColumnEncryptor loads the encryption keys, it returns nil when no column is encrypted
+func (ec EncryptionConfig) newColumnEncryptor() (*database.ColumnEncryptor, error) {
+	if len(ec.Columns) == 0 {
+		return nil, nil
+	}
+	// key ids are case insensitive as the configuration keys
+	keys := map[string][]byte{}
+	for keyId, value := range ec.Keys {
+		key, err := loadEncryptionKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("key '%s' : %s", keyId, err.Error())
+		}
+		keys[strings.ToLower(keyId)] = key
+	}
+	var indexKey []byte
+	if ec.IndexKey != "" {
+		key, err := loadEncryptionKey(ec.IndexKey)
+		if err != nil {
+			return nil, fmt.Errorf("index key : %s", err.Error())
+		}
+		indexKey = key
+	}
+	return database.NewColumnEncryptor(keys, strings.ToLower(ec.CurrentKey), indexKey, ec.Columns)
+}
+
+// loadEncryptionKey returns the base64 encoded key read from an environment variable ("env:NAME"), a file ("file:path") or the value itself
+func loadEncryptionKey(value string) ([]byte, error) {
+	encoded, err := loadSessionKey(value)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(string(encoded))
+}
+
+// Reencrypt encrypts again the encrypted columns with the current key and rebuilds their blind indexes,
+// to be run after a key rotation or when encrypting existing columns
+func Reencrypt(config *Config) error {
+	db, err := newGenericDB(config.Api)
+	if err != nil {
+		return err
+	}
+	defer db.PDO().CloseConn()
+	encryptor := db.GetEncryptor()
+	if encryptor == nil {
+		return fmt.Errorf("no encrypted column configured")
+	}
+	reflection := database.NewReflectionService(db, nil, 0)
+	tableNames := encryptor.GetTableNames()
+	sort.Strings(tableNames)
+	for _, tableName := range tableNames {
+		if !reflection.HasTable(tableName) {
+			return fmt.Errorf("table '%s' not found", tableName)
+		}
+		table := reflection.GetTable(tableName)
+		count, err := db.ReencryptTable(table, 1000)
+		if err != nil {
+			return fmt.Errorf("table '%s' : %s", tableName, err.Error())
+		}
+		utils.Log.Info("records encrypted again", "table", tableName, "count", count)
+	}
+	return nil
+}
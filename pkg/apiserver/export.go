@@ -0,0 +1,97 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/openapi"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportOpenApi reflects the database and writes the OpenAPI document of the configured controllers,
+// in "json" or "yaml". The serverUrl, if any, is set as the server of the document.
+func ExportOpenApi(w io.Writer, config *Config, format, serverUrl string) error {
+	if format != "json" && format != "yaml" {
+		return fmt.Errorf("unknown format '%s', expected json or yaml", format)
+	}
+	reflection, closeDb, err := newExportReflection(config)
+	if err != nil {
+		return err
+	}
+	defer closeDb()
+	base := map[string]interface{}{}
+	for key, value := range config.Api.OpenApiBase {
+		base[key] = value
+	}
+	if serverUrl != "" {
+		base["servers"] = []map[string]string{{"url": serverUrl}}
+	}
+	service := openapi.NewOpenApiService(reflection, base, config.Api.GetControllers(), config.Api.GetCustomOpenApiBuilders())
+	r, err := http.NewRequest(http.MethodGet, "/openapi", nil)
+	if err != nil {
+		return err
+	}
+	return writeExport(w, service.Get(r), format)
+}
+
+// ExportSchema reflects the database and writes its tables and columns in json, as returned by the columns controller
+func ExportSchema(w io.Writer, config *Config) error {
+	reflection, closeDb, err := newExportReflection(config)
+	if err != nil {
+		return err
+	}
+	defer closeDb()
+	tables := []*database.ReflectedTable{}
+	for _, tableName := range reflection.GetTableNames() {
+		tables = append(tables, reflection.GetTable(tableName))
+	}
+	return writeExport(w, map[string][]*database.ReflectedTable{"tables": tables}, "json")
+}
+
+// newExportReflection connects to the database and returns its reflection, without cache as it is read once,
+// the tables hidden by the server being hidden from the export too
+func newExportReflection(config *Config) (*database.ReflectionService, func(), error) {
+	db, err := newGenericDB(config.Api)
+	if err != nil {
+		return nil, nil, err
+	}
+	hideInternalTables(db, config)
+	closeDb := func() { db.PDO().CloseConn() }
+	if err := db.PingContext(context.Background()); err != nil {
+		closeDb()
+		return nil, nil, fmt.Errorf("unable to connect to the database : %s", err.Error())
+	}
+	reflection := database.NewReflectionService(db, nil, 0)
+	if err := reflection.Check(); err != nil {
+		closeDb()
+		return nil, nil, err
+	}
+	return reflection, closeDb, nil
+}
+
+// writeExport writes the document indented, the yaml being converted from the json serialization
+// so that the same fields are written
+func writeExport(w io.Writer, document interface{}, format string) error {
+	content, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	if format == "yaml" {
+		var values interface{}
+		if err := json.Unmarshal(content, &values); err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(values); err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+	_, err = fmt.Fprintf(w, "%s\n", content)
+	return err
+}
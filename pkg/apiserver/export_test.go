@@ -0,0 +1,93 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+func TestExportApi(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	defer os.Remove(db_path)
+	config := ReadConfig()
+	config.Init()
+	config.Api.Address = db_path
+	config.Api.Tables = "categories,tags"
+	config.Api.Controllers = "records"
+
+	var output bytes.Buffer
+	if err := ExportOpenApi(&output, config, "json", "https://api.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	document := map[string]interface{}{}
+	if err := json.Unmarshal(output.Bytes(), &document); err != nil {
+		t.Fatalf("Invalid json document : %s", err.Error())
+	}
+	paths, _ := document["paths"].(map[string]interface{})
+	for _, path := range []string{"/records/categories", "/records/tags/{id}"} {
+		if _, exists := paths[path]; !exists {
+			t.Errorf("Want path '%s' in %v", path, paths)
+		}
+	}
+	if _, exists := paths["/records/posts"]; exists || len(paths) != 4 {
+		t.Errorf("Want the paths of the selected tables only, got %v", paths)
+	}
+	if servers, _ := json.Marshal(document["servers"]); string(servers) != `[{"url":"https://api.example.com"}]` {
+		t.Errorf("Want server url, got %s", servers)
+	}
+	// the document does not depend on the order of the reflected tables
+	first := output.String()
+	output.Reset()
+	if err := ExportOpenApi(&output, config, "json", "https://api.example.com"); err != nil || output.String() != first {
+		t.Errorf("Want the same document on each export, got %v", err)
+	}
+
+	output.Reset()
+	if err := ExportOpenApi(&output, config, "yaml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output.String(), "components:\n") || !strings.Contains(output.String(), "\nopenapi: 3.0.0\n") {
+		t.Errorf("Want yaml document, got %s", output.String())
+	}
+	if err := ExportOpenApi(&output, config, "xml", ""); err == nil || err.Error() != "unknown format 'xml', expected json or yaml" {
+		t.Errorf("Want unknown format error, got %v", err)
+	}
+
+	output.Reset()
+	if err := ExportSchema(&output, config); err != nil {
+		t.Fatal(err)
+	}
+	schema := map[string][]map[string]interface{}{}
+	if err := json.Unmarshal(output.Bytes(), &schema); err != nil {
+		t.Fatalf("Invalid json schema : %s", err.Error())
+	}
+	if len(schema["tables"]) != 2 || schema["tables"][0]["name"] != "categories" || schema["tables"][1]["name"] != "tags" {
+		t.Errorf("Want categories and tags tables, got %s", output.String())
+	}
+
+	// the sessions and refresh tokens tables are hidden as by the server
+	db, err := newGenericDB(config.Api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tableName := range []string{"sessions", "refresh_tokens"} {
+		if _, err := db.PDO().Exec(nil, `CREATE TABLE "`+tableName+`" ("id" varchar(255) PRIMARY KEY, "data" text, "expires" bigint)`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.PDO().CloseConn()
+	config.Api.Tables = ""
+	config.Server.Session.Store = "database"
+	config.Api.Middlewares["dbAuth"] = map[string]interface{}{"loginMode": "token"}
+	output.Reset()
+	if err := ExportSchema(&output, config); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), `"sessions"`) || strings.Contains(output.String(), `"refresh_tokens"`) || !strings.Contains(output.String(), `"categories"`) {
+		t.Errorf("Want the sessions and refresh tokens tables hidden, got %s", output.String())
+	}
+}
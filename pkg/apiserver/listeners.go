@@ -0,0 +1,118 @@
+package apiserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdListenFdsStart is the first file descriptor passed by systemd
+const systemdListenFdsStart = 3
+
+// serverListener is a listener and the server it is served by
+type serverListener struct {
+	listener net.Listener
+	tls      bool
+	name     string
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation (LISTEN_FDS), the ones named "https"
+// (FileDescriptorName= of the socket unit) being served with tls and the others with http
+func systemdListeners() ([]serverListener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// the sockets must not be inherited by the child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	listeners := []serverListener{}
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", systemdListenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(systemdListenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %s : %s", name, err.Error())
+		}
+		listeners = append(listeners, serverListener{listener: listener, tls: name == "https", name: "systemd socket " + name})
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a unix domain socket with the file mode given in octal, ex : "0660".
+// A socket file left by a previous run is removed, the file being removed when the listener is closed.
+func listenUnix(path, mode string) (net.Listener, error) {
+	fileMode, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unix socket mode '%s'", mode)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(fileMode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// listen returns the listeners of the configuration : the sockets passed by systemd if any, else the http
+// and https addresses, and the unix domain socket
+func listen(config *ServerConfig) ([]serverListener, error) {
+	listeners, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) == 0 {
+		if config.Http {
+			addr := fmt.Sprintf("%s:%d", config.Address, config.HttpPort)
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			listeners = append(listeners, serverListener{listener: listener, name: "http server at " + addr})
+		}
+		if config.Https {
+			addr := fmt.Sprintf("%s:%d", config.Address, config.HttpsPort)
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				closeListeners(listeners)
+				return nil, err
+			}
+			listeners = append(listeners, serverListener{listener: listener, tls: true, name: "https server at " + addr})
+		}
+	}
+	if config.UnixSocket != "" {
+		listener, err := listenUnix(config.UnixSocket, config.UnixSocketMode)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, serverListener{listener: listener, name: "http server at unix socket " + config.UnixSocket})
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners []serverListener) {
+	for _, listener := range listeners {
+		listener.listener.Close()
+	}
+}
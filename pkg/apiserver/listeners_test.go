@@ -0,0 +1,65 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"golang.org/x/net/http2"
+)
+
+func TestUnixSocketApi(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	defer os.Remove(db_path)
+	config := ReadConfig()
+	config.Init()
+	config.Api.Address = db_path
+	delete(config.Api.Middlewares, "sslRedirect")
+	socketPath := filepath.Join(t.TempDir(), "gocrudapi.sock")
+	// a socket file left by a previous run is replaced
+	if listener, err := net.Listen("unix", socketPath); err != nil {
+		t.Fatal(err)
+	} else {
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+		listener.Close()
+	}
+	config.Server.Http = false
+	config.Server.Https = false
+	config.Server.UnixSocket = socketPath
+	config.Server.UnixSocketMode = "0600"
+	config.Server.H2c = true
+	serverStarted := new(sync.WaitGroup)
+	serverStarted.Add(1)
+	api := NewApi(config)
+	go api.Handle(serverStarted)
+	serverStarted.Wait()
+
+	if info, err := os.Stat(socketPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Want unix socket with mode 0600, got %v %v", info, err)
+	}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+	}
+	clients := map[string]*http.Client{
+		"HTTP/1.1": {Transport: &http.Transport{DialContext: dial}},
+		"HTTP/2.0": {Transport: &http2.Transport{AllowHTTP: true, DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return dial(context.Background(), network, addr)
+		}}},
+	}
+	for proto, client := range clients {
+		resp, err := client.Get("http://localhost/records/categories/1")
+		if err != nil {
+			t.Fatalf("%s request failed : %s", proto, err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Proto != proto {
+			t.Errorf("Want %s 200 response, got %s %d", proto, resp.Proto, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package apiserver
+
+import (
+	"os"
+	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// initLogger sets the logger from the server.log configuration
+func initLogger(config LogConfig) {
+	format := strings.ToLower(config.Format)
+	switch format {
+	case "", "text", "json", "logfmt":
+	default:
+		format = "text"
+		// logged once the new logger is set
+		defer func() { utils.Log.Warning("unknown log format, using text", "format", config.Format) }()
+	}
+	utils.SetLogger(utils.NewLogger(os.Stderr, format, utils.ParseLogLevel(config.Level)))
+}
@@ -32,6 +32,11 @@ func TestRecordsApi(t *testing.T) {
 		serverStarted.Wait()
 	}
 
+	// driverWant returns the response of the driver of the tests, the drivers reporting different constraint details
+	driverWant := func(wants map[string]string) string {
+		return wants[config.Api.Driver]
+	}
+
 	//https://ieftimov.com/post/testing-in-go-testing-http-servers/
 	//https://stackoverflow.com/questions/42474259/golang-how-to-live-test-an-http-server
 	tt := []utils.Test{
@@ -360,8 +365,8 @@ func TestRecordsApi(t *testing.T) {
 			Method:     http.MethodPost,
 			Uri:        "/records/posts",
 			Body:       `{"category_id":1,"content":"test"}`,
-			Want:       `{"code":1010,"message":"Data integrity violation"}`,
-			StatusCode: http.StatusConflict,
+			Want:       `{"code":1023,"details":{"column":"user_id"},"message":"Not null violation"}`,
+			StatusCode: http.StatusUnprocessableEntity,
 		},
 		{
 			Name:       "019_list_with_paginate_in_multiple_order",
@@ -642,16 +647,26 @@ func TestRecordsApi(t *testing.T) {
 			Method: http.MethodPost,
 			Uri:    "/records/kunsthåndværk",
 			//Rewrite body, seems like id in the test 044 from php-crud-api is not a duplicate...
-			Body:       `{"id":"e31ecfe6-591f-4660-9fbd-1a232083037f","Umlauts ä_ö_ü-COUNT":1, "user_id":1}`,
-			Want:       `{"code":1009,"message":"Duplicate key exception"}`,
+			Body: `{"id":"e31ecfe6-591f-4660-9fbd-1a232083037f","Umlauts ä_ö_ü-COUNT":1, "user_id":1}`,
+			Want: driverWant(map[string]string{
+				"sqlite": `{"code":1009,"details":{"column":"Umlauts ä_ö_ü-COUNT"},"message":"Duplicate key exception"}`,
+				"mysql":  `{"code":1009,"details":{"constraint":"kunsthåndværk.kunsthåndværk_Umlauts ä_ö_ü-COUNT_fkey"},"message":"Duplicate key exception"}`,
+				"pgsql":  `{"code":1009,"details":{"column":"Umlauts ä_ö_ü-COUNT","constraint":"kunsthåndværk_Umlauts ä_ö_ü-COUNT_uc"},"message":"Duplicate key exception"}`,
+				"sqlsrv": `{"code":1009,"details":{"constraint":"UC_kunsthåndværk_Umlauts ä_ö_ü-COUNT"},"message":"Duplicate key exception"}`,
+			}),
 			StatusCode: http.StatusConflict,
 		},
 		{
-			Name:       "045_error_on_failing_foreign_key_constraint",
-			Method:     http.MethodPost,
-			Uri:        "/records/posts",
-			Body:       `{"user_id":3,"category_id":1,"content":"fk constraint"}`,
-			Want:       `{"code":1010,"message":"Data integrity violation"}`,
+			Name:   "045_error_on_failing_foreign_key_constraint",
+			Method: http.MethodPost,
+			Uri:    "/records/posts",
+			Body:   `{"user_id":3,"category_id":1,"content":"fk constraint"}`,
+			Want: driverWant(map[string]string{
+				"sqlite": `{"code":1022,"message":"Foreign key violation"}`,
+				"mysql":  `{"code":1022,"details":{"column":"user_id","constraint":"abc_posts_user_id_fkey"},"message":"Foreign key violation"}`,
+				"pgsql":  `{"code":1022,"details":{"column":"user_id","constraint":"abc_posts_user_id_fkey"},"message":"Foreign key violation"}`,
+				"sqlsrv": `{"code":1022,"details":{"constraint":"abc_posts_user_id_fkey"},"message":"Foreign key violation"}`,
+			}),
 			StatusCode: http.StatusConflict,
 		},
 		{
@@ -943,6 +958,30 @@ func TestRecordsApi(t *testing.T) {
 			Want:       `{"visitors":0}`,
 			StatusCode: http.StatusOK,
 		},
+		{
+			Name:       "069_increment_event_visitors_H",
+			Method:     http.MethodPut,
+			Uri:        "/records/events/1",
+			Body:       `{"visitors":9007199254740993}`,
+			Want:       `1`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "069_increment_event_visitors_I",
+			Method:     http.MethodGet,
+			Uri:        "/records/events/1?include=visitors",
+			Body:       ``,
+			Want:       `{"visitors":9007199254740993}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "069_increment_event_visitors_J",
+			Method:     http.MethodPut,
+			Uri:        "/records/events/1",
+			Body:       `{"visitors":0}`,
+			Want:       `1`,
+			StatusCode: http.StatusOK,
+		},
 		{
 			Name:       "070_list_invisibles",
 			Method:     http.MethodGet,
@@ -1598,8 +1637,13 @@ func TestRecordsApi(t *testing.T) {
 			Uri:           "/records/posts?format=xml",
 			RequestHeader: map[string]string{"Content-Type": "application/xml"},
 			Body:          `<object><id>1</id><user_id>1</user_id><category_id>1</category_id><content>blog started</content></object>`,
-			Want:          `<root><code>1009</code><message>Duplicate key exception</message></root>`,
-			StatusCode:    http.StatusConflict,
+			Want: driverWant(map[string]string{
+				"sqlite": `<root><code>1009</code><details><column>id</column></details><message>Duplicate key exception</message></root>`,
+				"mysql":  `<root><code>1009</code><details><constraint>abc_posts.PRIMARY</constraint></details><message>Duplicate key exception</message></root>`,
+				"pgsql":  `<root><code>1009</code><details><column>id</column><constraint>abc_posts_pkey</constraint></details><message>Duplicate key exception</message></root>`,
+				"sqlsrv": `<root><code>1009</code><details><constraint>abc_posts_pkey</constraint></details><message>Duplicate key exception</message></root>`,
+			}),
+			StatusCode: http.StatusConflict,
 		},
 		{
 			Name:          "087_read_and_write_posts_as_xml_K",
@@ -1682,11 +1726,16 @@ func TestRecordsApi(t *testing.T) {
 			StatusCode: http.StatusOK,
 		},
 		{
-			Name:       "090_add_multiple_comments_C",
-			Method:     http.MethodPost,
-			Uri:        "/records/comments",
-			Body:       `[{"user_id":1,"post_id":6,"message":"multi 3","category_id":3},{"user_id":1,"post_id":0,"message":"multi 4","category_id":3}]`,
-			Want:       `[{"code":0,"message":"Success"},{"code":1010,"message":"Data integrity violation"}]`,
+			Name:   "090_add_multiple_comments_C",
+			Method: http.MethodPost,
+			Uri:    "/records/comments",
+			Body:   `[{"user_id":1,"post_id":6,"message":"multi 3","category_id":3},{"user_id":1,"post_id":0,"message":"multi 4","category_id":3}]`,
+			Want: driverWant(map[string]string{
+				"sqlite": `[{"code":0,"message":"Success"},{"code":1022,"message":"Foreign key violation"}]`,
+				"mysql":  `[{"code":0,"message":"Success"},{"code":1022,"details":{"column":"post_id","constraint":"comments_post_id_fkey"},"message":"Foreign key violation"}]`,
+				"pgsql":  `[{"code":0,"message":"Success"},{"code":1022,"details":{"column":"post_id","constraint":"comments_post_id_fkey"},"message":"Foreign key violation"}]`,
+				"sqlsrv": `[{"code":0,"message":"Success"},{"code":1022,"details":{"constraint":"comments_post_id_fkey"},"message":"Foreign key violation"}]`,
+			}),
 			StatusCode: http.StatusFailedDependency,
 		},
 		{
@@ -1714,11 +1763,16 @@ func TestRecordsApi(t *testing.T) {
 			StatusCode: http.StatusOK,
 		},
 		{
-			Name:       "091_edit_multiple_comments_C",
-			Method:     http.MethodPut,
-			Uri:        "/records/comments/7,8",
-			Body:       `[{"user_id":1,"post_id":6,"message":"multi 3","category_id":3},{"user_id":1,"post_id":0,"message":"multi 4","category_id":3}]`,
-			Want:       `[{"code":0,"message":"Success"},{"code":1010,"message":"Data integrity violation"}]`,
+			Name:   "091_edit_multiple_comments_C",
+			Method: http.MethodPut,
+			Uri:    "/records/comments/7,8",
+			Body:   `[{"user_id":1,"post_id":6,"message":"multi 3","category_id":3},{"user_id":1,"post_id":0,"message":"multi 4","category_id":3}]`,
+			Want: driverWant(map[string]string{
+				"sqlite": `[{"code":0,"message":"Success"},{"code":1022,"message":"Foreign key violation"}]`,
+				"mysql":  `[{"code":0,"message":"Success"},{"code":1022,"details":{"column":"post_id","constraint":"comments_post_id_fkey"},"message":"Foreign key violation"}]`,
+				"pgsql":  `[{"code":0,"message":"Success"},{"code":1022,"details":{"column":"post_id","constraint":"comments_post_id_fkey"},"message":"Foreign key violation"}]`,
+				"sqlsrv": `[{"code":0,"message":"Success"},{"code":1022,"details":{"constraint":"comments_post_id_fkey"},"message":"Foreign key violation"}]`,
+			}),
 			StatusCode: http.StatusFailedDependency,
 		},
 		{
@@ -1801,6 +1855,38 @@ func TestRecordsApi(t *testing.T) {
 			Want:       `[1,1]`,
 			StatusCode: http.StatusOK,
 		},
+		{
+			Name:       "094_update_product_price_A",
+			Method:     http.MethodPut,
+			Uri:        "/records/products/1",
+			Body:       `{"price":23.02}`,
+			Want:       `1`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "094_update_product_price_B",
+			Method:     http.MethodGet,
+			Uri:        "/records/products/1?include=price",
+			Body:       ``,
+			Want:       `{"price":"23.02"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "094_update_product_price_C",
+			Method:     http.MethodPut,
+			Uri:        "/records/products/1",
+			Body:       `{"price":23.015}`,
+			Want:       `{"code":1013,"details":{"price":"decimal too precise"},"message":"Input validation failed for 'products'"}`,
+			StatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:       "094_update_product_price_D",
+			Method:     http.MethodPut,
+			Uri:        "/records/products/1",
+			Body:       `{"price":23.01}`,
+			Want:       `1`,
+			StatusCode: http.StatusOK,
+		},
 	}
 	utils.RunTests(t, serverUrlHttps, tt)
 	if db_path != "" {
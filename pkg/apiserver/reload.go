@@ -0,0 +1,183 @@
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// apiHandler is the router built from a configuration, with the count of the requests it is serving
+// so that its database connections are closed once the requests started before a reload are done
+type apiHandler struct {
+	router  *mux.Router
+	db      *database.GenericDB
+	status  *controller.StatusController
+	closers []func()
+	mutex   sync.Mutex
+	active  int
+	retired bool
+	closed  bool
+}
+
+// acquire counts a request, returns false if the handler has been closed after a reload
+func (ah *apiHandler) acquire() bool {
+	ah.mutex.Lock()
+	defer ah.mutex.Unlock()
+	if ah.closed {
+		return false
+	}
+	ah.active++
+	return true
+}
+
+func (ah *apiHandler) release() {
+	ah.mutex.Lock()
+	defer ah.mutex.Unlock()
+	ah.active--
+	ah.closeIfIdle()
+}
+
+// retire closes the handler once its requests are done
+func (ah *apiHandler) retire() {
+	ah.mutex.Lock()
+	defer ah.mutex.Unlock()
+	ah.retired = true
+	ah.closeIfIdle()
+}
+
+func (ah *apiHandler) closeIfIdle() {
+	if ah.retired && ah.active == 0 && !ah.closed {
+		ah.closed = true
+		ah.db.PDO().CloseConn()
+		for _, close := range ah.closers {
+			close()
+		}
+	}
+}
+
+func (a *Api) getHandler() *apiHandler {
+	return a.handler.Load().(*apiHandler)
+}
+
+// ServeHTTP serves the request with the current handler, the requests in progress during a reload
+// finishing with the previous one
+func (a *Api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		handler := a.getHandler()
+		if handler.acquire() {
+			defer handler.release()
+			handler.router.ServeHTTP(w, r)
+			return
+		}
+	}
+}
+
+// Reload reads the configuration again and swaps in the handler built from it. The listeners of the server
+// block (addresses, ports and certificates) are not changed. On error, the current handler is kept.
+func (a *Api) Reload() error {
+	a.reloadMutex.Lock()
+	defer a.reloadMutex.Unlock()
+	handler, err := a.buildHandler()
+	a.reloads.Record(err)
+	if err != nil {
+		utils.Log.Error("configuration reload failed, keeping the current configuration", "error", err)
+		return err
+	}
+	previous := a.getHandler()
+	a.handler.Store(handler)
+	previous.retire()
+	utils.Log.Info("configuration reloaded")
+	return nil
+}
+
+// buildHandler reads and validates the configuration, a panic while building the handler (ex : a database
+// connection failure) being returned as an error. Only the api block is reloaded, the server block
+// (listeners, logger, session store and tracing) of the running server is kept.
+func (a *Api) buildHandler() (handler *apiHandler, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Api.init()
+	if errs := ValidateConfig(config); len(errs) > 0 {
+		messages := []string{}
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return nil, fmt.Errorf("invalid configuration : %s", strings.Join(messages, ", "))
+	}
+	config.Server = a.config.Server
+	if handler, err = a.newApiHandler(config); err != nil {
+		return nil, err
+	}
+	a.config.Api = config.Api
+	return handler, nil
+}
+
+// watchConfig reloads the configuration when its file changes, the events being grouped during a short delay
+// as editors often write a file in several steps
+func (a *Api) watchConfig() {
+	configFile, ok := os.LookupEnv("GCA_CONFIG_FILE")
+	if !ok {
+		configFile = viper.ConfigFileUsed()
+	}
+	if configFile == "" {
+		utils.Log.Warning("no configuration file to watch")
+		return
+	}
+	configFile, _ = filepath.Abs(configFile)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		utils.Log.Error("unable to watch the configuration file", "error", err)
+		return
+	}
+	// the directory is watched as the file can be replaced
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		utils.Log.Error("unable to watch the configuration file", "error", err)
+		watcher.Close()
+		return
+	}
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if eventFile, _ := filepath.Abs(event.Name); eventFile != configFile || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(500*time.Millisecond, func() {
+					a.Reload()
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				utils.Log.Warning("configuration file watch error", "error", err)
+			}
+		}
+	}()
+	utils.Log.Info("watching configuration file", "file", configFile)
+}
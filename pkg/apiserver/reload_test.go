@@ -0,0 +1,157 @@
+package apiserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+func TestReloadApi(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	defer os.Setenv("GCA_CONFIG_FILE", os.Getenv("GCA_CONFIG_FILE"))
+	configFile, err := ioutil.TempFile(os.TempDir(), "gocrudconfig-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile.Close()
+	defer os.Remove(configFile.Name())
+	writeConfig := func(allowedIpAddresses string) {
+		content := fmt.Sprintf(`server:
+  session:
+    store: "memory"
+api:
+  driver: "sqlite"
+  controllers: "records,status"
+  address: "%s"
+  database: "go-crud-api"
+  username: "go-crud-api"
+  password: "go-crud-api"
+  middlewares:
+  - firewall:
+    - allowedIpAddresses: "%s"
+  - dbAuth:
+    - mode: "optional"
+    - returnedColumns: "id,username"
+`, db_path, allowedIpAddresses)
+		if err := ioutil.WriteFile(configFile.Name(), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("10.0.0.1")
+	os.Setenv("GCA_CONFIG_FILE", configFile.Name())
+	config := ReadConfig()
+	config.Init()
+	api := NewApi(config)
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "reload_before_blocked",
+			Method:     http.MethodGet,
+			Uri:        "/records/categories/1",
+			Want:       `{"code":1016,"message":"Temporary or permanently blocked"}`,
+			StatusCode: http.StatusForbidden,
+		},
+	})
+
+	// a request in progress keeps the previous handler and its database until it is done
+	previous := api.getHandler()
+	previous.acquire()
+	writeConfig("127.0.0.1")
+	if err := api.Reload(); err != nil {
+		t.Fatalf("Reload failed : %s", err.Error())
+	}
+	if previous.closed {
+		t.Errorf("Previous handler closed with a request in progress")
+	}
+	previous.release()
+	if !previous.closed {
+		t.Errorf("Previous handler not closed after its last request")
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "reload_allowed",
+			Method:     http.MethodGet,
+			Uri:        "/records/categories/1",
+			Want:       `{"icon":null,"id":1,"name":"announcement"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "reload_status",
+			Method:     http.MethodGet,
+			Uri:        "/status/reload",
+			WantRegex:  `^{"failures":0,"lastReloadAt":"[^"]+","lastReloadStatus":"success","loadedAt":"[^"]+","reloads":1}$`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "reload_login",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass2"}`,
+			Jar:        jar,
+			Want:       `{"id":2,"username":"user2"}`,
+			StatusCode: http.StatusOK,
+		},
+	})
+
+	// the sessions of the memory store are kept across the reloads
+	if err := api.Reload(); err != nil {
+		t.Fatalf("Reload failed : %s", err.Error())
+	}
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "reload_session_kept",
+			Method:     http.MethodGet,
+			Uri:        "/me",
+			Jar:        jar,
+			Want:       `{"id":2,"username":"user2"}`,
+			StatusCode: http.StatusOK,
+		},
+	})
+
+	// a configuration failing the validation is rejected
+	writeConfig("127.0.0.1\"\n  - unknownMiddleware:\n    - enabled: \"true")
+	if err := api.Reload(); err == nil || !strings.Contains(err.Error(), "unknownMiddleware") {
+		t.Errorf("Want reload error with an unknown middleware, got %v", err)
+	}
+
+	// an invalid configuration is rejected and the current one is kept
+	if err := ioutil.WriteFile(configFile.Name(), []byte("api: [\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Reload(); err == nil {
+		t.Errorf("Want reload error with an invalid configuration")
+	}
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "reload_failed_allowed",
+			Method:     http.MethodGet,
+			Uri:        "/records/categories/1",
+			Want:       `{"icon":null,"id":1,"name":"announcement"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "reload_failed_status",
+			Method:     http.MethodGet,
+			Uri:        "/status/reload",
+			WantRegex:  `^{"failures":2,"lastReloadAt":"[^"]+","lastReloadError":"[^"]+","lastReloadStatus":"failure","loadedAt":"[^"]+","reloads":2}$`,
+			StatusCode: http.StatusOK,
+		},
+	})
+	api.getHandler().db.PDO().CloseConn()
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,131 @@
+package apiserver
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/cache"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// initSessionStore creates the session store from the server.session configuration, once as the sessions
+// are kept across the reloads of the api configuration
+func initSessionStore(config SessionConfig, apiConfig *ApiConfig) error {
+	// the users, claims and identities kept in the sessions are maps
+	gob.Register(map[string]interface{}{})
+	keyPairs := config.getKeyPairs()
+	var store sessions.Store
+	var options *sessions.Options
+	switch strings.ToLower(config.Store) {
+	case "memory":
+		serverStore := utils.NewServerSessionStore(utils.NewMemorySessionBackend(), keyPairs...)
+		serverStore.MaxAge(config.MaxAge)
+		store, options = serverStore, serverStore.Options
+	case "redis":
+		prefix := fmt.Sprintf("gocrudapi-%s-", config.Name)
+		if backend := cache.NewRedisSessionBackend(prefix, apiConfig.CachePath); backend != nil {
+			serverStore := utils.NewServerSessionStore(backend, keyPairs...)
+			serverStore.MaxAge(config.MaxAge)
+			store, options = serverStore, serverStore.Options
+		}
+	case "database":
+		// the database of the sessions is not closed by the reloads
+		db, err := newGenericDB(apiConfig)
+		if err != nil {
+			return err
+		}
+		serverStore := utils.NewServerSessionStore(database.NewDbSessionBackend(db, config.Table), keyPairs...)
+		serverStore.MaxAge(config.MaxAge)
+		store, options = serverStore, serverStore.Options
+	case "cookie", "":
+	default:
+		utils.Log.Warning("unknown session store, using cookie store", "store", config.Store)
+	}
+	if store == nil {
+		cookieStore := sessions.NewCookieStore(keyPairs...)
+		cookieStore.MaxAge(config.MaxAge)
+		store, options = cookieStore, cookieStore.Options
+	}
+	options.Domain = config.Domain
+	options.Path = config.Path
+	options.Secure = config.Secure
+	options.HttpOnly = config.HttpOnly
+	options.SameSite = getSameSite(config.SameSite)
+	utils.SetSessionStore(config.Name, store)
+	return nil
+}
+
+// getKeyPairs returns the hash and block keys, the first pair signing the new cookies
+// and the following ones being kept to read the cookies signed before a key rotation
+func (sc *SessionConfig) getKeyPairs() [][]byte {
+	keyPairs := [][]byte{}
+	for _, key := range sc.Keys {
+		hashKey, err := loadSessionKey(key.HashKey)
+		if err != nil {
+			utils.Log.Error("unable to load session hash key", "error", err)
+			continue
+		}
+		if len(hashKey) == 0 {
+			utils.Log.Error("empty session hash key")
+			continue
+		}
+		blockKey, err := loadSessionKey(key.BlockKey)
+		if err != nil {
+			utils.Log.Error("unable to load session block key", "error", err)
+			continue
+		}
+		switch len(blockKey) {
+		case 0:
+			// no encryption, the cookies are only signed
+			blockKey = nil
+		case 16, 24, 32:
+		default:
+			utils.Log.Error("session block key should be 16, 24 or 32 bytes long", "length", len(blockKey))
+			continue
+		}
+		keyPairs = append(keyPairs, hashKey, blockKey)
+	}
+	if len(keyPairs) == 0 {
+		utils.Log.Warning("no session keys configured, using a random key : sessions will not survive a restart")
+		keyPairs = append(keyPairs, securecookie.GenerateRandomKey(64), nil)
+	}
+	return keyPairs
+}
+
+// loadSessionKey returns the key read from an environment variable ("env:NAME"), a file ("file:path") or the value itself
+func loadSessionKey(value string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		if key, exists := os.LookupEnv(name); exists {
+			return []byte(key), nil
+		}
+		return nil, fmt.Errorf("environment variable %s not set", name)
+	case strings.HasPrefix(value, "file:"):
+		content, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimSpace(string(content))), nil
+	}
+	return []byte(value), nil
+}
+
+func getSameSite(sameSite string) http.SameSite {
+	switch strings.ToLower(sameSite) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteDefaultMode
+}
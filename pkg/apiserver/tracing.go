@@ -0,0 +1,18 @@
+package apiserver
+
+import "github.com/dranih/go-crud-api/pkg/utils"
+
+// initTracing sets the tracer from the server.tracing configuration, returns false if the tracing is disabled
+func initTracing(config TracingConfig) bool {
+	utils.ShutdownTracer()
+	exporter, err := utils.NewSpanExporter(config.Exporter, config.Endpoint, config.ServiceName)
+	if err != nil {
+		utils.Log.Warning("tracing disabled", "error", err)
+		return false
+	}
+	if exporter == nil {
+		return false
+	}
+	utils.SetTracer(utils.NewTracer(exporter, config.SampleRatio))
+	return true
+}
@@ -0,0 +1,335 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/dranih/go-crud-api/pkg/middleware"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// knownControllers are the controllers that can be listed in api.controllers
+const knownControllers = "records,columns,cache,openapi,geojson,status,admin"
+
+// middlewareProperties lists the properties read by each middleware
+var middlewareProperties = map[string]string{
+	"accessLog":      "header,reverseProxy,userColumn",
+	"sslRedirect":    "",
+	"cors":           "allowedOrigins,allowHeaders,allowMethods,exposeHeaders,maxAge",
+	"firewall":       "reverseProxy,allowedIpAddresses",
+	"rateLimit":      "limit,period,joinCost,header,keyBy,limits,reverseProxy,store,userColumn",
+	"xsrf":           "excludeMethods,cookieName,headerName",
+	"ajaxOnly":       "excludeMethods,headerName,headerValue",
+	"xml":            "types",
+	"json":           "controllers,tables,columns",
+	"reconnect":      "driverHandler,addressHandler,portHandler,databaseHandler,tablesHandler,mappingHandler,usernameHandler,passwordHandler",
+	"clientCertAuth": "mode,realm,caFile,allowed,usernameField",
+	"apiKeyAuth":     "mode,realm,header,keys",
+	"apiKeyDbAuth":   "mode,realm,header,usersTable,apiKeyColumn,plaintextKeys,usernameColumn,expiresColumn,lastUsedColumn,scopesColumn,adminUsers,keyTtl",
+	"dbAuth": "mode,usersTable,usernameColumn,passwordColumn,returnedColumns,usernameFormField,passwordFormField,newPasswordFormField," +
+		"registerUser,passwordLength,loginMode,tokenAlgorithm,tokenSecret,tokenKid,tokenHeader,tokenTtl,tokenIssuer,tokenAudience,tokenClaims," +
+		"refreshTokensTable,refreshTokenTtl,refreshTokenFormField,maxFailedAttempts,lockoutTime,failedAttemptsColumn,lockedUntilColumn," +
+		"resetTokenColumn,resetExpiresColumn,resetTokenTtl,resetTokenFormField,resetTokenWebhook,totpSecretColumn,totpStepColumn,totpFormField,totpIssuer,totpKey",
+	"jwtAuth":       "mode,realm,header,secret,secrets,algorithms,audiences,issuers,verifyClaims,leeway,ttl,time,jwksUrl,jwksFile,jwksRefresh",
+	"basicAuth":     "mode,realm,passwordFile",
+	"authorization": "tableHandler,columnHandler,recordHandler",
+	"rbac":          "policyFile,rolesClaim,rolesColumn,defaultRole,explain",
+	"masking":       "policyFile,hashKey,rolesClaim,rolesColumn,defaultRole",
+	"sanitation":    "handler,tables,types",
+	"validation":    "handler,tables,types",
+	"ipAddress":     "tables,columns,reverseProxy",
+	"multiTenancy":  "handler",
+	"pageLimits":    "pages,records",
+	"joinLimits":    "depth,tables,records",
+	"customization": "beforeHandler,afterHandlerHeader,afterHandlerBody",
+}
+
+// templateProperties lists the properties holding a go template handler
+var templateProperties = map[string]string{
+	"reconnect":     "driverHandler,addressHandler,portHandler,databaseHandler,tablesHandler,usernameHandler,passwordHandler",
+	"authorization": "tableHandler,columnHandler,recordHandler",
+	"sanitation":    "handler",
+	"validation":    "handler",
+	"multiTenancy":  "handler",
+	"customization": "beforeHandler,afterHandlerHeader,afterHandlerBody",
+}
+
+// secretProperties lists the properties redacted when the configuration is printed
+var secretProperties = map[string]string{
+	"reconnect":  "passwordHandler",
+	"apiKeyAuth": "keys",
+	"dbAuth":     "tokenSecret,totpKey",
+	"jwtAuth":    "secret,secrets",
+	"masking":    "hashKey",
+}
+
+// ValidateConfig checks the configuration file keys, the controllers, the middlewares and their properties,
+// and parses the template handlers. It returns all the errors found.
+func ValidateConfig(config *Config) []error {
+	errs := []error{}
+	if config.readErr != nil {
+		errs = append(errs, fmt.Errorf("unable to read the configuration file : %s", config.readErr.Error()))
+	}
+	errs = append(errs, validateKeys()...)
+	errs = append(errs, validateValues(config)...)
+	for _, controller := range strings.Split(config.Api.Controllers, ",") {
+		if controller = strings.TrimSpace(controller); !listContains(knownControllers, controller) {
+			errs = append(errs, fmt.Errorf("unknown controller '%s'%s", controller, suggest(controller, knownControllers)))
+		}
+	}
+	errs = append(errs, validateMiddlewares(config.Api.Middlewares)...)
+	return errs
+}
+
+// validateKeys returns the keys of the configuration file which are not a field of the configuration,
+// the keys of the map fields (ex : api.mapping) being free
+func validateKeys() []error {
+	fields := map[string]reflect.Kind{}
+	configFields(reflect.TypeOf(Config{}), "", fields)
+	keys := viper.AllKeys()
+	sort.Strings(keys)
+	errs := []error{}
+	for _, key := range keys {
+		known := false
+		path := ""
+		for _, part := range strings.Split(key, ".") {
+			if path != "" {
+				path += "."
+			}
+			path += part
+			if kind, exists := fields[path]; exists && (path == key || kind == reflect.Map) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			errs = append(errs, fmt.Errorf("unknown key '%s'", key))
+		}
+	}
+	return errs
+}
+
+// configFields lists the lowercased paths of the configuration fields, as the viper keys
+func configFields(t reflect.Type, prefix string, fields map[string]reflect.Kind) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		path := prefix + strings.ToLower(field.Name)
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		fields[path] = fieldType.Kind()
+		if fieldType.Kind() == reflect.Struct {
+			configFields(fieldType, path+".", fields)
+		}
+	}
+}
+
+// validateValues checks the options taking a value from a list
+func validateValues(config *Config) []error {
+	errs := []error{}
+	for _, option := range []struct {
+		name, value, values string
+	}{
+		{"api.driver", config.Api.Driver, "mysql,pgsql,sqlsrv,sqlite"},
+		{"api.cacheType", config.Api.CacheType, "TempFile,Gocache,Redis,Memcache,Memcached,NoCache"},
+		{"server.session.store", config.Server.Session.Store, ",cookie,memory,redis,database"},
+		{"server.log.format", strings.ToLower(config.Server.Log.Format), ",text,json,logfmt"},
+		{"server.log.level", strings.ToLower(config.Server.Log.Level), ",debug,info,warning,warn,error"},
+		{"server.tracing.exporter", strings.ToLower(config.Server.Tracing.Exporter), ",none,stdout,otlp"},
+		{"server.tlsMinVersion", config.Server.TlsMinVersion, "1.0,1.1,1.2,1.3"},
+	} {
+		if !listContains(option.values, option.value) {
+			errs = append(errs, fmt.Errorf("invalid %s '%s', expected one of %s", option.name, option.value, strings.Trim(option.values, ",")))
+		}
+	}
+	if _, err := tlsCipherSuites(config.Server.TlsCipherSuites); err != nil {
+		errs = append(errs, err)
+	}
+	for _, user := range strings.Split(config.Api.AdminUsers, ",") {
+		if user = strings.TrimSpace(user); user == "" {
+			continue
+		}
+		if source, name, _ := strings.Cut(user, ":"); name == "" || !listContains(adminUserSources, source) {
+			errs = append(errs, fmt.Errorf("invalid api.adminUsers '%s', expected middleware:name with middleware one of %s", user, adminUserSources))
+		}
+	}
+	return errs
+}
+
+// validateMiddlewares checks the middleware names and properties and parses the template handlers
+func validateMiddlewares(middlewares map[string]map[string]interface{}) []error {
+	knownMiddlewares := make([]string, 0, len(middlewareProperties))
+	for name := range middlewareProperties {
+		knownMiddlewares = append(knownMiddlewares, name)
+	}
+	sort.Strings(knownMiddlewares)
+	names := make([]string, 0, len(middlewares))
+	for name := range middlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := []error{}
+	for _, name := range names {
+		properties, exists := middlewareProperties[name]
+		if !exists {
+			errs = append(errs, fmt.Errorf("unknown middleware '%s'%s", name, suggest(name, strings.Join(knownMiddlewares, ","))))
+			continue
+		}
+		propertyNames := make([]string, 0, len(middlewares[name]))
+		for property := range middlewares[name] {
+			propertyNames = append(propertyNames, property)
+		}
+		sort.Strings(propertyNames)
+		for _, property := range propertyNames {
+			if !listContains(properties, property) {
+				errs = append(errs, fmt.Errorf("unknown property '%s' of middleware '%s'%s", property, name, suggest(property, properties)))
+				continue
+			}
+			if listContains(templateProperties[name], property) {
+				handler := fmt.Sprint(middlewares[name][property])
+				if _, err := template.New(property).Funcs(sprig.TxtFuncMap()).Parse(handler); err != nil {
+					errs = append(errs, fmt.Errorf("invalid template '%s' of middleware '%s' : %s", property, name, err.Error()))
+				}
+			}
+		}
+		if totpKey, exists := middlewares[name]["totpKey"]; name == "dbAuth" && exists {
+			if key, err := base64.StdEncoding.DecodeString(fmt.Sprint(totpKey)); err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+				errs = append(errs, fmt.Errorf("invalid totpKey of middleware 'dbAuth', expected a base64 encoded key of 16, 24 or 32 bytes"))
+			}
+		}
+		if name == "masking" {
+			policyFile, hashKey := "", ""
+			if value, exists := middlewares[name]["policyFile"]; exists {
+				policyFile = fmt.Sprint(value)
+			}
+			if value, exists := middlewares[name]["hashKey"]; exists {
+				hashKey = fmt.Sprint(value)
+			}
+			if err := middleware.CheckMaskingPolicy(policyFile, hashKey); err != nil {
+				errs = append(errs, fmt.Errorf("invalid policy of middleware 'masking' : %s", err.Error()))
+			}
+		}
+	}
+	return errs
+}
+
+// WriteConfig writes the effective configuration, with the defaults and the environment variables applied,
+// in yaml with the secrets redacted
+func WriteConfig(w io.Writer, config *Config) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(redactConfig(config)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// redactedConfigMap returns the redacted configuration with the keys of the yaml, for the admin controller
+func redactedConfigMap(config *Config) (map[string]interface{}, error) {
+	content, err := yaml.Marshal(redactConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	err = yaml.Unmarshal(content, &result)
+	return result, err
+}
+
+// redactConfig returns a copy of the configuration with the secrets redacted
+func redactConfig(config *Config) Config {
+	api := *config.Api
+	api.Password = redact(api.Password)
+	api.CachePath = redactCachePath(api.CachePath)
+	api.Encryption.IndexKey = redact(api.Encryption.IndexKey)
+	api.Encryption.Keys = map[string]string{}
+	for keyId, key := range config.Api.Encryption.Keys {
+		api.Encryption.Keys[keyId] = redact(key)
+	}
+	api.Middlewares = map[string]map[string]interface{}{}
+	for name, properties := range config.Api.Middlewares {
+		if properties == nil {
+			api.Middlewares[name] = nil
+			continue
+		}
+		api.Middlewares[name] = map[string]interface{}{}
+		for property, value := range properties {
+			if listContains(secretProperties[name], property) {
+				value = redact(fmt.Sprint(value))
+			}
+			api.Middlewares[name][property] = value
+		}
+	}
+	server := *config.Server
+	server.Session.Keys = make([]SessionKeyConfig, len(config.Server.Session.Keys))
+	for i, keys := range config.Server.Session.Keys {
+		server.Session.Keys[i] = SessionKeyConfig{HashKey: redact(keys.HashKey), BlockKey: redact(keys.BlockKey)}
+	}
+	return Config{Api: &api, Server: &server}
+}
+
+// redact hides a secret value, the references to an environment variable or a file being kept
+func redact(value string) string {
+	if value == "" || strings.HasPrefix(value, "env:") || strings.HasPrefix(value, "file:") {
+		return value
+	}
+	return "<redacted>"
+}
+
+// redactCachePath hides the password of the Redis json configuration or the userinfo of an url
+func redactCachePath(cachePath string) string {
+	var options map[string]interface{}
+	if err := json.Unmarshal([]byte(cachePath), &options); err == nil {
+		for key, value := range options {
+			if strings.EqualFold(key, "password") {
+				options[key] = redact(fmt.Sprint(value))
+			}
+		}
+		var content bytes.Buffer
+		encoder := json.NewEncoder(&content)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(options)
+		return strings.TrimSpace(content.String())
+	}
+	if u, err := url.Parse(cachePath); err == nil && u.User != nil {
+		u.User = nil
+		return strings.Replace(u.String(), "://", "://<redacted>@", 1)
+	}
+	return cachePath
+}
+
+// listContains returns true if the comma separated list holds the value
+func listContains(list, value string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// suggest returns a hint when the value differs from a known one by its case only
+func suggest(value, list string) string {
+	for _, item := range strings.Split(list, ",") {
+		if item != "" && strings.EqualFold(item, value) {
+			return fmt.Sprintf(", did you mean '%s' ?", item)
+		}
+	}
+	return ""
+}
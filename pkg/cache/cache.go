@@ -1,12 +1,19 @@
 package cache
 
-import "time"
+import (
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
 
 type Cache interface {
 	Set(string, string, int32) bool
 	Get(string) string
 	Clear() bool
 	Ping() int
+	Delete(string) bool
+	Keys() []string
+	Close() error
 }
 
 type BaseCache struct{}
@@ -23,6 +30,20 @@ func (bc *BaseCache) Clear() bool {
 	return true
 }
 
+func (bc *BaseCache) Delete(key string) bool {
+	return true
+}
+
+// Keys returns the cached keys, nil if the cache is not able to list them
+func (bc *BaseCache) Keys() []string {
+	return nil
+}
+
+// Close releases the connections of the cache, once it is no longer used
+func (bc *BaseCache) Close() error {
+	return nil
+}
+
 func (bc *BaseCache) Ping() int {
 	start := time.Now()
 	bc.Get("__ping__")
@@ -34,3 +55,13 @@ func (bc *BaseCache) Ping() int {
 type NoCache struct {
 	BaseCache
 }
+
+// countGet records a cache hit or miss in the metrics and returns the value
+func countGet(cacheType, value string) string {
+	result := "hit"
+	if value == "" {
+		result = "miss"
+	}
+	utils.Metrics.AddCounter("gocrudapi_cache_requests_total", "Number of cache reads by result", 1, "cache", cacheType, "result", result)
+	return value
+}
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"sort"
+	"strings"
 	"time"
 
 	gocache "github.com/patrickmn/go-cache"
@@ -19,18 +21,34 @@ func NewGocacheCache(prefix, config string) *GocacheCache {
 }
 
 func (gc *GocacheCache) Set(key, value string, ttl int32) bool {
-	gc.cache.Set(gc.prefix+key, value, time.Duration(ttl))
+	gc.cache.Set(gc.prefix+key, value, time.Duration(ttl)*time.Second)
 	return true
 }
 
 func (gc *GocacheCache) Get(key string) string {
 	if val, found := gc.cache.Get(gc.prefix + key); found {
-		return val.(string)
+		return countGet("gocache", val.(string))
 	}
-	return ""
+	return countGet("gocache", "")
 }
 
 func (gc *GocacheCache) Clear() bool {
 	gc.cache.Flush()
 	return true
 }
+
+func (gc *GocacheCache) Delete(key string) bool {
+	gc.cache.Delete(gc.prefix + key)
+	return true
+}
+
+func (gc *GocacheCache) Keys() []string {
+	keys := []string{}
+	for key := range gc.cache.Items() {
+		if strings.HasPrefix(key, gc.prefix) {
+			keys = append(keys, strings.TrimPrefix(key, gc.prefix))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
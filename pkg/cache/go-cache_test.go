@@ -0,0 +1,18 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGocacheTtl(t *testing.T) {
+	gc := NewGocacheCache("gocrudapi-test-", "")
+	gc.Set("key", "value", 1)
+	if got := gc.Get("key"); got != "value" {
+		t.Errorf("Want 'value' before the ttl, got '%s'", got)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if got := gc.Get("key"); got != "" {
+		t.Errorf("Want '' after the ttl of 1 second, got '%s'", got)
+	}
+}
@@ -2,10 +2,12 @@ package cache
 
 import (
 	"fmt"
-	"log"
 	"strings"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type MemcacheCache struct {
@@ -31,7 +33,7 @@ func NewMemcacheCache(prefix, config string) *MemcacheCache {
 
 func (mc *MemcacheCache) Set(key, value string, ttl int32) bool {
 	if err := mc.memcache.Set(&memcache.Item{Key: mc.prefix + key, Value: []byte(value), Expiration: ttl}); err != nil {
-		log.Printf("Caching error : %v", err)
+		utils.Log.Error("caching error", "error", err)
 		return false
 	}
 	return true
@@ -39,16 +41,34 @@ func (mc *MemcacheCache) Set(key, value string, ttl int32) bool {
 
 func (mc *MemcacheCache) Get(key string) string {
 	if item, err := mc.memcache.Get(mc.prefix + key); err != nil {
-		log.Printf("Caching error : %v", err)
-		return ""
+		utils.Log.Error("caching error", "error", err)
+		return countGet("memcache", "")
 	} else {
-		return string(item.Value)
+		return countGet("memcache", string(item.Value))
 	}
 }
 
+// Ping returns the duration in milliseconds of a check of the servers, -1 if one is unreachable
+func (mc *MemcacheCache) Ping() int {
+	start := time.Now()
+	if err := mc.memcache.Ping(); err != nil {
+		utils.Log.Error("caching error", "error", err)
+		return -1
+	}
+	return int(time.Since(start).Milliseconds())
+}
+
 func (mc *MemcacheCache) Clear() bool {
 	if err := mc.memcache.FlushAll(); err != nil {
 		return false
 	}
 	return true
 }
+
+func (mc *MemcacheCache) Delete(key string) bool {
+	if err := mc.memcache.Delete(mc.prefix + key); err != nil && err != memcache.ErrCacheMiss {
+		utils.Log.Error("caching error", "error", err)
+		return false
+	}
+	return true
+}
@@ -3,10 +3,13 @@ package cache
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type RedisCache struct {
@@ -20,7 +23,7 @@ type RedisCache struct {
 func NewRedisCache(prefix, config string) *RedisCache {
 	var redisConfig *redis.Options
 	if err := json.Unmarshal([]byte(config), &redisConfig); err != nil {
-		log.Printf("Error loading Redis configuration : %v", err)
+		utils.Log.Error("unable to load the Redis configuration", "error", err)
 		return nil
 	} else {
 		rdb := redis.NewClient(redisConfig)
@@ -29,8 +32,8 @@ func NewRedisCache(prefix, config string) *RedisCache {
 }
 
 func (rc *RedisCache) Set(key, value string, ttl int32) bool {
-	if err := rc.redisClient.Set(rc.ctx, rc.prefix+key, value, time.Duration(ttl)).Err(); err != nil {
-		log.Printf("Caching error : %v", err)
+	if err := rc.redisClient.Set(rc.ctx, rc.prefix+key, value, time.Duration(ttl)*time.Second).Err(); err != nil {
+		utils.Log.Error("caching error", "error", err)
 		return false
 	}
 	return true
@@ -38,11 +41,30 @@ func (rc *RedisCache) Set(key, value string, ttl int32) bool {
 
 func (rc *RedisCache) Get(key string) string {
 	if item, err := rc.redisClient.Get(rc.ctx, rc.prefix+key).Result(); err != nil {
-		log.Printf("Caching error : %v", err)
-		return ""
+		utils.Log.Error("caching error", "error", err)
+		return countGet("redis", "")
 	} else {
-		return item
+		return countGet("redis", item)
+	}
+}
+
+// Eval runs a Lua script on the prefixed keys, for the atomic operations shared across instances
+func (rc *RedisCache) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	prefixedKeys := make([]string, len(keys))
+	for i, key := range keys {
+		prefixedKeys[i] = rc.prefix + key
 	}
+	return rc.redisClient.Eval(rc.ctx, script, prefixedKeys, args...).Result()
+}
+
+// Ping returns the duration in milliseconds of a PING command, -1 if the server is unreachable
+func (rc *RedisCache) Ping() int {
+	start := time.Now()
+	if err := rc.redisClient.Ping(rc.ctx).Err(); err != nil {
+		utils.Log.Error("caching error", "error", err)
+		return -1
+	}
+	return int(time.Since(start).Milliseconds())
 }
 
 func (rc *RedisCache) Clear() bool {
@@ -51,3 +73,33 @@ func (rc *RedisCache) Clear() bool {
 	}
 	return true
 }
+
+func (rc *RedisCache) Delete(key string) bool {
+	if err := rc.redisClient.Del(rc.ctx, rc.prefix+key).Err(); err != nil {
+		utils.Log.Error("caching error", "error", err)
+		return false
+	}
+	return true
+}
+
+func (rc *RedisCache) Close() error {
+	if rc == nil {
+		return nil
+	}
+	return rc.redisClient.Close()
+}
+
+// Keys scans the keys of the prefix, the database being possibly shared
+func (rc *RedisCache) Keys() []string {
+	keys := []string{}
+	iter := rc.redisClient.Scan(rc.ctx, 0, rc.prefix+"*", 100).Iterator()
+	for iter.Next(rc.ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), rc.prefix))
+	}
+	if err := iter.Err(); err != nil {
+		utils.Log.Error("caching error", "error", err)
+		return nil
+	}
+	sort.Strings(keys)
+	return keys
+}
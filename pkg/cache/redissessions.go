@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// RedisSessionBackend keeps the server side sessions in Redis, configured as the Redis cache
+type RedisSessionBackend struct {
+	prefix      string
+	redisClient *redis.Client
+	ctx         context.Context
+}
+
+func NewRedisSessionBackend(prefix, config string) *RedisSessionBackend {
+	var redisConfig *redis.Options
+	if err := json.Unmarshal([]byte(config), &redisConfig); err != nil {
+		utils.Log.Error("unable to load the Redis configuration", "error", err)
+		return nil
+	}
+	return &RedisSessionBackend{prefix: prefix, redisClient: redis.NewClient(redisConfig), ctx: context.Background()}
+}
+
+func (rsb *RedisSessionBackend) Load(id string) (string, error) {
+	data, err := rsb.redisClient.Get(rsb.ctx, rsb.prefix+id).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return data, err
+}
+
+func (rsb *RedisSessionBackend) Save(id, data string, maxAge int) error {
+	return rsb.redisClient.Set(rsb.ctx, rsb.prefix+id, data, time.Duration(maxAge)*time.Second).Err()
+}
+
+func (rsb *RedisSessionBackend) Delete(id string) (int64, error) {
+	return rsb.redisClient.Del(rsb.ctx, rsb.prefix+id).Result()
+}
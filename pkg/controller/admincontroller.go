@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/dranih/go-crud-api/pkg/cache"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// AdminController returns the state of the running instance and reloads its reflection, for the admin users only
+type AdminController struct {
+	reflection  *database.ReflectionService
+	cache       cache.Cache
+	responder   Responder
+	admins      map[string]bool
+	userColumn  string
+	config      map[string]interface{}
+	middlewares []string
+	cacheType   string
+}
+
+// NewAdminController takes the admin users as "middleware:name" (see utils.SessionIdentity), the column holding
+// the name of the dbAuth and apiKeyDbAuth users, the redacted configuration and the middleware chain to return
+func NewAdminController(router *mux.Router, responder Responder, reflection *database.ReflectionService, cache cache.Cache, admins map[string]bool, userColumn string, config map[string]interface{}, middlewares []string, cacheType string) *AdminController {
+	ac := &AdminController{reflection, cache, responder, admins, userColumn, config, middlewares, cacheType}
+	router.HandleFunc("/admin", ac.admin(ac.info)).Methods("GET")
+	router.HandleFunc("/admin/reflection/reload", ac.admin(ac.reload)).Methods("POST")
+	router.HandleFunc("/admin/cache/purge/{table}", ac.admin(ac.purge)).Methods("POST")
+	return ac
+}
+
+// admin allows the request if the user authenticated by the middlewares is an admin user of this middleware
+func (ac *AdminController) admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source, user := utils.SessionIdentity(w, r, ac.userColumn)
+		if user == "" {
+			ac.responder.Error(record.AUTHENTICATION_REQUIRED, "", w, "")
+			return
+		}
+		if !ac.admins[source+":"+user] {
+			ac.responder.Error(record.OPERATION_FORBIDDEN, "", w, "")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (ac *AdminController) info(w http.ResponseWriter, r *http.Request) {
+	cacheInfo := map[string]interface{}{"type": ac.cacheType}
+	// memcache is not able to list its keys
+	if keys := ac.cache.Keys(); keys != nil {
+		cacheInfo["keys"] = keys
+	}
+	result := map[string]interface{}{
+		"config":      ac.config,
+		"middlewares": ac.middlewares,
+		"cache":       cacheInfo,
+		"reflection":  ac.reflection.Status(),
+	}
+	ac.responder.Success(result, w)
+}
+
+// reload reads again the reflection of the database, ex : after a migration
+func (ac *AdminController) reload(w http.ResponseWriter, r *http.Request) {
+	ac.reflection.Reload()
+	ac.responder.Success(ac.reflection.Status(), w)
+}
+
+// purge removes the reflection of a table from the cache, the table being read again from the database on its next use
+func (ac *AdminController) purge(w http.ResponseWriter, r *http.Request) {
+	tableName := mux.Vars(r)["table"]
+	if !ac.reflection.PurgeTable(tableName) {
+		ac.responder.Error(record.TABLE_NOT_FOUND, tableName, w, "")
+		return
+	}
+	ac.responder.Success(true, w)
+}
@@ -29,33 +29,44 @@ func NewColumnController(router *mux.Router, responder Responder, reflection *da
 	return cc
 }
 
+// requestReflection returns the reflection of the request if a middleware scoped one, the reflection of the api otherwise
+func (cc *ColumnController) requestReflection(r *http.Request) *database.ReflectionService {
+	if reflection := database.ReflectionFromContext(r.Context()); reflection != nil {
+		return reflection
+	}
+	return cc.reflection
+}
+
 func (cc *ColumnController) getDatabase(w http.ResponseWriter, r *http.Request) {
+	reflection := cc.requestReflection(r)
 	tables := []*database.ReflectedTable{}
-	for _, table := range cc.reflection.GetTableNames() {
-		tables = append(tables, cc.reflection.GetTable(table))
+	for _, table := range reflection.GetTableNames() {
+		tables = append(tables, reflection.GetTable(table))
 	}
 	database := map[string][]*database.ReflectedTable{"tables": tables}
 	cc.responder.Success(database, w)
 }
 
 func (cc *ColumnController) getTable(w http.ResponseWriter, r *http.Request) {
+	reflection := cc.requestReflection(r)
 	tableName := mux.Vars(r)["table"]
-	if !cc.reflection.HasTable(tableName) {
+	if !reflection.HasTable(tableName) {
 		cc.responder.Error(record.TABLE_NOT_FOUND, tableName, w, "")
 		return
 	}
-	table := cc.reflection.GetTable(tableName)
+	table := reflection.GetTable(tableName)
 	cc.responder.Success(table, w)
 }
 
 func (cc *ColumnController) getColumn(w http.ResponseWriter, r *http.Request) {
+	reflection := cc.requestReflection(r)
 	tableName := mux.Vars(r)["table"]
 	columnName := mux.Vars(r)["column"]
-	if !cc.reflection.HasTable(tableName) {
+	if !reflection.HasTable(tableName) {
 		cc.responder.Error(record.TABLE_NOT_FOUND, tableName, w, "")
 		return
 	}
-	table := cc.reflection.GetTable(tableName)
+	table := reflection.GetTable(tableName)
 	if !table.HasColumn(columnName) {
 		cc.responder.Error(record.COLUMN_NOT_FOUND, columnName, w, "")
 		return
@@ -65,8 +76,9 @@ func (cc *ColumnController) getColumn(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cc *ColumnController) updateTable(w http.ResponseWriter, r *http.Request) {
+	reflection := cc.requestReflection(r)
 	tableName := mux.Vars(r)["table"]
-	if !cc.reflection.HasTable(tableName) {
+	if !reflection.HasTable(tableName) {
 		cc.responder.Error(record.TABLE_NOT_FOUND, tableName, w, "")
 		return
 	}
@@ -83,13 +95,14 @@ func (cc *ColumnController) updateTable(w http.ResponseWriter, r *http.Request)
 }
 
 func (cc *ColumnController) updateColumn(w http.ResponseWriter, r *http.Request) {
+	reflection := cc.requestReflection(r)
 	tableName := mux.Vars(r)["table"]
 	columnName := mux.Vars(r)["column"]
-	if !cc.reflection.HasTable(tableName) {
+	if !reflection.HasTable(tableName) {
 		cc.responder.Error(record.TABLE_NOT_FOUND, tableName, w, "")
 		return
 	}
-	table := cc.reflection.GetTable(tableName)
+	table := reflection.GetTable(tableName)
 	if !table.HasColumn(columnName) {
 		cc.responder.Error(record.COLUMN_NOT_FOUND, columnName, w, "")
 		return
@@ -107,6 +120,7 @@ func (cc *ColumnController) updateColumn(w http.ResponseWriter, r *http.Request)
 }
 
 func (cc *ColumnController) addTable(w http.ResponseWriter, r *http.Request) {
+	reflection := cc.requestReflection(r)
 	jsonMap, err := utils.GetBodyMapData(r)
 	if err != nil {
 		cc.responder.Error(record.HTTP_MESSAGE_NOT_READABLE, "", w, "")
@@ -114,7 +128,7 @@ func (cc *ColumnController) addTable(w http.ResponseWriter, r *http.Request) {
 	}
 	if tableNameI, ok := jsonMap["name"]; ok {
 		if tableName, ok := tableNameI.(string); ok {
-			if cc.reflection.HasTable(tableName) {
+			if reflection.HasTable(tableName) {
 				cc.responder.Error(record.TABLE_ALREADY_EXISTS, tableName, w, "")
 				return
 			}
@@ -134,8 +148,9 @@ func (cc *ColumnController) addTable(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cc *ColumnController) addColumn(w http.ResponseWriter, r *http.Request) {
+	reflection := cc.requestReflection(r)
 	tableName := mux.Vars(r)["table"]
-	if !cc.reflection.HasTable(tableName) {
+	if !reflection.HasTable(tableName) {
 		cc.responder.Error(record.TABLE_NOT_FOUND, tableName, w, "")
 		return
 	}
@@ -144,7 +159,7 @@ func (cc *ColumnController) addColumn(w http.ResponseWriter, r *http.Request) {
 		cc.responder.Error(record.HTTP_MESSAGE_NOT_READABLE, "", w, "")
 		return
 	}
-	table := cc.reflection.GetTable(tableName)
+	table := reflection.GetTable(tableName)
 	if columnNameI, ok := jsonMap["name"]; ok {
 		if columnName, ok := columnNameI.(string); ok {
 			if table.HasColumn(columnName) {
@@ -167,8 +182,9 @@ func (cc *ColumnController) addColumn(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cc *ColumnController) removeTable(w http.ResponseWriter, r *http.Request) {
+	reflection := cc.requestReflection(r)
 	tableName := mux.Vars(r)["table"]
-	if !cc.reflection.HasTable(tableName) {
+	if !reflection.HasTable(tableName) {
 		cc.responder.Error(record.TABLE_NOT_FOUND, tableName, w, "")
 		return
 	}
@@ -180,13 +196,14 @@ func (cc *ColumnController) removeTable(w http.ResponseWriter, r *http.Request)
 }
 
 func (cc *ColumnController) removeColumn(w http.ResponseWriter, r *http.Request) {
+	reflection := cc.requestReflection(r)
 	tableName := mux.Vars(r)["table"]
 	columnName := mux.Vars(r)["column"]
-	if !cc.reflection.HasTable(tableName) {
+	if !reflection.HasTable(tableName) {
 		cc.responder.Error(record.TABLE_NOT_FOUND, tableName, w, "")
 		return
 	}
-	table := cc.reflection.GetTable(tableName)
+	table := reflection.GetTable(tableName)
 	if !table.HasColumn(columnName) {
 		cc.responder.Error(record.COLUMN_NOT_FOUND, columnName, w, "")
 		return
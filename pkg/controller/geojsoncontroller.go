@@ -24,12 +24,13 @@ func NewGeoJsonController(router *mux.Router, responder Responder, service *geoj
 
 func (gc *GeoJsonController) list(w http.ResponseWriter, r *http.Request) {
 	table := mux.Vars(r)["table"]
+	service := gc.service.WithContext(r.Context())
 	params := utils.GetRequestParams(r)
-	if !gc.service.HasTable(table) {
+	if !service.HasTable(table) {
 		gc.responder.Error(record.TABLE_NOT_FOUND, table, w, "")
 		return
 	}
-	if result, err := gc.service.List(table, params); err != nil {
+	if result, err := service.List(table, params); err != nil {
 		gc.responder.Exception(err, w)
 	} else {
 		gc.responder.Success(result, w)
@@ -38,11 +39,12 @@ func (gc *GeoJsonController) list(w http.ResponseWriter, r *http.Request) {
 
 func (gc *GeoJsonController) read(w http.ResponseWriter, r *http.Request) {
 	table := mux.Vars(r)["table"]
-	if !gc.service.HasTable(table) {
+	service := gc.service.WithContext(r.Context())
+	if !service.HasTable(table) {
 		gc.responder.Error(record.TABLE_NOT_FOUND, table, w, "")
 		return
 	}
-	if gc.service.GetType(table) != "table" {
+	if service.GetType(table) != "table" {
 		gc.responder.Error(record.OPERATION_NOT_SUPPORTED, "read", w, "")
 		return
 	}
@@ -55,7 +57,7 @@ func (gc *GeoJsonController) read(w http.ResponseWriter, r *http.Request) {
 			features []*geojson.Feature
 		}{"FeatureCollection", nil}
 		for i := 0; i < len(ids); i++ {
-			if f, err := gc.service.Read(table, ids[i], params); err != nil {
+			if f, err := service.Read(table, ids[i], params); err != nil {
 				gc.responder.Exception(err, w)
 				return
 			} else {
@@ -65,7 +67,7 @@ func (gc *GeoJsonController) read(w http.ResponseWriter, r *http.Request) {
 		gc.responder.Success(results, w)
 		return
 	} else {
-		if response, err := gc.service.Read(table, id, params); err != nil {
+		if response, err := service.Read(table, id, params); err != nil {
 			gc.responder.Exception(err, w)
 			return
 		} else {
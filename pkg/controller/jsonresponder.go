@@ -1,10 +1,11 @@
 package controller
 
 import (
-	"log"
+	"fmt"
 	"net/http"
 
 	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type JsonResponder struct {
@@ -18,6 +19,8 @@ func NewJsonResponder(debug bool) *JsonResponder {
 
 func (jr *JsonResponder) Error(errorCode int, argument string, w http.ResponseWriter, details interface{}) http.ResponseWriter {
 	document := record.NewErrorDocument(record.NewErrorCode(errorCode), argument, details)
+	// the request id is set in the response headers by the accessLog middleware
+	document.SetRequestId(w.Header().Get("X-Request-Id"))
 	return jr.rf.FromObject(document.GetStatus(), document, w)
 }
 
@@ -27,10 +30,12 @@ func (jr *JsonResponder) Success(result interface{}, w http.ResponseWriter) http
 
 func (jr *JsonResponder) Exception(err error, w http.ResponseWriter) http.ResponseWriter {
 	document := record.NewErrorDocumentFromError(err, jr.debug)
+	requestId := w.Header().Get("X-Request-Id")
+	document.SetRequestId(requestId)
 	if jr.debug {
 		addExceptionHeaders(w, err)
-		log.Printf("Error : %s", err.Error())
 	}
+	utils.Log.Error(err.Error(), "requestId", requestId, "exception", fmt.Sprintf("%T", err))
 	response := jr.rf.FromObject(document.GetStatus(), document, w)
 	return response
 }
@@ -2,9 +2,10 @@ package controller
 
 import (
 	"database/sql"
-	"log"
+	"math/rand"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/dranih/go-crud-api/pkg/record"
 	"github.com/dranih/go-crud-api/pkg/utils"
@@ -30,13 +31,14 @@ func NewRecordController(router *mux.Router, responder Responder, service *recor
 // List function lists a table
 // Should return err error
 func (rc *RecordController) list(w http.ResponseWriter, r *http.Request) {
+	service := rc.service.WithContext(r.Context())
 	table := mux.Vars(r)["table"]
 	params := utils.GetRequestParams(r)
-	if !rc.service.HasTable(table) {
+	if !service.HasTable(table) {
 		rc.responder.Error(record.TABLE_NOT_FOUND, table, w, "")
 		return
 	}
-	result := rc.service.List(table, params)
+	result := service.List(table, params)
 	rc.responder.Success(result, w)
 }
 
@@ -46,10 +48,44 @@ type argumentList struct {
 	params  map[string][]string
 }
 
+// clone returns a deep copy of the arguments, the payload being changed by the callbacks
+// (ex : sanitized, encrypted or completed with the auto columns)
+func (al *argumentList) clone() *argumentList {
+	payload := make([]interface{}, len(al.payload))
+	for i, value := range al.payload {
+		payload[i] = copyValue(value)
+	}
+	params := map[string][]string{}
+	for key, values := range al.params {
+		params[key] = append([]string{}, values...)
+	}
+	return &argumentList{al.table, payload, params}
+}
+
+// copyValue returns a deep copy of the maps and the slices of a decoded request body
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	}
+	return value
+}
+
 // Should return err error
 func (rc *RecordController) read(w http.ResponseWriter, r *http.Request) {
+	service := rc.service.WithContext(r.Context())
 	table := mux.Vars(r)["table"]
-	if !rc.service.HasTable(table) {
+	if !service.HasTable(table) {
 		rc.responder.Error(record.TABLE_NOT_FOUND, table, w, "")
 		return
 	}
@@ -61,11 +97,11 @@ func (rc *RecordController) read(w http.ResponseWriter, r *http.Request) {
 		for i := 0; i < len(ids); i++ {
 			argumentLists = append(argumentLists, &argumentList{table, []interface{}{ids[i]}, params})
 		}
-		result, errs := rc.multiCall(rc.service.Read, argumentLists)
+		result, errs := rc.multiCall(service.Read, argumentLists)
 		rc.responder.Multi(result, errs, w)
 		return
 	} else {
-		response, err := rc.service.Read(nil, table, params, id)
+		response, err := service.Read(nil, table, params, id)
 		if response == nil || err != nil {
 			rc.responder.Error(record.RECORD_NOT_FOUND, id, w, "")
 			return
@@ -74,40 +110,91 @@ func (rc *RecordController) read(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// multiCall runs the callbacks in a single transaction
+// The whole transaction is replayed with an exponential backoff when it fails with a deadlock or a serialization failure,
+// each attempt running on a copy of the arguments
 func (rc *RecordController) multiCall(callback func(*sql.Tx, string, map[string][]string, ...interface{}) (interface{}, error), argumentLists []*argumentList) (*[]interface{}, []error) {
+	var result *[]interface{}
+	var errs []error
+	retries, backoff := rc.service.GetTransactionRetries()
+	retryTransaction(retries, backoff, func() bool {
+		var retryable bool
+		attemptLists := make([]*argumentList, len(argumentLists))
+		for i, arguments := range argumentLists {
+			attemptLists[i] = arguments.clone()
+		}
+		result, errs, retryable = rc.multiCallOnce(callback, attemptLists)
+		return retryable
+	})
+	return result, errs
+}
+
+// retryTransaction calls run until it returns false (not retryable) or the retries are exhausted,
+// waiting between the attempts a backoff doubled on each attempt plus a random jitter
+func retryTransaction(retries int, backoff time.Duration, run func() bool) {
+	for attempt := 0; ; attempt++ {
+		if !run() || attempt >= retries {
+			return
+		}
+		delay := backoff << uint(attempt)
+		if backoff > 0 {
+			delay += time.Duration(rand.Int63n(int64(backoff)))
+		}
+		utils.Log.Warning("transaction failed with a retryable error, retrying", "delay", delay, "attempt", attempt+1, "retries", retries)
+		time.Sleep(delay)
+	}
+}
+
+func (rc *RecordController) multiCallOnce(callback func(*sql.Tx, string, map[string][]string, ...interface{}) (interface{}, error), argumentLists []*argumentList) (*[]interface{}, []error, bool) {
 	result := []interface{}{}
 	var errs []error
 	success := true
-	tx, _ := rc.service.BeginTransaction()
+	retryable := false
+	tx, err := rc.service.BeginTransaction()
+	if err != nil {
+		utils.Log.Error("unable to begin transaction", "error", err)
+		for range argumentLists {
+			result = append(result, nil)
+			errs = append(errs, err)
+		}
+		return &result, errs, rc.service.IsRetryableError(err)
+	}
 	for _, arguments := range argumentLists {
 		if tmp_result, err := callback(tx, arguments.table, arguments.params, arguments.payload...); err == nil {
 			result = append(result, tmp_result)
 			errs = append(errs, nil)
 		} else {
 			success = false
+			retryable = retryable || rc.service.IsRetryableError(err)
 			result = append(result, nil)
 			errs = append(errs, err)
 		}
 	}
 	if success {
 		if err := rc.service.CommitTransaction(tx); err != nil {
-			log.Printf("ERROR : unable to commit transaction : %s", err.Error())
+			utils.Log.Error("unable to commit transaction", "error", err)
+			for i := range errs {
+				result[i] = nil
+				errs[i] = err
+			}
+			retryable = rc.service.IsRetryableError(err)
 		}
 	} else {
 		if err := rc.service.RollBackTransaction(tx); err != nil {
-			log.Printf("ERROR : unable to rollback transaction : %s", err.Error())
+			utils.Log.Error("unable to rollback transaction", "error", err)
 		}
 	}
-	return &result, errs
+	return &result, errs, retryable
 }
 
 func (rc *RecordController) create(w http.ResponseWriter, r *http.Request) {
+	service := rc.service.WithContext(r.Context())
 	table := mux.Vars(r)["table"]
-	if !rc.service.HasTable(table) {
+	if !service.HasTable(table) {
 		rc.responder.Error(record.TABLE_NOT_FOUND, table, w, "")
 		return
 	}
-	if rc.service.GetType(table) != "table" {
+	if service.GetType(table) != "table" {
 		rc.responder.Error(record.OPERATION_NOT_SUPPORTED, "create", w, "")
 		return
 	}
@@ -124,11 +211,11 @@ func (rc *RecordController) create(w http.ResponseWriter, r *http.Request) {
 		for _, record := range records {
 			argumentLists = append(argumentLists, &argumentList{table, []interface{}{record}, params})
 		}
-		result, errs := rc.multiCall(rc.service.Create, argumentLists)
+		result, errs := rc.multiCall(service.Create, argumentLists)
 		rc.responder.Multi(result, errs, w)
 		return
 	} else {
-		response, err := rc.service.Create(nil, table, params, jsonMap)
+		response, err := service.Create(nil, table, params, jsonMap)
 		if response == nil || err != nil {
 			rc.responder.Exception(err, w)
 			return
@@ -138,12 +225,13 @@ func (rc *RecordController) create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (rc *RecordController) update(w http.ResponseWriter, r *http.Request) {
+	service := rc.service.WithContext(r.Context())
 	table := mux.Vars(r)["table"]
-	if !rc.service.HasTable(table) {
+	if !service.HasTable(table) {
 		rc.responder.Error(record.TABLE_NOT_FOUND, table, w, "")
 		return
 	}
-	if rc.service.GetType(table) != "table" {
+	if service.GetType(table) != "table" {
 		rc.responder.Error(record.OPERATION_NOT_SUPPORTED, "update", w, "")
 		return
 	}
@@ -165,7 +253,7 @@ func (rc *RecordController) update(w http.ResponseWriter, r *http.Request) {
 		for i := 0; i < len(ids); i++ {
 			argumentLists = append(argumentLists, &argumentList{table, []interface{}{ids[i], records[i]}, params})
 		}
-		result, errs := rc.multiCall(rc.service.Update, argumentLists)
+		result, errs := rc.multiCall(service.Update, argumentLists)
 		rc.responder.Multi(result, errs, w)
 		return
 	} else {
@@ -173,7 +261,7 @@ func (rc *RecordController) update(w http.ResponseWriter, r *http.Request) {
 			rc.responder.Error(record.ARGUMENT_COUNT_MISMATCH, id, w, "")
 			return
 		}
-		response, err := rc.service.Update(nil, table, params, id, jsonMap)
+		response, err := service.Update(nil, table, params, id, jsonMap)
 		if response == nil || err != nil {
 			rc.responder.Exception(err, w)
 			return
@@ -183,12 +271,13 @@ func (rc *RecordController) update(w http.ResponseWriter, r *http.Request) {
 }
 
 func (rc *RecordController) delete(w http.ResponseWriter, r *http.Request) {
+	service := rc.service.WithContext(r.Context())
 	table := mux.Vars(r)["table"]
-	if !rc.service.HasTable(table) {
+	if !service.HasTable(table) {
 		rc.responder.Error(record.TABLE_NOT_FOUND, table, w, "")
 		return
 	}
-	if rc.service.GetType(table) != "table" {
+	if service.GetType(table) != "table" {
 		rc.responder.Error(record.OPERATION_NOT_SUPPORTED, "delete", w, "")
 		return
 	}
@@ -200,11 +289,11 @@ func (rc *RecordController) delete(w http.ResponseWriter, r *http.Request) {
 		for i := 0; i < len(ids); i++ {
 			argumentLists = append(argumentLists, &argumentList{table, []interface{}{ids[i]}, params})
 		}
-		result, errs := rc.multiCall(rc.service.Delete, argumentLists)
+		result, errs := rc.multiCall(service.Delete, argumentLists)
 		rc.responder.Multi(result, errs, w)
 		return
 	} else {
-		response, err := rc.service.Delete(nil, table, params, id)
+		response, err := service.Delete(nil, table, params, id)
 		if response == nil || err != nil {
 			rc.responder.Exception(err, w)
 			return
@@ -214,12 +303,13 @@ func (rc *RecordController) delete(w http.ResponseWriter, r *http.Request) {
 }
 
 func (rc *RecordController) increment(w http.ResponseWriter, r *http.Request) {
+	service := rc.service.WithContext(r.Context())
 	table := mux.Vars(r)["table"]
-	if !rc.service.HasTable(table) {
+	if !service.HasTable(table) {
 		rc.responder.Error(record.TABLE_NOT_FOUND, table, w, "")
 		return
 	}
-	if rc.service.GetType(table) != "table" {
+	if service.GetType(table) != "table" {
 		rc.responder.Error(record.OPERATION_NOT_SUPPORTED, "update", w, "")
 		return
 	}
@@ -241,7 +331,7 @@ func (rc *RecordController) increment(w http.ResponseWriter, r *http.Request) {
 		for i := 0; i < len(ids); i++ {
 			argumentLists = append(argumentLists, &argumentList{table, []interface{}{ids[i], records[i]}, params})
 		}
-		result, errs := rc.multiCall(rc.service.Increment, argumentLists)
+		result, errs := rc.multiCall(service.Increment, argumentLists)
 		rc.responder.Multi(result, errs, w)
 		return
 	} else {
@@ -249,7 +339,7 @@ func (rc *RecordController) increment(w http.ResponseWriter, r *http.Request) {
 			rc.responder.Error(record.ARGUMENT_COUNT_MISMATCH, id, w, "")
 			return
 		}
-		response, err := rc.service.Increment(nil, table, params, id, jsonMap)
+		response, err := service.Increment(nil, table, params, id, jsonMap)
 		if response == nil || err != nil {
 			rc.responder.Exception(err, w)
 			return
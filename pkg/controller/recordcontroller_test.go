@@ -1,16 +1,20 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dranih/go-crud-api/pkg/cache"
 	"github.com/dranih/go-crud-api/pkg/database"
 	"github.com/dranih/go-crud-api/pkg/record"
 	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 )
 
@@ -150,3 +154,210 @@ func TestRecordController(t *testing.T) {
 		panic(err)
 	}
 }
+
+func TestRecordControllerEncryption(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api",
+	)
+	defer db.PDO().CloseConn()
+	if _, err := db.PDO().Exec(nil, `ALTER TABLE "users" ADD COLUMN "api_key_bidx" varchar(64) NULL`); err != nil {
+		t.Fatalf("Unable to add the blind index column : %s", err.Error())
+	}
+	key1 := []byte("0123456789abcdef0123456789abcdef")
+	key2 := []byte("fedcba9876543210fedcba9876543210")
+	indexKey := []byte("blindIndexKeyForTheTests")
+	columns := map[string]string{"users.api_key": "api_key_bidx"}
+	encryptor, err := database.NewColumnEncryptor(map[string][]byte{"k1": key1}, "k1", indexKey, columns)
+	if err != nil {
+		t.Fatalf("Unable to create the encryptor : %s", err.Error())
+	}
+	db.SetEncryptor(encryptor)
+	reflection := database.NewReflectionService(db, nil, 0)
+	records := record.NewRecordService(db, reflection)
+	responder := NewJsonResponder(false)
+	router := mux.NewRouter()
+	NewRecordController(router, responder, records)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	storedApiKey := func(id int) string {
+		stored, err := db.PDO().QueryRowSingleColumn(nil, `SELECT "api_key" FROM "users" WHERE "id" = ?`, id)
+		if err != nil {
+			t.Fatalf("Unable to read the stored value : %s", err.Error())
+		}
+		if b, ok := stored.([]byte); ok {
+			return string(b)
+		}
+		return fmt.Sprint(stored)
+	}
+
+	tt := []utils.Test{
+		{
+			Name:       "read value not encrypted yet",
+			Method:     http.MethodGet,
+			Uri:        "/records/users/1?include=id,api_key",
+			Want:       `{"api_key":"123456789abc","id":1}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "create encrypted",
+			Method:     http.MethodPost,
+			Uri:        "/records/users",
+			Body:       `{"username":"user3","password":"pass3","api_key":"secret-key-3","api_key_bidx":"ignored"}`,
+			Want:       `3`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "read decrypted without blind index",
+			Method:     http.MethodGet,
+			Uri:        "/records/users/3",
+			Want:       `{"api_key":"secret-key-3","id":3,"location":null,"password":"pass3","username":"user3"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "filter eq with blind index",
+			Method:     http.MethodGet,
+			Uri:        "/records/users?filter=api_key,eq,secret-key-3&include=id",
+			Want:       `{"records":[{"id":3}]}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "filter in with blind index",
+			Method:     http.MethodGet,
+			Uri:        "/records/users?filter=api_key,in,unknown,secret-key-3&include=id",
+			Want:       `{"records":[{"id":3}]}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "filter on ciphertext never matches",
+			Method:     http.MethodGet,
+			Uri:        "/records/users?filter=api_key,cs,enc&include=id",
+			Want:       `{"records":[]}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "update encrypted",
+			Method:     http.MethodPut,
+			Uri:        "/records/users/3",
+			Body:       `{"api_key":"other-key-3"}`,
+			Want:       `1`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "filter updated value",
+			Method:     http.MethodGet,
+			Uri:        "/records/users?filter=api_key,eq,other-key-3&include=id,api_key",
+			Want:       `{"records":[{"api_key":"other-key-3","id":3}]}`,
+			StatusCode: http.StatusOK,
+		},
+	}
+	utils.RunTests(t, ts.URL, tt)
+	if stored := storedApiKey(3); !strings.HasPrefix(stored, "enc:k1:") || strings.Contains(stored, "other-key-3") {
+		t.Errorf("Value stored in clear or with the wrong key : %s", stored)
+	}
+
+	// rotate the key then encrypt again the existing values, including the one not encrypted yet
+	encryptor, err = database.NewColumnEncryptor(map[string][]byte{"k1": key1, "k2": key2}, "k2", indexKey, columns)
+	if err != nil {
+		t.Fatalf("Unable to create the encryptor : %s", err.Error())
+	}
+	db.SetEncryptor(encryptor)
+	if count, err := db.ReencryptTable(reflection.GetTable("users"), 1); err != nil || count != 2 {
+		t.Errorf("Encrypt again : got %d updated records (error %v), want 2", count, err)
+	}
+	if count, err := db.ReencryptTable(reflection.GetTable("users"), 1); err != nil || count != 0 {
+		t.Errorf("Encrypt again twice : got %d updated records (error %v), want 0", count, err)
+	}
+	for _, id := range []int{1, 3} {
+		if stored := storedApiKey(id); !strings.HasPrefix(stored, "enc:k2:") {
+			t.Errorf("Value of user %d not encrypted with the new key : %s", id, stored)
+		}
+	}
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "filter value encrypted again",
+			Method:     http.MethodGet,
+			Uri:        "/records/users?filter=api_key,eq,123456789abc&include=id,api_key",
+			Want:       `{"records":[{"api_key":"123456789abc","id":1}]}`,
+			StatusCode: http.StatusOK,
+		},
+	})
+
+	// without the key, the values are marked as undecryptable and cannot be written back
+	encryptor, err = database.NewColumnEncryptor(map[string][]byte{"k1": key1}, "k1", indexKey, columns)
+	if err != nil {
+		t.Fatalf("Unable to create the encryptor : %s", err.Error())
+	}
+	db.SetEncryptor(encryptor)
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "read value with an unknown key",
+			Method:     http.MethodGet,
+			Uri:        "/records/users/1?include=id,api_key",
+			Want:       `{"api_key":"enc:undecryptable","id":1}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "write back an undecryptable value",
+			Method:     http.MethodPut,
+			Uri:        "/records/users/1",
+			Body:       `{"api_key":"enc:undecryptable"}`,
+			Want:       `{"code":9999,"message":"the value of column 'api_key' of table 'users' cannot be decrypted"}`,
+			StatusCode: http.StatusInternalServerError,
+		},
+	})
+	if stored := storedApiKey(1); !strings.HasPrefix(stored, "enc:k2:") {
+		t.Errorf("Want the undecryptable value kept, got %s", stored)
+	}
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
+
+func TestRetryTransaction(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	tt := []struct {
+		name     string
+		errs     []error
+		retries  int
+		attempts int
+	}{
+		{"no error", []error{nil}, 3, 1},
+		{"deadlock then success", []error{deadlock, nil}, 3, 2},
+		{"deadlock until the retries are exhausted", []error{deadlock, deadlock, deadlock, deadlock, deadlock}, 3, 4},
+		{"not retryable", []error{errors.New("syntax error"), nil}, 3, 1},
+		{"no retry", []error{deadlock, nil}, 0, 1},
+	}
+	for _, tc := range tt {
+		attempts := 0
+		retryTransaction(tc.retries, time.Millisecond, func() bool {
+			err := tc.errs[attempts]
+			attempts++
+			return database.IsRetryableError(err)
+		})
+		if attempts != tc.attempts {
+			t.Errorf("%s : got %d attempts, want %d", tc.name, attempts, tc.attempts)
+		}
+	}
+}
+
+func TestArgumentListClone(t *testing.T) {
+	arguments := &argumentList{"comments", []interface{}{map[string]interface{}{"message": "hello", "tags": []interface{}{"a"}}}, map[string][]string{"include": {"id"}}}
+	// a failed attempt changes its copy of the arguments
+	attempt := arguments.clone()
+	record := attempt.payload[0].(map[string]interface{})
+	record["message"] = "sanitized"
+	record["tags"].([]interface{})[0] = "b"
+	attempt.params["include"][0] = "message"
+	if got := fmt.Sprint(arguments.clone().payload, arguments.params); got != "[map[message:hello tags:[a]]] map[include:[id]]" {
+		t.Errorf("Want the arguments unchanged by the attempt, got %s", got)
+	}
+}
@@ -3,8 +3,9 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
-	"log"
 	"net/http"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type ResponseFactory struct{}
@@ -34,7 +35,7 @@ func (rf *ResponseFactory) From(status int, contentType string, content []byte,
 	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
 	w.WriteHeader(status)
 	if _, err := w.Write(content); err != nil {
-		log.Printf("ERROR : unable to write response : %s", err.Error())
+		utils.Log.Error("unable to write response", "error", err)
 	}
 	return w
 }
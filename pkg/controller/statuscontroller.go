@@ -1,19 +1,76 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dranih/go-crud-api/pkg/cache"
 	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/utils"
 	"github.com/gorilla/mux"
 )
 
 type StatusController struct {
-	db        *database.GenericDB
-	cache     cache.Cache
-	responder Responder
+	db           *database.GenericDB
+	cache        cache.Cache
+	responder    Responder
+	checks       map[string]func(ctx context.Context) error
+	timeout      time.Duration
+	shuttingDown int32
+	reloads      *ReloadStatus
+}
+
+// ReloadStatus keeps the result of the configuration reloads, shared by the status controllers of the successive configurations
+type ReloadStatus struct {
+	mutex     sync.Mutex
+	loadedAt  time.Time
+	reloads   int
+	failures  int
+	lastError string
+	lastAt    time.Time
+}
+
+func NewReloadStatus() *ReloadStatus {
+	return &ReloadStatus{loadedAt: time.Now()}
+}
+
+// Record counts a reload, failed if err is not nil
+func (rs *ReloadStatus) Record(err error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.lastAt = time.Now()
+	result := "success"
+	if err != nil {
+		rs.failures++
+		rs.lastError = err.Error()
+		result = "failure"
+	} else {
+		rs.reloads++
+		rs.loadedAt = rs.lastAt
+		rs.lastError = ""
+	}
+	utils.Metrics.AddCounter("gocrudapi_config_reloads_total", "Number of configuration reloads by result", 1, "result", result)
+}
+
+func (rs *ReloadStatus) Serialize() map[string]interface{} {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	result := map[string]interface{}{"loadedAt": rs.loadedAt.Format(time.RFC3339), "reloads": rs.reloads, "failures": rs.failures}
+	if !rs.lastAt.IsZero() {
+		result["lastReloadAt"] = rs.lastAt.Format(time.RFC3339)
+		result["lastReloadStatus"] = "success"
+		if rs.lastError != "" {
+			result["lastReloadStatus"] = "failure"
+			result["lastReloadError"] = rs.lastError
+		}
+	}
+	return result
 }
 
 func NewStatusController(router *mux.Router, responder Responder, lcache cache.Cache, db *database.GenericDB) *StatusController {
@@ -21,12 +78,133 @@ func NewStatusController(router *mux.Router, responder Responder, lcache cache.C
 		prefix := fmt.Sprintf("gocrudapi-%d-", os.Getpid())
 		lcache = cache.Create("TempFile", prefix, "")
 	}
-	sc := &StatusController{db, lcache, responder}
+	sc := &StatusController{db, lcache, responder, map[string]func(ctx context.Context) error{}, 2 * time.Second, 0, NewReloadStatus()}
+	sc.AddCheck("db", db.PingContext)
+	sc.AddCheck("cache", func(ctx context.Context) error {
+		if sc.cache.Ping() < 0 {
+			return fmt.Errorf("cache unreachable")
+		}
+		return nil
+	})
 	router.HandleFunc("/status/ping", sc.ping).Methods("GET")
+	router.HandleFunc("/status/live", sc.live).Methods("GET")
+	router.HandleFunc("/status/ready", sc.ready).Methods("GET")
+	router.HandleFunc("/status/metrics", sc.metrics).Methods("GET")
+	router.HandleFunc("/status/reload", sc.reload).Methods("GET")
 	return sc
 }
 
+// SetReloadStatus sets the results of the configuration reloads returned by /status/reload
+func (sc *StatusController) SetReloadStatus(reloads *ReloadStatus) {
+	sc.reloads = reloads
+}
+
+// AddCheck adds a dependency check to the readiness, the check failing if it returns an error or times out
+func (sc *StatusController) AddCheck(name string, check func(ctx context.Context) error) {
+	sc.checks[name] = check
+}
+
+// SetCheckTimeout sets the time given to each readiness check
+func (sc *StatusController) SetCheckTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		sc.timeout = timeout
+	}
+}
+
+// SetShuttingDown makes the readiness fail, so that no more requests are routed to the server during its shutdown
+func (sc *StatusController) SetShuttingDown() {
+	atomic.StoreInt32(&sc.shuttingDown, 1)
+}
+
 func (sc *StatusController) ping(w http.ResponseWriter, r *http.Request) {
 	result := map[string]int{"db": sc.db.Ping(), "cache": sc.cache.Ping()}
 	sc.responder.Success(result, w)
 }
+
+// live answers as long as the server is able to serve requests, the dependencies are not checked
+func (sc *StatusController) live(w http.ResponseWriter, r *http.Request) {
+	sc.responder.Success(map[string]string{"status": "up"}, w)
+}
+
+// ready runs the dependency checks in parallel and returns 503 if one of them fails or if the server is shutting down
+func (sc *StatusController) ready(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range sc.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make([]map[string]interface{}, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = sc.runCheck(r.Context(), sc.checks[name])
+		}(i, name)
+	}
+	wg.Wait()
+	status := "up"
+	checks := map[string]interface{}{}
+	for i, name := range names {
+		checks[name] = results[i]
+		if results[i]["status"] != "up" {
+			status = "down"
+		}
+	}
+	if atomic.LoadInt32(&sc.shuttingDown) == 1 {
+		status = "down"
+		checks["shutdown"] = map[string]interface{}{"status": "down", "error": "server shutting down"}
+	}
+	result := map[string]interface{}{"status": status, "checks": checks}
+	if status != "up" {
+		(&ResponseFactory{}).FromObject(http.StatusServiceUnavailable, result, w)
+		return
+	}
+	sc.responder.Success(result, w)
+}
+
+// runCheck returns the status and duration of a check, a check still running after the timeout being failed
+func (sc *StatusController) runCheck(ctx context.Context, check func(ctx context.Context) error) map[string]interface{} {
+	ctx, cancel := context.WithTimeout(ctx, sc.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timeout after %s", sc.timeout)
+	}
+	result := map[string]interface{}{"status": "up", "durationMs": time.Since(start).Milliseconds()}
+	if err != nil {
+		result["status"] = "down"
+		result["error"] = err.Error()
+	}
+	return result
+}
+
+// reload returns when the configuration was loaded and the result of the last reload
+func (sc *StatusController) reload(w http.ResponseWriter, r *http.Request) {
+	sc.responder.Success(sc.reloads.Serialize(), w)
+}
+
+// metrics writes the metrics in the Prometheus text format, the connection pool statistics being read on each scrape
+func (sc *StatusController) metrics(w http.ResponseWriter, r *http.Request) {
+	stats := sc.db.PDO().Stats()
+	utils.Metrics.SetGauge("gocrudapi_db_connections", "Number of database connections by state", float64(stats.InUse), "state", "in_use")
+	utils.Metrics.SetGauge("gocrudapi_db_connections", "Number of database connections by state", float64(stats.Idle), "state", "idle")
+	utils.Metrics.SetGauge("gocrudapi_db_connections_max_open", "Maximum number of open database connections (0 for unlimited)", float64(stats.MaxOpenConnections))
+	utils.Metrics.SetCounter("gocrudapi_db_connections_wait_total", "Number of waits for a database connection", float64(stats.WaitCount))
+	utils.Metrics.SetCounter("gocrudapi_db_connections_wait_seconds_total", "Time waited for a database connection in seconds", stats.WaitDuration.Seconds())
+	utils.Metrics.SetCounter("gocrudapi_db_connections_closed_total", "Number of database connections closed by reason", float64(stats.MaxIdleClosed), "reason", "max_idle")
+	utils.Metrics.SetCounter("gocrudapi_db_connections_closed_total", "Number of database connections closed by reason", float64(stats.MaxIdleTimeClosed), "reason", "max_idle_time")
+	utils.Metrics.SetCounter("gocrudapi_db_connections_closed_total", "Number of database connections closed by reason", float64(stats.MaxLifetimeClosed), "reason", "max_lifetime")
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := utils.Metrics.WriteText(w); err != nil {
+		utils.Log.Error("unable to write metrics", "error", err)
+	}
+}
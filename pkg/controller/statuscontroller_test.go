@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+func TestStatusController(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api",
+	)
+	defer db.PDO().CloseConn()
+	reflection := database.NewReflectionService(db, nil, 0)
+	responder := NewJsonResponder(false)
+	router := mux.NewRouter()
+	status := NewStatusController(router, responder, nil, db)
+	status.SetCheckTimeout(100 * time.Millisecond)
+	status.AddCheck("reflection", func(ctx context.Context) error {
+		return reflection.Check()
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "status_live",
+			Method:     http.MethodGet,
+			Uri:        "/status/live",
+			Want:       `{"status":"up"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "status_ready",
+			Method:     http.MethodGet,
+			Uri:        "/status/ready",
+			WantRegex:  `^{"checks":{"cache":{"durationMs":[0-9]+,"status":"up"},"db":{"durationMs":[0-9]+,"status":"up"},"reflection":{"durationMs":[0-9]+,"status":"up"}},"status":"up"}$`,
+			StatusCode: http.StatusOK,
+		},
+	})
+
+	status.AddCheck("failing", func(ctx context.Context) error {
+		return fmt.Errorf("not applied")
+	})
+	status.AddCheck("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "status_ready_failing_checks",
+			Method:     http.MethodGet,
+			Uri:        "/status/ready",
+			WantRegex:  `"failing":{"durationMs":[0-9]+,"error":"not applied","status":"down"}.*"slow":{"durationMs":[0-9]+,"error":"timeout after 100ms","status":"down"}},"status":"down"}$`,
+			StatusCode: http.StatusServiceUnavailable,
+		},
+	})
+
+	delete(status.checks, "failing")
+	delete(status.checks, "slow")
+	status.SetShuttingDown()
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "status_ready_shutting_down",
+			Method:     http.MethodGet,
+			Uri:        "/status/ready",
+			WantRegex:  `"shutdown":{"error":"server shutting down","status":"down"}},"status":"down"}$`,
+			StatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			Name:       "status_live_shutting_down",
+			Method:     http.MethodGet,
+			Uri:        "/status/live",
+			Want:       `{"status":"up"}`,
+			StatusCode: http.StatusOK,
+		},
+	})
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// AutoColumn is a column filled by the server on create and/or update, the client values being ignored
+// It is configured as "<create|update|always>:<now|user|const>[:<argument>]"
+type AutoColumn struct {
+	onCreate bool
+	onUpdate bool
+	source   string
+	argument string
+}
+
+// NewAutoColumn parses an auto column definition, ex : "create:now", "always:user:username" or "create:const:api"
+func NewAutoColumn(definition string) (*AutoColumn, error) {
+	parts := strings.SplitN(definition, ":", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid auto column definition '%s'", definition)
+	}
+	ac := &AutoColumn{}
+	switch strings.ToLower(parts[0]) {
+	case "create":
+		ac.onCreate = true
+	case "update":
+		ac.onUpdate = true
+	case "always":
+		ac.onCreate = true
+		ac.onUpdate = true
+	default:
+		return nil, fmt.Errorf("invalid auto column operation '%s'", parts[0])
+	}
+	ac.source = strings.ToLower(parts[1])
+	switch ac.source {
+	case "now", "user", "const":
+	default:
+		return nil, fmt.Errorf("invalid auto column source '%s'", parts[1])
+	}
+	if len(parts) == 3 {
+		ac.argument = parts[2]
+	}
+	return ac, nil
+}
+
+// OnCreate returns true if the column is filled when a record is created
+func (ac *AutoColumn) OnCreate() bool {
+	return ac.onCreate
+}
+
+// OnUpdate returns true if the column is filled when a record is updated
+func (ac *AutoColumn) OnUpdate() bool {
+	return ac.onUpdate
+}
+
+// GetSource returns where the value comes from : "now", "user" or "const"
+func (ac *AutoColumn) GetSource() string {
+	return ac.source
+}
+
+// GetArgument returns the user property or the constant value
+func (ac *AutoColumn) GetArgument() string {
+	return ac.argument
+}
+
+// autoColumnRules holds the auto columns configured as "table.column" => definition, the table being a glob
+type autoColumnRules map[string]map[string]*AutoColumn
+
+func newAutoColumnRules(autoColumns map[string]string) autoColumnRules {
+	rules := autoColumnRules{}
+	for key, definition := range autoColumns {
+		names := strings.SplitN(key, ".", 2)
+		if len(names) != 2 || names[0] == "" || names[1] == "" {
+			utils.Log.Warning("invalid auto column, should be 'table.column'", "column", key)
+			continue
+		}
+		if _, err := path.Match(names[0], ""); err != nil {
+			utils.Log.Warning("invalid auto column table pattern", "pattern", names[0], "error", err)
+			continue
+		}
+		autoColumn, err := NewAutoColumn(definition)
+		if err != nil {
+			utils.Log.Warning("invalid auto column", "column", key, "error", err)
+			continue
+		}
+		if _, exists := rules[names[0]]; !exists {
+			rules[names[0]] = map[string]*AutoColumn{}
+		}
+		rules[names[0]][names[1]] = autoColumn
+	}
+	return rules
+}
+
+// get returns the auto columns of a table by column name, rules naming the table take precedence over the globs
+func (acr autoColumnRules) get(tableName string) map[string]*AutoColumn {
+	result := map[string]*AutoColumn{}
+	for pattern, columns := range acr {
+		if pattern == tableName {
+			continue
+		}
+		if matched, _ := path.Match(pattern, tableName); matched {
+			for columnName, autoColumn := range columns {
+				result[columnName] = autoColumn
+			}
+		}
+	}
+	for columnName, autoColumn := range acr[tableName] {
+		result[columnName] = autoColumn
+	}
+	return result
+}
@@ -62,6 +62,10 @@ func (cc *ColumnConverter) ConvertColumnName(column *ReflectedColumn, value stri
 			return "CASE WHEN " + value + " IS NULL THEN NULL ELSE (SELECT CAST(" + value + " as varbinary(max)) FOR XML PATH(''), BINARY BASE64) END as " + value
 		}
 	}
+	if column.GetGenerator() != "" && cc.driver == "sqlsrv" {
+		// uniqueidentifier values are read as binary otherwise
+		return "CONVERT(nvarchar(36), " + value + ") as " + value
+	}
 	if column.IsGeometry() {
 		switch cc.driver {
 		case "mysql", "pgsql":
@@ -0,0 +1,351 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// encryptedValuePrefix starts the stored values, followed by the key id and the base64 encoded nonce and ciphertext
+const encryptedValuePrefix = "enc:"
+
+// UndecryptableValue replaces in the records the values that cannot be decrypted (unknown key, altered value),
+// to tell them from the null values. It cannot be written back, the stored value being kept.
+const UndecryptableValue = encryptedValuePrefix + "undecryptable"
+
+// ColumnEncryptor encrypts the values of sensitive columns with AES-GCM before they are written and decrypts
+// them when they are read, the key id being stored with each value so that the keys can be rotated.
+// An encrypted column may have a blind index column holding a keyed hash of the value, used by the "eq" filters.
+type ColumnEncryptor struct {
+	keys       map[string]cipher.AEAD
+	currentKey string
+	indexKey   []byte
+	columns    map[string]map[string]string
+}
+
+// NewColumnEncryptor returns an encryptor using the AES keys (16, 24 or 32 bytes) by key id, new values being encrypted
+// with the current key. Columns are given as "table.column" => blind index column ("" for no blind index).
+func NewColumnEncryptor(keys map[string][]byte, currentKey string, indexKey []byte, columns map[string]string) (*ColumnEncryptor, error) {
+	ce := &ColumnEncryptor{map[string]cipher.AEAD{}, currentKey, indexKey, map[string]map[string]string{}}
+	for keyId, key := range keys {
+		if keyId == "" || strings.Contains(keyId, ":") {
+			return nil, fmt.Errorf("invalid key id '%s'", keyId)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key '%s' : %s", keyId, err.Error())
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key '%s' : %s", keyId, err.Error())
+		}
+		ce.keys[keyId] = aead
+	}
+	if _, exists := ce.keys[currentKey]; !exists {
+		return nil, fmt.Errorf("current key '%s' not found", currentKey)
+	}
+	for key, indexColumn := range columns {
+		names := strings.SplitN(key, ".", 2)
+		if len(names) != 2 || names[0] == "" || names[1] == "" {
+			return nil, fmt.Errorf("invalid encrypted column '%s', should be 'table.column'", key)
+		}
+		if indexColumn != "" && len(indexKey) < 16 {
+			return nil, fmt.Errorf("an index key of at least 16 bytes is required for the blind index of '%s'", key)
+		}
+		if _, exists := ce.columns[names[0]]; !exists {
+			ce.columns[names[0]] = map[string]string{}
+		}
+		ce.columns[names[0]][names[1]] = indexColumn
+	}
+	return ce, nil
+}
+
+// GetColumns returns the encrypted columns of a table by column name, with the name of their blind index column
+func (ce *ColumnEncryptor) GetColumns(tableName string) map[string]string {
+	if ce == nil {
+		return nil
+	}
+	return ce.columns[tableName]
+}
+
+// GetTableNames returns the tables having encrypted columns
+func (ce *ColumnEncryptor) GetTableNames() []string {
+	tableNames := []string{}
+	if ce == nil {
+		return tableNames
+	}
+	for tableName := range ce.columns {
+		tableNames = append(tableNames, tableName)
+	}
+	return tableNames
+}
+
+// Encrypt returns the value encrypted with the current key, the table and column being authenticated
+// so that a value cannot be copied to another column
+func (ce *ColumnEncryptor) Encrypt(tableName, columnName, value string) (string, error) {
+	aead := ce.keys[ce.currentKey]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(tableName+"."+columnName))
+	return encryptedValuePrefix + ce.currentKey + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plain value, values not starting with "enc:" are returned as is (not encrypted yet)
+func (ce *ColumnEncryptor) Decrypt(tableName, columnName, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return value, nil
+	}
+	keyId, encoded, found := strings.Cut(strings.TrimPrefix(value, encryptedValuePrefix), ":")
+	if !found {
+		return "", fmt.Errorf("invalid encrypted value")
+	}
+	aead, exists := ce.keys[keyId]
+	if !exists {
+		return "", fmt.Errorf("unknown key '%s'", keyId)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(tableName+"."+columnName))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// getKeyId returns the id of the key a stored value is encrypted with, "" if the value is not encrypted
+func (ce *ColumnEncryptor) getKeyId(value string) string {
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return ""
+	}
+	keyId, _, _ := strings.Cut(strings.TrimPrefix(value, encryptedValuePrefix), ":")
+	return keyId
+}
+
+// BlindIndex returns the deterministic keyed hash of a value stored in the blind index column
+func (ce *ColumnEncryptor) BlindIndex(tableName, columnName, value string) string {
+	mac := hmac.New(sha256.New, ce.indexKey)
+	mac.Write([]byte(tableName + "." + columnName + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encryptColumnValues replaces the values of the encrypted columns by their encrypted value and sets their
+// blind index, the values given for the blind index columns are ignored
+func (ce *ColumnEncryptor) encryptColumnValues(table *ReflectedTable, columnValues map[string]interface{}) error {
+	tableName := table.GetName()
+	columns := ce.GetColumns(tableName)
+	if len(columns) == 0 {
+		return nil
+	}
+	for _, indexColumn := range columns {
+		delete(columnValues, indexColumn)
+	}
+	for columnName, indexColumn := range columns {
+		value, exists := columnValues[columnName]
+		if !exists {
+			continue
+		}
+		hasIndex := indexColumn != "" && table.HasColumn(indexColumn)
+		if value == nil {
+			if hasIndex {
+				columnValues[indexColumn] = nil
+			}
+			continue
+		}
+		plain := fmt.Sprint(value)
+		if plain == UndecryptableValue {
+			return fmt.Errorf("the value of column '%s' of table '%s' cannot be decrypted", columnName, tableName)
+		}
+		encrypted, err := ce.Encrypt(tableName, columnName, plain)
+		if err != nil {
+			return err
+		}
+		columnValues[columnName] = encrypted
+		if hasIndex {
+			columnValues[indexColumn] = ce.BlindIndex(tableName, columnName, plain)
+		}
+	}
+	return nil
+}
+
+// removeColumnValues removes the encrypted and blind index columns from the values (they cannot be incremented)
+func (ce *ColumnEncryptor) removeColumnValues(tableName string, columnValues map[string]interface{}) {
+	for columnName, indexColumn := range ce.GetColumns(tableName) {
+		delete(columnValues, columnName)
+		delete(columnValues, indexColumn)
+	}
+}
+
+// decryptRecords decrypts the values of the encrypted columns and removes the blind indexes from the records,
+// the values that cannot be decrypted being replaced by UndecryptableValue
+func (ce *ColumnEncryptor) decryptRecords(table *ReflectedTable, records []map[string]interface{}) {
+	tableName := table.GetName()
+	columns := ce.GetColumns(tableName)
+	if len(columns) == 0 {
+		return
+	}
+	for _, record := range records {
+		for columnName, indexColumn := range columns {
+			delete(record, indexColumn)
+			value, exists := record[columnName]
+			if !exists || value == nil {
+				continue
+			}
+			plain, err := ce.Decrypt(tableName, columnName, storedString(value))
+			if err != nil {
+				utils.Log.Error("unable to decrypt column", "table", tableName, "column", columnName, "error", err)
+				record[columnName] = UndecryptableValue
+				continue
+			}
+			record[columnName] = plain
+		}
+	}
+}
+
+// convertCondition rewrites the conditions on the encrypted columns : "eq" and "in" use the blind index,
+// "is" is kept and the other operators, not applicable to encrypted values, never match
+func (ce *ColumnEncryptor) convertCondition(table *ReflectedTable, condition interface{ Condition }) interface{ Condition } {
+	columns := ce.GetColumns(table.GetName())
+	if len(columns) == 0 {
+		return condition
+	}
+	switch c := condition.(type) {
+	case *AndCondition:
+		conditions := []interface{ Condition }{}
+		for _, child := range c.GetConditions() {
+			conditions = append(conditions, ce.convertCondition(table, child))
+		}
+		return &AndCondition{conditions, GenericCondition{}}
+	case *OrCondition:
+		conditions := []interface{ Condition }{}
+		for _, child := range c.GetConditions() {
+			conditions = append(conditions, ce.convertCondition(table, child))
+		}
+		return &OrCondition{conditions, GenericCondition{}}
+	case *NotCondition:
+		if child, ok := c.GetCondition().(interface{ Condition }); ok {
+			return NewNotCondition(ce.convertCondition(table, child))
+		}
+	case *ColumnCondition:
+		column := c.GetColumn()
+		indexColumn, encrypted := columns[column.GetName()]
+		if !encrypted || c.GetOperator() == "is" {
+			return condition
+		}
+		var index *ReflectedColumn
+		if indexColumn != "" && table.HasColumn(indexColumn) {
+			index = table.GetColumn(indexColumn)
+		}
+		switch {
+		case index != nil && c.GetOperator() == "eq":
+			return NewColumnCondition(index, "eq", ce.BlindIndex(table.GetName(), column.GetName(), c.GetValue()))
+		case index != nil && c.GetOperator() == "in":
+			values := strings.Split(c.GetValue(), ",")
+			for i, value := range values {
+				values[i] = ce.BlindIndex(table.GetName(), column.GetName(), value)
+			}
+			return NewColumnCondition(index, "in", strings.Join(values, ","))
+		}
+		// unknown operators are rendered as FALSE by the conditions builder
+		return NewColumnCondition(column, "none", "")
+	}
+	return condition
+}
+
+// storedString returns a value read from the database as a string
+func storedString(value interface{}) string {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(value)
+}
+
+// ReencryptTable encrypts again with the current key the values of the encrypted columns of a table,
+// including the values not encrypted yet, and rebuilds their blind index. It returns the number of updated records.
+func (g *GenericDB) ReencryptTable(table *ReflectedTable, batchSize int) (int, error) {
+	columns := g.encryptor.GetColumns(table.GetName())
+	if len(columns) == 0 || !table.HasPk() {
+		return 0, nil
+	}
+	pk := table.GetPk()
+	columnNames := []string{pk.GetName()}
+	for columnName, indexColumn := range columns {
+		if !table.HasColumn(columnName) {
+			return 0, fmt.Errorf("column '%s' not found in table '%s'", columnName, table.GetName())
+		}
+		columnNames = append(columnNames, columnName)
+		if indexColumn != "" && table.HasColumn(indexColumn) {
+			columnNames = append(columnNames, indexColumn)
+		}
+	}
+	selectColumns := g.columns.GetSelect(table, columnNames)
+	orderBy := g.columns.GetOrderBy(table, [][2]string{{pk.GetName(), "ASC"}})
+	tableRealName := table.GetRealName()
+	quote := g.getQuote()
+	count := 0
+	for offset := 0; ; offset += batchSize {
+		offsetLimit := g.columns.GetOffsetLimit(offset, batchSize)
+		sql := fmt.Sprintf("SELECT %s FROM %s%s%s %s %s", selectColumns, quote, tableRealName, quote, orderBy, offsetLimit)
+		records, err := g.query(nil, sql)
+		if err != nil {
+			return count, err
+		}
+		records = g.mapRecords(tableRealName, records)
+		for _, record := range records {
+			columnValues, err := g.getReencryptedValues(table, columns, record)
+			if err != nil {
+				return count, fmt.Errorf("record '%v' : %s", record[pk.GetName()], err.Error())
+			}
+			if len(columnValues) == 0 {
+				continue
+			}
+			if _, err := g.UpdateSingle(nil, table, columnValues, storedString(record[pk.GetName()])); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(records) < batchSize {
+			return count, nil
+		}
+	}
+}
+
+// getReencryptedValues returns the plain values of the columns not encrypted with the current key or having
+// an outdated blind index, to be encrypted again by UpdateSingle
+func (g *GenericDB) getReencryptedValues(table *ReflectedTable, columns map[string]string, record map[string]interface{}) (map[string]interface{}, error) {
+	tableName := table.GetName()
+	columnValues := map[string]interface{}{}
+	for columnName, indexColumn := range columns {
+		value := record[columnName]
+		if value == nil {
+			continue
+		}
+		stored := storedString(value)
+		plain, err := g.encryptor.Decrypt(tableName, columnName, stored)
+		if err != nil {
+			return nil, err
+		}
+		upToDate := g.encryptor.getKeyId(stored) == g.encryptor.currentKey
+		if indexColumn != "" && table.HasColumn(indexColumn) {
+			upToDate = upToDate && storedString(record[indexColumn]) == g.encryptor.BlindIndex(tableName, columnName, plain)
+		}
+		if !upToDate {
+			columnValues[columnName] = plain
+		}
+	}
+	return columnValues, nil
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 )
@@ -114,8 +115,7 @@ func (cb *ColumnsBuilder) GetIncrement(table *ReflectedTable, columnValues map[s
 	parameters := []interface{}{}
 	for columnName, val := range columnValues {
 		switch val.(type) {
-		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-		case float32, float64, complex64, complex128:
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, complex64, complex128, json.Number:
 			column := table.GetColumn(columnName)
 			quotedColumnName := cb.quoteColumnName(column)
 			columnValue := cb.converter.ConvertColumnValue(column, parameters)
@@ -2,7 +2,6 @@ package database
 
 import (
 	"fmt"
-	"log"
 	"strings"
 )
 
@@ -27,9 +26,8 @@ func (cb *ConditionsBuilder) getConditionSql(condition interface{ Condition }, a
 	case *ColumnCondition:
 		return cb.getColumnConditionSql(v, arguments)
 	default:
-		log.Panicf("Unknown Condition: %T\n", v)
+		panic(fmt.Sprintf("Unknown Condition: %T", v))
 	}
-	return ""
 }
 
 func (cb *ConditionsBuilder) getAndConditionSql(and *AndCondition, arguments *[]interface{}) string {
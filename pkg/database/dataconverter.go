@@ -1,21 +1,40 @@
 package database
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/shopspring/decimal"
 )
 
+var jsonNumberRegexp = regexp.MustCompile(`^-?(0|[1-9]\d*)(\.\d+)?([eE][+-]?\d+)?$`)
+
 type DataConverter struct {
-	driver string
+	driver        string
+	numberFormats map[string]string
+	encryptor     *ColumnEncryptor
 }
 
 func NewDataConverter(driver string) *DataConverter {
-	return &DataConverter{driver}
+	return &DataConverter{driver, map[string]string{}, nil}
+}
+
+// SetNumberFormats sets, by column type, if numeric values are returned as json "string" or "number"
+func (dc *DataConverter) SetNumberFormats(numberFormats map[string]string) {
+	dc.numberFormats = map[string]string{}
+	for columnType, format := range numberFormats {
+		dc.numberFormats[strings.ToLower(columnType)] = strings.ToLower(format)
+	}
+}
+
+// SetEncryptor sets the encryptor decrypting the encrypted columns of the records
+func (dc *DataConverter) SetEncryptor(encryptor *ColumnEncryptor) {
+	dc.encryptor = encryptor
 }
 
 // Should check conv errors
@@ -41,8 +60,9 @@ func (dc *DataConverter) convertRecordValue(conversion string, value interface{}
 	case "integer":
 		switch v := value.(type) {
 		case string:
-			res, _ := strconv.Atoi(v)
-			return res
+			if res, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return res
+			}
 		case int, int64:
 			return v
 		}
@@ -55,23 +75,28 @@ func (dc *DataConverter) convertRecordValue(conversion string, value interface{}
 			return v
 		}
 	case "decimal":
-		var flt *float64
+		var dec *decimal.Decimal
 		switch v := value.(type) {
 		case string:
-			val, _ := strconv.ParseFloat(v, 32)
-			flt = &val
+			if val, err := decimal.NewFromString(v); err == nil {
+				dec = &val
+			}
 		case float64:
-			flt = &v
+			val := decimal.NewFromFloat(v)
+			dec = &val
 		case float32:
-			val := float64(v)
-			flt = &val
+			val := decimal.NewFromFloat32(v)
+			dec = &val
+		case int64:
+			val := decimal.NewFromInt(v)
+			dec = &val
 		}
-		if flt != nil {
+		if dec != nil {
 			decimals := 0
 			if len(args) == 2 {
 				decimals, _ = strconv.Atoi(args[1])
 			}
-			return utils.NumberFormat(*flt, decimals, ".", "")
+			return dec.StringFixed(int32(decimals))
 		}
 	case "date":
 		if t, ok := value.(time.Time); ok {
@@ -108,18 +133,57 @@ func (dc *DataConverter) getRecordValueConversion(column *ReflectedColumn) strin
 	return "none"
 }
 
+// formatNumber returns the numeric value as an exact string or as a json.Number, depending on format
+func (dc *DataConverter) formatNumber(format string, value interface{}) interface{} {
+	var number string
+	switch v := value.(type) {
+	case string:
+		number = v
+	case json.Number:
+		number = v.String()
+	case float32:
+		number = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		number = strconv.FormatFloat(v, 'f', -1, 64)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		number = fmt.Sprint(v)
+	default:
+		return value
+	}
+	switch format {
+	case "string":
+		return number
+	case "number":
+		if jsonNumberRegexp.MatchString(number) {
+			return json.Number(number)
+		}
+	}
+	return value
+}
+
 //Something nasty here in type conversion
 func (dc *DataConverter) ConvertRecords(table *ReflectedTable, columnNames []string, records *[]map[string]interface{}) {
+	dc.encryptor.decryptRecords(table, *records)
 	for _, columnName := range columnNames {
 		column := table.GetColumn(columnName)
 		conversion := dc.getRecordValueConversion(column)
-		if conversion != "none" {
+		format := ""
+		if !column.IsBoolean() {
+			format = dc.numberFormats[column.GetType()]
+		}
+		if conversion != "none" || format != "" {
 			for i, record := range *records {
 				value, ok := record[columnName]
-				if !ok {
+				if !ok || value == nil {
 					continue
 				}
-				(*records)[i][columnName] = dc.convertRecordValue(conversion, value)
+				if conversion != "none" {
+					value = dc.convertRecordValue(conversion, value)
+				}
+				if format != "" {
+					value = dc.formatNumber(format, value)
+				}
+				(*records)[i][columnName] = value
 			}
 		}
 
@@ -144,6 +208,21 @@ func (dc *DataConverter) convertInputValue(conversion, value string) interface{}
 	return value
 }
 
+// convertNumberValue converts a json.Number from a request body to the go type matching the column type
+func (dc *DataConverter) convertNumberValue(column *ReflectedColumn, value json.Number) interface{} {
+	switch column.GetType() {
+	case "integer", "bigint":
+		if res, err := value.Int64(); err == nil {
+			return res
+		}
+	case "float", "double":
+		if res, err := value.Float64(); err == nil {
+			return res
+		}
+	}
+	return value.String()
+}
+
 func (dc *DataConverter) getInputValueConversion(column *ReflectedColumn) string {
 	if column.IsBoolean() {
 		return `boolean`
@@ -158,7 +237,9 @@ func (dc *DataConverter) ConvertColumnValues(table *ReflectedTable, columnValues
 	for columnName := range *columnValues {
 		column := table.GetColumn(columnName)
 		conversion := dc.getInputValueConversion(column)
-		if conversion != `none` {
+		if number, ok := (*columnValues)[columnName].(json.Number); ok && conversion == `none` {
+			(*columnValues)[columnName] = dc.convertNumberValue(column, number)
+		} else if conversion != `none` {
 			if value, exists := (*columnValues)[columnName]; exists {
 				if value == nil {
 					(*columnValues)[columnName] = nil
@@ -1,6 +1,6 @@
 package database
 
-import "log"
+import "github.com/dranih/go-crud-api/pkg/utils"
 
 type DefinitionService struct {
 	db         *GenericDB
@@ -16,14 +16,13 @@ func (ds *DefinitionService) UpdateTable(tableName string, changes map[string]in
 	newTable := NewReflectedTableFromJson(mergeMaps(table.JsonSerialize(), changes))
 	if table.GetRealName() != newTable.GetRealName() {
 		if err := ds.db.Definition().RenameTable(table.GetRealName(), newTable.GetRealName()); err != nil {
-			log.Printf("Error : %v", err)
+			utils.Log.Error("unable to rename the table", "table", table.GetRealName(), "error", err)
 			return false
 		}
 		if ds.db.tables != nil {
 			delete(ds.db.tables, table.GetRealName())
 			ds.db.tables[newTable.GetRealName()] = true
-			delete(ds.reflection.tables, table.GetRealName())
-			ds.reflection.tables[newTable.GetRealName()] = newTable
+			ds.reflection.replaceTable(table.GetRealName(), newTable)
 		}
 	}
 	return true
@@ -48,7 +47,7 @@ func (ds *DefinitionService) UpdateColumn(tableName, columnName string, changes
 		if oldColumn.GetRealName() != columnName {
 			oldColumn.SetPk(false)
 			if err := ds.db.definition.RemoveColumnPrimaryKey(table.GetRealName(), oldColumn.GetRealName(), oldColumn); err != nil {
-				log.Printf("Error removing primary key for column %s : %v", oldColumn.GetRealName(), err)
+				utils.Log.Error("unable to remove the primary key", "column", oldColumn.GetRealName(), "error", err)
 				return false
 			}
 		}
@@ -58,13 +57,13 @@ func (ds *DefinitionService) UpdateColumn(tableName, columnName string, changes
 	newColumn = NewReflectedColumnFromJson(mergeMaps(column.JsonSerialize(), map[string]interface{}{"pk": false, "fk": ""}))
 	if newColumn.GetPk() != column.GetPk() && !newColumn.GetPk() {
 		if err := ds.db.definition.RemoveColumnPrimaryKey(table.GetRealName(), column.GetRealName(), newColumn); err != nil {
-			log.Printf("Error removing primary key for column %s : %v", column.GetRealName(), err)
+			utils.Log.Error("unable to remove the primary key", "column", column.GetRealName(), "error", err)
 			return false
 		}
 	}
 	if newColumn.GetFk() != column.GetFk() && newColumn.GetFk() == "" {
 		if err := ds.db.definition.RemoveColumnForeignKey(table.GetRealName(), column.GetRealName(), newColumn); err != nil {
-			log.Printf("Error removing foreign key for column %s : %v", column.GetRealName(), err)
+			utils.Log.Error("unable to remove the foreign key", "column", column.GetRealName(), "error", err)
 			return false
 		}
 	}
@@ -75,7 +74,7 @@ func (ds *DefinitionService) UpdateColumn(tableName, columnName string, changes
 	newColumn.SetFk("")
 	if newColumn.GetRealName() != column.GetRealName() {
 		if err := ds.db.definition.RenameColumn(table.GetRealName(), column.GetRealName(), newColumn); err != nil {
-			log.Printf("Error rename column %s : %v", column.GetRealName(), err)
+			utils.Log.Error("unable to rename the column", "column", column.GetRealName(), "error", err)
 			return false
 		}
 	}
@@ -84,13 +83,13 @@ func (ds *DefinitionService) UpdateColumn(tableName, columnName string, changes
 		newColumn.GetPrecision() != column.GetPrecision() ||
 		newColumn.GetScale() != column.GetScale() {
 		if err := ds.db.definition.RetypeColumn(table.GetRealName(), newColumn.GetRealName(), newColumn); err != nil {
-			log.Printf("Error changing type for column %s : %v", newColumn.GetRealName(), err)
+			utils.Log.Error("unable to change the type of the column", "column", newColumn.GetRealName(), "error", err)
 			return false
 		}
 	}
 	if newColumn.GetNullable() != column.GetNullable() {
 		if err := ds.db.definition.SetColumnNullable(table.GetRealName(), newColumn.GetRealName(), newColumn); err != nil {
-			log.Printf("Error changing nullable for column %s : %v", newColumn.GetRealName(), err)
+			utils.Log.Error("unable to change the nullable of the column", "column", newColumn.GetRealName(), "error", err)
 			return false
 		}
 	}
@@ -99,13 +98,13 @@ func (ds *DefinitionService) UpdateColumn(tableName, columnName string, changes
 	newColumn = NewReflectedColumnFromJson(mergeMaps(column.JsonSerialize(), changes))
 	if newColumn.GetFk() != "" {
 		if err := ds.db.definition.AddColumnForeignKey(table.GetRealName(), newColumn.GetRealName(), newColumn); err != nil {
-			log.Printf("Error adding foreign key for column %s : %v", newColumn.GetRealName(), err)
+			utils.Log.Error("unable to add the foreign key", "column", newColumn.GetRealName(), "error", err)
 			return false
 		}
 	}
 	if newColumn.GetPk() {
 		if err := ds.db.definition.AddColumnPrimaryKey(table.GetRealName(), newColumn.GetRealName(), newColumn); err != nil {
-			log.Printf("Error adding primary key for column %s : %v", newColumn.GetRealName(), err)
+			utils.Log.Error("unable to add the primary key", "column", newColumn.GetRealName(), "error", err)
 			return false
 		}
 	}
@@ -115,32 +114,46 @@ func (ds *DefinitionService) UpdateColumn(tableName, columnName string, changes
 
 func (ds *DefinitionService) AddTable(definition map[string]interface{}) bool {
 	newTable := NewReflectedTableFromJson(definition)
+	for _, columnName := range newTable.GetColumnNames() {
+		if column := newTable.GetColumn(columnName); column.GetPk() {
+			if err := ds.db.checkKeyGenerator(newTable.GetRealName(), column); err != nil {
+				utils.Log.Error("unable to add the table", "table", newTable.GetRealName(), "error", err)
+				return false
+			}
+		}
+	}
 	if err := ds.db.definition.AddTable(newTable); err != nil {
-		log.Printf("Error adding table %s : %v", newTable.GetRealName(), err)
+		utils.Log.Error("unable to add the table", "table", newTable.GetRealName(), "error", err)
 		return false
 	}
 	if ds.db.tables != nil {
 		ds.db.tables[newTable.GetRealName()] = true
-		ds.reflection.tables[newTable.GetRealName()] = newTable
+		ds.reflection.replaceTable("", newTable)
 	}
 	return true
 }
 
 func (ds *DefinitionService) AddColumn(tableName string, definition map[string]interface{}) bool {
 	newColumn := NewReflectedColumnFromJson(definition)
+	if newColumn.GetPk() {
+		if err := ds.db.checkKeyGenerator(tableName, newColumn); err != nil {
+			utils.Log.Error("unable to add the column", "column", newColumn.GetRealName(), "error", err)
+			return false
+		}
+	}
 	if err := ds.db.definition.AddColumn(tableName, newColumn); err != nil {
-		log.Printf("Error adding column %s : %v", newColumn.GetRealName(), err)
+		utils.Log.Error("unable to add the column", "column", newColumn.GetRealName(), "error", err)
 		return false
 	}
 	if newColumn.GetFk() != "" {
 		if err := ds.db.definition.AddColumnForeignKey(tableName, newColumn.GetRealName(), newColumn); err != nil {
-			log.Printf("Error adding foreign key for column %s : %v", newColumn.GetRealName(), err)
+			utils.Log.Error("unable to add the foreign key", "column", newColumn.GetRealName(), "error", err)
 			return false
 		}
 	}
 	if newColumn.GetPk() {
 		if err := ds.db.definition.AddColumnPrimaryKey(tableName, newColumn.GetRealName(), newColumn); err != nil {
-			log.Printf("Error adding primary key for column %s : %v", newColumn.GetRealName(), err)
+			utils.Log.Error("unable to add the primary key", "column", newColumn.GetRealName(), "error", err)
 			return false
 		}
 	}
@@ -149,7 +162,7 @@ func (ds *DefinitionService) AddColumn(tableName string, definition map[string]i
 
 func (ds *DefinitionService) RemoveTable(tableName string) bool {
 	if err := ds.db.definition.RemoveTable(tableName); err != nil {
-		log.Printf("Error removing table %s : %v", tableName, err)
+		utils.Log.Error("unable to remove the table", "table", tableName, "error", err)
 		return false
 	}
 	if ds.db.tables != nil {
@@ -165,19 +178,19 @@ func (ds *DefinitionService) RemoveColumn(tableName, columnName string) bool {
 	if newColumn.GetPk() {
 		newColumn.SetPk(false)
 		if err := ds.db.definition.RemoveColumnPrimaryKey(table.GetRealName(), newColumn.GetRealName(), newColumn); err != nil {
-			log.Printf("Error removing primary key for column %s : %v", newColumn.GetRealName(), err)
+			utils.Log.Error("unable to remove the primary key", "column", newColumn.GetRealName(), "error", err)
 			return false
 		}
 	}
 	if newColumn.GetFk() != "" {
 		newColumn.SetFk("")
 		if err := ds.db.definition.RemoveColumnForeignKey(tableName, newColumn.GetRealName(), newColumn); err != nil {
-			log.Printf("Error removing foreign key for column %s : %v", newColumn.GetRealName(), err)
+			utils.Log.Error("unable to remove the foreign key", "column", newColumn.GetRealName(), "error", err)
 			return false
 		}
 	}
 	if err := ds.db.definition.RemoveColumn(tableName, columnName); err != nil {
-		log.Printf("Error removing column %s : %v", newColumn.GetRealName(), err)
+		utils.Log.Error("unable to remove the column", "column", newColumn.GetRealName(), "error", err)
 		return false
 	}
 	return true
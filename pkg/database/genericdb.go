@@ -1,11 +1,11 @@
 package database
 
 import (
+	"context"
 	"crypto/md5"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -30,6 +30,14 @@ type GenericDB struct {
 	columns       *ColumnsBuilder
 	converter     *DataConverter
 	VariableStore *utils.VariableStore
+	isolation     sql.IsolationLevel
+	retries       int
+	backoff       time.Duration
+	numberFormats map[string]string
+	keyGenerators map[string]string
+	autoColumns   autoColumnRules
+	hiddenTables  []string
+	encryptor     *ColumnEncryptor
 }
 
 func (g *GenericDB) getDsn() string {
@@ -93,6 +101,7 @@ func (g *GenericDB) initPdo() bool {
 		g.pdo = NewLazyPdo(g.getDsn(), g.username, g.password, g.getOptions())
 		result = true
 	}
+	g.pdo.SetIsolationLevel(g.isolation)
 	commands := g.getCommands()
 	for _, command := range commands {
 		g.pdo.AddInitCommand(command)
@@ -100,10 +109,14 @@ func (g *GenericDB) initPdo() bool {
 
 	g.mapper = NewRealNameMapper(g.mapping)
 	g.reflection = NewGenericReflection(g.pdo, g.driver, g.database, g.tables, g.mapper)
+	g.reflection.SetKeyGenerators(g.keyGenerators)
+	g.reflection.SetHiddenTables(g.hiddenTables)
 	g.definition = NewGenericDefinition(g.pdo, g.driver, g.database, g.tables, g.mapper)
 	g.conditions = NewConditionsBuilder(g.driver)
 	g.columns = NewColumnsBuilder(g.driver)
 	g.converter = NewDataConverter(g.driver)
+	g.converter.SetNumberFormats(g.numberFormats)
+	g.converter.SetEncryptor(g.encryptor)
 
 	return result
 }
@@ -151,6 +164,21 @@ func (g *GenericDB) Reconstruct(driver, address string, port int, database strin
 	return g.initPdo()
 }
 
+// WithContext returns a copy of the database whose queries are traced as children of the span of the context,
+// logged with its request id and restricted by the variables of the request, the database itself when the context has none
+func (g *GenericDB) WithContext(ctx context.Context) *GenericDB {
+	variables := utils.VariablesFromContext(ctx)
+	if !utils.SpanContextFromContext(ctx).Sampled && utils.RequestIdFromContext(ctx) == "" && variables == nil {
+		return g
+	}
+	scoped := *g
+	scoped.pdo = g.pdo.WithContext(ctx)
+	if variables != nil {
+		scoped.VariableStore = variables
+	}
+	return &scoped
+}
+
 func (g *GenericDB) PDO() *LazyPdo {
 	return g.pdo
 }
@@ -163,6 +191,81 @@ func (g *GenericDB) Definition() *GenericDefinition {
 	return g.definition
 }
 
+// SetTransactionOptions sets the isolation level of the transactions and how many times
+// a transaction failing with a deadlock or a serialization failure is replayed (backoff in milliseconds)
+func (g *GenericDB) SetTransactionOptions(isolation string, retries, backoff int) {
+	g.isolation = ParseIsolationLevel(isolation)
+	if retries < 0 {
+		retries = 0
+	}
+	g.retries = retries
+	g.backoff = time.Duration(backoff) * time.Millisecond
+	g.pdo.SetIsolationLevel(g.isolation)
+}
+
+// SetSlowQueryThreshold sets the duration in milliseconds above which the queries are logged, 0 disables the log
+func (g *GenericDB) SetSlowQueryThreshold(threshold int) {
+	g.pdo.SetSlowQueryThreshold(time.Duration(threshold) * time.Millisecond)
+}
+
+// SetNumberFormats sets, by column type, if numeric values are returned as json "string" or "number"
+func (g *GenericDB) SetNumberFormats(numberFormats map[string]string) {
+	g.numberFormats = numberFormats
+	g.converter.SetNumberFormats(numberFormats)
+}
+
+// SetKeyGenerators sets the generator (uuid, uuidv7 or ulid) of primary keys, as "table.column" => generator
+func (g *GenericDB) SetKeyGenerators(keyGenerators map[string]string) {
+	g.keyGenerators = keyGenerators
+	g.reflection.SetKeyGenerators(keyGenerators)
+}
+
+// SetAutoColumns sets the columns filled by the server, as "table.column" => definition (see NewAutoColumn)
+func (g *GenericDB) SetAutoColumns(autoColumns map[string]string) {
+	g.autoColumns = newAutoColumnRules(autoColumns)
+}
+
+// GetAutoColumns returns the auto columns of a table by column name
+func (g *GenericDB) GetAutoColumns(tableName string) map[string]*AutoColumn {
+	return g.autoColumns.get(tableName)
+}
+
+// SetEncryptor sets the encryptor of the encrypted columns, nil when no column is encrypted
+func (g *GenericDB) SetEncryptor(encryptor *ColumnEncryptor) {
+	g.encryptor = encryptor
+	g.converter.SetEncryptor(encryptor)
+}
+
+// GetEncryptor returns the encryptor of the encrypted columns, nil when no column is encrypted
+func (g *GenericDB) GetEncryptor() *ColumnEncryptor {
+	return g.encryptor
+}
+
+// HideTable excludes a table used internally from the published tables
+func (g *GenericDB) HideTable(tableName string) {
+	g.hiddenTables = append(g.hiddenTables, tableName)
+	g.reflection.SetHiddenTables(g.hiddenTables)
+}
+
+// checkKeyGenerator returns an error if the generator of a primary key created through the columns api would be lost
+// on the next reflection : it has to be configured in keyGenerators, unless detected from the native uuid type
+func (g *GenericDB) checkKeyGenerator(tableName string, column *ReflectedColumn) error {
+	generator := column.GetGenerator()
+	if generator == "" {
+		return nil
+	}
+	reflected := g.reflection.getKeyGenerator(tableName, column.GetRealName(), g.definition.getUuidType(column))
+	if !sameKeyGenerator(generator, reflected) {
+		return fmt.Errorf("generator '%s' of key %s.%s is not configured in keyGenerators", generator, tableName, column.GetRealName())
+	}
+	return nil
+}
+
+// GetTransactionRetries returns the maximum number of replays of a transaction and the initial backoff delay
+func (g *GenericDB) GetTransactionRetries() (int, time.Duration) {
+	return g.retries, g.backoff
+}
+
 func (g *GenericDB) BeginTransaction() (*sql.Tx, error) {
 	return g.pdo.BeginTransaction()
 }
@@ -176,16 +279,37 @@ func (g *GenericDB) RollBackTransaction(tx *sql.Tx) error {
 }
 
 // Should type check
-func (g *GenericDB) addMiddlewareConditions(tableName string, condition interface{ Condition }) interface{ Condition } {
+func (g *GenericDB) addMiddlewareConditions(table *ReflectedTable, condition interface{ Condition }) interface{ Condition } {
+	tableName := table.GetName()
 	condition1 := g.VariableStore.Get("authorization.conditions." + tableName)
 	if condition1 != nil {
 		condition = condition.And(condition1).(interface{ Condition })
 	}
+	condition3 := g.VariableStore.Get("rbac.conditions." + tableName)
+	if condition3 != nil {
+		condition = condition.And(condition3).(interface{ Condition })
+	}
 	condition2 := g.VariableStore.Get("multiTenancy.conditions." + tableName)
 	if condition2 != nil {
 		condition = condition.And(condition2).(interface{ Condition })
 	}
-	return condition
+	// filters on the encrypted columns use their blind index
+	return g.encryptor.convertCondition(table, condition)
+}
+
+// applyMiddlewareMasks replaces the values of the columns masked by the masking middleware
+func (g *GenericDB) applyMiddlewareMasks(tableName string, records []map[string]interface{}) {
+	masks, ok := g.VariableStore.Get("masking.columns." + tableName).(map[string]func(interface{}) interface{})
+	if !ok {
+		return
+	}
+	for _, record := range records {
+		for columnName, mask := range masks {
+			if value, exists := record[columnName]; exists {
+				record[columnName] = mask(value)
+			}
+		}
+	}
 }
 
 // getQuote returns the quote to use to escape columns and tables
@@ -199,10 +323,24 @@ func (g *GenericDB) getQuote() string {
 }
 
 func (g *GenericDB) CreateSingle(tx *sql.Tx, table *ReflectedTable, columnValues map[string]interface{}) (interface{}, error) {
+	pk := table.GetPk()
+	pkName := pk.GetName()
+	// generate the primary key value if not specified in the input
+	if generator := pk.GetGenerator(); generator != "" {
+		if pkValue, exists := columnValues[pkName]; !exists || pkValue == nil || pkValue == "" {
+			key, err := GenerateKey(generator)
+			if err != nil {
+				return nil, err
+			}
+			columnValues[pkName] = key
+		}
+	}
 	g.converter.ConvertColumnValues(table, &columnValues)
+	if err := g.encryptor.encryptColumnValues(table, columnValues); err != nil {
+		return nil, err
+	}
 	insertColumns, parameters := g.columns.GetInsert(table, columnValues)
 	tableRealName := table.GetRealName()
-	pkName := table.GetPk().GetName()
 	quote := g.getQuote()
 	sql := fmt.Sprintf("INSERT INTO %s%s%s %s", quote, tableRealName, quote, insertColumns)
 	//For pgsql and sqlsrv, get id from returning value
@@ -211,10 +349,12 @@ func (g *GenericDB) CreateSingle(tx *sql.Tx, table *ReflectedTable, columnValues
 		if err != nil {
 			return nil, err
 		}
-		if table.GetPk().GetType() == `bigint` || table.GetPk().GetType() == `int` {
-			if pkValueInt, ok := res.(int); ok {
-				return pkValueInt, nil
-			}
+		// return primary key value if specified in the input, uuid types are not returned as strings
+		if pkValue, exists := columnValues[pkName]; exists {
+			return pkValue, nil
+		}
+		if b, ok := res.([]byte); ok {
+			return string(b), nil
 		}
 		return res, nil
 	} else {
@@ -230,6 +370,11 @@ func (g *GenericDB) CreateSingle(tx *sql.Tx, table *ReflectedTable, columnValues
 		if err != nil {
 			return nil, err
 		}
+		// last insert id is the rowid in sqlite, which is not the key for non integer primary keys
+		if g.driver == "sqlite" && !pk.IsInteger() {
+			quotedPkName := quote + pk.GetRealName() + quote
+			return g.queryRowSingleColumn(tx, fmt.Sprintf("SELECT %s FROM %s%s%s WHERE rowid = ?", quotedPkName, quote, tableRealName, quote), id)
+		}
 		return id, nil
 	}
 	/*
@@ -261,7 +406,7 @@ func (g *GenericDB) SelectSingle(tx *sql.Tx, table *ReflectedTable, columnNames
 	tableRealName := table.GetRealName()
 	var condition interface{ Condition }
 	condition = NewColumnCondition(table.GetPk(), `eq`, id)
-	condition = g.addMiddlewareConditions(tableName, condition)
+	condition = g.addMiddlewareConditions(table, condition)
 	parameters := []interface{}{}
 	whereClause := g.conditions.GetWhereClause(condition, &parameters)
 	quote := g.getQuote()
@@ -272,6 +417,7 @@ func (g *GenericDB) SelectSingle(tx *sql.Tx, table *ReflectedTable, columnNames
 	}
 	records = g.mapRecords(tableRealName, records)
 	g.converter.ConvertRecords(table, columnNames, &records)
+	g.applyMiddlewareMasks(tableName, records)
 	return records[:1]
 }
 
@@ -286,7 +432,7 @@ func (g *GenericDB) SelectMultiple(table *ReflectedTable, columnNames, ids []str
 	tableRealName := table.GetRealName()
 	var condition interface{ Condition }
 	condition = NewColumnCondition(table.GetPk(), `in`, strings.Join(ids, `,`))
-	condition = g.addMiddlewareConditions(tableName, condition)
+	condition = g.addMiddlewareConditions(table, condition)
 	parameters := []interface{}{}
 	whereClause := g.conditions.GetWhereClause(condition, &parameters)
 	quote := g.getQuote()
@@ -294,6 +440,7 @@ func (g *GenericDB) SelectMultiple(table *ReflectedTable, columnNames, ids []str
 	records, _ = g.query(nil, sql, parameters...)
 	records = g.mapRecords(tableRealName, records)
 	g.converter.ConvertRecords(table, columnNames, &records)
+	g.applyMiddlewareMasks(tableName, records)
 	return records
 }
 
@@ -301,7 +448,7 @@ func (g *GenericDB) SelectMultiple(table *ReflectedTable, columnNames, ids []str
 func (g *GenericDB) SelectCount(table *ReflectedTable, condition interface{ Condition }) int {
 	tableName := table.GetName()
 	tableRealName := table.GetRealName()
-	condition = g.addMiddlewareConditions(tableName, condition)
+	condition = g.addMiddlewareConditions(table, condition)
 	parameters := []interface{}{}
 	whereClause := g.conditions.GetWhereClause(condition, &parameters)
 	quote := g.getQuote()
@@ -321,7 +468,7 @@ func (g *GenericDB) SelectCount(table *ReflectedTable, condition interface{ Cond
 			return i
 		}
 	}
-	log.Printf("Error processing count return value : %v of type % T from table %s\n", stmt, stmt, tableName)
+	utils.Log.Error("unable to read the count", "table", tableName, "value", fmt.Sprintf("%v (%T)", stmt, stmt))
 	return 0
 }
 
@@ -345,7 +492,7 @@ func (g *GenericDB) SelectAll(table *ReflectedTable, columnNames []string, condi
 	selectColumns := g.columns.GetSelect(table, columnNames)
 	tableName := table.GetName()
 	tableRealName := table.GetRealName()
-	condition = g.addMiddlewareConditions(tableName, condition)
+	condition = g.addMiddlewareConditions(table, condition)
 	parameters := []interface{}{}
 	whereClause := g.conditions.GetWhereClause(condition, &parameters)
 	orderBy := g.columns.GetOrderBy(table, columnOrdering)
@@ -355,6 +502,7 @@ func (g *GenericDB) SelectAll(table *ReflectedTable, columnNames []string, condi
 	records, _ := g.query(nil, sql, parameters...)
 	records = g.mapRecords(tableRealName, records)
 	g.converter.ConvertRecords(table, columnNames, &records)
+	g.applyMiddlewareMasks(tableName, records)
 	return records
 }
 
@@ -363,13 +511,15 @@ func (g *GenericDB) UpdateSingle(tx *sql.Tx, table *ReflectedTable, columnValues
 		return 0, nil
 	}
 	g.converter.ConvertColumnValues(table, &columnValues)
+	if err := g.encryptor.encryptColumnValues(table, columnValues); err != nil {
+		return 0, err
+	}
 	updateColumns, parameters := g.columns.GetUpdate(table, columnValues)
-	tableName := table.GetName()
 	tableRealName := table.GetRealName()
 	var condition interface{ Condition }
 	pk := table.GetPk()
 	condition = NewColumnCondition(pk, `eq`, id)
-	condition = g.addMiddlewareConditions(tableName, condition)
+	condition = g.addMiddlewareConditions(table, condition)
 	whereClause := g.conditions.GetWhereClause(condition, &parameters)
 	quote := g.getQuote()
 	sql := fmt.Sprintf("UPDATE %s%s%s SET %s %s", quote, tableRealName, quote, updateColumns, whereClause)
@@ -387,12 +537,11 @@ func (g *GenericDB) UpdateSingle(tx *sql.Tx, table *ReflectedTable, columnValues
 }
 
 func (g *GenericDB) DeleteSingle(tx *sql.Tx, table *ReflectedTable, id string) (int64, error) {
-	tableName := table.GetName()
 	tableRealName := table.GetRealName()
 	var condition interface{ Condition }
 	pk := table.GetPk()
 	condition = NewColumnCondition(pk, `eq`, id)
-	condition = g.addMiddlewareConditions(tableName, condition)
+	condition = g.addMiddlewareConditions(table, condition)
 	parameters := []interface{}{}
 	whereClause := g.conditions.GetWhereClause(condition, &parameters)
 	quote := g.getQuote()
@@ -415,16 +564,16 @@ func (g *GenericDB) IncrementSingle(tx *sql.Tx, table *ReflectedTable, columnVal
 		return 0, nil
 	}
 	g.converter.ConvertColumnValues(table, &columnValues)
+	g.encryptor.removeColumnValues(table.GetName(), columnValues)
 	updateColumns, parameters := g.columns.GetIncrement(table, columnValues)
 	if updateColumns == "" {
 		return 0, nil
 	}
-	tableName := table.GetName()
 	tableRealName := table.GetRealName()
 	var condition interface{ Condition }
 	pk := table.GetPk()
 	condition = NewColumnCondition(pk, `eq`, id)
-	condition = g.addMiddlewareConditions(tableName, condition)
+	condition = g.addMiddlewareConditions(table, condition)
 	whereClause := g.conditions.GetWhereClause(condition, &parameters)
 	quote := g.getQuote()
 	sql := fmt.Sprintf("UPDATE %s%s%s SET %s %s", quote, tableRealName, quote, updateColumns, whereClause)
@@ -455,7 +604,11 @@ func (g *GenericDB) exec(tx *sql.Tx, sql string, parameters ...interface{}) (sql
 
 func (g *GenericDB) Ping() int {
 	start := time.Now()
-	stmt, err := g.pdo.connect().Prepare("SELECT 1")
+	pdo, err := g.pdo.connect()
+	if err != nil {
+		return -1
+	}
+	stmt, err := pdo.Prepare("SELECT 1")
 	if err != nil {
 		return -1
 	}
@@ -468,6 +621,16 @@ func (g *GenericDB) Ping() int {
 	return int(elapsed.Milliseconds())
 }
 
+// PingContext checks the connection to the database, within the deadline of the context
+func (g *GenericDB) PingContext(ctx context.Context) error {
+	pdo, err := g.pdo.connect()
+	if err != nil {
+		return err
+	}
+	_, err = pdo.ExecContext(ctx, "SELECT 1")
+	return err
+}
+
 func (g *GenericDB) GetCacheKey() string {
 	gMap, _ := json.Marshal(map[string]interface{}{
 		"driver":   g.driver,
@@ -477,6 +640,8 @@ func (g *GenericDB) GetCacheKey() string {
 		"tables":   g.tables,
 		"mapping":  g.mapping,
 		"username": g.username,
+		"keys":     g.keyGenerators,
+		"hidden":   g.hiddenTables,
 	})
 	return fmt.Sprintf("%x", md5.Sum(gMap))
 }
@@ -33,7 +33,9 @@ func (gd *GenericDefinition) GetColumnType(column *ReflectedColumn, update bool)
 	}
 	columnType := gd.typeConverter.FromJdbc(column.GetType())
 	size := ""
-	if column.HasPrecision() && column.HasScale() {
+	if nativeType := gd.getUuidType(column); nativeType != "" {
+		columnType = nativeType
+	} else if column.HasPrecision() && column.HasScale() {
 		size = fmt.Sprintf("(%d,%d)", column.GetPrecision(), column.GetScale())
 	} else if column.HasPrecision() {
 		size = fmt.Sprintf("(%d)", column.GetPrecision())
@@ -45,6 +47,20 @@ func (gd *GenericDefinition) GetColumnType(column *ReflectedColumn, update bool)
 	return fmt.Sprintf("%s%s%s%s", columnType, size, null, auto)
 }
 
+// getUuidType returns the native uuid type of the driver for columns holding generated uuids
+func (gd *GenericDefinition) getUuidType(column *ReflectedColumn) string {
+	switch column.GetGenerator() {
+	case "uuid", "uuidv4", "uuidv7":
+		switch gd.driver {
+		case "pgsql":
+			return "uuid"
+		case "sqlsrv":
+			return "uniqueidentifier"
+		}
+	}
+	return ""
+}
+
 func (gd *GenericDefinition) getPrimaryKey(tableName string) string {
 	pks := gd.reflection.GetTablePrimaryKeys(tableName)
 	if len(pks) == 1 {
@@ -472,7 +488,11 @@ func (gd *GenericDefinition) RemoveColumn(tableName, columnName string) error {
 }
 
 func (gd *GenericDefinition) exec(sql string, parameters ...interface{}) (sql.Result, error) {
-	res, err := gd.pdo.connect().Exec(sql, parameters...)
+	pdo, err := gd.pdo.connect()
+	if err != nil {
+		return nil, err
+	}
+	res, err := pdo.Exec(sql, parameters...)
 	if err != nil {
 		return nil, err
 	}
@@ -2,9 +2,10 @@ package database
 
 import (
 	"fmt"
-	"log"
 	"regexp"
 	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type GenericReflection struct {
@@ -14,20 +15,51 @@ type GenericReflection struct {
 	tables        map[string]bool
 	mapper        *RealNameMapper
 	typeConverter *TypeConverter
+	keyGenerators map[string]string
+	hiddenTables  []string
 }
 
 func NewGenericReflection(pdo *LazyPdo, driver string, database string, tables map[string]bool, mapper *RealNameMapper) *GenericReflection {
-	return &GenericReflection{pdo, driver, database, tables, mapper, NewTypeConverter(driver)}
+	return &GenericReflection{pdo, driver, database, tables, mapper, NewTypeConverter(driver), map[string]string{}, []string{}}
+}
+
+// SetKeyGenerators sets the configured primary key generators, as "table.column" => generator
+func (r *GenericReflection) SetKeyGenerators(keyGenerators map[string]string) {
+	r.keyGenerators = map[string]string{}
+	for key, generator := range keyGenerators {
+		if IsKeyGenerator(generator) {
+			r.keyGenerators[key] = strings.ToLower(generator)
+		} else {
+			utils.Log.Warning("unknown key generator", "generator", generator, "column", key)
+		}
+	}
+}
+
+// getKeyGenerator returns the generator of the primary key of a table, configured or from its uuid data type
+func (r *GenericReflection) getKeyGenerator(tableName, columnName, dataType string) string {
+	if generator, exists := r.keyGenerators[tableName+"."+columnName]; exists {
+		return generator
+	}
+	switch strings.ToLower(dataType) {
+	case "uuid", "uniqueidentifier":
+		return "uuid"
+	}
+	return ""
+}
+
+// SetHiddenTables sets the tables used internally (ex : sessions) that are not published
+func (r *GenericReflection) SetHiddenTables(hiddenTables []string) {
+	r.hiddenTables = hiddenTables
 }
 
 func (r *GenericReflection) GetIgnoredTables() []string {
 	switch r.driver {
 	case "pgsql":
-		return []string{"spatial_ref_sys", "raster_columns", "raster_overviews", "geography_columns", "geometry_columns"}
+		return append([]string{"spatial_ref_sys", "raster_columns", "raster_overviews", "geography_columns", "geometry_columns"}, r.hiddenTables...)
 	case "sqlite":
-		return []string{"sqlite_sequence"}
+		return append([]string{"sqlite_sequence"}, r.hiddenTables...)
 	default:
-		return []string{}
+		return append([]string{}, r.hiddenTables...)
 	}
 }
 
@@ -212,8 +244,13 @@ func (r *GenericReflection) ToJdbcType(jdbcType string, size string) string {
 
 // Should check errors
 func (r *GenericReflection) query(sql string, parameters ...interface{}) []map[string]interface{} {
-	if rows, err := r.pdo.connect().Query(sql, parameters...); err != nil {
-		log.Printf("Error executing request : %s got : %s", sql, err)
+	pdo, err := r.pdo.connect()
+	if err != nil {
+		utils.Log.Error("unable to execute the reflection query", "sql", sql, "error", err)
+		return nil
+	}
+	if rows, err := pdo.Query(sql, parameters...); err != nil {
+		utils.Log.Error("unable to execute the reflection query", "sql", sql, "error", err)
 		return nil
 	} else {
 		results, _ := r.pdo.Rows2Map(rows)
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// crockford base32 alphabet used by ulids
+const ulidEncoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// IsKeyGenerator returns true if generator is a supported primary key generator
+func IsKeyGenerator(generator string) bool {
+	switch strings.ToLower(generator) {
+	case "uuid", "uuidv4", "uuidv7", "ulid":
+		return true
+	}
+	return false
+}
+
+// sameKeyGenerator returns true if both generators produce the same kind of keys ("uuid" being "uuidv4")
+func sameKeyGenerator(generator1, generator2 string) bool {
+	normalize := func(generator string) string {
+		if generator = strings.ToLower(generator); generator == "uuidv4" {
+			return "uuid"
+		}
+		return generator
+	}
+	return normalize(generator1) == normalize(generator2)
+}
+
+// GetKeyGeneratorLength returns the length of the keys produced by generator
+func GetKeyGeneratorLength(generator string) int {
+	if strings.ToLower(generator) == "ulid" {
+		return 26
+	}
+	return 36
+}
+
+// GenerateKey returns a new primary key value : a uuid v4 ("uuid" or "uuidv4"), a time ordered uuid v7 ("uuidv7") or an ulid ("ulid")
+func GenerateKey(generator string) (string, error) {
+	switch strings.ToLower(generator) {
+	case "uuid", "uuidv4":
+		return newUuidV4()
+	case "uuidv7":
+		return newUuidV7(time.Now())
+	case "ulid":
+		return newUlid(time.Now())
+	}
+	return "", fmt.Errorf("unknown key generator '%s'", generator)
+}
+
+func newUuidV4() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return formatUuid(b), nil
+}
+
+// newUuidV7 returns a uuid starting with the 48 bits unix timestamp in milliseconds (RFC 9562)
+func newUuidV7(t time.Time) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	ms := uint64(t.UnixMilli())
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+	b[6] = (b[6] & 0x0f) | 0x70
+	b[8] = (b[8] & 0x3f) | 0x80
+	return formatUuid(b), nil
+}
+
+func formatUuid(b [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newUlid returns a 26 characters ulid : 48 bits timestamp in milliseconds followed by 80 random bits
+func newUlid(t time.Time) (string, error) {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[0:8], uint64(t.UnixMilli())<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	// 128 bits are encoded in 26 characters of 5 bits, the first one only holding 3 bits
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+	var res [26]byte
+	for i := 25; i >= 0; i-- {
+		res[i] = ulidEncoding[lo&0x1f]
+		lo = (lo >> 5) | (hi << 59)
+		hi >>= 5
+	}
+	return string(res[:]), nil
+}
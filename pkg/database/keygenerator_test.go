@@ -0,0 +1,49 @@
+package database
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	formats := map[string]*regexp.Regexp{
+		"uuid":   regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		"uuidv7": regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		"ulid":   regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}$`),
+	}
+	for generator, format := range formats {
+		key, err := GenerateKey(generator)
+		if err != nil || !format.MatchString(key) || len(key) != GetKeyGeneratorLength(generator) {
+			t.Errorf("%s : got key '%s' (error %v)", generator, key, err)
+		}
+	}
+}
+
+func TestCheckKeyGenerator(t *testing.T) {
+	configured := map[string]string{"configured.id": "uuidv7"}
+	tt := []struct {
+		table     string
+		generator string
+		// drivers accepting the generator, the others refusing it
+		valid map[string]bool
+	}{
+		{"configured", "uuidv7", map[string]bool{"mysql": true, "pgsql": true, "sqlsrv": true, "sqlite": true}},
+		{"configured", "ulid", map[string]bool{}},
+		{"unconfigured", "", map[string]bool{"mysql": true, "pgsql": true, "sqlsrv": true, "sqlite": true}},
+		{"unconfigured", "ulid", map[string]bool{}},
+		{"unconfigured", "uuidv7", map[string]bool{}},
+		// detected from the native uuid type on the next reflection
+		{"unconfigured", "uuid", map[string]bool{"pgsql": true, "sqlsrv": true}},
+		{"unconfigured", "uuidv4", map[string]bool{"pgsql": true, "sqlsrv": true}},
+	}
+	for _, driver := range []string{"mysql", "pgsql", "sqlsrv", "sqlite"} {
+		db := NewGenericDB(driver, "localhost", 0, "go-crud-api", nil, nil, "go-crud-api", "go-crud-api")
+		db.SetKeyGenerators(configured)
+		for _, tc := range tt {
+			column := NewReflectedColumnFromJson(map[string]interface{}{"name": "id", "pk": true, "generator": tc.generator})
+			if err := db.checkKeyGenerator(tc.table, column); (err == nil) != tc.valid[driver] {
+				t.Errorf("%s : generator '%s' of %s.id, got error %v, want valid %v", driver, tc.generator, tc.table, err, tc.valid[driver])
+			}
+		}
+	}
+}
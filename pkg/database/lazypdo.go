@@ -4,8 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"strings"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 
 	_ "github.com/denisenkom/go-mssqldb"
 	_ "github.com/go-sql-driver/mysql"
@@ -15,18 +17,21 @@ import (
 
 // LazyPdo is a custom db client
 type LazyPdo struct {
-	dsn      string
-	user     string
-	password string
-	options  map[string]string
-	commands []string
-	pdo      *sql.DB
+	dsn       string
+	user      string
+	password  string
+	options   map[string]string
+	commands  []string
+	pdo       *sql.DB
+	isolation sql.IsolationLevel
+	ctx       context.Context
+	slowQuery time.Duration
 }
 
 func NewLazyPdo(dsn string, user string, password string, options map[string]string) *LazyPdo {
-	l := &LazyPdo{dsn, user, password, options, nil, nil}
-	if conn := l.connect(); conn == nil {
-		panic("Connection failed to database")
+	l := &LazyPdo{dsn, user, password, options, nil, nil, sql.LevelDefault, nil, 0}
+	if _, err := l.connect(); err != nil {
+		panic(err.Error())
 	}
 	return l
 }
@@ -37,11 +42,13 @@ func (l *LazyPdo) AddInitCommand(command string) {
 
 // pdo connect to database
 // should deals with compatible databases
-func (l *LazyPdo) connect() *sql.DB {
+func (l *LazyPdo) connect() (*sql.DB, error) {
 	if l.pdo == nil {
-		var err error
-		var auth string
+		var driver, source, auth string
 		splitDsn := strings.SplitN(l.dsn, ":", 2)
+		if len(splitDsn) < 2 {
+			return nil, fmt.Errorf("invalid dsn '%s'", l.dsn)
+		}
 		dsn := splitDsn[1]
 		switch splitDsn[0] {
 		case "mysql":
@@ -49,52 +56,41 @@ func (l *LazyPdo) connect() *sql.DB {
 			if l.user != "" && l.password != "" {
 				auth = fmt.Sprintf("%s:%s@", l.user, l.password)
 			}
-			if l.pdo, err = sql.Open("mysql", fmt.Sprintf("%s%s", auth, dsn)); err != nil {
-				log.Fatalf("Connection failed to database %s with error : %s", dsn, err)
-			} else {
-				log.Printf("Connected to %s", dsn)
-			}
+			driver, source = "mysql", fmt.Sprintf("%s%s", auth, dsn)
 		case "pgsql":
 			if l.user != "" && l.password != "" {
 				auth = fmt.Sprintf(" user=%s password=%s ", l.user, l.password)
 			}
 			//Should add an option for ssl
-			if l.pdo, err = sql.Open("postgres", fmt.Sprintf("%s %s sslmode=disable", auth, dsn)); err != nil {
-				log.Fatalf("Connection failed to database %s with error : %s", dsn, err)
-			} else {
-				log.Printf("Connected to %s", dsn)
-			}
+			driver, source = "postgres", fmt.Sprintf("%s %s sslmode=disable", auth, dsn)
 		case "sqlsrv":
 			if l.user != "" && l.password != "" {
 				auth = fmt.Sprintf(";user id=%s;password=%s ", l.user, l.password)
 			}
-			if l.pdo, err = sql.Open("sqlserver", fmt.Sprintf("%s%s", dsn, auth)); err != nil {
-				log.Fatalf("Connection failed to database %s with error : %s", dsn, err)
-				return nil
-			} else {
-				log.Printf("Connected to %s", dsn)
-			}
+			driver, source = "sqlserver", fmt.Sprintf("%s%s", dsn, auth)
 		case "sqlite":
 			//file:test.s3db?_auth&_auth_user=admin&_auth_pass=admin
 			if l.user != "" && l.password != "" {
 				auth = fmt.Sprintf("&_auth&_auth_user=%s&_auth_pass=%s", l.user, l.password)
 			}
-			if l.pdo, err = sql.Open("sqlite3", fmt.Sprintf("%s%s", dsn, auth)); err != nil {
-				log.Fatalf("Connection failed to database %s with error : %s", dsn, err)
-				return nil
-			} else {
-				log.Printf("Connected to %s", dsn)
-			}
+			driver, source = "sqlite3", fmt.Sprintf("%s%s", dsn, auth)
 		default:
+			return nil, fmt.Errorf("unknown database driver '%s'", splitDsn[0])
+		}
+		pdo, err := sql.Open(driver, source)
+		if err != nil {
+			return nil, fmt.Errorf("connection failed to database %s with error : %s", dsn, err.Error())
 		}
 		for _, command := range l.commands {
-			_, err := l.Query(nil, command, "")
-			if err != nil {
-				log.Fatalf("Init commands failed on database %s with error : %s", dsn, err)
+			if _, err := pdo.Exec(command); err != nil {
+				pdo.Close()
+				return nil, fmt.Errorf("init commands failed on database %s with error : %s", dsn, err.Error())
 			}
 		}
+		l.pdo = pdo
+		utils.Log.Info("connected to the database", "driver", splitDsn[0])
 	}
-	return l.pdo
+	return l.pdo, nil
 }
 
 func (l *LazyPdo) Reconstruct(dsn string, user string, password string, options map[string]string) bool {
@@ -132,8 +128,36 @@ func (l *LazyPdo) GetAttribute(attribute string) interface{} {
 	return nil
 }
 
+// SetIsolationLevel sets the isolation level used by the transactions started with BeginTransaction
+func (l *LazyPdo) SetIsolationLevel(isolation sql.IsolationLevel) {
+	l.isolation = isolation
+}
+
+// SetSlowQueryThreshold sets the duration above which the queries are logged, 0 disables the log
+func (l *LazyPdo) SetSlowQueryThreshold(threshold time.Duration) {
+	l.slowQuery = threshold
+}
+
 func (l *LazyPdo) BeginTransaction() (*sql.Tx, error) {
-	return l.connect().BeginTx(context.Background(), nil)
+	pdo, err := l.connect()
+	if err != nil {
+		return nil, err
+	}
+	if l.isolation == sql.LevelDefault {
+		return pdo.BeginTx(context.Background(), nil)
+	}
+	return pdo.BeginTx(context.Background(), &sql.TxOptions{Isolation: l.isolation})
+}
+
+// WithContext returns a copy of the client sharing the connection pool, whose queries are traced
+// as children of the span of the context
+func (l *LazyPdo) WithContext(ctx context.Context) *LazyPdo {
+	if _, err := l.connect(); err != nil {
+		utils.Log.Error("unable to connect to the database", "error", err)
+	}
+	scoped := *l
+	scoped.ctx = ctx
+	return &scoped
 }
 
 // Should check return status
@@ -179,43 +203,99 @@ func (l *LazyPdo) LastInsertId($name = null): string
 */
 
 func (l *LazyPdo) Exec(tx *sql.Tx, req string, parameters ...interface{}) (sql.Result, error) {
+	start, span := time.Now(), l.startSpan("exec", req)
+	var result sql.Result
+	var err error
 	if tx == nil {
-		return l.connect().Exec(req, parameters...)
+		var pdo *sql.DB
+		if pdo, err = l.connect(); err == nil {
+			result, err = pdo.Exec(req, parameters...)
+		}
 	} else {
-		return tx.Exec(req, parameters...)
+		result, err = tx.Exec(req, parameters...)
 	}
+	l.observeQuery("exec", req, start, span, err)
+	return result, err
 }
 
 func (l *LazyPdo) Query(tx *sql.Tx, req string, parameters ...interface{}) ([]map[string]interface{}, error) {
+	start, span := time.Now(), l.startSpan("query", req)
 	var err error
 	var rows *sql.Rows
 	if tx == nil {
-		rows, err = l.connect().Query(req, parameters...)
+		var pdo *sql.DB
+		if pdo, err = l.connect(); err == nil {
+			rows, err = pdo.Query(req, parameters...)
+		}
 	} else {
 		rows, err = tx.Query(req, parameters...)
 	}
 	if err != nil {
+		l.observeQuery("query", req, start, span, err)
 		return nil, err
 	}
 	results, err := l.Rows2Map(rows)
+	l.observeQuery("query", req, start, span, err)
 	return results, err
 }
 
 func (l *LazyPdo) QueryRowSingleColumn(tx *sql.Tx, req string, parameters ...interface{}) (interface{}, error) {
+	start, span := time.Now(), l.startSpan("query", req)
 	var row *sql.Row
 	if tx == nil {
-		row = l.connect().QueryRow(req, parameters...)
+		pdo, err := l.connect()
+		if err != nil {
+			l.observeQuery("query", req, start, span, err)
+			return nil, err
+		}
+		row = pdo.QueryRow(req, parameters...)
 	} else {
 		row = tx.QueryRow(req, parameters...)
 	}
 	var result interface{}
 	if err := row.Scan(&result); err != nil {
+		if err == sql.ErrNoRows {
+			l.observeQuery("query", req, start, span, nil)
+		} else {
+			l.observeQuery("query", req, start, span, err)
+		}
 		return nil, err
 	} else {
+		l.observeQuery("query", req, start, span, nil)
 		return result, nil
 	}
 }
 
+// startSpan starts the span of a database query with the sql text, the parameter values are not recorded
+func (l *LazyPdo) startSpan(method, req string) *utils.Span {
+	_, span := utils.StartSpan(l.ctx, "SQL "+method, utils.SpanKindClient, "db.system", strings.SplitN(l.dsn, ":", 2)[0], "db.statement", req)
+	return span
+}
+
+// observeQuery records the duration and the failure of a database query in the metrics, ends its span
+// and logs the query if slow
+func (l *LazyPdo) observeQuery(method, req string, start time.Time, span *utils.Span, err error) {
+	span.SetError(err)
+	span.Finish()
+	duration := time.Since(start)
+	if l.slowQuery > 0 && duration >= l.slowQuery {
+		utils.Log.Warning("slow query", "requestId", utils.RequestIdFromContext(l.ctx), "method", method,
+			"durationMs", float64(duration.Microseconds())/1000, "statement", req)
+	}
+	utils.Metrics.Observe("gocrudapi_db_query_duration_seconds", "Duration of the database queries in seconds", duration.Seconds(), "method", method)
+	if err != nil {
+		utils.Metrics.AddCounter("gocrudapi_db_query_errors_total", "Number of failed database queries", 1, "method", method)
+	}
+}
+
+// Stats returns the statistics of the connection pool
+func (l *LazyPdo) Stats() sql.DBStats {
+	if l.pdo == nil {
+		return sql.DBStats{}
+	}
+	return l.pdo.Stats()
+}
+
 // from https://kylewbanks.com/blog/query-result-to-map-in-golang
 func (l *LazyPdo) Rows2Map(rows *sql.Rows) ([]map[string]interface{}, error) {
 	result := []map[string]interface{}{}
@@ -261,7 +341,7 @@ func (l *LazyPdo) Rows2Map(rows *sql.Rows) ([]map[string]interface{}, error) {
 func (l *LazyPdo) CloseConn() {
 	if l.pdo != nil {
 		if err := l.pdo.Close(); err != nil {
-			log.Printf("ERRROR : unable to close database connection : %s", err.Error())
+			utils.Log.Error("unable to close database connection", "error", err)
 		}
 	}
 }
@@ -3,9 +3,10 @@ package database
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type ReflectedColumn struct {
@@ -18,6 +19,7 @@ type ReflectedColumn struct {
 	nullable   bool
 	pk         bool
 	fk         string
+	generator  string
 }
 
 const (
@@ -28,7 +30,7 @@ const (
 
 // done
 func NewReflectedColumn(name, realName, columnType string, length, precision, scale int, nullable, pk bool, fk string) *ReflectedColumn {
-	r := &ReflectedColumn{name, realName, columnType, length, precision, scale, nullable, pk, fk}
+	r := &ReflectedColumn{name, realName, columnType, length, precision, scale, nullable, pk, fk, ""}
 	r.sanitize()
 	return r
 }
@@ -46,7 +48,7 @@ func parseColumnType(columnType string, length, precision, scale *int) {
 			*length, err = strconv.Atoi(dataSize)
 			if err != nil {
 				*length = -1
-				log.Printf("Error parsing column type length : %v", err)
+				utils.Log.Error("unable to parse the column type length", "type", columnType, "error", err)
 			}
 		} else {
 			pos = strings.Index(dataSize, ",")
@@ -54,18 +56,18 @@ func parseColumnType(columnType string, length, precision, scale *int) {
 				*precision, err = strconv.Atoi(dataSize[:pos])
 				if err != nil {
 					*precision = -1
-					log.Printf("Error parsing column type precision : %v", err)
+					utils.Log.Error("unable to parse the column type precision", "type", columnType, "error", err)
 				}
 				*scale, err = strconv.Atoi(dataSize[pos+1:])
 				if err != nil {
 					*scale = -1
-					log.Printf("Error parsing column type scale : %v", err)
+					utils.Log.Error("unable to parse the column type scale", "type", columnType, "error", err)
 				}
 			} else {
 				*precision, err = strconv.Atoi(dataSize)
 				if err != nil {
 					*precision = -1
-					log.Printf("Error parsing column type precision : %v", err)
+					utils.Log.Error("unable to parse the column type precision", "type", columnType, "error", err)
 				}
 				*scale = -1
 			}
@@ -170,8 +172,19 @@ func NewReflectedColumnFromJson(json map[string]interface{}) *ReflectedColumn {
 	if l, exists := json["fk"]; exists {
 		fk = fmt.Sprint(l)
 	}
+	generator := ""
+	if l, exists := json["generator"]; exists && IsKeyGenerator(fmt.Sprint(l)) {
+		generator = strings.ToLower(fmt.Sprint(l))
+		// keys generated by the server are stored as strings by default
+		if _, exists := json["type"]; !exists {
+			columnType = "varchar"
+			length = GetKeyGeneratorLength(generator)
+		}
+	}
 
-	return NewReflectedColumn(name, realName, columnType, length, precision, scale, nullable, pk, fk)
+	column := NewReflectedColumn(name, realName, columnType, length, precision, scale, nullable, pk, fk)
+	column.SetGenerator(generator)
+	return column
 }
 
 func (rc *ReflectedColumn) sanitize() {
@@ -288,6 +301,15 @@ func (rc *ReflectedColumn) GetFk() string {
 	return rc.fk
 }
 
+// SetGenerator sets how the value of the primary key is generated when not provided on create (uuid, uuidv7 or ulid)
+func (rc *ReflectedColumn) SetGenerator(value string) {
+	rc.generator = value
+}
+
+func (rc *ReflectedColumn) GetGenerator() string {
+	return rc.generator
+}
+
 func (rc *ReflectedColumn) Serialize() map[string]interface{} {
 	res := map[string]interface{}{
 		"name": rc.realName,
@@ -314,6 +336,9 @@ func (rc *ReflectedColumn) Serialize() map[string]interface{} {
 	if rc.fk != "" {
 		res["fk"] = rc.fk
 	}
+	if rc.generator != "" {
+		res["generator"] = rc.generator
+	}
 
 	return res
 
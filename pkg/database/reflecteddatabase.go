@@ -2,6 +2,7 @@ package database
 
 import (
 	"encoding/json"
+	"sort"
 )
 
 type ReflectedDatabase struct {
@@ -82,6 +83,7 @@ func (rd *ReflectedDatabase) GetTableNames() []string {
 		keys[i] = key
 		i++
 	}
+	sort.Strings(keys)
 	return keys
 }
 
@@ -94,6 +96,19 @@ func (rd *ReflectedDatabase) RemoveTable(tableName string) bool {
 	return true
 }
 
+// Clone returns a copy of the database whose tables can be removed without changing the original
+func (rd *ReflectedDatabase) Clone() *ReflectedDatabase {
+	tableTypes := map[string]string{}
+	for tableName, tableType := range rd.tableTypes {
+		tableTypes[tableName] = tableType
+	}
+	tableRealNames := map[string]string{}
+	for tableName, tableRealName := range rd.tableRealNames {
+		tableRealNames[tableName] = tableRealName
+	}
+	return NewReflectedDatabase(tableTypes, tableRealNames)
+}
+
 func (rd *ReflectedDatabase) Serialize() map[string]interface{} {
 	return map[string]interface{}{
 		"types":     rd.tableTypes,
@@ -43,9 +43,11 @@ func NewReflectedTable(name, realName, tableType string, columns map[string]*Ref
 func NewReflectedTableFromReflection(reflection *GenericReflection, name, realName, viewType string) *ReflectedTable {
 	// set columns
 	columns := map[string]*ReflectedColumn{}
+	dataTypes := map[string]string{}
 	for _, tableColumn := range reflection.GetTableColumns(name, viewType) {
 		column := NewReflectedColumnFromReflection(reflection, tableColumn)
 		columns[column.GetName()] = column
+		dataTypes[column.GetName()] = fmt.Sprint(tableColumn["DATA_TYPE"])
 	}
 	// set primary key
 	columnName := ""
@@ -59,6 +61,7 @@ func NewReflectedTableFromReflection(reflection *GenericReflection, name, realNa
 	}
 	if _, ok := columns[columnName]; columnName != "" && ok {
 		columns[columnName].SetPk(true)
+		columns[columnName].SetGenerator(reflection.getKeyGenerator(name, columnName, dataTypes[columnName]))
 	}
 	// set foreign keys
 	if viewType == "view" {
@@ -146,6 +149,7 @@ func (rt *ReflectedTable) GetColumnNames() []string {
 	for key := range rt.columns {
 		result = append(result, key)
 	}
+	sort.Strings(result)
 	return result
 }
 
@@ -171,6 +175,11 @@ func (rt *ReflectedTable) RemoveColumn(columnName string) bool {
 	return true
 }
 
+// Clone returns a copy of the table whose columns can be removed without changing the original
+func (rt *ReflectedTable) Clone() *ReflectedTable {
+	return NewReflectedTable(rt.name, rt.realName, rt.tableType, rt.columns)
+}
+
 func (rt *ReflectedTable) Serialize() map[string]interface{} {
 	var columns []*ReflectedColumn
 
@@ -1,21 +1,31 @@
 package database
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/dranih/go-crud-api/pkg/cache"
 	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
+// ReflectionService holds the reflected database and the tables loaded on their first use. The mutex guards
+// the tables map and the database, replaced by the reloads while the requests read them : the database is
+// not changed in place, RemoveTable replacing it by a copy.
 type ReflectionService struct {
+	mutex    sync.RWMutex
 	db       *GenericDB
 	cache    cache.Cache
 	ttl      int32
 	database *ReflectedDatabase
 	tables   map[string]*ReflectedTable
+	loadedAt time.Time
+	// the service a request scoped reflection is copied from
+	parent *ReflectionService
 }
 
 func NewReflectionService(db *GenericDB, lcache cache.Cache, ttl int32) *ReflectionService {
@@ -23,15 +33,47 @@ func NewReflectionService(db *GenericDB, lcache cache.Cache, ttl int32) *Reflect
 		prefix := fmt.Sprintf("gocrudapi-%d-", os.Getpid())
 		lcache = cache.Create("TempFile", prefix, "")
 	}
-	return &ReflectionService{db, lcache, ttl, nil, map[string]*ReflectedTable{}}
+	return &ReflectionService{db: db, cache: lcache, ttl: ttl, tables: map[string]*ReflectedTable{}}
 }
 
-// done
+// Scope returns a copy of the reflection for a request, the tables and columns removed from the copy
+// (ex : the ones denied to the user) being kept in the service
+func (rs *ReflectionService) Scope() *ReflectionService {
+	database := rs.getDatabase().Clone()
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	return &ReflectionService{db: rs.db, cache: rs.cache, ttl: rs.ttl, database: database, tables: map[string]*ReflectedTable{}, loadedAt: rs.loadedAt, parent: rs}
+}
+
+type reflectionContextKey struct{}
+
+// ContextWithReflection returns a context holding the request scoped reflection
+func ContextWithReflection(ctx context.Context, reflection *ReflectionService) context.Context {
+	return context.WithValue(ctx, reflectionContextKey{}, reflection)
+}
+
+// ReflectionFromContext returns the request scoped reflection, nil if none
+func ReflectionFromContext(ctx context.Context) *ReflectionService {
+	if reflection, ok := ctx.Value(reflectionContextKey{}).(*ReflectionService); ok {
+		return reflection
+	}
+	return nil
+}
+
+// getDatabase returns the reflected database, loaded on first use
 func (rs *ReflectionService) getDatabase() *ReflectedDatabase {
-	if rs.database != nil {
-		return rs.database
+	rs.mutex.RLock()
+	database := rs.database
+	rs.mutex.RUnlock()
+	if database != nil {
+		return database
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if rs.database == nil {
+		rs.database = rs.loadDatabase(true)
+		rs.loadedAt = time.Now()
 	}
-	rs.database = rs.loadDatabase(true)
 	return rs.database
 }
 
@@ -48,27 +90,32 @@ func (rs *ReflectionService) loadDatabase(useCache bool) *ReflectedDatabase {
 		if err == nil {
 			database = NewReflectedDatabaseFromJson(ungzipData)
 		} else {
-			log.Printf("Error cache uncompress : %v", err)
+			utils.Log.Error("unable to uncompress the cached reflection", "error", err)
 		}
 	}
 	if database == nil {
+		utils.Metrics.AddCounter("gocrudapi_reflection_reloads_total", "Number of reflections read from the database", 1, "kind", "database")
 		database = NewReflectedDatabaseFromReflection(rs.db.Reflection())
 		if jsonData, err := json.Marshal(database); err == nil {
 			if data, err := utils.GzCompress(string(jsonData)); err == nil {
 				rs.cache.Set(key, data, rs.ttl)
 			} else {
-				log.Printf("Error cache compress : %v", err)
+				utils.Log.Error("unable to compress the reflection for caching", "error", err)
 			}
 		} else {
-			log.Printf("Error marshaling database for caching : %v", err)
+			utils.Log.Error("unable to marshal the database for caching", "error", err)
 		}
 	}
 	return database
 }
 
+func (rs *ReflectionService) getTableCacheKey(tableName string) string {
+	return fmt.Sprintf("%s-ReflectedTable(%s)", rs.db.GetCacheKey(), tableName)
+}
+
 // to finish with cache
 func (rs *ReflectionService) loadTable(tableName string, useCache bool) *ReflectedTable {
-	key := fmt.Sprintf("%s-ReflectedTable(%s)", rs.db.GetCacheKey(), tableName)
+	key := rs.getTableCacheKey(tableName)
 	var data string
 	var table *ReflectedTable
 	if useCache {
@@ -79,37 +126,93 @@ func (rs *ReflectionService) loadTable(tableName string, useCache bool) *Reflect
 		if err == nil {
 			var jsonData map[string]interface{}
 			if err := json.Unmarshal([]byte(ungzipData), &jsonData); err != nil {
-				log.Printf("Error cache table unmarshalling : %v", err)
+				utils.Log.Error("unable to unmarshal the cached table", "table", tableName, "error", err)
 			} else {
 				table = NewReflectedTableFromJson(jsonData)
 			}
 		} else {
-			log.Printf("Error cache uncompress : %v", err)
+			utils.Log.Error("unable to uncompress the cached reflection", "error", err)
 		}
 	}
 	if table == nil {
 		tableType := rs.getDatabase().GetType(tableName)
 		tableRealName := rs.getDatabase().GetRealName(tableName)
+		utils.Metrics.AddCounter("gocrudapi_reflection_reloads_total", "Number of reflections read from the database", 1, "kind", "table")
 		table = NewReflectedTableFromReflection(rs.db.Reflection(), tableName, tableRealName, tableType)
 		if jsonData, err := json.Marshal(table); err == nil {
 			if data, err := utils.GzCompress(string(jsonData)); err == nil {
 				rs.cache.Set(key, data, rs.ttl)
 			} else {
-				log.Printf("Error cache compress : %v", err)
+				utils.Log.Error("unable to compress the reflection for caching", "error", err)
 			}
 		} else {
-			log.Printf("Error marshaling table for caching : %v", err)
+			utils.Log.Error("unable to marshal the table for caching", "table", tableName, "error", err)
 		}
 	}
 	return table
 }
 
 func (rs *ReflectionService) RefreshTables() {
-	rs.database = rs.loadDatabase(false)
+	database := rs.loadDatabase(false)
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.database = database
+	rs.loadedAt = time.Now()
 }
 
 func (rs *ReflectionService) RefreshTable(tableName string) {
-	rs.tables[tableName] = rs.loadTable(tableName, false)
+	table := rs.loadTable(tableName, false)
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.tables[tableName] = table
+}
+
+// ResetTable drops the loaded table, the next GetTable reads it again from the cache
+func (rs *ReflectionService) ResetTable(tableName string) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	delete(rs.tables, tableName)
+}
+
+// Reload reads again the tables from the database, the cached tables being removed for them to be read on their next use
+func (rs *ReflectionService) Reload() {
+	for _, tableName := range rs.getDatabase().GetTableNames() {
+		rs.cache.Delete(rs.getTableCacheKey(tableName))
+	}
+	database := rs.loadDatabase(false)
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.tables = map[string]*ReflectedTable{}
+	rs.database = database
+	rs.loadedAt = time.Now()
+}
+
+// PurgeTable removes the table from the cache and from the loaded tables, returns false if the table does not exist
+func (rs *ReflectionService) PurgeTable(tableName string) bool {
+	if !rs.HasTable(tableName) {
+		return false
+	}
+	rs.cache.Delete(rs.getTableCacheKey(tableName))
+	rs.ResetTable(tableName)
+	return true
+}
+
+// Status returns the reflected tables, the ones loaded and when the reflection was loaded
+func (rs *ReflectionService) Status() map[string]interface{} {
+	tableNames := rs.getDatabase().GetTableNames()
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	loadedTables := []string{}
+	for tableName := range rs.tables {
+		loadedTables = append(loadedTables, tableName)
+	}
+	sort.Strings(loadedTables)
+	return map[string]interface{}{
+		"tables":       tableNames,
+		"loadedTables": loadedTables,
+		"loadedAt":     rs.loadedAt.Format(time.RFC3339),
+		"cacheTtl":     rs.ttl,
+	}
 }
 
 func (rs *ReflectionService) HasTable(tableName string) bool {
@@ -121,17 +224,62 @@ func (rs *ReflectionService) GetType(tableName string) string {
 }
 
 func (rs *ReflectionService) GetTable(tableName string) *ReflectedTable {
-	if _, ok := rs.tables[tableName]; !ok {
-		rs.tables[tableName] = rs.loadTable(tableName, true)
+	rs.mutex.RLock()
+	table, ok := rs.tables[tableName]
+	rs.mutex.RUnlock()
+	if ok {
+		return table
+	}
+	// the table is loaded without the lock, as loading it reads the database
+	if rs.parent != nil {
+		table = rs.parent.GetTable(tableName).Clone()
+	} else {
+		table = rs.loadTable(tableName, true)
 	}
-	return rs.tables[tableName]
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if loaded, ok := rs.tables[tableName]; ok {
+		return loaded
+	}
+	rs.tables[tableName] = table
+	return table
 }
 
 func (rs *ReflectionService) GetTableNames() []string {
 	return rs.getDatabase().GetTableNames()
 }
 
+// Check loads the reflection of the database if not done yet, returns an error if no table is published
+func (rs *ReflectionService) Check() error {
+	if len(rs.getDatabase().GetTableNames()) == 0 {
+		return fmt.Errorf("no table reflected")
+	}
+	return nil
+}
+
 func (rs *ReflectionService) RemoveTable(tableName string) bool {
+	rs.getDatabase()
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
 	delete(rs.tables, tableName)
-	return rs.getDatabase().RemoveTable(tableName)
+	// the database read by the other requests is replaced, not changed
+	database := rs.database.Clone()
+	removed := database.RemoveTable(tableName)
+	rs.database = database
+	return removed
+}
+
+// replaceTable sets the loaded table after a definition change, the table named oldName being removed if any
+func (rs *ReflectionService) replaceTable(oldName string, table *ReflectedTable) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if oldName != "" {
+		delete(rs.tables, oldName)
+	}
+	rs.tables[table.GetRealName()] = table
+}
+
+// GetAutoColumns returns the columns of a table filled by the server, by column name
+func (rs *ReflectionService) GetAutoColumns(tableName string) map[string]*AutoColumn {
+	return rs.db.GetAutoColumns(tableName)
 }
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+func TestReflectionServiceConcurrency(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	defer os.Remove(db_path)
+	db := NewGenericDB("sqlite", db_path, 0, "go-crud-api", nil, nil, "go-crud-api", "go-crud-api")
+	defer db.PDO().CloseConn()
+	reflection := NewReflectionService(db, nil, 0)
+
+	// the admin actions run while the requests read the tables
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				switch (i + j) % 4 {
+				case 0:
+					reflection.Reload()
+				case 1:
+					reflection.PurgeTable("categories")
+				case 2:
+					reflection.Status()
+				default:
+					if table := reflection.GetTable("categories"); table == nil || !table.HasColumn("name") {
+						t.Errorf("Want table categories with column name")
+					}
+					reflection.Scope().RemoveTable("categories")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if !reflection.HasTable("categories") {
+		t.Errorf("Want table categories kept after the removal from a scoped reflection")
+	}
+}
+
+func TestReflectionServiceScope(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	defer os.Remove(db_path)
+	db := NewGenericDB("sqlite", db_path, 0, "go-crud-api", nil, nil, "go-crud-api", "go-crud-api")
+	defer db.PDO().CloseConn()
+	reflection := NewReflectionService(db, nil, 0)
+
+	scoped := reflection.Scope()
+	if !scoped.RemoveTable("invisibles") {
+		t.Errorf("Want table invisibles removed from the scoped reflection")
+	}
+	if !scoped.GetTable("kunsthåndværk").RemoveColumn("invisible") {
+		t.Errorf("Want column invisible removed from the scoped reflection")
+	}
+	if scoped.HasTable("invisibles") || scoped.GetTable("kunsthåndværk").HasColumn("invisible") {
+		t.Errorf("Want table invisibles and column invisible absent from the scoped reflection")
+	}
+	if !reflection.HasTable("invisibles") || !reflection.GetTable("kunsthåndværk").HasColumn("invisible") {
+		t.Errorf("Want table invisibles and column invisible kept in the reflection")
+	}
+	if !reflection.Scope().GetTable("kunsthåndværk").HasColumn("invisible") {
+		t.Errorf("Want column invisible in a new scoped reflection")
+	}
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DbSessionBackend keeps the server side sessions in a database table with the columns
+// "id" (varchar primary key), "data" (text) and "expires" (bigint unix timestamp)
+// The table is hidden from the api. It also keeps the dbAuth refresh tokens (the user id in "data")
+type DbSessionBackend struct {
+	db        *GenericDB
+	tableName string
+	table     *ReflectedTable
+}
+
+func NewDbSessionBackend(db *GenericDB, tableName string) *DbSessionBackend {
+	db.HideTable(tableName)
+	return &DbSessionBackend{db: db, tableName: tableName}
+}
+
+func (dsb *DbSessionBackend) getTable() (*ReflectedTable, error) {
+	if dsb.table == nil {
+		table := NewReflectedTableFromReflection(dsb.db.Reflection(), dsb.tableName, dsb.tableName, "table")
+		for _, columnName := range []string{"id", "data", "expires"} {
+			if !table.HasColumn(columnName) {
+				return nil, fmt.Errorf("session table '%s' has no column '%s'", dsb.tableName, columnName)
+			}
+		}
+		dsb.table = table
+	}
+	return dsb.table, nil
+}
+
+func (dsb *DbSessionBackend) Load(id string) (string, error) {
+	table, err := dsb.getTable()
+	if err != nil {
+		return "", err
+	}
+	records := dsb.db.SelectSingle(nil, table, []string{"data", "expires"}, id)
+	if len(records) == 0 {
+		return "", nil
+	}
+	expires, err := strconv.ParseInt(fmt.Sprint(records[0]["expires"]), 10, 64)
+	if err != nil || expires < time.Now().Unix() {
+		_, err := dsb.db.DeleteSingle(nil, table, id)
+		return "", err
+	}
+	return fmt.Sprint(records[0]["data"]), nil
+}
+
+func (dsb *DbSessionBackend) Save(id, data string, maxAge int) error {
+	table, err := dsb.getTable()
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{"data": data, "expires": time.Now().Unix() + int64(maxAge)}
+	affected, err := dsb.db.UpdateSingle(nil, table, values, id)
+	if err != nil || affected > 0 {
+		return err
+	}
+	values["id"] = id
+	_, err = dsb.db.CreateSingle(nil, table, values)
+	return err
+}
+
+func (dsb *DbSessionBackend) Delete(id string) (int64, error) {
+	table, err := dsb.getTable()
+	if err != nil {
+		return 0, err
+	}
+	return dsb.db.DeleteSingle(nil, table, id)
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// IsRetryableError returns true if err is a deadlock or a serialization failure
+// reported by the driver, in which case the whole transaction can be replayed
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// ER_LOCK_DEADLOCK
+		return mysqlErr.Number == 1213
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// serialization_failure and deadlock_detected
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		// transaction was deadlocked and has been chosen as the deadlock victim
+		return mssqlErr.Number == 1205
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy
+	}
+	return false
+}
+
+// ParseIsolationLevel converts a configured isolation level name (ex : "read committed", "REPEATABLE_READ")
+// to a sql.IsolationLevel. Unknown names fall back to the driver default level
+func ParseIsolationLevel(level string) sql.IsolationLevel {
+	normalized := strings.ToLower(strings.TrimSpace(strings.NewReplacer("_", " ", "-", " ").Replace(level)))
+	switch normalized {
+	case "", "default":
+		return sql.LevelDefault
+	case "read uncommitted":
+		return sql.LevelReadUncommitted
+	case "read committed":
+		return sql.LevelReadCommitted
+	case "write committed":
+		return sql.LevelWriteCommitted
+	case "repeatable read":
+		return sql.LevelRepeatableRead
+	case "snapshot":
+		return sql.LevelSnapshot
+	case "serializable":
+		return sql.LevelSerializable
+	case "linearizable":
+		return sql.LevelLinearizable
+	}
+	utils.Log.Warning("unknown transaction isolation level, using driver default", "level", level)
+	return sql.LevelDefault
+}
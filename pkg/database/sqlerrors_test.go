@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	tt := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"generic", errors.New("deadlock"), false},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213}, true},
+		{"mysql lock wait timeout", &mysql.MySQLError{Number: 1205}, false},
+		{"mysql duplicate entry", &mysql.MySQLError{Number: 1062}, false},
+		{"pgsql serialization failure", &pq.Error{Code: "40001"}, true},
+		{"pgsql deadlock", &pq.Error{Code: "40P01"}, true},
+		{"pgsql unique violation", &pq.Error{Code: "23505"}, false},
+		{"sqlsrv deadlock victim", mssql.Error{Number: 1205}, true},
+		{"sqlsrv duplicate key", mssql.Error{Number: 2627}, false},
+		{"sqlite busy", sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{"sqlite constraint", sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{"wrapped mysql deadlock", fmt.Errorf("commit : %w", &mysql.MySQLError{Number: 1213}), true},
+	}
+	for _, tc := range tt {
+		if got := IsRetryableError(tc.err); got != tc.want {
+			t.Errorf("%s : got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseIsolationLevel(t *testing.T) {
+	tt := map[string]sql.IsolationLevel{
+		"":                 sql.LevelDefault,
+		"default":          sql.LevelDefault,
+		"read uncommitted": sql.LevelReadUncommitted,
+		"READ_COMMITTED":   sql.LevelReadCommitted,
+		"write-committed":  sql.LevelWriteCommitted,
+		" Repeatable Read": sql.LevelRepeatableRead,
+		"snapshot":         sql.LevelSnapshot,
+		"SERIALIZABLE":     sql.LevelSerializable,
+		"linearizable":     sql.LevelLinearizable,
+		"chaos":            sql.LevelDefault,
+	}
+	for level, want := range tt {
+		if got := ParseIsolationLevel(level); got != want {
+			t.Errorf("'%s' : got %s, want %s", level, got, want)
+		}
+	}
+}
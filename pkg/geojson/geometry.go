@@ -3,9 +3,10 @@ package geojson
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"regexp"
 	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type Geometry struct {
@@ -49,7 +50,7 @@ func NewGeometryFromWkt(wkt string) (*Geometry, error) {
 	coordinates = re.Replace(coordinates)
 	var coord interface{}
 	if err := json.Unmarshal([]byte(coordinates), &coord); err != nil {
-		log.Printf("Could not decode WKT %s : %s", coordinates, err)
+		utils.Log.Warning("could not decode WKT", "coordinates", coordinates, "error", err)
 		return nil, err
 	} else {
 		return &Geometry{geoType, coord}, nil
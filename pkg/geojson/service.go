@@ -1,6 +1,7 @@
 package geojson
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"strconv"
@@ -19,6 +20,15 @@ func NewGeoJsonService(reflection *database.ReflectionService, records *record.R
 	return &Service{reflection, records}
 }
 
+// WithContext returns a copy of the service using the reflection and the records service of the request
+func (s *Service) WithContext(ctx context.Context) *Service {
+	reflection := database.ReflectionFromContext(ctx)
+	if reflection == nil {
+		reflection = s.reflection
+	}
+	return &Service{reflection, s.records.WithContext(ctx)}
+}
+
 func (s *Service) HasTable(table string) bool {
 	return s.reflection.HasTable(table)
 }
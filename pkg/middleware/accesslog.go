@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// requestIdPattern limits the request ids accepted from the clients, as they are written in the logs
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._:+/=-]{1,128}$`)
+
+// AccessLogMiddleware logs a record for each request, identified by the X-Request-Id header (generated if not given)
+// which is returned in the response headers and in the error documents
+type AccessLogMiddleware struct {
+	GenericMiddleware
+}
+
+func NewAccessLogMiddleware(responder controller.Responder, properties map[string]interface{}) *AccessLogMiddleware {
+	return &AccessLogMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}}
+}
+
+// getRequestId returns the request id given by the client or a new random one
+func (alm *AccessLogMiddleware) getRequestId(r *http.Request) string {
+	if requestId := r.Header.Get(alm.getStringProperty("header", "X-Request-Id")); requestIdPattern.MatchString(requestId) {
+		return requestId
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// getUser returns the authenticated user found in the session, the dbAuth or apiKeyDbAuth users being read from the userColumn
+func (alm *AccessLogMiddleware) getUser(w http.ResponseWriter, r *http.Request) string {
+	return utils.SessionUser(w, r, alm.getStringProperty("userColumn", "username"))
+}
+
+func (alm *AccessLogMiddleware) Process(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestId := alm.getRequestId(r)
+		w.Header().Set("X-Request-Id", requestId)
+		r = r.WithContext(utils.ContextWithRequestId(r.Context(), requestId))
+		// the session registry is created in the request context before the next handlers,
+		// so that the user authenticated by the middlewares is found afterwards
+		utils.GetSession(w, r)
+		tableName := ""
+		switch utils.GetPathSegment(r, 1) {
+		case "records", "geojson", "columns":
+			tableName = utils.GetPathSegment(r, 2)
+		}
+		operation := utils.GetOperation(r)
+		srw := &statusResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(srw, r)
+		utils.Log.Info("request", "requestId", requestId, "method", r.Method, "path", r.URL.Path, "table", tableName,
+			"operation", operation, "status", srw.getStatusCode(), "bytes", srw.bytes,
+			"durationMs", float64(time.Since(start).Microseconds())/1000,
+			"clientIp", getClientIpAddress(r, alm.getStringProperty("reverseProxy", "") != ""), "user", alm.getUser(w, r))
+	})
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	utils.SetLogger(utils.NewLogger(&logs, "json", utils.LogLevelInfo))
+	defer utils.SetLogger(utils.NewLogger(os.Stderr, "text", utils.LogLevelInfo))
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	// every query is slow
+	db.PDO().SetSlowQueryThreshold(1)
+	reflection := database.NewReflectionService(db, nil, 0)
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	accessLogMiddle := NewAccessLogMiddleware(responder, nil)
+	bamMiddle := NewBasicAuth(responder, map[string]interface{}{"mode": "optional", "passwordFile": "../../test/test.pwd"})
+	records := record.NewRecordService(db, reflection)
+	controller.NewRecordController(router, responder, records)
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	router.Use(accessLogMiddle.Process)
+	router.Use(bamMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tt := []utils.Test{
+		{
+			Name:          "access_log_read_record",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/1",
+			RequestHeader: map[string]string{"X-Request-Id": "abc-123"},
+			AuthMethod:    "basicauth",
+			Username:      "user1",
+			Password:      "MyPwd01",
+			Want:          `{"icon":null,"id":1,"name":"announcement"}`,
+			WantHeader:    map[string]string{"X-Request-Id": "abc-123"},
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "access_log_error_document",
+			Method:        http.MethodGet,
+			Uri:           "/records/unknowns/1",
+			RequestHeader: map[string]string{"X-Request-Id": "def-456"},
+			Want:          `{"code":1001,"message":"Table 'unknowns' not found","requestId":"def-456"}`,
+			StatusCode:    http.StatusNotFound,
+		},
+		{
+			Name:          "access_log_invalid_request_id",
+			Method:        http.MethodGet,
+			Uri:           "/records/unknowns/1",
+			RequestHeader: map[string]string{"X-Request-Id": "bad id\""},
+			WantRegex:     `^{"code":1001,"message":"Table 'unknowns' not found","requestId":"[0-9a-f]{32}"}$`,
+			StatusCode:    http.StatusNotFound,
+		},
+	}
+	utils.RunTests(t, ts.URL, tt)
+
+	var access, slowQuery map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Errorf("Invalid json log line '%s' : %s", line, err.Error())
+			continue
+		}
+		if entry["requestId"] != "abc-123" {
+			continue
+		}
+		switch entry["msg"] {
+		case "request":
+			access = entry
+		case "slow query":
+			slowQuery = entry
+		}
+	}
+	if access == nil || slowQuery == nil {
+		t.Fatalf("Missing access or slow query log, got '%s'", logs.String())
+	}
+	want := map[string]interface{}{"level": "info", "method": "GET", "path": "/records/categories/1", "table": "categories",
+		"operation": "read", "status": 200.0, "clientIp": "127.0.0.1", "user": "user1"}
+	for key, value := range want {
+		if access[key] != value {
+			t.Errorf("Want access log %s '%v', got '%v'", key, value, access[key])
+		}
+	}
+	if access["bytes"] != 42.0 {
+		t.Errorf("Want access log bytes 42, got '%v'", access["bytes"])
+	}
+	if statement, _ := slowQuery["statement"].(string); !strings.HasPrefix(statement, "SELECT") || slowQuery["level"] != "warning" {
+		t.Errorf("Want slow query warning with the sql text, got '%v'", slowQuery)
+	}
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
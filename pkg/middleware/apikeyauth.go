@@ -14,7 +14,7 @@ type ApiKeyAuthMiddleware struct {
 }
 
 func NewApiKeyAuth(responder controller.Responder, properties map[string]interface{}) *ApiKeyAuthMiddleware {
-	return &ApiKeyAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}}
+	return &ApiKeyAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: newAuthMetricsResponder(responder, "apiKeyAuth"), Properties: properties}}
 }
 
 func (akam *ApiKeyAuthMiddleware) Process(next http.Handler) http.Handler {
@@ -1,8 +1,11 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/dranih/go-crud-api/pkg/controller"
 	"github.com/dranih/go-crud-api/pkg/database"
@@ -18,7 +21,7 @@ type ApiKeyDbAuthMiddleware struct {
 }
 
 func NewApiKeyDbAuth(responder controller.Responder, properties map[string]interface{}, reflection *database.ReflectionService, db *database.GenericDB) *ApiKeyDbAuthMiddleware {
-	return &ApiKeyDbAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}, reflection: reflection, db: db, ordering: record.NewOrderingInfo()}
+	return &ApiKeyDbAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: newAuthMetricsResponder(responder, "apiKeyDbAuth"), Properties: properties}, reflection: reflection, db: db, ordering: record.NewOrderingInfo()}
 }
 
 func (akdam *ApiKeyDbAuthMiddleware) Process(next http.Handler) http.Handler {
@@ -30,17 +33,17 @@ func (akdam *ApiKeyDbAuthMiddleware) Process(next http.Handler) http.Handler {
 		if apiKey != "" {
 			tableName := fmt.Sprint(akdam.getProperty("usersTable", "users"))
 			table := akdam.reflection.GetTable(tableName)
-			apiKeyColumnName := fmt.Sprint(akdam.getProperty("apiKeyColumn", "api_key"))
-			apiKeyColumn := table.GetColumn(apiKeyColumnName)
-			condition := database.NewColumnCondition(apiKeyColumn, "eq", apiKey)
-			columnNames := table.GetColumnNames()
-			columnOrdering := akdam.ordering.GetDefaultColumnOrdering(table)
-			users := akdam.db.SelectAll(table, columnNames, condition, columnOrdering, 0, 1)
-			if len(users) < 1 {
-				akdam.Responder.Error(record.AUTHENTICATION_FAILED, apiKey, w, "")
+			if user = akdam.findUser(table, apiKey); user == nil {
+				akdam.Responder.Error(record.AUTHENTICATION_FAILED, getApiKeyPrefix(apiKey), w, "")
+				ok = false
+			} else if expires, hasExpiry := akdam.getKeyExpiry(table, user); hasExpiry && !expires.After(time.Now()) {
+				akdam.Responder.Error(record.AUTHENTICATION_FAILED, getApiKeyPrefix(apiKey), w, "")
+				ok = false
+			} else if !akdam.hasScope(table, user, r) {
+				akdam.Responder.Error(record.OPERATION_FORBIDDEN, "", w, "")
 				ok = false
 			} else {
-				user = users[0]
+				akdam.touchKey(table, user)
 			}
 		} else {
 			if authenticationMode := akdam.getProperty("mode", "required"); authenticationMode == "required" {
@@ -55,7 +58,41 @@ func (akdam *ApiKeyDbAuthMiddleware) Process(next http.Handler) http.Handler {
 				session := utils.GetSession(w, r)
 				session.Values["apiUser"] = user
 			}
+			if utils.GetPathSegment(r, 1) == "apikeys" {
+				akdam.processAdmin(user, w, r)
+				return
+			}
 			next.ServeHTTP(w, r)
 		}
 	})
 }
+
+// findUser returns the user owning the key : a hashed key is looked up by its prefix, a plaintext key
+// (when allowed) by equality
+func (akdam *ApiKeyDbAuthMiddleware) findUser(table *database.ReflectedTable, apiKey string) map[string]interface{} {
+	apiKeyColumnName := fmt.Sprint(akdam.getProperty("apiKeyColumn", "api_key"))
+	apiKeyColumn := table.GetColumn(apiKeyColumnName)
+	columnNames := table.GetColumnNames()
+	columnOrdering := akdam.ordering.GetDefaultColumnOrdering(table)
+	if prefix, secret, found := strings.Cut(apiKey, "."); found && isApiKeyPrefix(prefix) {
+		condition := database.NewColumnCondition(apiKeyColumn, "sw", prefix+"$")
+		for _, user := range akdam.db.SelectAll(table, columnNames, condition, columnOrdering, 0, 10) {
+			if verifyApiKeyHash(fmt.Sprint(user[apiKeyColumnName]), prefix, secret) {
+				return user
+			}
+		}
+	}
+	if akdam.getStringProperty("plaintextKeys", "true") != "true" {
+		return nil
+	}
+	condition := database.NewColumnCondition(apiKeyColumn, "eq", apiKey)
+	users := akdam.db.SelectAll(table, columnNames, condition, columnOrdering, 0, 1)
+	// a stored hash is not a valid key
+	if len(users) < 1 || isApiKeyHash(fmt.Sprint(users[0][apiKeyColumnName])) {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(fmt.Sprint(users[0][apiKeyColumnName])), []byte(apiKey)) != 1 {
+		return nil
+	}
+	return users[0]
+}
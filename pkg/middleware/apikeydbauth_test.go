@@ -1,13 +1,19 @@
 package middleware
 
 import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dranih/go-crud-api/pkg/controller"
 	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
 	"github.com/dranih/go-crud-api/pkg/utils"
 	"github.com/gorilla/mux"
 )
@@ -51,7 +57,7 @@ func TestApiKeyDbAuth(t *testing.T) {
 			Method:        http.MethodGet,
 			Uri:           "/",
 			RequestHeader: map[string]string{"X-API-Key": "1234"},
-			Want:          `{"code":1012,"message":"Authentication failed for '1234'"}`,
+			Want:          `{"code":1012,"message":"Authentication failed for ''"}`,
 			StatusCode:    http.StatusForbidden,
 		},
 		{
@@ -64,6 +70,203 @@ func TestApiKeyDbAuth(t *testing.T) {
 		},
 	}
 	utils.RunTests(t, ts.URL, tt)
+
+	// the keys stored in plaintext can be refused
+	hashedRouter := mux.NewRouter()
+	hashedRouter.HandleFunc("/", utils.AllowedTest).Methods("GET")
+	hashedRouter.Use(NewApiKeyDbAuth(responder, map[string]interface{}{"mode": "required", "plaintextKeys": "false"}, reflection, db).Process)
+	tsHashed := httptest.NewServer(hashedRouter)
+	defer tsHashed.Close()
+	utils.RunTests(t, tsHashed.URL, []utils.Test{
+		{
+			Name:          "plaintext key refused",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: map[string]string{"X-API-Key": "123456789abc"},
+			Want:          `{"code":1012,"message":"Authentication failed for ''"}`,
+			StatusCode:    http.StatusForbidden,
+		},
+	})
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
+
+func TestApiKeyDbAuthHashedKeys(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	for _, column := range []string{`"scopes" text`, `"expires_at" bigint`, `"last_used_at" varchar(32)`} {
+		if _, err := db.PDO().Exec(nil, `ALTER TABLE "users" ADD COLUMN `+column); err != nil {
+			t.Fatalf("Unable to add column %s : %s", column, err.Error())
+		}
+	}
+	gob.Register(map[string]interface{}{})
+	reflection := database.NewReflectionService(db, nil, 0)
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	akdamMiddle := NewApiKeyDbAuth(responder, map[string]interface{}{
+		"mode":       "optional",
+		"adminUsers": "user1",
+	}, reflection, db)
+	records := record.NewRecordService(db, reflection)
+	controller.NewRecordController(router, responder, records)
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	router.Use(akdamMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	admin := map[string]string{"X-API-Key": "123456789abc"}
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/apikeys/2", strings.NewReader(`{"scopes":["records:categories:read","openapi"],"expiresIn":3600}`))
+	req.Header.Set("X-API-Key", "123456789abc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Got error on api key creation : %s", err.Error())
+	}
+	var minted map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&minted)
+	resp.Body.Close()
+	apiKey, ok := minted["api_key"].(string)
+	if err != nil || !ok || !strings.Contains(apiKey, ".") {
+		t.Fatalf("Want a new api key, got %v", minted)
+	}
+	// the errors show the prefix of the key, not the secret
+	prefix, _, _ := strings.Cut(apiKey, ".")
+	if minted["scopes"] != "records:categories:read,openapi" {
+		t.Errorf("Want the scopes of the key, got %v", minted["scopes"])
+	}
+	var stored string
+	for _, user := range db.SelectSingle(nil, reflection.GetTable("users"), []string{"api_key"}, "2") {
+		stored = fmt.Sprint(user["api_key"])
+	}
+	key := map[string]string{"X-API-Key": apiKey}
+
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:          "hashed_key_in_scope",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/1",
+			RequestHeader: key,
+			Want:          `{"icon":null,"id":1,"name":"announcement"}`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "hashed_key_operation_out_of_scope",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories",
+			RequestHeader: key,
+			Want:          `{"code":1014,"message":"Operation forbidden"}`,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "hashed_key_table_out_of_scope",
+			Method:        http.MethodGet,
+			Uri:           "/records/comments/1",
+			RequestHeader: key,
+			Want:          `{"code":1014,"message":"Operation forbidden"}`,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "hashed_key_stored_value",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/1",
+			RequestHeader: map[string]string{"X-API-Key": stored},
+			Want:          `{"code":1012,"message":"Authentication failed for ''"}`,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "hashed_key_not_admin",
+			Method:        http.MethodDelete,
+			Uri:           "/apikeys/1",
+			RequestHeader: map[string]string{"X-API-Key": apiKey},
+			Want:          `{"code":1014,"message":"Operation forbidden"}`,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "hashed_key_last_used",
+			Method:        http.MethodGet,
+			Uri:           "/records/users/2?include=last_used_at,expires_at",
+			RequestHeader: admin,
+			WantRegex:     `^\{"expires_at":[0-9]+,"last_used_at":"[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9:]{8}"\}$`,
+			StatusCode:    http.StatusOK,
+		},
+	})
+
+	if _, err := db.PDO().Exec(nil, `UPDATE "users" SET "expires_at" = 1 WHERE "id" = 2`); err != nil {
+		t.Fatalf("Unable to expire the key : %s", err.Error())
+	}
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:          "hashed_key_expired",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/1",
+			RequestHeader: key,
+			Want:          `{"code":1012,"message":"Authentication failed for '` + prefix + `'"}`,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "hashed_key_revoke",
+			Method:        http.MethodDelete,
+			Uri:           "/apikeys/2",
+			RequestHeader: admin,
+			Want:          `true`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "hashed_key_revoked",
+			Method:        http.MethodGet,
+			Uri:           "/records/users/2?include=api_key,scopes,expires_at",
+			RequestHeader: admin,
+			Want:          `{"api_key":null,"expires_at":null,"scopes":null}`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "hashed_key_unknown_user",
+			Method:        http.MethodPost,
+			Uri:           "/apikeys/99",
+			RequestHeader: admin,
+			Want:          `{"code":1003,"message":"Record '99' not found"}`,
+			StatusCode:    http.StatusNotFound,
+		},
+	})
+
+	// with a keyTtl, a key asked without expiry or for longer expires after keyTtl
+	ttlRouter := mux.NewRouter()
+	ttlRouter.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	ttlRouter.Use(NewApiKeyDbAuth(responder, map[string]interface{}{
+		"mode":       "optional",
+		"adminUsers": "user1",
+		"keyTtl":     "60",
+	}, reflection, db).Process)
+	tsTtl := httptest.NewServer(ttlRouter)
+	defer tsTtl.Close()
+	for _, expiresIn := range []string{"0", "-1", "86400"} {
+		req, _ := http.NewRequest(http.MethodPost, tsTtl.URL+"/apikeys/2", strings.NewReader(`{"expiresIn":`+expiresIn+`}`))
+		req.Header.Set("X-API-Key", "123456789abc")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Got error on api key creation : %s", err.Error())
+		}
+		var minted map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&minted)
+		resp.Body.Close()
+		expiresAt, ok := minted["expires_at"].(float64)
+		if err != nil || !ok || int64(expiresAt) > time.Now().Unix()+60 {
+			t.Errorf("expiresIn %s : want an expiry within the keyTtl, got %v", expiresIn, minted)
+		}
+	}
 	if err := os.Remove(db_path); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// An api key is given as "<prefix>.<secret>" and stored as "<prefix>$<salt>$<sha256(salt+secret)>",
+// the prefix being used to look the key up
+
+// newApiKey returns a new key and the value to store
+func newApiKey() (string, string, error) {
+	random := make([]byte, 48)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	prefix := hex.EncodeToString(random[:8])
+	salt := hex.EncodeToString(random[8:24])
+	secret := base64.RawURLEncoding.EncodeToString(random[24:])
+	return prefix + "." + secret, prefix + "$" + salt + "$" + hashApiKey(salt, secret), nil
+}
+
+func hashApiKey(salt, secret string) string {
+	hash := sha256.Sum256([]byte(salt + secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// isApiKeyPrefix checks the prefix before using it in a "starts with" condition
+func isApiKeyPrefix(prefix string) bool {
+	decoded, err := hex.DecodeString(prefix)
+	return err == nil && len(decoded) == 8
+}
+
+// getApiKeyPrefix returns the public prefix of a key, shown instead of the key in the errors ("" for a plaintext key)
+func getApiKeyPrefix(apiKey string) string {
+	if prefix, _, found := strings.Cut(apiKey, "."); found && isApiKeyPrefix(prefix) {
+		return prefix
+	}
+	return ""
+}
+
+func isApiKeyHash(stored string) bool {
+	return len(strings.Split(stored, "$")) == 3
+}
+
+func verifyApiKeyHash(stored, prefix, secret string) bool {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 3 || parts[0] != prefix {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(parts[2]), []byte(hashApiKey(parts[1], secret))) == 1
+}
+
+// getKeyExpiry reads the expiry column, as a unix timestamp or a date
+func (akdam *ApiKeyDbAuthMiddleware) getKeyExpiry(table *database.ReflectedTable, user map[string]interface{}) (time.Time, bool) {
+	value, exists := user[akdam.getStringProperty("expiresColumn", "expires_at")]
+	if !exists || value == nil || !table.HasColumn(akdam.getStringProperty("expiresColumn", "expires_at")) {
+		return time.Time{}, false
+	}
+	return parseTimeValue(value)
+}
+
+func parseTimeValue(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case int64:
+		return time.Unix(v, 0), true
+	}
+	str := fmt.Sprint(value)
+	if seconds, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(seconds, 0), true
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, str, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	utils.Log.Warning("unable to read the api key date", "date", str)
+	return time.Time{}, true
+}
+
+// formatTimeValue returns the value stored in an integer (unix timestamp) or a date column
+func formatTimeValue(table *database.ReflectedTable, columnName string, t time.Time) interface{} {
+	if table.GetColumn(columnName).IsInteger() {
+		return t.Unix()
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// getScopeTarget returns the controller, the tables and the operation of the request
+func getScopeTarget(r *http.Request) (string, []string, string) {
+	controller := utils.GetPathSegment(r, 1)
+	switch controller {
+	case "records":
+		return controller, utils.GetTableNames(r, nil), utils.GetOperation(r)
+	case "geojson", "columns":
+		return controller, []string{utils.GetPathSegment(r, 2)}, getRbacOperations(r)[0]
+	case "openapi":
+		return controller, []string{""}, "document"
+	case "apikeys":
+		if r.Method == http.MethodDelete {
+			return controller, []string{""}, "delete"
+		}
+		return controller, []string{""}, "create"
+	}
+	return controller, []string{""}, strings.ToLower(r.Method)
+}
+
+// hasScope checks the request against the scopes of the key, a comma separated list of
+// "controller:table:operation" globs, ex : "records:posts:read,records:*:list,openapi". A key without
+// scopes is not restricted.
+func (akdam *ApiKeyDbAuthMiddleware) hasScope(table *database.ReflectedTable, user map[string]interface{}, r *http.Request) bool {
+	scopesColumnName := akdam.getStringProperty("scopesColumn", "scopes")
+	if !table.HasColumn(scopesColumnName) || user[scopesColumnName] == nil || strings.TrimSpace(fmt.Sprint(user[scopesColumnName])) == "" {
+		return true
+	}
+	controller, tableNames, operation := getScopeTarget(r)
+	scopes := strings.Split(fmt.Sprint(user[scopesColumnName]), ",")
+	for _, tableName := range tableNames {
+		allowed := false
+		for _, scope := range scopes {
+			if matchScope(strings.TrimSpace(scope), controller, tableName, operation) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+func matchScope(scope, controller, tableName, operation string) bool {
+	parts := strings.Split(scope, ":")
+	if scope == "" || len(parts) > 3 {
+		return false
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "*")
+	}
+	for i, value := range []string{controller, tableName, operation} {
+		if matched, err := path.Match(parts[i], value); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// touchKey records the use of the key, at most once a minute
+func (akdam *ApiKeyDbAuthMiddleware) touchKey(table *database.ReflectedTable, user map[string]interface{}) {
+	lastUsedColumnName := akdam.getStringProperty("lastUsedColumn", "last_used_at")
+	if !table.HasColumn(lastUsedColumnName) {
+		return
+	}
+	now := time.Now()
+	if user[lastUsedColumnName] != nil {
+		if lastUsed, ok := parseTimeValue(user[lastUsedColumnName]); ok && now.Sub(lastUsed) < time.Minute {
+			return
+		}
+	}
+	value := formatTimeValue(table, lastUsedColumnName, now)
+	if _, err := akdam.db.UpdateSingle(nil, table, map[string]interface{}{lastUsedColumnName: value}, fmt.Sprint(user[table.GetPk().GetName()])); err != nil {
+		utils.Log.Error("unable to update the api key last use", "error", err)
+		return
+	}
+	user[lastUsedColumnName] = value
+}
+
+// isAdmin tells if the user of the api key or of the dbAuth session is one of the adminUsers
+func (akdam *ApiKeyDbAuthMiddleware) isAdmin(user map[string]interface{}, w http.ResponseWriter, r *http.Request) bool {
+	admins := akdam.getArrayProperty("adminUsers", "")
+	usernameColumnName := akdam.getStringProperty("usernameColumn", "username")
+	if len(user) > 0 && admins[fmt.Sprint(user[usernameColumnName])] {
+		return true
+	}
+	session := utils.GetSession(w, r)
+	if sessionUser, ok := session.Values["user"].(map[string]interface{}); ok && sessionUser[usernameColumnName] != nil {
+		return admins[fmt.Sprint(sessionUser[usernameColumnName])]
+	}
+	return false
+}
+
+// processAdmin mints (POST /apikeys/{id}) or revokes (DELETE /apikeys/{id}) the key of a user, a new key
+// is only returned once
+func (akdam *ApiKeyDbAuthMiddleware) processAdmin(user map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		akdam.Responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+		return
+	}
+	if !akdam.isAdmin(user, w, r) {
+		if len(user) == 0 {
+			akdam.Responder.Error(record.AUTHENTICATION_REQUIRED, "", w, "")
+		} else {
+			akdam.Responder.Error(record.OPERATION_FORBIDDEN, "", w, "")
+		}
+		return
+	}
+	table := akdam.reflection.GetTable(akdam.getStringProperty("usersTable", "users"))
+	id := utils.GetPathSegment(r, 2)
+	if id == "" || len(akdam.db.SelectSingle(nil, table, []string{table.GetPk().GetName()}, id)) == 0 {
+		akdam.Responder.Error(record.RECORD_NOT_FOUND, id, w, "")
+		return
+	}
+	apiKeyColumnName := akdam.getStringProperty("apiKeyColumn", "api_key")
+	scopesColumnName := akdam.getStringProperty("scopesColumn", "scopes")
+	expiresColumnName := akdam.getStringProperty("expiresColumn", "expires_at")
+	data := map[string]interface{}{apiKeyColumnName: nil}
+	for _, columnName := range []string{scopesColumnName, expiresColumnName} {
+		if table.HasColumn(columnName) {
+			data[columnName] = nil
+		}
+	}
+	response := map[string]interface{}{table.GetPk().GetName(): id}
+	if r.Method == http.MethodPost {
+		body, err := utils.GetBodyMapData(r)
+		if err != nil {
+			akdam.Responder.Error(record.HTTP_MESSAGE_NOT_READABLE, "", w, "")
+			return
+		}
+		apiKey, stored, err := newApiKey()
+		if err != nil {
+			utils.Log.Error("unable to generate api key", "error", err)
+			akdam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+			return
+		}
+		data[apiKeyColumnName] = stored
+		response["api_key"] = apiKey
+		if scopes, exists := body["scopes"]; exists && scopes != nil && table.HasColumn(scopesColumnName) {
+			if list, ok := scopes.([]interface{}); ok {
+				values := []string{}
+				for _, scope := range list {
+					values = append(values, fmt.Sprint(scope))
+				}
+				scopes = strings.Join(values, ",")
+			}
+			data[scopesColumnName] = fmt.Sprint(scopes)
+			response["scopes"] = data[scopesColumnName]
+		}
+		keyTtl := int64(akdam.getIntProperty("keyTtl", 0))
+		ttl := keyTtl
+		if expiresIn, exists := body["expiresIn"]; exists {
+			ttl, _ = strconv.ParseInt(fmt.Sprint(expiresIn), 10, 64)
+			// with a keyTtl, the keys cannot live longer nor never expire
+			if keyTtl > 0 && (ttl <= 0 || ttl > keyTtl) {
+				ttl = keyTtl
+			}
+		}
+		if ttl > 0 && table.HasColumn(expiresColumnName) {
+			data[expiresColumnName] = formatTimeValue(table, expiresColumnName, time.Now().Add(time.Duration(ttl)*time.Second))
+			response["expires_at"] = data[expiresColumnName]
+		}
+	}
+	if _, err := akdam.db.UpdateSingle(nil, table, data, id); err != nil {
+		utils.Log.Error("unable to save api key", "error", err)
+		akdam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		akdam.Responder.Success(true, w)
+		return
+	}
+	akdam.Responder.Success(response, w)
+}
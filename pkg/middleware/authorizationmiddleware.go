@@ -3,7 +3,6 @@ package middleware
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -28,10 +27,10 @@ func NewAuthorizationMiddleware(responder controller.Responder, properties map[s
 	return &AuthorizationMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}, reflection: reflection}
 }
 
-func (am *AuthorizationMiddleware) handleColumns(operation, tableName string, session *sessions.Session) {
+func (am *AuthorizationMiddleware) handleColumns(reflection *database.ReflectionService, operation, tableName string, session *sessions.Session) {
 	columnHandler := fmt.Sprint(am.getProperty("columnHandler", ""))
 	if columnHandler != "" {
-		table := am.reflection.GetTable(tableName)
+		table := reflection.GetTable(tableName)
 		if t, err := template.New("columnHandler").Funcs(sprig.TxtFuncMap()).Parse(columnHandler); err == nil {
 			for _, columnName := range table.GetColumnNames() {
 				var res bytes.Buffer
@@ -45,17 +44,17 @@ func (am *AuthorizationMiddleware) handleColumns(operation, tableName string, se
 						table.RemoveColumn(columnName)
 					}
 				} else {
-					log.Printf("Error : could not execute template tableHandler : %s", err.Error())
+					utils.Log.Error("could not execute template tableHandler", "error", err)
 				}
 			}
 		} else {
-			log.Printf("Error : could not parse template columnHandler : %s", err.Error())
+			utils.Log.Error("could not parse template columnHandler", "error", err)
 		}
 	}
 }
 
-func (am *AuthorizationMiddleware) handleTable(operation, tableName string, session *sessions.Session) {
-	if !am.reflection.HasTable(tableName) {
+func (am *AuthorizationMiddleware) handleTable(reflection *database.ReflectionService, operation, tableName string, session *sessions.Session) {
+	if !reflection.HasTable(tableName) {
 		return
 	}
 	allowed := true
@@ -71,21 +70,21 @@ func (am *AuthorizationMiddleware) handleTable(operation, tableName string, sess
 			if err := t.Execute(&res, data); err == nil {
 				allowed, _ = strconv.ParseBool(strings.TrimSpace(res.String()))
 			} else {
-				log.Printf("Error : could not execute template tableHandler : %s", err.Error())
+				utils.Log.Error("could not execute template tableHandler", "error", err)
 			}
 		} else {
-			log.Printf("Error : could not parse template tableHandler : %s", err.Error())
+			utils.Log.Error("could not parse template tableHandler", "error", err)
 		}
 	}
 	if !allowed {
-		am.reflection.RemoveTable(tableName)
+		reflection.RemoveTable(tableName)
 	} else {
-		am.handleColumns(operation, tableName, session)
+		am.handleColumns(reflection, operation, tableName, session)
 	}
 }
 
-func (am *AuthorizationMiddleware) handleRecords(operation, tableName string, session *sessions.Session) {
-	if !am.reflection.HasTable(tableName) {
+func (am *AuthorizationMiddleware) handleRecords(reflection *database.ReflectionService, variables *utils.VariableStore, operation, tableName string, session *sessions.Session) {
+	if !reflection.HasTable(tableName) {
 		return
 	}
 	recordHandler := fmt.Sprint(am.getProperty("recordHandler", ""))
@@ -100,19 +99,19 @@ func (am *AuthorizationMiddleware) handleRecords(operation, tableName string, se
 			if err := t.Execute(&res, data); err == nil {
 				query := strings.TrimSpace(res.String())
 				filters := &record.FilterInfo{}
-				table := am.reflection.GetTable(tableName)
+				table := reflection.GetTable(tableName)
 				query = strings.Replace(strings.Replace(query, "=", "[]=", -1), "][]=", "]=", -1)
 				if params, err := url.ParseQuery(query); err == nil {
 					condition := filters.GetCombinedConditions(table, params)
-					utils.VStore.Set(fmt.Sprintf("authorization.conditions.%s", tableName), condition)
+					variables.Set(fmt.Sprintf("authorization.conditions.%s", tableName), condition)
 				} else {
-					log.Printf("Error : parse recordHandler query : %s", err.Error())
+					utils.Log.Error("parse recordHandler query", "error", err)
 				}
 			} else {
-				log.Printf("Error : could not execute template recordHandler : %s", err.Error())
+				utils.Log.Error("could not execute template recordHandler", "error", err)
 			}
 		} else {
-			log.Printf("Error : could not parse template recordHandler : %s", err.Error())
+			utils.Log.Error("could not parse template recordHandler", "error", err)
 		}
 	}
 }
@@ -121,19 +120,20 @@ func (am *AuthorizationMiddleware) Process(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := utils.GetPathSegment(r, 1)
 		operation := utils.GetOperation(r)
-		am.reflection.RefreshTables()
-		tableNames := utils.GetTableNames(r, am.reflection.GetTableNames())
+		r, variables := utils.RequestVariables(r)
+		r, reflection := requestReflection(r, am.reflection)
+		tableNames := utils.GetTableNames(r, reflection.GetTableNames())
 		session := utils.GetSession(w, r)
 
 		for _, tableName := range tableNames {
-			am.handleTable(operation, tableName, session)
+			am.handleTable(reflection, operation, tableName, session)
 			if path == "records" {
-				am.handleRecords(operation, tableName, session)
+				am.handleRecords(reflection, variables, operation, tableName, session)
 			}
 		}
 		if path == "openapi" {
-			utils.VStore.Set("authorization.tableHandler", am.getProperty("tableHandler", ""))
-			utils.VStore.Set("authorization.columnHandler", am.getProperty("columnHandler", ""))
+			variables.Set("authorization.tableHandler", am.getProperty("tableHandler", ""))
+			variables.Set("authorization.columnHandler", am.getProperty("columnHandler", ""))
 		}
 		next.ServeHTTP(w, r)
 	})
@@ -105,6 +105,17 @@ func TestAuthorizationMiddleware(t *testing.T) {
 		},
 	}
 	utils.RunTests(t, ts.URL, tt)
+
+	// the tables, the columns and the conditions denied to the requests are kept out of the shared state
+	if !reflection.HasTable("invisibles") {
+		t.Errorf("Want table invisibles kept in the reflection of the api")
+	}
+	if !reflection.GetTable("kunsthåndværk").HasColumn("invisible") {
+		t.Errorf("Want column invisible kept in the reflection of the api")
+	}
+	if condition := utils.VStore.Get("authorization.conditions.comments"); condition != nil {
+		t.Errorf("Want no condition in the shared variables, got %v", condition)
+	}
 	if err := os.Remove(db_path); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// AutoColumnsMiddleware fills the configured columns (timestamps, audit columns...) on create and update
+// The values sent by the client for those columns are discarded
+type AutoColumnsMiddleware struct {
+	GenericMiddleware
+	reflection *database.ReflectionService
+}
+
+func NewAutoColumnsMiddleware(responder controller.Responder, properties map[string]interface{}, reflection *database.ReflectionService) *AutoColumnsMiddleware {
+	return &AutoColumnsMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}, reflection: reflection}
+}
+
+func (acm *AutoColumnsMiddleware) callHandler(w http.ResponseWriter, r *http.Request, operation, tableName string, autoColumns map[string]*database.AutoColumn) *http.Request {
+	jsonMap, err := utils.GetBodyData(r)
+	if err != nil || jsonMap == nil {
+		return r
+	}
+	// the records are modified in place, the body being the modified jsonMap
+	var records []map[string]interface{}
+	switch v := jsonMap.(type) {
+	case []interface{}:
+		for _, obj := range v {
+			if record, ok := obj.(map[string]interface{}); ok {
+				records = append(records, record)
+			}
+		}
+	case []map[string]interface{}:
+		records = v
+	case map[string]interface{}:
+		records = append(records, v)
+	default:
+		return r
+	}
+	table := acm.reflection.GetTable(tableName)
+	now := time.Now().UTC()
+	touched := false
+	for columnName, autoColumn := range autoColumns {
+		if !table.HasColumn(columnName) {
+			continue
+		}
+		fill := (operation == "create" && autoColumn.OnCreate()) || (operation == "update" && autoColumn.OnUpdate())
+		var value interface{}
+		if fill {
+			value = acm.getValue(w, r, table.GetColumn(columnName), autoColumn, now)
+		}
+		for i := range records {
+			if fill {
+				records[i][columnName] = value
+			} else {
+				delete(records[i], columnName)
+			}
+		}
+		touched = true
+	}
+	if touched {
+		body, err := json.Marshal(jsonMap)
+		if err != nil {
+			utils.Log.Error("could not marshal modified body to string", "error", err)
+			return r
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	}
+	return r
+}
+
+func (acm *AutoColumnsMiddleware) getValue(w http.ResponseWriter, r *http.Request, column *database.ReflectedColumn, autoColumn *database.AutoColumn, now time.Time) interface{} {
+	switch autoColumn.GetSource() {
+	case "now":
+		return acm.getTime(column, now)
+	case "user":
+		return acm.getUser(w, r, autoColumn.GetArgument())
+	}
+	return autoColumn.GetArgument()
+}
+
+// getTime formats the current time according to the type of the column
+func (acm *AutoColumnsMiddleware) getTime(column *database.ReflectedColumn, now time.Time) interface{} {
+	switch column.GetType() {
+	case "date":
+		return now.Format("2006-01-02")
+	case "time":
+		return now.Format("15:04:05")
+	case "integer", "bigint":
+		return now.Unix()
+	}
+	return now.Format("2006-01-02 15:04:05")
+}
+
+// getUser returns the authenticated user found in the session : the jwt claims (default "sub"),
+// the dbAuth or apiKeyDbAuth user (default "id"), the basicAuth username or the api key
+func (acm *AutoColumnsMiddleware) getUser(w http.ResponseWriter, r *http.Request, property string) interface{} {
+	session := utils.GetSession(w, r)
+	if claims, ok := session.Values["claims"].(map[string]interface{}); ok {
+		name := property
+		if name == "" {
+			name = "sub"
+		}
+		if value, exists := claims[name]; exists {
+			return value
+		}
+	}
+	for _, key := range []string{"user", "apiUser"} {
+		if user, ok := session.Values[key].(map[string]interface{}); ok {
+			name := property
+			if name == "" {
+				name = "id"
+			}
+			if value, exists := user[name]; exists {
+				return value
+			}
+		}
+	}
+	if username, exists := session.Values["username"]; exists && username != nil && (property == "" || property == "username") {
+		return username
+	}
+	if apiKey, exists := session.Values["apiKey"]; exists && apiKey != nil && property == "" {
+		return apiKey
+	}
+	return nil
+}
+
+func (acm *AutoColumnsMiddleware) Process(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := utils.GetOperation(r)
+		if operation == "create" || operation == "update" || operation == "increment" {
+			tableName := utils.GetPathSegment(r, 2)
+			if acm.reflection.HasTable(tableName) {
+				if autoColumns := acm.reflection.GetAutoColumns(tableName); len(autoColumns) > 0 {
+					r = acm.callHandler(w, r, operation, tableName, autoColumns)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+func TestAutoColumnsMiddleware(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	db.SetAutoColumns(map[string]string{
+		"products.created_at": "create:now",
+		"prod*.deleted_at":    "update:const:2000-01-01 00:00:00",
+		"*.unknown":           "always:user",
+		"events.name":         "invalid",
+	})
+	reflection := database.NewReflectionService(db, nil, 0)
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	acMiddle := NewAutoColumnsMiddleware(responder, nil, reflection)
+	records := record.NewRecordService(db, reflection)
+	controller.NewRecordController(router, responder, records)
+	router.Use(acMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tt := []utils.Test{
+		{
+			Name:       "create_with_auto_columns_A",
+			Method:     http.MethodPost,
+			Uri:        "/records/products",
+			Body:       `{"name":"Abacus","price":"12.50","properties":"{}","created_at":"1999-01-01 00:00:00","deleted_at":"1999-01-01 00:00:00"}`,
+			Want:       `2`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "create_with_auto_columns_B",
+			Method:     http.MethodGet,
+			Uri:        "/records/products/2?include=created_at,deleted_at",
+			WantRegex:  `^{"created_at":"2[0-9]{3}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}","deleted_at":null}$`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "update_with_auto_columns_A",
+			Method:     http.MethodPut,
+			Uri:        "/records/products/1",
+			Body:       `{"name":"Calculator 2","created_at":"1999-01-01 00:00:00"}`,
+			Want:       `1`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "update_with_auto_columns_B",
+			Method:     http.MethodGet,
+			Uri:        "/records/products/1?include=name,created_at,deleted_at",
+			Want:       `{"created_at":"1970-01-01 01:01:01","deleted_at":"2000-01-01 00:00:00","name":"Calculator 2"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "batch_create_with_auto_columns_A",
+			Method:     http.MethodPost,
+			Uri:        "/records/products",
+			Body:       `[{"name":"Ruler","price":"1.50","properties":"{}","created_at":"1999-01-01 00:00:00"},{"name":"Pencil","price":"0.50","properties":"{}","deleted_at":"1999-01-01 00:00:00"}]`,
+			Want:       `[3,4]`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "batch_create_with_auto_columns_B",
+			Method:     http.MethodGet,
+			Uri:        "/records/products/3,4?include=created_at,deleted_at",
+			WantRegex:  `^\[{"created_at":"2[0-9]{3}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}","deleted_at":null},{"created_at":"2[0-9]{3}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}","deleted_at":null}\]$`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "batch_update_with_auto_columns_A",
+			Method:     http.MethodPut,
+			Uri:        "/records/products/3,4",
+			Body:       `[{"name":"Ruler 2","created_at":"1999-01-01 00:00:00"},{"name":"Pencil 2","deleted_at":"1999-01-01 00:00:00"}]`,
+			Want:       `[1,1]`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "batch_update_with_auto_columns_B",
+			Method:     http.MethodGet,
+			Uri:        "/records/products/3,4?include=name,created_at,deleted_at",
+			WantRegex:  `^\[{"created_at":"2[0-9]{3}-[^"]+","deleted_at":"2000-01-01 00:00:00","name":"Ruler 2"},{"created_at":"2[0-9]{3}-[^"]+","deleted_at":"2000-01-01 00:00:00","name":"Pencil 2"}\]$`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "create_without_auto_columns",
+			Method:     http.MethodPost,
+			Uri:        "/records/events",
+			Body:       `{"name":"Meetup","datetime":"2022-01-01 10:00:00","visitors":1}`,
+			Want:       `2`,
+			StatusCode: http.StatusOK,
+		},
+	}
+	utils.RunTests(t, ts.URL, tt)
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
@@ -3,7 +3,6 @@ package middleware
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -19,7 +18,7 @@ type BasicAuthMiddleware struct {
 }
 
 func NewBasicAuth(responder controller.Responder, properties map[string]interface{}) *BasicAuthMiddleware {
-	return &BasicAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}}
+	return &BasicAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: newAuthMetricsResponder(responder, "basicAuth"), Properties: properties}}
 }
 
 func (bam *BasicAuthMiddleware) hasCorrectPassword(username, password string, passwords *map[string]string) (bool, bool) {
@@ -43,7 +42,7 @@ func (bam *BasicAuthMiddleware) getValidUsername(username, password, passwordFil
 	valid, rewrite2 := bam.hasCorrectPassword(username, password, &passwords)
 	if rewrite1 || rewrite2 {
 		if err := bam.writePasswords(passwordFile, passwords); err != nil {
-			log.Printf("Error writing password file %s : %v", passwordFile, err)
+			utils.Log.Error("unable to write the password file", "file", passwordFile, "error", err)
 		}
 	}
 	if valid {
@@ -57,7 +56,7 @@ func (bam *BasicAuthMiddleware) readPasswords(passwordFile string) (map[string]s
 	passwords := map[string]string{}
 	file, err := os.Open(passwordFile)
 	if err != nil {
-		log.Printf("Error opening %s : %v", passwordFile, err)
+		utils.Log.Error("unable to open the password file", "file", passwordFile, "error", err)
 	}
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
@@ -75,7 +74,7 @@ func (bam *BasicAuthMiddleware) readPasswords(passwordFile string) (map[string]s
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading %s : %v", passwordFile, err)
+		utils.Log.Error("unable to read the password file", "file", passwordFile, "error", err)
 	}
 	return passwords, rewrite
 }
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+type ClientCertAuthMiddleware struct {
+	GenericMiddleware
+	roots *x509.CertPool
+}
+
+// NewClientCertAuth returns a middleware authenticating the requests with the client certificate of the
+// tls connection. The chain is verified by the server (server.clientCaFile) or, if caFile is set, by the
+// middleware itself.
+func NewClientCertAuth(responder controller.Responder, properties map[string]interface{}) *ClientCertAuthMiddleware {
+	ccam := &ClientCertAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: newAuthMetricsResponder(responder, "clientCertAuth"), Properties: properties}}
+	if caFile := ccam.getStringProperty("caFile", ""); caFile != "" {
+		pool, err := utils.LoadCertPool(caFile)
+		if err != nil {
+			utils.Log.Error("unable to load client CA file", "file", caFile, "error", err)
+		}
+		ccam.roots = pool
+	}
+	utils.VStore.Set("clientCertAuth.mode", ccam.getStringProperty("mode", "required"))
+	return ccam
+}
+
+func (ccam *ClientCertAuthMiddleware) Process(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok := true
+		var identity map[string]interface{}
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			cert := r.TLS.PeerCertificates[0]
+			identity = getCertificateIdentity(cert)
+			username := ccam.getUsername(identity)
+			allowed := ccam.getArrayProperty("allowed", "")
+			if !ccam.verify(r) || username == "" || (allowed != nil && !allowed[username]) {
+				ccam.Responder.Error(record.AUTHENTICATION_FAILED, cert.Subject.String(), w, "")
+				ok = false
+			} else {
+				identity["username"] = username
+			}
+		} else {
+			if authenticationMode := ccam.getProperty("mode", "required"); authenticationMode == "required" {
+				ccam.Responder.Error(record.AUTHENTICATION_REQUIRED, "", w, fmt.Sprint(ccam.getProperty("realm", "Client certificate required")))
+				ok = false
+			}
+		}
+		if ok {
+			if identity != nil {
+				session := utils.GetSession(w, r)
+				session.Values["clientCert"] = identity
+			}
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// verify checks the chain of the peer certificate, against the caFile or as verified by the server
+func (ccam *ClientCertAuthMiddleware) verify(r *http.Request) bool {
+	if ccam.roots == nil {
+		if ccam.getStringProperty("caFile", "") != "" {
+			return false
+		}
+		return len(r.TLS.VerifiedChains) > 0
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := r.TLS.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         ccam.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+func getCertificateIdentity(cert *x509.Certificate) map[string]interface{} {
+	uris := []string{}
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+	return map[string]interface{}{
+		"cn":     cert.Subject.CommonName,
+		"ou":     append([]string{}, cert.Subject.OrganizationalUnit...),
+		"dns":    append([]string{}, cert.DNSNames...),
+		"email":  append([]string{}, cert.EmailAddresses...),
+		"uri":    uris,
+		"serial": cert.SerialNumber.String(),
+	}
+}
+
+// getUsername returns the identity field given by the usernameField property : cn, ou, dns, email, uri or
+// san (the first of dns, email and uri)
+func (ccam *ClientCertAuthMiddleware) getUsername(identity map[string]interface{}) string {
+	field := strings.ToLower(ccam.getStringProperty("usernameField", "cn"))
+	fields := []string{field}
+	if field == "san" {
+		fields = []string{"dns", "email", "uri"}
+	}
+	for _, f := range fields {
+		switch v := identity[f].(type) {
+		case string:
+			return v
+		case []string:
+			if len(v) > 0 {
+				return v[0]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/gob"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// newTestCertificate returns a certificate signed by the parent, or self-signed if parent is nil
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key : %s", err.Error())
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parentCert, parentKey := template, interface{}(key)
+	if parent != nil {
+		parentCert, parentKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Unable to create certificate : %s", err.Error())
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	ca := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	client := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "service-a", OrganizationalUnit: []string{"backend"}},
+		DNSNames:    []string{"svc.internal"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+	rogueCa := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "rogue ca"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	rogue := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "rogue"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &rogueCa)
+	caFile, err := ioutil.TempFile(os.TempDir(), "gocrudtests-ca-")
+	if err != nil {
+		t.Fatalf("Cannot create temporary file %s", err.Error())
+	}
+	defer os.Remove(caFile.Name())
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	caFile.Close()
+	pool, err := utils.LoadCertPool(caFile.Name())
+	if err != nil {
+		t.Fatalf("Unable to load CA file : %s", err.Error())
+	}
+
+	gob.Register(map[string]interface{}{})
+	responder := controller.NewJsonResponder(false)
+	newServer := func(properties map[string]interface{}, tlsConfig *tls.Config) *httptest.Server {
+		router := mux.NewRouter()
+		ccamMiddle := NewClientCertAuth(responder, properties)
+		router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if identity, ok := utils.GetSession(w, r).Values["clientCert"].(map[string]interface{}); ok {
+				fmt.Fprintf(w, "%s %v", identity["username"], identity["ou"])
+				return
+			}
+			w.Write([]byte("anonymous"))
+		}).Methods("GET")
+		router.Use(ccamMiddle.Process)
+		ts := httptest.NewUnstartedServer(router)
+		ts.TLS = tlsConfig
+		ts.StartTLS()
+		return ts
+	}
+
+	// the chain is verified by the server
+	ts := newServer(map[string]interface{}{"mode": "required"}, &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven})
+	defer ts.Close()
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "client_cert_required",
+			Method:     http.MethodGet,
+			Uri:        "/",
+			Want:       `{"code":1011,"details":"Client certificate required","message":"Authentication required"}`,
+			StatusCode: http.StatusUnauthorized,
+		},
+		{
+			Name:         "client_cert_cn",
+			Method:       http.MethodGet,
+			Uri:          "/",
+			Certificates: []tls.Certificate{client},
+			Want:         `service-a [backend]`,
+			StatusCode:   http.StatusOK,
+		},
+	})
+
+	// the chain is verified by the middleware
+	tsCa := newServer(map[string]interface{}{"mode": "optional", "caFile": caFile.Name(), "usernameField": "san"}, &tls.Config{ClientAuth: tls.RequestClientCert})
+	defer tsCa.Close()
+	utils.RunTests(t, tsCa.URL, []utils.Test{
+		{
+			Name:       "client_cert_optional",
+			Method:     http.MethodGet,
+			Uri:        "/",
+			Want:       `anonymous`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:         "client_cert_san",
+			Method:       http.MethodGet,
+			Uri:          "/",
+			Certificates: []tls.Certificate{client},
+			Want:         `svc.internal [backend]`,
+			StatusCode:   http.StatusOK,
+		},
+		{
+			Name:         "client_cert_untrusted",
+			Method:       http.MethodGet,
+			Uri:          "/",
+			Certificates: []tls.Certificate{rogue},
+			Want:         `{"code":1012,"message":"Authentication failed for 'CN=rogue'"}`,
+			StatusCode:   http.StatusForbidden,
+		},
+	})
+
+	tsAllowed := newServer(map[string]interface{}{"caFile": caFile.Name(), "allowed": "service-b"}, &tls.Config{ClientAuth: tls.RequestClientCert})
+	defer tsAllowed.Close()
+	utils.RunTests(t, tsAllowed.URL, []utils.Test{
+		{
+			Name:         "client_cert_not_allowed",
+			Method:       http.MethodGet,
+			Uri:          "/",
+			Certificates: []tls.Certificate{client},
+			Want:         `{"code":1012,"message":"Authentication failed for 'CN=service-a,OU=backend'"}`,
+			StatusCode:   http.StatusForbidden,
+		},
+	})
+}
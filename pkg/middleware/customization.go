@@ -3,7 +3,6 @@ package middleware
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"net/http"
 	"text/template"
 
@@ -39,10 +38,10 @@ func (cm *CustomizationMiddleware) Process(next http.Handler) http.Handler {
 				}{Operation: operation, TableName: tableName, Request: r, Environment: env}
 				var res bytes.Buffer
 				if err := t.Execute(&res, data); err != nil {
-					log.Printf("Error : could not execute template beforeHandler : %s", err.Error())
+					utils.Log.Error("could not execute template beforeHandler", "error", err)
 				}
 			} else {
-				log.Printf("Error : could not parse template beforeHandler : %s", err.Error())
+				utils.Log.Error("could not parse template beforeHandler", "error", err)
 			}
 		}
 
@@ -53,7 +52,7 @@ func (cm *CustomizationMiddleware) Process(next http.Handler) http.Handler {
 				if t, err := template.New("afterHandlerHeader").Funcs(sprig.TxtFuncMap()).Parse(afterHandlerHeader); err == nil {
 					templateHeader = t
 				} else {
-					log.Printf("Error : could not parse template afterHandlerHeader : %s", err.Error())
+					utils.Log.Error("could not parse template afterHandlerHeader", "error", err)
 				}
 			}
 		}
@@ -63,7 +62,7 @@ func (cm *CustomizationMiddleware) Process(next http.Handler) http.Handler {
 				if t, err := template.New("afterHandlerBody").Funcs(sprig.TxtFuncMap()).Parse(afterHandlerBody); err == nil {
 					templateBody = t
 				} else {
-					log.Printf("Error : could not parse template afterHandlerBody : %s", err.Error())
+					utils.Log.Error("could not parse template afterHandlerBody", "error", err)
 				}
 			}
 		}
@@ -98,7 +97,7 @@ func (crw *customResponseWriter) Write(b []byte) (int, error) {
 		}{Operation: crw.operation, TableName: crw.tableName, Content: b, Environment: crw.environment}
 		var res bytes.Buffer
 		if err := crw.templateHeader.Execute(&res, data); err != nil {
-			log.Printf("Error : could not execute template afterHandlerBody : %s", err.Error())
+			utils.Log.Error("could not execute template afterHandlerBody", "error", err)
 		}
 	}
 	return crw.ResponseWriter.Write(b)
@@ -119,7 +118,7 @@ func (crw *customResponseWriter) WriteHeader(statusCode int) {
 		}{Operation: crw.operation, TableName: crw.tableName, Headers: headers, Environment: crw.environment}
 		var res bytes.Buffer
 		if err := crw.templateHeader.Execute(&res, data); err != nil {
-			log.Printf("Error : could not execute template afterHandlerHeader : %s", err.Error())
+			utils.Log.Error("could not execute template afterHandlerHeader", "error", err)
 		} else {
 			for key, val := range headers {
 				crw.Header().Set(key, fmt.Sprint(val))
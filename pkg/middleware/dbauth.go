@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/dranih/go-crud-api/pkg/cache"
 	"github.com/dranih/go-crud-api/pkg/controller"
 	"github.com/dranih/go-crud-api/pkg/database"
 	"github.com/dranih/go-crud-api/pkg/record"
@@ -15,19 +16,83 @@ import (
 
 type DbAuthMiddleware struct {
 	GenericMiddleware
-	reflection *database.ReflectionService
-	db         *database.GenericDB
-	ordering   *record.OrderingInfo
+	reflection    *database.ReflectionService
+	db            *database.GenericDB
+	ordering      *record.OrderingInfo
+	cache         cache.Cache
+	tokenMode     bool
+	issuer        *jwtIssuer
+	verifier      *JwtAuthMiddleware
+	refreshTokens *database.DbSessionBackend
+	totpEncryptor *database.ColumnEncryptor
+	totpLocks     userLocks
 }
 
-func NewDbAuth(responder controller.Responder, properties map[string]interface{}, reflection *database.ReflectionService, db *database.GenericDB) *DbAuthMiddleware {
-	return &DbAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}, reflection: reflection, db: db, ordering: record.NewOrderingInfo()}
+func NewDbAuth(responder controller.Responder, properties map[string]interface{}, reflection *database.ReflectionService, db *database.GenericDB, cache cache.Cache) *DbAuthMiddleware {
+	dam := &DbAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: newAuthMetricsResponder(responder, "dbAuth"), Properties: properties}, reflection: reflection, db: db, ordering: record.NewOrderingInfo(), cache: cache}
+	if dam.getStringProperty("loginMode", "session") == "token" {
+		dam.tokenMode = true
+		issuer, err := newJwtIssuer(dam.getStringProperty("tokenAlgorithm", "HS256"), dam.getStringProperty("tokenKid", ""), dam.getStringProperty("tokenSecret", ""))
+		if err != nil {
+			utils.Log.Error("unable to sign dbAuth tokens", "error", err)
+		}
+		dam.issuer = issuer
+		// the access tokens are verified with the same key, a jwtAuth middleware is not needed
+		verifierProperties := map[string]interface{}{
+			"mode":         dam.getStringProperty("mode", "required"),
+			"header":       dam.getStringProperty("tokenHeader", "X-Authorization"),
+			"algorithms":   dam.getStringProperty("tokenAlgorithm", "HS256"),
+			"secret":       dam.getStringProperty("tokenSecret", ""),
+			"issuers":      dam.getStringProperty("tokenIssuer", ""),
+			"audiences":    dam.getStringProperty("tokenAudience", ""),
+			"verifyClaims": "true",
+		}
+		if kid := dam.getStringProperty("tokenKid", ""); kid != "" {
+			verifierProperties["secrets"] = kid + ":" + dam.getStringProperty("tokenSecret", "")
+		}
+		dam.verifier = &JwtAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: dam.Responder, Properties: verifierProperties}}
+		dam.refreshTokens = database.NewDbSessionBackend(db, dam.getStringProperty("refreshTokensTable", "refresh_tokens"))
+	}
+	if totpKey := dam.getStringProperty("totpKey", ""); totpKey != "" {
+		encryptor, err := newTotpEncryptor(totpKey)
+		if err != nil {
+			utils.Log.Error("unable to encrypt the dbAuth TOTP secrets", "error", err)
+		}
+		dam.totpEncryptor = encryptor
+	}
+	return dam
 }
 
 func (dam *DbAuthMiddleware) Process(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := utils.GetPathSegment(r, 1)
 		method := r.Method
+		if dam.tokenMode && method == http.MethodPost && (path == "refresh" || path == "logout") {
+			dam.processRefreshToken(path, w, r)
+			return
+		}
+		if method == http.MethodPost && ((path == "password" && utils.GetPathSegment(r, 2) == "reset") || path == "totp") {
+			table := dam.reflection.GetTable(dam.getStringProperty("usersTable", "users"))
+			protectionColumns := map[string]bool{}
+			for _, columnName := range dam.getProtectionColumns(table) {
+				protectionColumns[columnName] = true
+			}
+			resetEnabled := protectionColumns[dam.getStringProperty("resetTokenColumn", "reset_token")] && protectionColumns[dam.getStringProperty("resetExpiresColumn", "reset_expires")]
+			totpEnabled := protectionColumns[dam.getStringProperty("totpSecretColumn", "totp_secret")] && dam.totpEncryptor != nil
+			if (path == "password" && resetEnabled) || (path == "totp" && totpEnabled) {
+				body, err := utils.GetBodyMapData(r)
+				if err != nil {
+					dam.Responder.Error(record.HTTP_MESSAGE_NOT_READABLE, "", w, "")
+					return
+				}
+				if path == "password" {
+					dam.processPasswordReset(r.Context(), utils.GetPathSegment(r, 3), body, w)
+				} else {
+					dam.processTotp(utils.GetPathSegment(r, 2), body, w)
+				}
+				return
+			}
+		}
 		if method == http.MethodPost && map[string]bool{"login": true, "register": true, "password": true}[path] {
 			body, err := utils.GetBodyMapData(r)
 			if err != nil {
@@ -104,7 +169,7 @@ func (dam *DbAuthMiddleware) Process(next http.Handler) http.Handler {
 				}
 				users = dam.db.SelectAll(table, columnNames, condition, columnOrdering, 0, 1)
 				for _, user := range users {
-					delete(user, passwordColumnName)
+					dam.cleanUser(table, user, columnNames)
 					dam.Responder.Success(user, w)
 					return
 				}
@@ -112,17 +177,26 @@ func (dam *DbAuthMiddleware) Process(next http.Handler) http.Handler {
 				return
 			}
 			if path == "login" {
-				users := dam.db.SelectAll(table, columnNames, condition, columnOrdering, 0, 1)
-				for _, user := range users {
-					if err := bcrypt.CompareHashAndPassword([]byte(fmt.Sprint(user[passwordColumnName])), []byte(password)); err == nil {
-						session := utils.GetSession(w, r)
-						delete(user, passwordColumnName)
-						session.Values["user"] = user
-						if err := session.Save(r, w); err == nil {
-							dam.Responder.Success(user, w)
-							return
-						}
-					}
+				user := dam.checkCredentials(table, username, password, columnNames, w)
+				if user == nil {
+					return
+				}
+				if !dam.checkLoginTotp(table, user, username, body) {
+					dam.registerFailedAttempt(table, user, username)
+					dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
+					return
+				}
+				dam.resetFailedAttempts(table, user, username)
+				if dam.tokenMode {
+					dam.respondTokens(table, fmt.Sprint(user[pkName]), w)
+					return
+				}
+				dam.cleanUser(table, user, columnNames)
+				session := utils.GetSession(w, r)
+				session.Values["user"] = user
+				if err := session.Save(r, w); err == nil {
+					dam.Responder.Success(user, w)
+					return
 				}
 				dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
 				return
@@ -133,49 +207,48 @@ func (dam *DbAuthMiddleware) Process(next http.Handler) http.Handler {
 				if sessUser, exists := session.Values["user"]; exists {
 					sessMapUser, _ = sessUser.(map[string]interface{})
 				}
-				if sessMapUser == nil {
-					dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
+				// without session, the current password is the only proof of identity
+				if !dam.tokenMode {
+					if sessMapUser == nil {
+						dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
+						return
+					}
+					if val, exists := sessMapUser[usernameColumnName]; !exists || val != username {
+						dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
+						return
+					}
+				}
+				user := dam.checkCredentials(table, username, password, columnNames, w)
+				if user == nil {
 					return
 				}
-				if val, exists := sessMapUser[usernameColumnName]; !exists || val != username {
+				if !dam.checkLoginTotp(table, user, username, body) {
+					dam.registerFailedAttempt(table, user, username)
 					dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
 					return
 				}
-				userColumns := columnNames
-				found := false
-				for _, col := range columnNames {
-					if col == pkName {
-						found = true
-					}
+				data := map[string]interface{}{}
+				hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+				if err == nil {
+					data[passwordColumnName] = string(hash)
+				} else {
+					dam.Responder.Error(record.HTTP_MESSAGE_NOT_READABLE, "", w, "")
+					return
 				}
-				if !found {
-					userColumns = append(userColumns, pkName)
+				if _, err := dam.db.UpdateSingle(nil, table, data, fmt.Sprint(user[pkName])); err != nil {
+					dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+					return
 				}
-				users := dam.db.SelectAll(table, userColumns, condition, columnOrdering, 0, 1)
-				for _, user := range users {
-					if err := bcrypt.CompareHashAndPassword([]byte(fmt.Sprint(user[passwordColumnName])), []byte(password)); err == nil {
-						data := map[string]interface{}{}
-						hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-						if err == nil {
-							data[passwordColumnName] = string(hash)
-						} else {
-							dam.Responder.Error(record.HTTP_MESSAGE_NOT_READABLE, "", w, "")
-							return
-						}
-						if _, err := dam.db.UpdateSingle(nil, table, data, fmt.Sprint(user[pkName])); err != nil {
-							dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
-							return
-						}
-						delete(user, passwordColumnName)
-						if !found {
-							delete(user, pkName)
-						}
-						session.Values["user"] = user
-						if err := session.Save(r, w); err == nil {
-							dam.Responder.Success(user, w)
-							return
-						}
-					}
+				dam.resetFailedAttempts(table, user, username)
+				dam.cleanUser(table, user, columnNames)
+				if dam.tokenMode {
+					dam.Responder.Success(user, w)
+					return
+				}
+				session.Values["user"] = user
+				if err := session.Save(r, w); err == nil {
+					dam.Responder.Success(user, w)
+					return
 				}
 				dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
 				return
@@ -195,6 +268,10 @@ func (dam *DbAuthMiddleware) Process(next http.Handler) http.Handler {
 			return
 		}
 		if method == http.MethodGet && path == "me" {
+			if dam.tokenMode {
+				dam.processTokenUser(w, r)
+				return
+			}
 			session := utils.GetSession(w, r)
 			if user, exists := session.Values["user"]; exists {
 				dam.Responder.Success(user, w)
@@ -203,6 +280,11 @@ func (dam *DbAuthMiddleware) Process(next http.Handler) http.Handler {
 			dam.Responder.Error(record.AUTHENTICATION_REQUIRED, "", w, "")
 			return
 		}
+		// in token mode, the requests are authenticated by their access token
+		if dam.tokenMode {
+			dam.verifier.Process(next).ServeHTTP(w, r)
+			return
+		}
 		session := utils.GetSession(w, r)
 		if user, exists := session.Values["user"]; !exists || user == nil {
 			if authenticationMode := dam.getProperty("mode", "required"); authenticationMode == "required" {
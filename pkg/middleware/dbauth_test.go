@@ -0,0 +1,620 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/cache"
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+func TestDbAuthServerSession(t *testing.T) {
+	properties := map[string]interface{}{
+		"mode":            "optional",
+		"returnedColumns": "id,username,password",
+	}
+
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	gob.Register(map[string]interface{}{})
+	utils.SetSessionStore("gcasession", utils.NewServerSessionStore(utils.NewMemorySessionBackend(), securecookie.GenerateRandomKey(32), securecookie.GenerateRandomKey(32)))
+	defer utils.SetSessionStore("session", sessions.NewCookieStore(securecookie.GenerateRandomKey(64)))
+	reflection := database.NewReflectionService(db, nil, 0)
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	damMiddle := NewDbAuth(responder, properties, reflection, db, nil)
+	records := record.NewRecordService(db, reflection)
+	controller.NewRecordController(router, responder, records)
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	router.Use(damMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("Got error while creating cookie jar %s", err.Error())
+	}
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "server_session_login",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass2"}`,
+			Want:       `{"id":2,"username":"user2"}`,
+			Jar:        jar,
+			StatusCode: http.StatusOK,
+		},
+	})
+
+	// A copy of the session cookie, as it would be kept by another client
+	serverUrl, _ := url.Parse(ts.URL)
+	cookies := jar.Cookies(serverUrl)
+	if len(cookies) != 1 || cookies[0].Name != "gcasession" {
+		t.Fatalf("Want one 'gcasession' cookie, got %v", cookies)
+	}
+	copiedCookie := map[string]string{"Cookie": cookies[0].Name + "=" + cookies[0].Value}
+
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:          "server_session_me_with_copied_cookie",
+			Method:        http.MethodGet,
+			Uri:           "/me",
+			Want:          `{"id":2,"username":"user2"}`,
+			RequestHeader: copiedCookie,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:       "server_session_logout",
+			Method:     http.MethodPost,
+			Uri:        "/logout",
+			Want:       `{"id":2,"username":"user2"}`,
+			Jar:        jar,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:          "server_session_me_after_logout",
+			Method:        http.MethodGet,
+			Uri:           "/me",
+			Want:          `{"code":1011,"message":"Authentication required"}`,
+			RequestHeader: copiedCookie,
+			StatusCode:    http.StatusUnauthorized,
+		},
+	})
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
+
+// postTokens posts the body and returns the tokens of the json response
+func postTokens(t *testing.T, url, body string) map[string]interface{} {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Got error on POST %s : %s", url, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Want status '200', got '%d' at url '%s'", resp.StatusCode, url)
+	}
+	var tokens map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		t.Fatalf("Unable to decode tokens : %s", err.Error())
+	}
+	for _, key := range []string{"access_token", "refresh_token"} {
+		if v, ok := tokens[key].(string); !ok || v == "" {
+			t.Fatalf("Want a '%s' in the response, got %v", key, tokens)
+		}
+	}
+	return tokens
+}
+
+func TestDbAuthTokens(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+	keyPem := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	if _, err := db.PDO().Exec(nil, `CREATE TABLE "refresh_tokens" ("id" varchar(64) NOT NULL PRIMARY KEY, "data" text NOT NULL, "expires" bigint NOT NULL)`); err != nil {
+		t.Fatalf("Unable to create refresh tokens table : %s", err.Error())
+	}
+	gob.Register(map[string]interface{}{})
+	reflection := database.NewReflectionService(db, nil, 0)
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	damMiddle := NewDbAuth(responder, map[string]interface{}{
+		"mode":            "required",
+		"loginMode":       "token",
+		"returnedColumns": "id,username",
+		"tokenAlgorithm":  "EdDSA",
+		"tokenKid":        "k1",
+		"tokenSecret":     keyPem,
+		"tokenClaims":     "sub:id,name:username",
+	}, reflection, db, nil)
+	records := record.NewRecordService(db, reflection)
+	controller.NewRecordController(router, responder, records)
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	// the access tokens are verified by dbAuth, without jwtAuth
+	router.Use(damMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	login := postTokens(t, ts.URL+"/login", `{"username":"user2","password":"pass2"}`)
+	bearer := map[string]string{"X-Authorization": "Bearer " + login["access_token"].(string)}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	forged, _ := (&jwtIssuer{"EdDSA", "k1", otherKey}).sign(map[string]interface{}{"sub": 1, "exp": time.Now().Unix() + 60})
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "token_login_bad_password",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass1"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "token_read_without_token",
+			Method:     http.MethodGet,
+			Uri:        "/records/categories/1",
+			WantRegex:  `"code":1011`,
+			StatusCode: http.StatusUnauthorized,
+		},
+		{
+			Name:          "token_read_with_access_token",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/1",
+			Want:          `{"icon":null,"id":1,"name":"announcement"}`,
+			RequestHeader: bearer,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "token_read_with_forged_token",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/1",
+			Want:          `{"code":1012,"message":"Authentication failed for 'JWT'"}`,
+			RequestHeader: map[string]string{"X-Authorization": "Bearer " + forged},
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "token_me_with_access_token",
+			Method:        http.MethodGet,
+			Uri:           "/me",
+			Want:          `{"id":2,"username":"user2"}`,
+			RequestHeader: bearer,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "token_me_with_forged_token",
+			Method:        http.MethodGet,
+			Uri:           "/me",
+			Want:          `{"code":1011,"message":"Authentication required"}`,
+			RequestHeader: map[string]string{"X-Authorization": "Bearer " + forged},
+			StatusCode:    http.StatusUnauthorized,
+		},
+		{
+			Name:       "token_refresh_unknown",
+			Method:     http.MethodPost,
+			Uri:        "/refresh",
+			Body:       `{"refresh_token":"unknown"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'refresh token'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+	})
+
+	refreshed := postTokens(t, ts.URL+"/refresh", `{"refresh_token":"`+login["refresh_token"].(string)+`"}`)
+	if refreshed["refresh_token"] == login["refresh_token"] {
+		t.Errorf("Want a new refresh token, got the same")
+	}
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:          "token_read_with_refreshed_token",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/1",
+			Want:          `{"icon":null,"id":1,"name":"announcement"}`,
+			RequestHeader: map[string]string{"X-Authorization": "Bearer " + refreshed["access_token"].(string)},
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:       "token_refresh_reused",
+			Method:     http.MethodPost,
+			Uri:        "/refresh",
+			Body:       `{"refresh_token":"` + login["refresh_token"].(string) + `"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'refresh token'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "token_logout",
+			Method:     http.MethodPost,
+			Uri:        "/logout",
+			Body:       `{"refresh_token":"` + refreshed["refresh_token"].(string) + `"}`,
+			Want:       `{"id":2,"username":"user2"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "token_refresh_after_logout",
+			Method:     http.MethodPost,
+			Uri:        "/refresh",
+			Body:       `{"refresh_token":"` + refreshed["refresh_token"].(string) + `"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'refresh token'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "token_logout_twice",
+			Method:     http.MethodPost,
+			Uri:        "/logout",
+			Body:       `{"refresh_token":"` + refreshed["refresh_token"].(string) + `"}`,
+			Want:       `{"code":1011,"message":"Authentication required"}`,
+			StatusCode: http.StatusUnauthorized,
+		},
+		{
+			Name:          "token_table_hidden",
+			Method:        http.MethodGet,
+			Uri:           "/records/refresh_tokens",
+			Want:          `{"code":1001,"message":"Table 'refresh_tokens' not found"}`,
+			RequestHeader: map[string]string{"X-Authorization": "Bearer " + refreshed["access_token"].(string)},
+			StatusCode:    http.StatusNotFound,
+		},
+	})
+
+	// of concurrent refreshes with the same token, only one gets new tokens
+	login = postTokens(t, ts.URL+"/login", `{"username":"user2","password":"pass2"}`)
+	var wg sync.WaitGroup
+	var refreshes int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(ts.URL+"/refresh", "application/json", strings.NewReader(`{"refresh_token":"`+login["refresh_token"].(string)+`"}`))
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					atomic.AddInt32(&refreshes, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if refreshes != 1 {
+		t.Errorf("Want a single refresh with the same token, got %d", refreshes)
+	}
+	backend := database.NewDbSessionBackend(db, "refresh_tokens")
+	backend.Save("token", "2", 60)
+	if first, _ := backend.Delete("token"); first != 1 {
+		t.Errorf("Want 1 deleted refresh token, got %d", first)
+	}
+	if second, _ := backend.Delete("token"); second != 0 {
+		t.Errorf("Want no refresh token deleted twice, got %d", second)
+	}
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
+
+func TestDbAuthProtection(t *testing.T) {
+	resetTokens := make(chan string, 1)
+	resetTraceParents := make(chan string, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err == nil {
+			resetTraceParents <- r.Header.Get("traceparent")
+			resetTokens <- fmt.Sprint(payload["token"])
+		}
+	}))
+	defer webhook.Close()
+	traceParent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	for _, column := range []string{`"failed_attempts" integer`, `"locked_until" bigint`, `"reset_token" varchar(64)`, `"reset_expires" bigint`, `"totp_secret" varchar(128)`, `"totp_step" bigint`} {
+		if _, err := db.PDO().Exec(nil, `ALTER TABLE "users" ADD COLUMN `+column); err != nil {
+			t.Fatalf("Unable to add column %s : %s", column, err.Error())
+		}
+	}
+	gob.Register(map[string]interface{}{})
+	reflection := database.NewReflectionService(db, nil, 0)
+	responder := controller.NewJsonResponder(false)
+	totpKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	newServer := func(properties map[string]interface{}) *httptest.Server {
+		router := mux.NewRouter()
+		damMiddle := NewDbAuth(responder, properties, reflection, db, cache.Create("Gocache", "gocrudtests-", ""))
+		router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+		})
+		router.Use(NewTracingMiddleware(responder, nil).Process)
+		router.Use(damMiddle.Process)
+		return httptest.NewServer(router)
+	}
+	ts := newServer(map[string]interface{}{
+		"mode":              "optional",
+		"returnedColumns":   "id,username",
+		"passwordLength":    "4",
+		"maxFailedAttempts": "2",
+		"resetTokenWebhook": webhook.URL,
+		"totpKey":           totpKey,
+	})
+	defer ts.Close()
+
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "lockout_first_failure",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"wrong"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "lockout_second_failure",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"wrong"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "lockout_valid_password_blocked",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass2"}`,
+			Want:       `{"code":1016,"message":"Temporary or permanently blocked"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "reset_request_unknown_user",
+			Method:     http.MethodPost,
+			Uri:        "/password/reset/request",
+			Body:       `{"username":"nobody"}`,
+			Want:       `true`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:          "reset_request",
+			Method:        http.MethodPost,
+			Uri:           "/password/reset/request",
+			Body:          `{"username":"user2"}`,
+			Want:          `true`,
+			StatusCode:    http.StatusOK,
+			RequestHeader: map[string]string{"traceparent": traceParent},
+		},
+	})
+	// the trace of the request is propagated to the webhook
+	if received := <-resetTraceParents; len(received) != len(traceParent) || received[3:35] != traceParent[3:35] {
+		t.Errorf("Reset webhook got traceparent '%s', want the trace of '%s'", received, traceParent)
+	}
+	resetToken := <-resetTokens
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:       "reset_confirm_bad_token",
+			Method:     http.MethodPost,
+			Uri:        "/password/reset/confirm",
+			Body:       `{"username":"user2","token":"wrong","newPassword":"pass2new"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "reset_confirm",
+			Method:     http.MethodPost,
+			Uri:        "/password/reset/confirm",
+			Body:       `{"username":"user2","token":"` + resetToken + `","newPassword":"pass2new"}`,
+			Want:       `{"id":2,"username":"user2"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "reset_confirm_token_used",
+			Method:     http.MethodPost,
+			Uri:        "/password/reset/confirm",
+			Body:       `{"username":"user2","token":"` + resetToken + `","newPassword":"pass2other"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "reset_login_new_password",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass2new"}`,
+			Want:       `{"id":2,"username":"user2"}`,
+			StatusCode: http.StatusOK,
+		},
+	})
+
+	resp, err := http.Post(ts.URL+"/totp/enroll", "application/json", strings.NewReader(`{"username":"user2","password":"pass2new"}`))
+	if err != nil {
+		t.Fatalf("Got error on TOTP enrolment : %s", err.Error())
+	}
+	var enrolment map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&enrolment)
+	resp.Body.Close()
+	secret, ok := enrolment["secret"].(string)
+	if err != nil || !ok {
+		t.Fatalf("Want a TOTP secret, got %v", enrolment)
+	}
+	if uri := fmt.Sprint(enrolment["uri"]); !strings.HasPrefix(uri, "otpauth://totp/GoCrudApi:user2?secret="+secret) {
+		t.Errorf("Want an otpauth uri, got '%s'", uri)
+	}
+	// the secret is stored encrypted
+	for _, user := range db.SelectSingle(nil, reflection.GetTable("users"), []string{"totp_secret"}, "2") {
+		if stored := fmt.Sprint(user["totp_secret"]); !strings.HasPrefix(stored, "enc:totp:") || strings.Contains(stored, secret) {
+			t.Errorf("Want the TOTP secret encrypted, got '%s'", stored)
+		}
+	}
+	// without lockout, the refused codes do not block the account
+	tsTotp := newServer(map[string]interface{}{
+		"mode":            "optional",
+		"returnedColumns": "id,username",
+		"totpKey":         totpKey,
+	})
+	defer tsTotp.Close()
+	// the codes of the time steps around now are valid, each one being accepted once
+	if time.Now().Unix()%30 > 25 {
+		time.Sleep(5 * time.Second)
+	}
+	counter := time.Now().Unix() / 30
+	code := func(offset int64) string {
+		key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+		return totpCode(key, uint64(counter+offset))
+	}
+	utils.RunTests(t, tsTotp.URL, []utils.Test{
+		{
+			Name:       "totp_login_before_verification",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass2new"}`,
+			Want:       `{"id":2,"username":"user2"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "totp_verify_bad_code",
+			Method:     http.MethodPost,
+			Uri:        "/totp/verify",
+			Body:       `{"username":"user2","password":"pass2new","totpCode":"abcdef"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "totp_verify",
+			Method:     http.MethodPost,
+			Uri:        "/totp/verify",
+			Body:       `{"username":"user2","password":"pass2new","totpCode":"` + code(-1) + `"}`,
+			Want:       `true`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "totp_login_without_code",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass2new"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "totp_login_with_code",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass2new","totpCode":"` + code(0) + `"}`,
+			Want:       `{"id":2,"username":"user2"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "totp_login_code_replayed",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass2new","totpCode":"` + code(0) + `"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "totp_login_code_of_previous_step",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"user2","password":"pass2new","totpCode":"` + code(-1) + `"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "totp_disable_without_code",
+			Method:     http.MethodPost,
+			Uri:        "/totp/disable",
+			Body:       `{"username":"user2","password":"pass2new"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'user2'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "totp_disable",
+			Method:     http.MethodPost,
+			Uri:        "/totp/disable",
+			Body:       `{"username":"user2","password":"pass2new","totpCode":"` + code(1) + `"}`,
+			Want:       `true`,
+			StatusCode: http.StatusOK,
+		},
+	})
+
+	// without the lockout columns the failed attempts are counted in the cache, unknown users included
+	tsCache := newServer(map[string]interface{}{
+		"mode":                 "optional",
+		"failedAttemptsColumn": "",
+		"maxFailedAttempts":    "1",
+	})
+	defer tsCache.Close()
+	utils.RunTests(t, tsCache.URL, []utils.Test{
+		{
+			Name:       "cache_lockout_failure",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"nobody","password":"wrong"}`,
+			Want:       `{"code":1012,"message":"Authentication failed for 'nobody'"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "cache_lockout_blocked",
+			Method:     http.MethodPost,
+			Uri:        "/login",
+			Body:       `{"username":"nobody","password":"wrong"}`,
+			Want:       `{"code":1016,"message":"Temporary or permanently blocked"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "totp_without_key",
+			Method:     http.MethodPost,
+			Uri:        "/totp/enroll",
+			Body:       `{"username":"user1","password":"pass1"}`,
+			Want:       `{"code":1000,"message":"Route '/totp/enroll' not found"}`,
+			StatusCode: http.StatusNotFound,
+		},
+	})
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
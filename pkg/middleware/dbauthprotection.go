@@ -0,0 +1,521 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// totpPending prefixes a TOTP secret enrolled but not verified yet
+const totpPending = "pending:"
+
+// userLocks serializes the operations of a same user, the locks being removed once released by all
+type userLocks struct {
+	mutex sync.Mutex
+	locks map[string]*userLock
+}
+
+type userLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the username and returns the function unlocking it
+func (ul *userLocks) lock(username string) func() {
+	ul.mutex.Lock()
+	if ul.locks == nil {
+		ul.locks = map[string]*userLock{}
+	}
+	lock, exists := ul.locks[username]
+	if !exists {
+		lock = &userLock{}
+		ul.locks[username] = lock
+	}
+	lock.refs++
+	ul.mutex.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		ul.mutex.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(ul.locks, username)
+		}
+		ul.mutex.Unlock()
+	}
+}
+
+// newTotpEncryptor returns the encryptor of the TOTP secrets using the base64 encoded totpKey
+func newTotpEncryptor(totpKey string) (*database.ColumnEncryptor, error) {
+	key, err := base64.StdEncoding.DecodeString(totpKey)
+	if err != nil {
+		return nil, err
+	}
+	return database.NewColumnEncryptor(map[string][]byte{"totp": key}, "totp", nil, nil)
+}
+
+// getTotpSecret returns the decrypted TOTP secret of the user, "" if none. The secrets stored before the
+// encryption are returned as is.
+func (dam *DbAuthMiddleware) getTotpSecret(table *database.ReflectedTable, user map[string]interface{}) (string, error) {
+	totpSecretColumn := dam.getStringProperty("totpSecretColumn", "totp_secret")
+	if user[totpSecretColumn] == nil {
+		return "", nil
+	}
+	secret := fmt.Sprint(user[totpSecretColumn])
+	if dam.totpEncryptor == nil {
+		if !strings.HasPrefix(secret, "enc:") {
+			return secret, nil
+		}
+		return "", fmt.Errorf("no totpKey to decrypt the TOTP secret")
+	}
+	return dam.totpEncryptor.Decrypt(table.GetName(), totpSecretColumn, secret)
+}
+
+// getProtectionColumns returns the configured account protection columns existing in the users table,
+// they are never returned to the client
+func (dam *DbAuthMiddleware) getProtectionColumns(table *database.ReflectedTable) []string {
+	columnNames := []string{}
+	for _, property := range [][2]string{
+		{"failedAttemptsColumn", "failed_attempts"},
+		{"lockedUntilColumn", "locked_until"},
+		{"resetTokenColumn", "reset_token"},
+		{"resetExpiresColumn", "reset_expires"},
+		{"totpSecretColumn", "totp_secret"},
+		{"totpStepColumn", "totp_step"},
+	} {
+		if columnName := dam.getStringProperty(property[0], property[1]); columnName != "" && table.HasColumn(columnName) {
+			columnNames = append(columnNames, columnName)
+		}
+	}
+	return columnNames
+}
+
+// getLoginColumns adds the primary key and the protection columns to the selected columns
+func (dam *DbAuthMiddleware) getLoginColumns(table *database.ReflectedTable, columnNames []string) []string {
+	loginColumns := append([]string{table.GetPk().GetName()}, columnNames...)
+	loginColumns = append(loginColumns, dam.getProtectionColumns(table)...)
+	return utils.RemoveDuplicateStr(loginColumns)
+}
+
+// cleanUser removes the password, the protection columns and the columns not in columnNames
+func (dam *DbAuthMiddleware) cleanUser(table *database.ReflectedTable, user map[string]interface{}, columnNames []string) {
+	returned := map[string]bool{}
+	for _, columnName := range columnNames {
+		returned[columnName] = true
+	}
+	for columnName := range user {
+		if !returned[columnName] {
+			delete(user, columnName)
+		}
+	}
+	delete(user, dam.getStringProperty("passwordColumn", "password"))
+	for _, columnName := range dam.getProtectionColumns(table) {
+		delete(user, columnName)
+	}
+}
+
+// hasLockoutColumns tells if the failed attempts are counted in the users table instead of the cache
+func (dam *DbAuthMiddleware) hasLockoutColumns(table *database.ReflectedTable) bool {
+	failedAttemptsColumn := dam.getStringProperty("failedAttemptsColumn", "failed_attempts")
+	lockedUntilColumn := dam.getStringProperty("lockedUntilColumn", "locked_until")
+	return failedAttemptsColumn != "" && lockedUntilColumn != "" && table.HasColumn(failedAttemptsColumn) && table.HasColumn(lockedUntilColumn)
+}
+
+func lockoutCacheKey(username string) string {
+	return "dbAuth-failed-" + base64.RawURLEncoding.EncodeToString([]byte(username))
+}
+
+func totpCacheKey(username string) string {
+	return "dbAuth-totp-" + base64.RawURLEncoding.EncodeToString([]byte(username))
+}
+
+func getInt64Value(value interface{}) int64 {
+	result, _ := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+	return result
+}
+
+// isLockedOut tells if the login of the user is blocked after too many failed attempts
+func (dam *DbAuthMiddleware) isLockedOut(table *database.ReflectedTable, user map[string]interface{}, username string) bool {
+	maxFailedAttempts := dam.getIntProperty("maxFailedAttempts", 0)
+	if maxFailedAttempts <= 0 {
+		return false
+	}
+	if dam.hasLockoutColumns(table) {
+		if user == nil {
+			return false
+		}
+		return getInt64Value(user[dam.getStringProperty("lockedUntilColumn", "locked_until")]) > time.Now().Unix()
+	}
+	if dam.cache == nil {
+		return false
+	}
+	attempts, _ := strconv.Atoi(dam.cache.Get(lockoutCacheKey(username)))
+	return attempts >= maxFailedAttempts
+}
+
+// registerFailedAttempt counts a failed attempt, the user is locked out for lockoutTime seconds
+// when maxFailedAttempts is reached
+func (dam *DbAuthMiddleware) registerFailedAttempt(table *database.ReflectedTable, user map[string]interface{}, username string) {
+	maxFailedAttempts := dam.getIntProperty("maxFailedAttempts", 0)
+	if maxFailedAttempts <= 0 {
+		return
+	}
+	lockoutTime := dam.getIntProperty("lockoutTime", 900)
+	if dam.hasLockoutColumns(table) {
+		if user == nil {
+			return
+		}
+		failedAttemptsColumn := dam.getStringProperty("failedAttemptsColumn", "failed_attempts")
+		attempts := getInt64Value(user[failedAttemptsColumn]) + 1
+		data := map[string]interface{}{failedAttemptsColumn: attempts}
+		if attempts >= int64(maxFailedAttempts) {
+			data[failedAttemptsColumn] = 0
+			data[dam.getStringProperty("lockedUntilColumn", "locked_until")] = time.Now().Unix() + int64(lockoutTime)
+		}
+		if _, err := dam.db.UpdateSingle(nil, table, data, fmt.Sprint(user[table.GetPk().GetName()])); err != nil {
+			utils.Log.Error("unable to count failed login attempt", "error", err)
+		}
+		return
+	}
+	if dam.cache == nil {
+		return
+	}
+	key := lockoutCacheKey(username)
+	attempts, _ := strconv.Atoi(dam.cache.Get(key))
+	dam.cache.Set(key, strconv.Itoa(attempts+1), int32(lockoutTime))
+}
+
+// resetFailedAttempts clears the failed attempts after a successful authentication
+func (dam *DbAuthMiddleware) resetFailedAttempts(table *database.ReflectedTable, user map[string]interface{}, username string) {
+	if dam.getIntProperty("maxFailedAttempts", 0) <= 0 {
+		return
+	}
+	if dam.hasLockoutColumns(table) {
+		failedAttemptsColumn := dam.getStringProperty("failedAttemptsColumn", "failed_attempts")
+		lockedUntilColumn := dam.getStringProperty("lockedUntilColumn", "locked_until")
+		if getInt64Value(user[failedAttemptsColumn]) == 0 && user[lockedUntilColumn] == nil {
+			return
+		}
+		data := map[string]interface{}{failedAttemptsColumn: 0, lockedUntilColumn: nil}
+		if _, err := dam.db.UpdateSingle(nil, table, data, fmt.Sprint(user[table.GetPk().GetName()])); err != nil {
+			utils.Log.Error("unable to reset failed login attempts", "error", err)
+		}
+		return
+	}
+	if dam.cache != nil && dam.cache.Get(lockoutCacheKey(username)) != "" {
+		dam.cache.Set(lockoutCacheKey(username), "0", 1)
+	}
+}
+
+// getUser returns the user with the username, with its primary key and protection columns
+func (dam *DbAuthMiddleware) getUser(table *database.ReflectedTable, username string, columnNames []string) map[string]interface{} {
+	usernameColumn := table.GetColumn(dam.getStringProperty("usernameColumn", "username"))
+	condition := database.NewColumnCondition(usernameColumn, "eq", username)
+	users := dam.db.SelectAll(table, dam.getLoginColumns(table, columnNames), condition, dam.ordering.GetDefaultColumnOrdering(table), 0, 1)
+	if len(users) == 0 {
+		return nil
+	}
+	return users[0]
+}
+
+// checkCredentials returns the user if the password is valid, the failed attempts being counted
+func (dam *DbAuthMiddleware) checkCredentials(table *database.ReflectedTable, username, password string, columnNames []string, w http.ResponseWriter) map[string]interface{} {
+	passwordColumnName := dam.getStringProperty("passwordColumn", "password")
+	user := dam.getUser(table, username, append([]string{passwordColumnName}, columnNames...))
+	if dam.isLockedOut(table, user, username) {
+		dam.Responder.Error(record.TEMPORARY_OR_PERMANENTLY_BLOCKED, username, w, "")
+		return nil
+	}
+	if user == nil || bcrypt.CompareHashAndPassword([]byte(fmt.Sprint(user[passwordColumnName])), []byte(password)) != nil {
+		dam.registerFailedAttempt(table, user, username)
+		dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
+		return nil
+	}
+	return user
+}
+
+// processPasswordReset handles /password/reset/request and /password/reset/confirm
+func (dam *DbAuthMiddleware) processPasswordReset(ctx context.Context, action string, body map[string]interface{}, w http.ResponseWriter) {
+	table := dam.reflection.GetTable(dam.getStringProperty("usersTable", "users"))
+	resetTokenColumn := dam.getStringProperty("resetTokenColumn", "reset_token")
+	resetExpiresColumn := dam.getStringProperty("resetExpiresColumn", "reset_expires")
+	username := getBodyString(body, dam.getStringProperty("usernameFormField", "username"))
+	switch action {
+	case "request":
+		// the response does not tell if the user exists
+		user := dam.getUser(table, username, nil)
+		if user == nil {
+			dam.Responder.Success(true, w)
+			return
+		}
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			utils.Log.Error("unable to generate reset token", "error", err)
+			dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+			return
+		}
+		token := base64.RawURLEncoding.EncodeToString(random)
+		expires := time.Now().Unix() + int64(dam.getIntProperty("resetTokenTtl", 3600))
+		data := map[string]interface{}{resetTokenColumn: hashToken(token), resetExpiresColumn: expires}
+		if _, err := dam.db.UpdateSingle(nil, table, data, fmt.Sprint(user[table.GetPk().GetName()])); err != nil {
+			utils.Log.Error("unable to save reset token", "error", err)
+			dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+			return
+		}
+		// the webhook is called in the background, the response time does not tell if the user exists,
+		// so it continues the trace of the request without being canceled with it
+		go dam.sendResetToken(utils.ContextWithSpanContext(context.Background(), utils.SpanContextFromContext(ctx)), username, token, expires)
+		dam.Responder.Success(true, w)
+	case "confirm":
+		token := getBodyString(body, dam.getStringProperty("resetTokenFormField", "token"))
+		newPassword := getBodyString(body, dam.getStringProperty("newPasswordFormField", "newPassword"))
+		columnNames := dam.getReturnedColumnNames(table)
+		user := dam.getUser(table, username, columnNames)
+		if user == nil || token == "" || user[resetTokenColumn] == nil ||
+			subtle.ConstantTimeCompare([]byte(fmt.Sprint(user[resetTokenColumn])), []byte(hashToken(token))) != 1 ||
+			getInt64Value(user[resetExpiresColumn]) < time.Now().Unix() {
+			dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
+			return
+		}
+		if passwordLength := dam.getIntProperty("passwordLength", 12); len(newPassword) < passwordLength {
+			dam.Responder.Error(record.PASSWORD_TOO_SHORT, fmt.Sprintf("%d", passwordLength), w, "")
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		if err != nil {
+			dam.Responder.Error(record.HTTP_MESSAGE_NOT_READABLE, "", w, "")
+			return
+		}
+		// the token is used only once and the lockout is lifted
+		data := map[string]interface{}{dam.getStringProperty("passwordColumn", "password"): string(hash), resetTokenColumn: nil, resetExpiresColumn: nil}
+		if dam.hasLockoutColumns(table) {
+			data[dam.getStringProperty("failedAttemptsColumn", "failed_attempts")] = 0
+			data[dam.getStringProperty("lockedUntilColumn", "locked_until")] = nil
+		}
+		if _, err := dam.db.UpdateSingle(nil, table, data, fmt.Sprint(user[table.GetPk().GetName()])); err != nil {
+			dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+			return
+		}
+		if !dam.hasLockoutColumns(table) {
+			dam.resetFailedAttempts(table, user, username)
+		}
+		dam.cleanUser(table, user, columnNames)
+		dam.Responder.Success(user, w)
+	default:
+		dam.Responder.Error(record.ROUTE_NOT_FOUND, "/password/reset/"+action, w, "")
+	}
+}
+
+// sendResetToken posts the reset token to the resetTokenWebhook, in charge of delivering it to the user
+func (dam *DbAuthMiddleware) sendResetToken(ctx context.Context, username, token string, expires int64) {
+	webhook := dam.getStringProperty("resetTokenWebhook", "")
+	if webhook == "" {
+		utils.Log.Warning("no resetTokenWebhook configured, the reset token is not delivered", "username", username)
+		return
+	}
+	payload, _ := json.Marshal(map[string]interface{}{"username": username, "token": token, "expires": expires})
+	ctx, span := utils.StartSpan(ctx, "POST resetTokenWebhook", utils.SpanKindClient, "http.url", webhook)
+	defer span.Finish()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(payload))
+	if err != nil {
+		span.SetError(err)
+		utils.Log.Error("unable to send reset token", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	utils.InjectTraceContext(ctx, req.Header)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		span.SetError(err)
+		utils.Log.Error("unable to send reset token", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		span.SetError(fmt.Errorf("status %d", resp.StatusCode))
+		utils.Log.Error("reset token webhook returned an error", "status", resp.StatusCode)
+	}
+}
+
+// processTotp handles /totp/enroll, /totp/verify and /totp/disable, the password being required for each
+func (dam *DbAuthMiddleware) processTotp(action string, body map[string]interface{}, w http.ResponseWriter) {
+	table := dam.reflection.GetTable(dam.getStringProperty("usersTable", "users"))
+	totpSecretColumn := dam.getStringProperty("totpSecretColumn", "totp_secret")
+	username := getBodyString(body, dam.getStringProperty("usernameFormField", "username"))
+	password := getBodyString(body, dam.getStringProperty("passwordFormField", "password"))
+	code := getBodyString(body, dam.getStringProperty("totpFormField", "totpCode"))
+	if action != "enroll" && action != "verify" && action != "disable" {
+		dam.Responder.Error(record.ROUTE_NOT_FOUND, "/totp/"+action, w, "")
+		return
+	}
+	user := dam.checkCredentials(table, username, password, nil, w)
+	if user == nil {
+		return
+	}
+	secret, err := dam.getTotpSecret(table, user)
+	if err != nil {
+		utils.Log.Error("unable to decrypt the TOTP secret", "error", err)
+		dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+		return
+	}
+	active := secret != "" && !strings.HasPrefix(secret, totpPending)
+	// an active secret is replaced or removed only with a valid code
+	if active && !dam.acceptTotp(table, username, secret, code) {
+		dam.registerFailedAttempt(table, user, username)
+		dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
+		return
+	}
+	var value interface{}
+	var response interface{} = true
+	switch action {
+	case "enroll":
+		random := make([]byte, 20)
+		if _, err := rand.Read(random); err != nil {
+			dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+			return
+		}
+		newSecret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random)
+		issuer := dam.getStringProperty("totpIssuer", "GoCrudApi")
+		label := url.PathEscape(issuer + ":" + username)
+		value = totpPending + newSecret
+		response = map[string]interface{}{
+			"secret": newSecret,
+			"uri":    fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s&algorithm=SHA1&digits=6&period=30", label, newSecret, url.QueryEscape(issuer)),
+		}
+	case "verify":
+		if !strings.HasPrefix(secret, totpPending) || !dam.acceptTotp(table, username, strings.TrimPrefix(secret, totpPending), code) {
+			dam.registerFailedAttempt(table, user, username)
+			dam.Responder.Error(record.AUTHENTICATION_FAILED, username, w, "")
+			return
+		}
+		value = strings.TrimPrefix(secret, totpPending)
+	case "disable":
+		value = nil
+	}
+	// the secrets are stored encrypted, they cannot be read from the users table
+	if value != nil {
+		encrypted, err := dam.totpEncryptor.Encrypt(table.GetName(), totpSecretColumn, fmt.Sprint(value))
+		if err != nil {
+			dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+			return
+		}
+		value = encrypted
+	}
+	data := map[string]interface{}{totpSecretColumn: value}
+	// the accepted time steps of a replaced or removed secret are forgotten
+	if action != "verify" {
+		if totpStepColumn := dam.getStringProperty("totpStepColumn", "totp_step"); totpStepColumn != "" && table.HasColumn(totpStepColumn) {
+			data[totpStepColumn] = nil
+		} else if dam.cache != nil {
+			dam.cache.Delete(totpCacheKey(username))
+		}
+	}
+	if _, err := dam.db.UpdateSingle(nil, table, data, fmt.Sprint(user[table.GetPk().GetName()])); err != nil {
+		dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+		return
+	}
+	dam.resetFailedAttempts(table, user, username)
+	dam.Responder.Success(response, w)
+}
+
+// checkLoginTotp verifies the code of the body if the user has an active TOTP secret, the login being refused
+// when the secret cannot be decrypted
+func (dam *DbAuthMiddleware) checkLoginTotp(table *database.ReflectedTable, user map[string]interface{}, username string, body map[string]interface{}) bool {
+	secret, err := dam.getTotpSecret(table, user)
+	if err != nil {
+		utils.Log.Error("unable to decrypt the TOTP secret", "error", err)
+		return false
+	}
+	if secret == "" || strings.HasPrefix(secret, totpPending) {
+		return true
+	}
+	return dam.acceptTotp(table, username, secret, getBodyString(body, dam.getStringProperty("totpFormField", "totpCode")))
+}
+
+// acceptTotp verifies the code of the user, the time step of the accepted code being kept in the totpStepColumn
+// (or in the cache without it) for the code not to be accepted twice, the verifications of a user being serialized
+func (dam *DbAuthMiddleware) acceptTotp(table *database.ReflectedTable, username, secret, code string) bool {
+	unlock := dam.totpLocks.lock(username)
+	defer unlock()
+	totpStepColumn := dam.getStringProperty("totpStepColumn", "totp_step")
+	hasStepColumn := totpStepColumn != "" && table.HasColumn(totpStepColumn)
+	var lastStep int64
+	var user map[string]interface{}
+	if hasStepColumn {
+		// read again, the code may have been accepted by a concurrent request
+		if user = dam.getUser(table, username, nil); user == nil {
+			return false
+		}
+		lastStep = getInt64Value(user[totpStepColumn])
+	} else if dam.cache != nil {
+		lastStep = getInt64Value(dam.cache.Get(totpCacheKey(username)))
+	}
+	step, ok := verifyTotp(secret, code, time.Now().Unix(), lastStep)
+	if !ok {
+		return false
+	}
+	if hasStepColumn {
+		if _, err := dam.db.UpdateSingle(nil, table, map[string]interface{}{totpStepColumn: step}, fmt.Sprint(user[table.GetPk().GetName()])); err != nil {
+			utils.Log.Error("unable to save the TOTP time step", "error", err)
+			return false
+		}
+	} else if dam.cache != nil {
+		// the steps older than the window are refused anyway
+		dam.cache.Set(totpCacheKey(username), strconv.FormatInt(step, 10), 90)
+	}
+	return true
+}
+
+func getBodyString(body map[string]interface{}, field string) string {
+	if v, ok := body[field]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// totpCode computes the RFC 6238 code (HMAC-SHA1, 6 digits) of the time step
+func totpCode(key []byte, counter uint64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// verifyTotp checks the code against the 30 seconds time steps around now, allowing for clock skew,
+// and returns the matching step : the steps up to lastStep are already used and refused
+func verifyTotp(secret, code string, now, lastStep int64) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(code) != 6 {
+		return 0, false
+	}
+	counter := now / 30
+	for step := counter - 1; step <= counter+1; step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(totpCode(key, uint64(step))), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// respondTokens issues a signed access token and a refresh token for the user
+func (dam *DbAuthMiddleware) respondTokens(table *database.ReflectedTable, userId string, w http.ResponseWriter) {
+	users := dam.db.SelectSingle(nil, table, table.GetColumnNames(), userId)
+	if len(users) == 0 || dam.issuer == nil {
+		dam.Responder.Error(record.AUTHENTICATION_FAILED, userId, w, "")
+		return
+	}
+	now := time.Now().Unix()
+	accessTtl := dam.getIntProperty("tokenTtl", 900)
+	claims := map[string]interface{}{"iat": now, "exp": now + int64(accessTtl)}
+	for claim, columnName := range dam.getMapProperty("tokenClaims", "sub:"+table.GetPk().GetName()) {
+		if value, exists := users[0][columnName]; exists {
+			claims[claim] = value
+		}
+	}
+	if issuer := dam.getStringProperty("tokenIssuer", ""); issuer != "" {
+		claims["iss"] = issuer
+	}
+	if audience := dam.getStringProperty("tokenAudience", ""); audience != "" {
+		claims["aud"] = audience
+	}
+	accessToken, err := dam.issuer.sign(claims)
+	if err != nil {
+		utils.Log.Error("unable to sign access token", "error", err)
+		dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+		return
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		utils.Log.Error("unable to generate refresh token", "error", err)
+		dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+		return
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(random)
+	if err := dam.refreshTokens.Save(hashToken(refreshToken), userId, dam.getIntProperty("refreshTokenTtl", 2592000)); err != nil {
+		utils.Log.Error("unable to save refresh token", "error", err)
+		dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+		return
+	}
+	dam.Responder.Success(map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    accessTtl,
+		"refresh_token": refreshToken,
+	}, w)
+}
+
+// processRefreshToken revokes the refresh token of the body, "refresh" then issues new tokens
+// and "logout" returns the user
+func (dam *DbAuthMiddleware) processRefreshToken(path string, w http.ResponseWriter, r *http.Request) {
+	body, err := utils.GetBodyMapData(r)
+	if err != nil {
+		dam.Responder.Error(record.HTTP_MESSAGE_NOT_READABLE, "", w, "")
+		return
+	}
+	refreshToken := ""
+	if v, ok := body[dam.getStringProperty("refreshTokenFormField", "refresh_token")]; ok {
+		refreshToken = fmt.Sprint(v)
+	}
+	userId := ""
+	if refreshToken != "" {
+		id := hashToken(refreshToken)
+		if userId, err = dam.refreshTokens.Load(id); err != nil {
+			utils.Log.Error("unable to load refresh token", "error", err)
+		}
+		if userId != "" {
+			// a refresh token is used only once : of concurrent requests with the same token, only the one
+			// deleting it gets new tokens
+			deleted, err := dam.refreshTokens.Delete(id)
+			if err != nil {
+				utils.Log.Error("unable to revoke refresh token", "error", err)
+				dam.Responder.Error(record.INTERNAL_SERVER_ERROR, "", w, "")
+				return
+			}
+			if deleted != 1 {
+				userId = ""
+			}
+		}
+	}
+	table := dam.reflection.GetTable(dam.getStringProperty("usersTable", "users"))
+	if path == "refresh" {
+		if userId == "" {
+			dam.Responder.Error(record.AUTHENTICATION_FAILED, "refresh token", w, "")
+			return
+		}
+		dam.respondTokens(table, userId, w)
+		return
+	}
+	if userId != "" && dam.respondUser(table, userId, w) {
+		return
+	}
+	dam.Responder.Error(record.AUTHENTICATION_REQUIRED, "", w, "")
+}
+
+// processTokenUser returns the user of the verified access token of the request, the user not being in the session
+func (dam *DbAuthMiddleware) processTokenUser(w http.ResponseWriter, r *http.Request) {
+	table := dam.reflection.GetTable(dam.getStringProperty("usersTable", "users"))
+	if token := dam.verifier.getAuthorizationToken(r); token != "" {
+		if claims := dam.verifier.getClaims(r.Context(), token); claims != nil {
+			if userId := dam.getTokenUserId(table, claims); userId != "" && dam.respondUser(table, userId, w) {
+				return
+			}
+		}
+	}
+	dam.Responder.Error(record.AUTHENTICATION_REQUIRED, "", w, "")
+}
+
+// getTokenUserId returns the primary key of the user from the claim the tokenClaims map it to, empty if none
+func (dam *DbAuthMiddleware) getTokenUserId(table *database.ReflectedTable, claims map[string]interface{}) string {
+	for claim, columnName := range dam.getMapProperty("tokenClaims", "sub:"+table.GetPk().GetName()) {
+		if columnName != table.GetPk().GetName() {
+			continue
+		}
+		switch v := claims[claim].(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case string:
+			return v
+		}
+	}
+	return ""
+}
+
+// respondUser returns the returned columns of the user, false if the user does not exist
+func (dam *DbAuthMiddleware) respondUser(table *database.ReflectedTable, userId string, w http.ResponseWriter) bool {
+	columnNames := dam.getReturnedColumnNames(table)
+	for _, user := range dam.db.SelectSingle(nil, table, columnNames, userId) {
+		dam.cleanUser(table, user, columnNames)
+		dam.Responder.Success(user, w)
+		return true
+	}
+	return false
+}
+
+func (dam *DbAuthMiddleware) getReturnedColumnNames(table *database.ReflectedTable) []string {
+	returnedColumns := dam.getStringProperty("returnedColumns", "")
+	if returnedColumns == "" {
+		return table.GetColumnNames()
+	}
+	columnNames := strings.Split(returnedColumns, ",")
+	for i, elem := range columnNames {
+		columnNames[i] = strings.TrimSpace(elem)
+	}
+	return columnNames
+}
+
+// hashToken returns the value stored for a refresh or reset token, the token itself is not stored
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
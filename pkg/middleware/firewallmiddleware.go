@@ -2,7 +2,7 @@ package middleware
 
 import (
 	"fmt"
-	"log"
+	"github.com/dranih/go-crud-api/pkg/utils"
 	"net"
 	"net/http"
 	"strings"
@@ -46,29 +46,39 @@ func (fwm *FirewallMiddleware) isIpAllowed(ipAddress, allowedIpAddresses string)
 }
 
 func (fwm *FirewallMiddleware) getIpAddress(r *http.Request) string {
-	var ipAddress string
-	reverseProxy := fmt.Sprint(fwm.getProperty("reverseProxy", ""))
-	if reverseProxy != "" {
-		ipAddress = r.Header.Get("X-Forwarded-For")
-	} else {
-		if r.RemoteAddr != "" {
-			ipAddress = r.RemoteAddr
-		} else {
-			ipAddress = "127.0.0.1"
-		}
-	}
+	return getClientIpAddress(r, fmt.Sprint(fwm.getProperty("reverseProxy", "")) != "")
+}
+
+// getClientIpAddress returns the address of the client without its port
+func getClientIpAddress(r *http.Request, reverseProxy bool) string {
+	ipAddress := getRemoteAddress(r, reverseProxy)
 	if ip, _, err := net.SplitHostPort(ipAddress); err == nil {
 		return ip
 	}
 	return ipAddress
 }
 
+// getRemoteAddress returns the address of the client, behind a reverse proxy the last X-Forwarded-For address :
+// the one added by the proxy, the previous ones being sent by the client
+func getRemoteAddress(r *http.Request, reverseProxy bool) string {
+	if reverseProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ipAddress := strings.TrimSpace(forwarded[len(forwarded)-1]); ipAddress != "" {
+			return ipAddress
+		}
+	}
+	if r.RemoteAddr != "" {
+		return r.RemoteAddr
+	}
+	return "127.0.0.1"
+}
+
 func (fwm *FirewallMiddleware) Process(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ipAddress := fwm.getIpAddress(r)
 		allowedIpAddresses := fwm.getStringProperty("allowedIpAddresses", "")
 		if !fwm.isIpAllowed(ipAddress, allowedIpAddresses) {
-			log.Printf("Warning - Firewall middleware blocked ip address %s", ipAddress)
+			utils.Log.Warning("firewall middleware blocked ip address", "requestId", utils.RequestIdFromContext(r.Context()), "ipAddress", ipAddress)
 			fwm.Responder.Error(record.TEMPORARY_OR_PERMANENTLY_BLOCKED, "", w, "")
 			return
 		}
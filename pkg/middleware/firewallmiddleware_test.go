@@ -57,4 +57,32 @@ func TestFirewallMiddleware(t *testing.T) {
 
 	utils.RunTests(t, ts.URL, tt)
 	utils.RunTests(t, ts2.URL, tt2)
+
+	// behind a reverse proxy, only the last X-Forwarded-For address is trusted
+	router3 := mux.NewRouter()
+	router3.HandleFunc("/", utils.AllowedTest).Methods("GET")
+	router3.Use(NewFirewallMiddleware(responder, map[string]interface{}{
+		"allowedIpAddresses": "198.51.100.0/24",
+		"reverseProxy":       "true",
+	}).Process)
+	ts3 := httptest.NewServer(router3)
+	defer ts3.Close()
+	utils.RunTests(t, ts3.URL, []utils.Test{
+		{
+			Name:          "forwarded_spoofed",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: map[string]string{"X-Forwarded-For": "198.51.100.7, 192.0.2.1"},
+			Want:          `{"code":1016,"message":"Temporary or permanently blocked"}`,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "forwarded_last_hop",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: map[string]string{"X-Forwarded-For": "192.0.2.1, 198.51.100.7"},
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+	})
 }
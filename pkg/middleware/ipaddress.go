@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 
@@ -63,7 +62,7 @@ func (iam *IpAddressMiddleware) callHandler(r *http.Request, operation, tableNam
 			body, err = json.Marshal(records[0])
 		}
 		if err != nil {
-			log.Printf("Error : could not marshal modified body to string : %s", err.Error())
+			utils.Log.Error("could not marshal modified body to string", "error", err)
 			return r
 		}
 		r.Body = ioutil.NopCloser(bytes.NewReader(body))
@@ -74,18 +73,7 @@ func (iam *IpAddressMiddleware) callHandler(r *http.Request, operation, tableNam
 }
 
 func (iam *IpAddressMiddleware) getIpAddress(r *http.Request) string {
-	var ipAddress string
-	reverseProxy := fmt.Sprint(iam.getProperty("reverseProxy", ""))
-	if reverseProxy != "" {
-		ipAddress = r.Header.Get("X-Forwarded-For")
-	} else {
-		if r.RemoteAddr != "" {
-			ipAddress = r.RemoteAddr
-		} else {
-			ipAddress = "127.0.0.1"
-		}
-	}
-	return ipAddress
+	return getRemoteAddress(r, fmt.Sprint(iam.getProperty("reverseProxy", "")) != "")
 }
 
 func (iam *IpAddressMiddleware) Process(next http.Handler) http.Handler {
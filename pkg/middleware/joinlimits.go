@@ -46,7 +46,9 @@ func (jm *JoinLimitsMiddleware) Process(next http.Handler) http.Handler {
 			}
 			params["join"] = joinPaths
 			r.URL.RawQuery = params.Encode()
-			utils.VStore.Set("joinLimits.maxRecords", maxRecords)
+			var variables *utils.VariableStore
+			r, variables = utils.RequestVariables(r)
+			variables.Set("joinLimits.maxRecords", maxRecords)
 		}
 		next.ServeHTTP(w, r)
 	})
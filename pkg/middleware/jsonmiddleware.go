@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
 
 	"github.com/dranih/go-crud-api/pkg/controller"
@@ -30,13 +29,13 @@ func NewJsonResponseWriter(w http.ResponseWriter, columnNames map[string]bool) *
 
 func (jrw *jsonResponseWriter) Write(b []byte) (int, error) {
 	var body interface{}
-	if err := json.Unmarshal(b, &body); err != nil {
-		log.Printf("Error : %s", err.Error())
+	if err := utils.DecodeJson(b, &body); err != nil {
+		utils.Log.Error(err.Error())
 		return jrw.ResponseWriter.Write(b)
 	}
 	conv := jrw.convert(body)
 	if res, err := json.Marshal(conv); err != nil {
-		log.Printf("Error : %s", err.Error())
+		utils.Log.Error(err.Error())
 		return jrw.ResponseWriter.Write(b)
 	} else {
 		return jrw.ResponseWriter.Write(res)
@@ -45,7 +44,7 @@ func (jrw *jsonResponseWriter) Write(b []byte) (int, error) {
 
 func (jrw *jsonResponseWriter) convertValue(v string) interface{} {
 	var res map[string]interface{}
-	if err := json.Unmarshal([]byte(v), &res); err != nil {
+	if err := utils.DecodeJson([]byte(v), &res); err != nil {
 		return v
 	}
 	return res
@@ -121,7 +120,7 @@ func (jm *JsonMiddleware) convertJsonRequest(r *http.Request, columnNames map[st
 	var body []byte
 	body, err = json.Marshal(res)
 	if err != nil {
-		log.Printf("Error : could not marshal modified body to string : %s", err.Error())
+		utils.Log.Error("could not marshal modified body to string", "error", err)
 		return r
 	}
 	r.Body = ioutil.NopCloser(bytes.NewReader(body))
@@ -133,7 +132,7 @@ func (jm *JsonMiddleware) convertJsonRequest(r *http.Request, columnNames map[st
 func (jm *JsonMiddleware) convertJsonRequestValue(value interface{}) interface{} {
 	if _, ok := value.(map[string]interface{}); ok {
 		if res, err := json.Marshal(value); err != nil {
-			log.Printf("Error : %s", err.Error())
+			utils.Log.Error(err.Error())
 			return value
 		} else {
 			return string(res)
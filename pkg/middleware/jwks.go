@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// Minimum delay between two refreshes triggered by an unknown key id
+const jwksForcedRefreshInterval = 10 * time.Second
+
+// jsonWebKey is a key of a JWKS document (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// jwksKey is a parsed key : a *rsa.PublicKey, an *ecdsa.PublicKey, an ed25519.PublicKey or a []byte secret
+type jwksKey struct {
+	alg string
+	key interface{}
+}
+
+// jwksKeySet holds the keys of a JWKS document loaded from an url or a file, by key id
+type jwksKeySet struct {
+	url           string
+	file          string
+	client        *http.Client
+	mutex         sync.RWMutex
+	keys          map[string]jwksKey
+	forcedRefresh time.Time
+	stop          chan struct{}
+}
+
+// newJwksKeySet loads the key set and refreshes it in background every refresh interval if not zero
+func newJwksKeySet(url, file string, refresh time.Duration) *jwksKeySet {
+	ks := &jwksKeySet{url: url, file: file, client: &http.Client{Timeout: 10 * time.Second}, keys: map[string]jwksKey{}, stop: make(chan struct{})}
+	if err := ks.refresh(context.Background()); err != nil {
+		utils.Log.Error("unable to load JWKS", "error", err)
+	}
+	if refresh > 0 {
+		go func() {
+			ticker := time.NewTicker(refresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := ks.refresh(context.Background()); err != nil {
+						utils.Log.Error("unable to refresh JWKS", "error", err)
+					}
+				case <-ks.stop:
+					return
+				}
+			}
+		}()
+	}
+	return ks
+}
+
+// close stops the background refresh of the key set
+func (ks *jwksKeySet) close() {
+	close(ks.stop)
+}
+
+// load reads the JWKS document, the trace of the context being propagated to the JWKS url
+func (ks *jwksKeySet) load(ctx context.Context) ([]byte, error) {
+	if ks.file != "" {
+		return ioutil.ReadFile(ks.file)
+	}
+	ctx, span := utils.StartSpan(ctx, "GET JWKS", utils.SpanKindClient, "http.url", ks.url)
+	defer span.Finish()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	utils.InjectTraceContext(ctx, req.Header)
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("JWKS url %s returned status %d", ks.url, resp.StatusCode)
+		span.SetError(err)
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// refresh replaces the keys by the ones currently published, the old keys are kept if loading fails
+func (ks *jwksKeySet) refresh(ctx context.Context) error {
+	data, err := ks.load(ctx)
+	if err != nil {
+		return err
+	}
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	keys := map[string]jwksKey{}
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			utils.Log.Warning("ignoring JWKS key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = jwksKey{jwk.Alg, key}
+	}
+	ks.mutex.Lock()
+	ks.keys = keys
+	ks.mutex.Unlock()
+	return nil
+}
+
+// getKeys returns the keys matching the key id (all the keys if kid is empty),
+// an unknown key id forces a refresh in case the signing key has been rotated
+func (ks *jwksKeySet) getKeys(ctx context.Context, kid string) []jwksKey {
+	keys := ks.findKeys(kid)
+	if len(keys) == 0 && kid != "" {
+		ks.mutex.Lock()
+		expired := time.Since(ks.forcedRefresh) > jwksForcedRefreshInterval
+		if expired {
+			ks.forcedRefresh = time.Now()
+		}
+		ks.mutex.Unlock()
+		if expired {
+			if err := ks.refresh(ctx); err != nil {
+				utils.Log.Error("unable to refresh JWKS", "error", err)
+			}
+			keys = ks.findKeys(kid)
+		}
+	}
+	return keys
+}
+
+func (ks *jwksKeySet) findKeys(kid string) []jwksKey {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	if kid != "" {
+		if key, exists := ks.keys[kid]; exists {
+			return []jwksKey{key}
+		}
+		return nil
+	}
+	keys := []jwksKey{}
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeSegment(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeSegment(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := decodeSegment(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeSegment(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid point on curve '%s'", jwk.Crv)
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := decodeSegment(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key size %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return decodeSegment(jwk.K)
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", jwk.Kty)
+}
+
+// decodeSegment decodes a base64url value, with or without padding
+func decodeSegment(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// jwksTestServer publishes a JWKS document that can be changed to simulate a key rotation
+type jwksTestServer struct {
+	mutex sync.Mutex
+	keys  []map[string]string
+}
+
+func (jts *jwksTestServer) setKeys(keys ...map[string]string) {
+	jts.mutex.Lock()
+	defer jts.mutex.Unlock()
+	jts.keys = keys
+}
+
+func (jts *jwksTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	jts.mutex.Lock()
+	defer jts.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": jts.keys})
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ecJwk(kid string, key *ecdsa.PrivateKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"use": "sig",
+		"crv": key.Curve.Params().Name,
+		"x":   encodeSegment(key.X.FillBytes(make([]byte, size))),
+		"y":   encodeSegment(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func signTestToken(t *testing.T, algorithm, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": algorithm, "kid": kid})
+	payload, _ := json.Marshal(claims)
+	data := encodeSegment(header) + "." + encodeSegment(payload)
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		hash := crypto.SHA256
+		if algorithm == "ES384" {
+			hash = crypto.SHA384
+		}
+		hasher := hash.New()
+		hasher.Write([]byte(data))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, hasher.Sum(nil))
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(data))
+	case *rsa.PrivateKey:
+		hasher := crypto.SHA256.New()
+		hasher.Write([]byte(data))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hasher.Sum(nil))
+	}
+	if err != nil {
+		t.Fatalf("Unable to sign token : %s", err.Error())
+	}
+	return data + "." + encodeSegment(signature)
+}
+
+func TestJwtAuthJwks(t *testing.T) {
+	ec256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rotatedKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublicKey, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks := &jwksTestServer{}
+	jwks.setKeys(
+		ecJwk("ec256", ec256Key),
+		ecJwk("ec384", ec384Key),
+		map[string]string{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": encodeSegment(edPublicKey)},
+		map[string]string{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": encodeSegment(rsaKey.N.Bytes()), "e": encodeSegment(big.NewInt(int64(rsaKey.E)).Bytes())},
+	)
+	jwksServer := httptest.NewServer(jwks)
+	defer jwksServer.Close()
+
+	properties := map[string]interface{}{
+		"mode":    "required",
+		"realm":   "GoCrudApi : JWT required",
+		"time":    "1538207605",
+		"leeway":  "5",
+		"jwksUrl": jwksServer.URL,
+	}
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	jaMiddle := NewJwtAuth(responder, properties)
+	router.HandleFunc("/", utils.AllowedTest).Methods("GET")
+	router.Use(jaMiddle.Process)
+	gob.Register(map[string]interface{}{})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	valid := map[string]interface{}{"sub": "1234567890", "iat": 1538207600, "exp": 1538207635}
+	bearer := func(token string) map[string]string {
+		return map[string]string{"X-Authorization": "Bearer " + token}
+	}
+	failed := `{"code":1012,"message":"Authentication failed for 'JWT'"}`
+
+	tt := []utils.Test{
+		{
+			Name:          "jwks ES256",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "ec256", ec256Key, valid)),
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "jwks ES384",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES384", "ec384", ec384Key, valid)),
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "jwks EdDSA",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "EdDSA", "ed", edKey, valid)),
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "jwks RS256",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "RS256", "rsa", rsaKey, valid)),
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "jwks algorithm not matching the key",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES384", "ec256", ec256Key, valid)),
+			Want:          failed,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "jwks signed by another key",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "ec256", rotatedKey, valid)),
+			Want:          failed,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "jwks unknown kid",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "unknown", ec256Key, valid)),
+			Want:          failed,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "expired token",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "ec256", ec256Key, map[string]interface{}{"sub": "1", "exp": 1538207599})),
+			Want:          failed,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "expired token within leeway",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "ec256", ec256Key, map[string]interface{}{"sub": "1", "exp": 1538207601})),
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "token not yet valid",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "ec256", ec256Key, map[string]interface{}{"sub": "1", "nbf": 1538207611})),
+			Want:          failed,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "token not yet valid within leeway",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "ec256", ec256Key, map[string]interface{}{"sub": "1", "nbf": 1538207609, "exp": 1538207635})),
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "token issued in the future",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "ec256", ec256Key, map[string]interface{}{"sub": "1", "iat": 1538207611, "exp": 1538207635})),
+			Want:          failed,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:          "token older than ttl without exp",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "ec256", ec256Key, map[string]interface{}{"sub": "1", "iat": 1538207560})),
+			Want:          failed,
+			StatusCode:    http.StatusForbidden,
+		},
+	}
+	utils.RunTests(t, ts.URL, tt)
+
+	// The identity provider rotates its signing key : the new kid triggers a refresh of the key set
+	// (the forced refresh delay is reset as the unknown kid test above already used it)
+	jwks.setKeys(ecJwk("rotated", rotatedKey))
+	jaMiddle.jwks.forcedRefresh = time.Time{}
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:          "jwks rotated key",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "rotated", rotatedKey, valid)),
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "jwks removed key",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: bearer(signTestToken(t, "ES256", "ec256", ec256Key, valid)),
+			Want:          failed,
+			StatusCode:    http.StatusForbidden,
+		},
+	})
+}
+
+func TestJwtAuthJwksFile(t *testing.T) {
+	edPublicKey, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	document := fmt.Sprintf(`{"keys":[{"kty":"OKP","kid":"file","crv":"Ed25519","x":"%s"}]}`, encodeSegment(edPublicKey))
+	file, err := ioutil.TempFile(os.TempDir(), "gocrudtests-jwks-")
+	if err != nil {
+		t.Fatalf("Cannot create temporary file %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(document); err != nil {
+		t.Fatalf("Cannot write temporary file %s", err.Error())
+	}
+	file.Close()
+
+	properties := map[string]interface{}{
+		"mode":        "required",
+		"time":        "1538207605",
+		"jwksFile":    file.Name(),
+		"jwksRefresh": "0",
+	}
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	jaMiddle := NewJwtAuth(responder, properties)
+	router.HandleFunc("/", utils.AllowedTest).Methods("GET")
+	router.Use(jaMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:          "jwks file EdDSA",
+			Method:        http.MethodGet,
+			Uri:           "/",
+			RequestHeader: map[string]string{"X-Authorization": "Bearer " + signTestToken(t, "EdDSA", "file", edKey, map[string]interface{}{"sub": "1", "exp": 1538207635})},
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+	})
+}
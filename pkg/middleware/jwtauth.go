@@ -1,19 +1,21 @@
 package middleware
 
 import (
+	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/subtle"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"hash"
-	"log"
+	"math/big"
 	"net/http"
 	"strconv"
 	"strings"
@@ -26,10 +28,25 @@ import (
 
 type JwtAuthMiddleware struct {
 	GenericMiddleware
+	jwks *jwksKeySet
 }
 
 func NewJwtAuth(responder controller.Responder, properties map[string]interface{}) *JwtAuthMiddleware {
-	return &JwtAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}}
+	ja := &JwtAuthMiddleware{GenericMiddleware: GenericMiddleware{Responder: newAuthMetricsResponder(responder, "jwtAuth"), Properties: properties}}
+	jwksUrl := ja.getStringProperty("jwksUrl", "")
+	jwksFile := ja.getStringProperty("jwksFile", "")
+	if jwksUrl != "" || jwksFile != "" {
+		refresh := time.Duration(ja.getIntProperty("jwksRefresh", 3600)) * time.Second
+		ja.jwks = newJwksKeySet(jwksUrl, jwksFile, refresh)
+	}
+	return ja
+}
+
+// Close stops the refresh of the JWKS keys, once the middleware is no longer used
+func (ja *JwtAuthMiddleware) Close() {
+	if ja.jwks != nil {
+		ja.jwks.close()
+	}
 }
 
 func (ja *JwtAuthMiddleware) getAuthorizationToken(r *http.Request) string {
@@ -45,7 +62,7 @@ func (ja *JwtAuthMiddleware) getAuthorizationToken(r *http.Request) string {
 	return parts[1]
 }
 
-func (ja *JwtAuthMiddleware) getClaims(token string) map[string]interface{} {
+func (ja *JwtAuthMiddleware) getClaims(ctx context.Context, token string) map[string]interface{} {
 	time := ja.getInt64Property("time", time.Now().Unix())
 	leeway := ja.getIntProperty("leeway", 5)
 	ttl := ja.getIntProperty("ttl", 30)
@@ -54,21 +71,29 @@ func (ja *JwtAuthMiddleware) getClaims(token string) map[string]interface{} {
 	if len(secretsMap) < 1 {
 		secrets = map[string]string{"0": ja.getStringProperty("secret", "")}
 	} else {
+		// secrets are given as "kid:secret" or only "secret", numbered from 0
 		i := 0
-		for key := range secretsMap {
-			secrets[fmt.Sprint(i)] = key
-			i++
+		for key, secret := range secretsMap {
+			if secret != "" {
+				secrets[key] = secret
+			} else {
+				secrets[fmt.Sprint(i)] = key
+				i++
+			}
 		}
 	}
 	requirements := map[string]map[string]bool{
 		"alg": ja.getArrayProperty("algorithms", ""),
-		"aud": ja.getArrayProperty("audiences", ""),
-		"iss": ja.getArrayProperty("issuers", ""),
 	}
-	return ja.getVerifiedClaims(token, time, leeway, ttl, secrets, requirements)
+	// the audiences and the issuers were not checked by the previous versions, they are on demand
+	if ja.getStringProperty("verifyClaims", "false") == "true" {
+		requirements["aud"] = ja.getArrayProperty("audiences", "")
+		requirements["iss"] = ja.getArrayProperty("issuers", "")
+	}
+	return ja.getVerifiedClaims(ctx, token, time, leeway, ttl, secrets, requirements)
 }
 
-func (ja *JwtAuthMiddleware) getVerifiedClaims(token string, time int64, leeway, ttl int, secrets map[string]string, requirements map[string]map[string]bool) map[string]interface{} {
+func (ja *JwtAuthMiddleware) getVerifiedClaims(ctx context.Context, token string, time int64, leeway, ttl int, secrets map[string]string, requirements map[string]map[string]bool) map[string]interface{} {
 	algorithms := map[string]string{
 		"HS256": "sha256",
 		"HS384": "sha384",
@@ -76,15 +101,18 @@ func (ja *JwtAuthMiddleware) getVerifiedClaims(token string, time int64, leeway,
 		"RS256": "sha256",
 		"RS384": "sha384",
 		"RS512": "sha512",
+		"ES256": "sha256",
+		"ES384": "sha384",
+		"ES512": "sha512",
+		"EdDSA": "",
 	}
 
 	tokenSlice := strings.Split(token, ".")
-	if len(token) < 3 {
+	if len(tokenSlice) != 3 {
 		return nil
 	}
 
-	headerb64 := strings.ReplaceAll(strings.ReplaceAll(tokenSlice[0], `_`, `/`), `-`, `+`)
-	headerjson, err := base64.StdEncoding.DecodeString(headerb64)
+	headerjson, err := decodeSegment(tokenSlice[0])
 	if err != nil {
 		return nil
 	}
@@ -93,14 +121,10 @@ func (ja *JwtAuthMiddleware) getVerifiedClaims(token string, time int64, leeway,
 	if err != nil {
 		return nil
 	}
-	kid := "0"
+	kid := ""
 	if v, exists := header["kid"]; exists {
 		kid = fmt.Sprint(v)
 	}
-	secret, exists := secrets[kid]
-	if !exists {
-		return nil
-	}
 	if v, exists := header["typ"]; !exists || fmt.Sprint(v) != "JWT" {
 		return nil
 	}
@@ -110,33 +134,32 @@ func (ja *JwtAuthMiddleware) getVerifiedClaims(token string, time int64, leeway,
 		return nil
 	}
 	algorithm := fmt.Sprint(algorithmI)
+	hmac, exists := algorithms[algorithm]
+	if !exists {
+		return nil
+	}
 	if requirements["alg"] != nil && len(requirements["alg"]) > 0 && !requirements["alg"][algorithm] {
 		return nil
 	}
 
-	hmac := algorithms[algorithm]
-
-	signatureb64 := strings.ReplaceAll(strings.ReplaceAll(tokenSlice[2], `_`, `/`), `-`, `+`)
-	signature, err := base64.RawStdEncoding.DecodeString(signatureb64)
+	signature, err := decodeSegment(tokenSlice[2])
 	if err != nil {
 		return nil
 	}
 
 	data := fmt.Sprintf("%s.%s", tokenSlice[0], tokenSlice[1])
 
-	switch algorithm[0:1] {
-	case "H":
-		hash := ja.genHMAC([]byte(data), []byte(secret), hmac)
-		if subtle.ConstantTimeCompare(hash, signature) != 1 {
-			return nil
-		}
-	case "R":
-		if ja.rsaVerify([]byte(data), signature, []byte(secret), hmac) != 1 {
-			return nil
+	verified := false
+	for _, key := range ja.getKeys(ctx, algorithm, kid, secrets) {
+		if ja.verify([]byte(data), signature, key, algorithm, hmac) {
+			verified = true
+			break
 		}
 	}
-	claimsb64 := strings.ReplaceAll(strings.ReplaceAll(tokenSlice[1], `_`, `/`), `-`, `+`)
-	claimsjson, err := base64.StdEncoding.DecodeString(claimsb64)
+	if !verified {
+		return nil
+	}
+	claimsjson, err := decodeSegment(tokenSlice[1])
 	if err != nil || claimsjson == nil {
 		return nil
 	}
@@ -148,16 +171,16 @@ func (ja *JwtAuthMiddleware) getVerifiedClaims(token string, time int64, leeway,
 
 	for field, values := range requirements {
 		if len(values) > 0 {
-			if field == "alg" {
+			if field != "alg" {
 				cfield, exists := claims[field]
 				if !exists {
 					return nil
 				}
 				switch t := cfield.(type) {
-				case []string:
+				case []interface{}:
 					found := false
 					for _, cf := range t {
-						if values[cf] {
+						if values[fmt.Sprint(cf)] {
 							found = true
 						}
 					}
@@ -172,41 +195,90 @@ func (ja *JwtAuthMiddleware) getVerifiedClaims(token string, time int64, leeway,
 			}
 		}
 	}
-	nbf, existsNbf := claims["nbf"]
-	if existsNbf {
-		if a, err := strconv.ParseInt(fmt.Sprint(nbf), 10, 64); err == nil {
-			if time+int64(leeway) < a {
-				return nil
-			}
-		}
+	// the leeway allows for clock skew between the token issuer and the api
+	nbf, existsNbf := getNumericClaim(claims, "nbf")
+	if existsNbf && time+int64(leeway) < nbf {
+		return nil
 	}
-	iat, existsIat := claims["iat"]
-	if existsIat {
-		if a, err := strconv.ParseInt(fmt.Sprint(iat), 10, 64); err == nil {
-			if time+int64(leeway) < a {
-				return nil
-			}
+	iat, existsIat := getNumericClaim(claims, "iat")
+	if existsIat && time+int64(leeway) < iat {
+		return nil
+	}
+	exp, existsExp := getNumericClaim(claims, "exp")
+	if existsExp && time-int64(leeway) > exp {
+		return nil
+	}
+	if existsIat && !existsExp && time-int64(leeway) > iat+int64(ttl) {
+		return nil
+	}
+	return claims
+}
+
+// getNumericClaim returns a date claim, given as a json number or as a string
+func getNumericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	switch v := claims[name].(type) {
+	case float64:
+		return int64(v), true
+	case string:
+		if a, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return a, true
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return int64(f), true
 		}
 	}
-	exp, existsExp := claims["exp"]
-	if existsExp {
-		if a, err := strconv.ParseInt(fmt.Sprint(exp), 10, 64); err == nil {
-			if time+int64(leeway) < a {
-				return nil
+	return 0, false
+}
+
+// getKeys returns the keys the token may be signed with : the JWKS keys with the token key id
+// and the configured secret for this key id ("0" without key id)
+func (ja *JwtAuthMiddleware) getKeys(ctx context.Context, algorithm, kid string, secrets map[string]string) []interface{} {
+	keys := []interface{}{}
+	if ja.jwks != nil {
+		for _, key := range ja.jwks.getKeys(ctx, kid) {
+			if key.alg == "" || key.alg == algorithm {
+				keys = append(keys, key.key)
 			}
 		}
 	}
-	if existsIat && !existsExp {
-		if a, err := strconv.ParseInt(fmt.Sprint(iat), 10, 64); err == nil {
-			if time-int64(leeway) > a+int64(ttl) {
-				return nil
-			}
+	secretKid := kid
+	if secretKid == "" {
+		secretKid = "0"
+	}
+	if secret, exists := secrets[secretKid]; exists && secret != "" {
+		if algorithm[0:1] == "H" {
+			keys = append(keys, []byte(secret))
+		} else if key, err := parsePublicKey(secret); err == nil {
+			keys = append(keys, key)
+		} else {
+			utils.Log.Error("unable to parse JWT public key", "error", err)
 		}
 	}
-	return claims
+	return keys
+}
+
+// parsePublicKey reads a PEM encoded certificate, public key or private key
+func parsePublicKey(secret string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(secret))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	// the private key signing the tokens of dbAuth can be given as is
+	key, err := parsePrivateKey(secret)
+	if err != nil {
+		return nil, fmt.Errorf("no certificate, public or private key found")
+	}
+	return key.Public(), nil
 }
 
-func (ja *JwtAuthMiddleware) rsaVerify(data, signature, secret []byte, hmacS string) int {
+// verify checks the signature of data, the type of the key has to match the algorithm
+func (ja *JwtAuthMiddleware) verify(data, signature []byte, key interface{}, algorithm, hmacS string) bool {
 	var hashl crypto.Hash
 	switch hmacS {
 	case "sha256":
@@ -215,18 +287,44 @@ func (ja *JwtAuthMiddleware) rsaVerify(data, signature, secret []byte, hmacS str
 		hashl = crypto.SHA384
 	case "sha512":
 		hashl = crypto.SHA512
-	default:
-		return -1
 	}
-	block, _ := pem.Decode([]byte(secret))
-	var cert *x509.Certificate
-	cert, _ = x509.ParseCertificate(block.Bytes)
-	rsaPublicKey := cert.PublicKey.(*rsa.PublicKey)
-	err := rsa.VerifyPKCS1v15(rsaPublicKey, hashl, data, signature)
-	if err != nil {
-		return -1
+	var digest []byte
+	if hashl != 0 {
+		hasher := hashl.New()
+		hasher.Write(data)
+		digest = hasher.Sum(nil)
+	}
+	switch k := key.(type) {
+	case []byte:
+		if algorithm[0:1] != "H" {
+			return false
+		}
+		return subtle.ConstantTimeCompare(ja.genHMAC(data, k, hmacS), signature) == 1
+	case *rsa.PublicKey:
+		if algorithm[0:1] != "R" {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(k, hashl, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		curves := map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+		if curves[algorithm] != k.Curve.Params().Name {
+			return false
+		}
+		// the signature is the concatenation of r and s, each of the size of the curve
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	case ed25519.PublicKey:
+		if algorithm != "EdDSA" {
+			return false
+		}
+		return ed25519.Verify(k, data, signature)
 	}
-	return 1
+	return false
 }
 
 func (ja *JwtAuthMiddleware) genHMAC(ciphertext, key []byte, hmacS string) []byte {
@@ -251,11 +349,11 @@ func (ja *JwtAuthMiddleware) Process(next http.Handler) http.Handler {
 		token := ja.getAuthorizationToken(r)
 		session := utils.GetSession(w, r)
 		if token != "" {
-			claims := ja.getClaims(token)
+			claims := ja.getClaims(r.Context(), token)
 			if claims == nil || len(claims) < 1 {
 				delete(session.Values, "claims")
 				if err := session.Save(r, w); err != nil {
-					log.Printf("ERROR : unable to save session : %s", err.Error())
+					utils.Log.Error("unable to save session", "error", err)
 				}
 				ja.Responder.Error(record.AUTHENTICATION_FAILED, "JWT", w, "")
 				return
@@ -1,7 +1,11 @@
 package middleware
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/json"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
@@ -81,3 +85,42 @@ func TestJwtAuth(t *testing.T) {
 	}
 	utils.RunTests(t, ts.URL, tt)
 }
+
+func TestJwtAuthVerifyClaims(t *testing.T) {
+	secret := "axpIrCGNGqxzx2R9dtXLIPUSqPo778uhb8CA0F4Hx"
+	sign := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "HS256"})
+		payload, _ := json.Marshal(claims)
+		data := encodeSegment(header) + "." + encodeSegment(payload)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(data))
+		return data + "." + encodeSegment(mac.Sum(nil))
+	}
+	other := sign(map[string]interface{}{"sub": "1", "aud": "other", "iss": "other", "iat": 1538207605, "exp": 1538207635})
+	expected := sign(map[string]interface{}{"sub": "1", "aud": []string{"other", "api"}, "iss": "idp", "iat": 1538207605, "exp": 1538207635})
+	tt := []struct {
+		name         string
+		verifyClaims string
+		token        string
+		verified     bool
+	}{
+		{"claims not checked by default", "", other, true},
+		{"unexpected audience and issuer", "true", other, false},
+		{"expected audience and issuer", "true", expected, true},
+	}
+	for _, tc := range tt {
+		properties := map[string]interface{}{
+			"time":      "1538207605",
+			"secret":    secret,
+			"audiences": "api",
+			"issuers":   "idp",
+		}
+		if tc.verifyClaims != "" {
+			properties["verifyClaims"] = tc.verifyClaims
+		}
+		ja := NewJwtAuth(controller.NewJsonResponder(false), properties)
+		if verified := ja.getClaims(context.Background(), tc.token) != nil; verified != tc.verified {
+			t.Errorf("%s : got verified %v, want %v", tc.name, verified, tc.verified)
+		}
+	}
+}
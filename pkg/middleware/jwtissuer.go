@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+)
+
+// jwtIssuer signs the tokens verified by the jwtAuth middleware
+type jwtIssuer struct {
+	algorithm string
+	kid       string
+	key       interface{}
+}
+
+// newJwtIssuer returns an issuer signing with a secret (HS algorithms) or a PEM encoded private key,
+// the same secret or key can be given to jwtAuth to verify the tokens
+func newJwtIssuer(algorithm, kid, secret string) (*jwtIssuer, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("no secret configured")
+	}
+	switch algorithm {
+	case "HS256", "HS384", "HS512":
+		return &jwtIssuer{algorithm, kid, []byte(secret)}, nil
+	case "RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA":
+		key, err := parsePrivateKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		return &jwtIssuer{algorithm, kid, key}, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm '%s'", algorithm)
+}
+
+// parsePrivateKey reads a PEM encoded PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) private key
+func parsePrivateKey(secret string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(secret))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func (ji *jwtIssuer) sign(claims map[string]interface{}) (string, error) {
+	header := map[string]string{"typ": "JWT", "alg": ji.algorithm}
+	if ji.kid != "" {
+		header["kid"] = ji.kid
+	}
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	data := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson)
+	var hashl crypto.Hash
+	switch ji.algorithm[2:] {
+	case "256":
+		hashl = crypto.SHA256
+	case "384":
+		hashl = crypto.SHA384
+	case "512":
+		hashl = crypto.SHA512
+	}
+	var signature []byte
+	switch k := ji.key.(type) {
+	case []byte:
+		mac := hmac.New(hashl.New, k)
+		mac.Write([]byte(data))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if ji.algorithm[0:1] != "R" {
+			return "", fmt.Errorf("algorithm '%s' does not match a RSA key", ji.algorithm)
+		}
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, hashl, digest(hashl, data))
+	case *ecdsa.PrivateKey:
+		curves := map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+		if curves[ji.algorithm] != k.Curve.Params().Name {
+			return "", fmt.Errorf("algorithm '%s' does not match the curve %s", ji.algorithm, k.Curve.Params().Name)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest(hashl, data))
+		if err != nil {
+			return "", err
+		}
+		// the signature is the concatenation of r and s, each of the size of the curve
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	case ed25519.PrivateKey:
+		if ji.algorithm != "EdDSA" {
+			return "", fmt.Errorf("algorithm '%s' does not match an Ed25519 key", ji.algorithm)
+		}
+		signature = ed25519.Sign(k, []byte(data))
+	default:
+		return "", fmt.Errorf("unsupported key type %T", ji.key)
+	}
+	if err != nil {
+		return "", err
+	}
+	return data + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func digest(hashl crypto.Hash, data string) []byte {
+	hasher := hashl.New()
+	hasher.Write([]byte(data))
+	return hasher.Sum(nil)
+}
@@ -0,0 +1,258 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// maskingAllRoles holds the masks of all the roles, a role overriding them with its own masks or "none"
+const maskingAllRoles = "*"
+
+// maskingPolicy is the content of the policy file : the mask of the columns by role and table name or glob
+type maskingPolicy struct {
+	Roles map[string]struct {
+		Tables map[string]map[string]string `yaml:"tables"`
+	} `yaml:"roles"`
+}
+
+type MaskingMiddleware struct {
+	GenericMiddleware
+	reflection *database.ReflectionService
+	policy     *maskingPolicy
+}
+
+func NewMaskingMiddleware(responder controller.Responder, properties map[string]interface{}, reflection *database.ReflectionService) *MaskingMiddleware {
+	mm := &MaskingMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}, reflection: reflection}
+	policy, err := loadMaskingPolicy(mm.getStringProperty("policyFile", ""))
+	if err != nil {
+		utils.Log.Error("unable to load masking policy", "error", err)
+		policy = &maskingPolicy{}
+	}
+	if err := policy.checkHashKey(mm.getStringProperty("hashKey", "")); err != nil {
+		utils.Log.Error("invalid masking policy, the hashed values are removed", "error", err)
+	}
+	mm.policy = policy
+	return mm
+}
+
+// CheckMaskingPolicy loads the policy file and checks that a hashKey is given for the hash masks
+func CheckMaskingPolicy(fileName, hashKey string) error {
+	policy, err := loadMaskingPolicy(fileName)
+	if err != nil {
+		return err
+	}
+	return policy.checkHashKey(hashKey)
+}
+
+// checkHashKey refuses the hash masks without hashKey, the hashes of guessable values being reversible
+func (policy *maskingPolicy) checkHashKey(hashKey string) error {
+	if hashKey != "" {
+		return nil
+	}
+	for _, role := range policy.Roles {
+		for _, columns := range role.Tables {
+			for _, method := range columns {
+				if name, _, _ := strings.Cut(method, ":"); name == "hash" {
+					return fmt.Errorf("the hash masks require a hashKey")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func loadMaskingPolicy(fileName string) (*maskingPolicy, error) {
+	if fileName == "" {
+		return nil, fmt.Errorf("no policyFile configured")
+	}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	policy := &maskingPolicy{}
+	if err := yaml.Unmarshal(content, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// getMethod returns the mask of the role on the column, an exact table name overriding the globs,
+// or the mask of all the roles
+func (mm *MaskingMiddleware) getMethod(role, tableName, columnName string) string {
+	for _, r := range []string{role, maskingAllRoles} {
+		tables := mm.policy.Roles[r].Tables
+		if columns, exists := tables[tableName]; exists {
+			if method, exists := columns[columnName]; exists {
+				return method
+			}
+		}
+		patterns := []string{}
+		for pattern := range tables {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, tableName); matched {
+				if method, exists := tables[pattern][columnName]; exists {
+					return method
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// getMasks returns the masks of the table columns, a column being masked only if masked for all the roles
+// (with the mask of the first one). The keys are never masked, the joins depending on them.
+func (mm *MaskingMiddleware) getMasks(roles []string, table *database.ReflectedTable) map[string]func(interface{}) interface{} {
+	masks := map[string]func(interface{}) interface{}{}
+	for _, columnName := range table.GetColumnNames() {
+		column := table.GetColumn(columnName)
+		method := ""
+		for _, role := range roles {
+			roleMethod := mm.getMethod(role, table.GetName(), columnName)
+			if roleMethod == "" || roleMethod == "none" {
+				method = ""
+				break
+			}
+			if method == "" {
+				method = roleMethod
+			}
+		}
+		if method == "" {
+			continue
+		}
+		if column.GetPk() || column.GetFk() != "" {
+			utils.Log.Warning("key column cannot be masked", "table", table.GetName(), "column", columnName)
+			continue
+		}
+		masks[columnName] = mm.getMask(method)
+	}
+	return masks
+}
+
+// getMask returns the function masking a value : null, hash, email, last4, partial:<first>,<last> or fixed:<value>
+func (mm *MaskingMiddleware) getMask(method string) func(interface{}) interface{} {
+	name, argument, _ := strings.Cut(method, ":")
+	switch name {
+	case "null":
+		return func(value interface{}) interface{} { return nil }
+	case "fixed":
+		return func(value interface{}) interface{} {
+			if value == nil {
+				return nil
+			}
+			return argument
+		}
+	case "hash":
+		key := []byte(mm.getStringProperty("hashKey", ""))
+		if len(key) == 0 {
+			return func(value interface{}) interface{} { return nil }
+		}
+		return func(value interface{}) interface{} {
+			if value == nil {
+				return nil
+			}
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(fmt.Sprint(value)))
+			return hex.EncodeToString(mac.Sum(nil))
+		}
+	case "email", "last4", "partial":
+		return func(value interface{}) interface{} {
+			if value == nil {
+				return nil
+			}
+			return maskString(name, argument, fmt.Sprint(value))
+		}
+	}
+	utils.Log.Warning("unknown mask, the values are removed", "mask", method)
+	return func(value interface{}) interface{} { return nil }
+}
+
+func maskString(name, argument, value string) string {
+	switch name {
+	case "email":
+		local, domain, found := strings.Cut(value, "@")
+		if !found || local == "" {
+			return maskString("partial", "1,0", value)
+		}
+		return local[:1] + "***@" + domain
+	case "last4":
+		chars := []rune(strings.NewReplacer(" ", "", "-", "").Replace(value))
+		if len(chars) <= 4 {
+			return "****"
+		}
+		return "**** " + string(chars[len(chars)-4:])
+	}
+	first, last := 1, 1
+	if start, end, found := strings.Cut(argument, ","); found {
+		first, _ = strconv.Atoi(strings.TrimSpace(start))
+		last, _ = strconv.Atoi(strings.TrimSpace(end))
+	}
+	chars := []rune(value)
+	if first < 0 || last < 0 || len(chars) <= first+last {
+		return strings.Repeat("*", len(chars))
+	}
+	return string(chars[:first]) + strings.Repeat("*", len(chars)-first-last) + string(chars[len(chars)-last:])
+}
+
+// getMaskedParameter returns the first masked column used in the filter or order parameters
+func getMaskedParameter(r *http.Request, masks map[string]func(interface{}) interface{}) string {
+	for key, values := range utils.GetRequestParams(r) {
+		if !strings.HasPrefix(key, "filter") && key != "order" {
+			continue
+		}
+		for _, value := range values {
+			columnName := strings.SplitN(value, ",", 2)[0]
+			if _, masked := masks[columnName]; masked {
+				return columnName
+			}
+		}
+	}
+	return ""
+}
+
+func (mm *MaskingMiddleware) Process(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := utils.GetPathSegment(r, 1)
+		if path != "records" && path != "geojson" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		roles := mm.getRoles(utils.GetSession(w, r))
+		// the masks of the user are kept in the request, the requests of other users running concurrently
+		r, variables := utils.RequestVariables(r)
+		// the joined tables are masked too
+		for _, tableName := range utils.GetTableNames(r, mm.reflection.GetTableNames()) {
+			table := mm.reflection.GetTable(tableName)
+			if table == nil {
+				continue
+			}
+			if masks := mm.getMasks(roles, table); len(masks) > 0 {
+				variables.Set("masking.columns."+tableName, masks)
+			}
+		}
+		if masks, ok := variables.Get("masking.columns." + utils.GetPathSegment(r, 2)).(map[string]func(interface{}) interface{}); ok {
+			// the masked values cannot be probed with filters or ordering
+			if columnName := getMaskedParameter(r, masks); columnName != "" {
+				mm.Responder.Error(record.OPERATION_FORBIDDEN, "", w, columnName)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
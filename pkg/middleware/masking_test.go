@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+const maskingTestPolicy = `
+roles:
+  "*":
+    tables:
+      users:
+        password: "null"
+        api_key: last4
+        username: "partial:1,1"
+  admin:
+    tables:
+      users:
+        password: none
+        api_key: none
+        username: none
+  support:
+    tables:
+      "*":
+        message: "fixed:[redacted]"
+      users:
+        username: hash
+`
+
+func TestMaskingMiddleware(t *testing.T) {
+	policyFile, err := ioutil.TempFile(os.TempDir(), "gocrudtests-masking-")
+	if err != nil {
+		t.Fatalf("Cannot create temporary file %s", err.Error())
+	}
+	defer os.Remove(policyFile.Name())
+	if _, err := policyFile.WriteString(maskingTestPolicy); err != nil {
+		t.Fatalf("Cannot write temporary file %s", err.Error())
+	}
+	policyFile.Close()
+
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	gob.Register(map[string]interface{}{})
+	reflection := database.NewReflectionService(db, nil, 0)
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	secret := "maskingTestSecretWithEnoughEntropy"
+	jaMiddle := NewJwtAuth(responder, map[string]interface{}{"mode": "optional", "secret": secret})
+	maskingMiddle := NewMaskingMiddleware(responder, map[string]interface{}{"policyFile": policyFile.Name(), "hashKey": "k"}, reflection)
+	records := record.NewRecordService(db, reflection)
+	controller.NewRecordController(router, responder, records)
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	router.Use(jaMiddle.Process)
+	router.Use(maskingMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	issuer, err := newJwtIssuer("HS256", "", secret)
+	if err != nil {
+		t.Fatalf("Unable to create issuer : %s", err.Error())
+	}
+	bearer := func(roles string) map[string]string {
+		token, err := issuer.sign(map[string]interface{}{"roles": roles})
+		if err != nil {
+			t.Fatalf("Unable to sign token : %s", err.Error())
+		}
+		return map[string]string{"X-Authorization": "Bearer " + token}
+	}
+	mac := hmac.New(sha256.New, []byte("k"))
+	mac.Write([]byte("user1"))
+	hashedUsername := hex.EncodeToString(mac.Sum(nil))
+
+	tt := []utils.Test{
+		{
+			Name:       "masking_default_role",
+			Method:     http.MethodGet,
+			Uri:        "/records/users/1",
+			Want:       `{"api_key":"**** 9abc","id":1,"location":null,"password":null,"username":"u***1"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "masking_joined_records",
+			Method:     http.MethodGet,
+			Uri:        "/records/abc_posts/1?join=users",
+			Want:       `{"abc_category_id":1,"abc_content":"blog started","abc_id":1,"abc_user_id":{"api_key":"**** 9abc","id":1,"location":null,"password":null,"username":"u***1"}}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:          "masking_unmasked_role",
+			Method:        http.MethodGet,
+			Uri:           "/records/users/1",
+			RequestHeader: bearer("admin"),
+			Want:          `{"api_key":"123456789abc","id":1,"location":null,"password":"pass1","username":"user1"}`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "masking_role_mask",
+			Method:        http.MethodGet,
+			Uri:           "/records/users/1?include=id,username",
+			RequestHeader: bearer("support"),
+			Want:          `{"id":1,"username":"` + hashedUsername + `"}`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "masking_table_glob",
+			Method:        http.MethodGet,
+			Uri:           "/records/comments/1",
+			RequestHeader: bearer("support"),
+			Want:          `{"category_id":3,"id":1,"message":"[redacted]","post_id":1}`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "masking_roles_union",
+			Method:        http.MethodGet,
+			Uri:           "/records/users/1?include=id,username",
+			RequestHeader: bearer("admin,support"),
+			Want:          `{"id":1,"username":"user1"}`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:       "masking_filter_forbidden",
+			Method:     http.MethodGet,
+			Uri:        "/records/users?filter=password,sw,p",
+			Want:       `{"code":1014,"details":"password","message":"Operation forbidden"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:       "masking_order_forbidden",
+			Method:     http.MethodGet,
+			Uri:        "/records/users?order=api_key,desc",
+			Want:       `{"code":1014,"details":"api_key","message":"Operation forbidden"}`,
+			StatusCode: http.StatusForbidden,
+		},
+		{
+			Name:          "masking_filter_unmasked",
+			Method:        http.MethodGet,
+			Uri:           "/records/users?filter=password,eq,pass1&include=id",
+			RequestHeader: bearer("admin"),
+			Want:          `{"records":[{"id":1}]}`,
+			StatusCode:    http.StatusOK,
+		},
+	}
+	utils.RunTests(t, ts.URL, tt)
+
+	// the hash masks require a hashKey
+	if err := CheckMaskingPolicy(policyFile.Name(), ""); err == nil || err.Error() != "the hash masks require a hashKey" {
+		t.Errorf("Want the hash masks refused without hashKey, got %v", err)
+	}
+	if err := CheckMaskingPolicy(policyFile.Name(), "k"); err != nil {
+		t.Errorf("Want a valid masking policy, got %s", err.Error())
+	}
+
+	// the masks of concurrent requests apply to their own user only : the requests are held
+	// after the masking middleware until all of them are processed by it
+	wants := []struct {
+		header map[string]string
+		want   string
+	}{
+		{nil, `{"api_key":"**** 9abc","id":1,"location":null,"password":null,"username":"u***1"}`},
+		{bearer("admin"), `{"api_key":"123456789abc","id":1,"location":null,"password":"pass1","username":"user1"}`},
+	}
+	var arrived int32
+	processed := make(chan struct{})
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if utils.VStore.Get("masking.columns.users") != nil {
+				t.Errorf("Want the masks in the request variables, got them in the shared variables")
+			}
+			if atomic.AddInt32(&arrived, 1) == int32(len(wants)) {
+				close(processed)
+			}
+			select {
+			case <-processed:
+			case <-time.After(5 * time.Second):
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	var wg sync.WaitGroup
+	for _, w := range wants {
+		wg.Add(1)
+		go func(header map[string]string, want string) {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/records/users/1", nil)
+			for key, value := range header {
+				req.Header.Set(key, value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("Request failed : %s", err.Error())
+				return
+			}
+			defer resp.Body.Close()
+			if body, _ := ioutil.ReadAll(resp.Body); strings.TrimSpace(string(body)) != want {
+				t.Errorf("Concurrent request : got %s, want %s", body, want)
+			}
+		}(w.header, w.want)
+	}
+	wg.Wait()
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
+
+func TestMaskString(t *testing.T) {
+	for _, tc := range []struct{ name, argument, value, want string }{
+		{"email", "", "john@x.com", "j***@x.com"},
+		{"email", "", "not an email", "n***********"},
+		{"last4", "", "4242 4242 4242 4242", "**** 4242"},
+		{"last4", "", "42", "****"},
+		{"partial", "2,3", "0612345678", "06*****678"},
+		{"partial", "", "ab", "**"},
+	} {
+		if got := maskString(tc.name, tc.argument, tc.value); got != tc.want {
+			t.Errorf("Mask %s:%s of '%s' : want '%s', got '%s'", tc.name, tc.argument, tc.value, tc.want, got)
+		}
+	}
+}
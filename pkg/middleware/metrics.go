@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// MetricsMiddleware records the duration of the requests by controller, table, operation and status,
+// exposed by the status controller on /status/metrics
+type MetricsMiddleware struct {
+	GenericMiddleware
+	reflection  *database.ReflectionService
+	controllers map[string]bool
+}
+
+func NewMetricsMiddleware(responder controller.Responder, properties map[string]interface{}, reflection *database.ReflectionService, controllers map[string]bool) *MetricsMiddleware {
+	return &MetricsMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}, reflection: reflection, controllers: controllers}
+}
+
+func (mm *MetricsMiddleware) Process(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// labels are read before the request as the tables can be removed from the reflection by the middlewares,
+		// unknown controllers and tables are not labelled to keep the number of series bounded
+		controllerName, tableName := "", ""
+		if path := utils.GetPathSegment(r, 1); mm.controllers[path] {
+			controllerName = path
+			switch path {
+			case "records", "geojson", "columns":
+				if table := utils.GetPathSegment(r, 2); table != "" && mm.reflection.HasTable(table) {
+					tableName = table
+				}
+			}
+		}
+		operation := utils.GetOperation(r)
+		srw := &statusResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(srw, r)
+		utils.Metrics.Observe("gocrudapi_http_request_duration_seconds", "Duration of the HTTP requests in seconds", time.Since(start).Seconds(),
+			"controller", controllerName, "table", tableName, "operation", operation, "status", strconv.Itoa(srw.getStatusCode()))
+	})
+}
+
+// statusResponseWriter keeps the status code and the size of the response
+type statusResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int
+}
+
+func (srw *statusResponseWriter) WriteHeader(statusCode int) {
+	if srw.statusCode == 0 {
+		srw.statusCode = statusCode
+	}
+	srw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (srw *statusResponseWriter) Write(b []byte) (int, error) {
+	n, err := srw.ResponseWriter.Write(b)
+	srw.bytes += n
+	return n, err
+}
+
+func (srw *statusResponseWriter) getStatusCode() int {
+	if srw.statusCode == 0 {
+		return http.StatusOK
+	}
+	return srw.statusCode
+}
+
+// authMetricsResponder counts the authentication errors returned by an authentication middleware
+type authMetricsResponder struct {
+	controller.Responder
+	middleware string
+}
+
+func newAuthMetricsResponder(responder controller.Responder, middleware string) controller.Responder {
+	return &authMetricsResponder{responder, middleware}
+}
+
+func (amr *authMetricsResponder) Error(errorCode int, argument string, w http.ResponseWriter, details interface{}) http.ResponseWriter {
+	switch errorCode {
+	case record.AUTHENTICATION_REQUIRED, record.AUTHENTICATION_FAILED:
+		utils.Metrics.AddCounter("gocrudapi_auth_failures_total", "Number of authentication errors by middleware and error code", 1,
+			"middleware", amr.middleware, "code", strconv.Itoa(errorCode))
+	}
+	return amr.Responder.Error(errorCode, argument, w, details)
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	reflection := database.NewReflectionService(db, nil, 0)
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	controllers := map[string]bool{"records": true, "status": true}
+	metricsMiddle := NewMetricsMiddleware(responder, nil, reflection, controllers)
+	akamMiddle := NewApiKeyAuth(responder, map[string]interface{}{"mode": "optional", "keys": "123456789abc"})
+	records := record.NewRecordService(db, reflection)
+	controller.NewRecordController(router, responder, records)
+	controller.NewStatusController(router, responder, nil, db)
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	router.Use(metricsMiddle.Process)
+	router.Use(akamMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tt := []utils.Test{
+		{
+			Name:       "metrics_read_record",
+			Method:     http.MethodGet,
+			Uri:        "/records/categories/1",
+			Want:       `{"icon":null,"id":1,"name":"announcement"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "metrics_unknown_table",
+			Method:     http.MethodGet,
+			Uri:        "/records/unknowns/1",
+			Want:       `{"code":1001,"message":"Table 'unknowns' not found"}`,
+			StatusCode: http.StatusNotFound,
+		},
+		{
+			Name:          "metrics_auth_failure",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/1",
+			RequestHeader: map[string]string{"X-API-Key": "wrong"},
+			Want:          `{"code":1012,"message":"Authentication failed for 'wrong'"}`,
+			StatusCode:    http.StatusForbidden,
+		},
+		{
+			Name:       "metrics_http_requests",
+			Method:     http.MethodGet,
+			Uri:        "/status/metrics",
+			WantRegex:  `gocrudapi_http_request_duration_seconds_count\{controller="records",table="categories",operation="read",status="200"\} [1-9]`,
+			WantHeader: map[string]string{"Content-Type": "text/plain; version=0.0.4; charset=utf-8"},
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "metrics_unknown_table_not_labelled",
+			Method:     http.MethodGet,
+			Uri:        "/status/metrics",
+			WantRegex:  `gocrudapi_http_request_duration_seconds_bucket\{controller="records",table="",operation="read",status="404",le="\+Inf"\} [1-9]`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "metrics_auth_failures",
+			Method:     http.MethodGet,
+			Uri:        "/status/metrics",
+			WantRegex:  `# TYPE gocrudapi_auth_failures_total counter\n(.*\n)*gocrudapi_auth_failures_total\{middleware="apiKeyAuth",code="1012"\} [1-9]`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "metrics_db_queries",
+			Method:     http.MethodGet,
+			Uri:        "/status/metrics",
+			WantRegex:  `gocrudapi_db_query_duration_seconds_count\{method="query"\} [1-9]`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "metrics_db_pool",
+			Method:     http.MethodGet,
+			Uri:        "/status/metrics",
+			WantRegex:  `gocrudapi_db_connections\{state="idle"\} [0-9]+\n`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "metrics_cache",
+			Method:     http.MethodGet,
+			Uri:        "/status/metrics",
+			WantRegex:  `gocrudapi_cache_requests_total\{cache="gocache",result="(hit|miss)"\} [1-9]`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "metrics_reflection",
+			Method:     http.MethodGet,
+			Uri:        "/status/metrics",
+			WantRegex:  `gocrudapi_reflection_reloads_total\{kind="table"\} [1-9]`,
+			StatusCode: http.StatusOK,
+		},
+	}
+	utils.RunTests(t, ts.URL, tt)
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
@@ -1,12 +1,14 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
 )
 
 type Middleware interface {
@@ -88,10 +90,30 @@ func (gm *GenericMiddleware) getMapProperty(key, defaut string) map[string]strin
 	for pair := range pairs {
 		if strings.Contains(pair, ":") {
 			val := strings.SplitN(pair, ":", 2)
-			result[val[1]] = val[2]
+			result[val[0]] = val[1]
 		} else {
 			result[pair] = ""
 		}
 	}
 	return result
 }
+
+// templateValue returns the value given to the handler templates : json numbers are given as float64
+func templateValue(value interface{}) interface{} {
+	if number, ok := value.(json.Number); ok {
+		if f, err := number.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// requestReflection returns the reflection of the request, copied from the reflection of the api on first use :
+// the tables and the columns denied to the user are removed from it, not from the reflection shared by the requests
+func requestReflection(r *http.Request, reflection *database.ReflectionService) (*http.Request, *database.ReflectionService) {
+	if scoped := database.ReflectionFromContext(r.Context()); scoped != nil {
+		return r, scoped
+	}
+	scoped := reflection.Scope()
+	return r.WithContext(database.ContextWithReflection(r.Context(), scoped)), scoped
+}
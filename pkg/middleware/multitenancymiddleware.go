@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 
 	"github.com/dranih/go-crud-api/pkg/controller"
@@ -55,13 +54,13 @@ func (mt *MultiTenancy) getPairs(handler, operation, tableName string) map[strin
 					}
 				}
 			} else {
-				log.Printf("Error : could not unmarshal json from multitenancy handler : %s", err.Error())
+				utils.Log.Error("could not unmarshal json from multitenancy handler", "error", err)
 			}
 		} else {
-			log.Printf("Error : could not execute template multitenancy handler : %s", err.Error())
+			utils.Log.Error("could not execute template multitenancy handler", "error", err)
 		}
 	} else {
-		log.Printf("Error : could not parse template multitenancy handler : %s", err.Error())
+		utils.Log.Error("could not parse template multitenancy handler", "error", err)
 	}
 	return result
 }
@@ -98,7 +97,7 @@ func (mt *MultiTenancy) handleRecord(r *http.Request, operation string, pairs ma
 		body, err = json.Marshal(records[0])
 	}
 	if err != nil {
-		log.Printf("Error : could not marshal modified body to string : %s", err.Error())
+		utils.Log.Error("could not marshal modified body to string", "error", err)
 		return r
 	}
 	r.Body = ioutil.NopCloser(bytes.NewReader(body))
@@ -114,6 +113,8 @@ func (mt *MultiTenancy) Process(next http.Handler) http.Handler {
 			path := utils.GetPathSegment(r, 1)
 			if path == "records" {
 				operation := utils.GetOperation(r)
+				var variables *utils.VariableStore
+				r, variables = utils.RequestVariables(r)
 				tableNames := utils.GetTableNames(r, mt.reflection.GetTableNames())
 				for i, tableName := range tableNames {
 					if !mt.reflection.HasTable(tableName) {
@@ -126,7 +127,7 @@ func (mt *MultiTenancy) Process(next http.Handler) http.Handler {
 							}
 						}
 						condition := mt.getCondition(tableName, pairs)
-						utils.VStore.Set(fmt.Sprintf("multiTenancy.conditions.%s", tableName), condition)
+						variables.Set(fmt.Sprintf("multiTenancy.conditions.%s", tableName), condition)
 					}
 				}
 			}
@@ -0,0 +1,281 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/cache"
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// rateLimitRule gives limit requests per period seconds to the requests matching the scope
+// ("controller:table:operation" globs, as the api keys scopes)
+type rateLimitRule struct {
+	scope  string
+	limit  float64
+	period float64
+}
+
+// rateLimitStore keeps the token buckets, take returns if the cost was taken and the tokens left
+type rateLimitStore interface {
+	take(key string, rule rateLimitRule, cost float64, now time.Time) (bool, float64, error)
+	close()
+}
+
+type RateLimitMiddleware struct {
+	GenericMiddleware
+	rules []rateLimitRule
+	store rateLimitStore
+}
+
+func NewRateLimitMiddleware(responder controller.Responder, properties map[string]interface{}, cache cache.Cache) *RateLimitMiddleware {
+	rlm := &RateLimitMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}}
+	rlm.rules = rlm.getRules()
+	rlm.store = &memoryRateLimitStore{buckets: map[string]*rateLimitBucket{}}
+	if rlm.getStringProperty("store", "memory") == "redis" {
+		if scripted, ok := cache.(scriptCache); ok {
+			rlm.store = &redisRateLimitStore{cache: scripted}
+		} else {
+			utils.Log.Warning("rateLimit store 'redis' requires the Redis cache, the limits are kept in memory")
+		}
+	}
+	return rlm
+}
+
+// Close releases the buckets of the store, once the middleware is no longer used
+func (rlm *RateLimitMiddleware) Close() {
+	rlm.store.close()
+}
+
+// getRules reads the limits property, ex : "records:*:list=30/60,records:comments:*=10/1", the
+// first matching rule applies and the default is limit requests per period seconds
+func (rlm *RateLimitMiddleware) getRules() []rateLimitRule {
+	rules := []rateLimitRule{}
+	if limits := rlm.getStringProperty("limits", ""); limits != "" {
+		for _, limit := range strings.Split(limits, ",") {
+			scope, quota, found := strings.Cut(strings.TrimSpace(limit), "=")
+			if rule, err := parseRateLimitQuota(quota); found && err == nil {
+				rule.scope = scope
+				rules = append(rules, rule)
+			} else {
+				utils.Log.Warning("invalid rateLimit limit", "limit", limit)
+			}
+		}
+	}
+	return append(rules, rateLimitRule{"*", float64(rlm.getIntProperty("limit", 60)), float64(rlm.getIntProperty("period", 60))})
+}
+
+func parseRateLimitQuota(quota string) (rateLimitRule, error) {
+	limit, period, _ := strings.Cut(quota, "/")
+	if period == "" {
+		period = "60"
+	}
+	l, err := strconv.ParseFloat(strings.TrimSpace(limit), 64)
+	if err != nil {
+		return rateLimitRule{}, err
+	}
+	p, err := strconv.ParseFloat(strings.TrimSpace(period), 64)
+	if err != nil || p <= 0 {
+		return rateLimitRule{}, fmt.Errorf("invalid period '%s'", period)
+	}
+	return rateLimitRule{limit: l, period: p}, nil
+}
+
+// getIdentity returns the first identity found among the keyBy property values : user (session user,
+// api user, jwt subject or client certificate), apiKey (header) and ip
+func (rlm *RateLimitMiddleware) getIdentity(w http.ResponseWriter, r *http.Request) string {
+	for _, keyBy := range strings.Split(rlm.getStringProperty("keyBy", "ip"), ",") {
+		switch strings.TrimSpace(keyBy) {
+		case "user":
+			session := utils.GetSession(w, r)
+			userColumn := rlm.getStringProperty("userColumn", "id")
+			for _, key := range []string{"user", "apiUser"} {
+				if user, ok := session.Values[key].(map[string]interface{}); ok && user[userColumn] != nil {
+					return "user:" + fmt.Sprint(user[userColumn])
+				}
+			}
+			if claims, ok := session.Values["claims"].(map[string]interface{}); ok && claims["sub"] != nil {
+				return "user:" + fmt.Sprint(claims["sub"])
+			}
+			if identity, ok := session.Values["clientCert"].(map[string]interface{}); ok && identity["username"] != nil {
+				return "user:" + fmt.Sprint(identity["username"])
+			}
+		case "apiKey":
+			if apiKey := r.Header.Get(rlm.getStringProperty("header", "X-API-Key")); apiKey != "" {
+				return "apiKey:" + hashToken(apiKey)
+			}
+		case "ip":
+			return "ip:" + rlm.getIpAddress(r)
+		}
+	}
+	return "ip:" + rlm.getIpAddress(r)
+}
+
+// KeyedByUser tells if the requests are limited by user, the middleware being used after the authentication middlewares
+func (rlm *RateLimitMiddleware) KeyedByUser() bool {
+	for _, keyBy := range strings.Split(rlm.getStringProperty("keyBy", "ip"), ",") {
+		if strings.TrimSpace(keyBy) == "user" {
+			return true
+		}
+	}
+	return false
+}
+
+func (rlm *RateLimitMiddleware) getIpAddress(r *http.Request) string {
+	return getClientIpAddress(r, rlm.getStringProperty("reverseProxy", "") != "")
+}
+
+// getCost counts a request as 1 plus joinCost for each joined table
+func (rlm *RateLimitMiddleware) getCost(r *http.Request) float64 {
+	cost := 1.0
+	if joinCost := rlm.getIntProperty("joinCost", 0); joinCost > 0 && utils.GetPathSegment(r, 1) == "records" {
+		cost += float64(joinCost * (len(utils.GetTableNames(r, nil)) - 1))
+	}
+	return cost
+}
+
+func (rlm *RateLimitMiddleware) Process(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		controller, tableNames, operation := getScopeTarget(r)
+		tableName := tableNames[0]
+		if controller == "records" {
+			// the joined tables are counted in the cost
+			tableName = utils.GetPathSegment(r, 2)
+		}
+		var rule rateLimitRule
+		index := 0
+		for i, candidate := range rlm.rules {
+			if matchScope(candidate.scope, controller, tableName, operation) {
+				rule, index = candidate, i
+				break
+			}
+		}
+		if rule.limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cost := rlm.getCost(r)
+		key := fmt.Sprintf("rateLimit-%d-%s", index, rlm.getIdentity(w, r))
+		allowed, tokens, err := rlm.store.take(key, rule, cost, time.Now())
+		if err != nil {
+			// the requests are not blocked when the store is not available
+			utils.Log.Error("rateLimit store", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		rate := rule.limit / rule.period
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(int(rule.limit)))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(math.Floor(tokens))))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil((rule.limit-tokens)/rate))))
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil((cost-tokens)/rate))))
+			rlm.Responder.Error(record.RATE_LIMIT_EXCEEDED, "", w, "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refill returns the tokens of a bucket after the elapsed seconds, the bucket holding at most limit tokens
+func (rule rateLimitRule) refill(tokens, elapsed float64) float64 {
+	return math.Min(rule.limit, tokens+elapsed*rule.limit/rule.period)
+}
+
+type rateLimitBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type memoryRateLimitStore struct {
+	sync.Mutex
+	buckets map[string]*rateLimitBucket
+	swept   time.Time
+}
+
+func (mrls *memoryRateLimitStore) take(key string, rule rateLimitRule, cost float64, now time.Time) (bool, float64, error) {
+	mrls.Lock()
+	defer mrls.Unlock()
+	// the buckets not used for an hour are full again and can be forgotten
+	if now.Sub(mrls.swept) > time.Minute {
+		for k, bucket := range mrls.buckets {
+			if now.Sub(bucket.updated) > time.Hour {
+				delete(mrls.buckets, k)
+			}
+		}
+		mrls.swept = now
+	}
+	bucket, exists := mrls.buckets[key]
+	if !exists {
+		bucket = &rateLimitBucket{tokens: rule.limit, updated: now}
+		mrls.buckets[key] = bucket
+	}
+	bucket.tokens = rule.refill(bucket.tokens, now.Sub(bucket.updated).Seconds())
+	bucket.updated = now
+	if bucket.tokens < cost {
+		return false, bucket.tokens, nil
+	}
+	bucket.tokens -= cost
+	return true, bucket.tokens, nil
+}
+
+// close forgets the buckets, the store being kept in memory only
+func (mrls *memoryRateLimitStore) close() {
+	mrls.Lock()
+	defer mrls.Unlock()
+	mrls.buckets = map[string]*rateLimitBucket{}
+}
+
+// scriptCache is a cache running Lua scripts, as the Redis cache
+type scriptCache interface {
+	Eval(script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// rateLimitScript refills and takes the tokens of the bucket atomically, the bucket expiring once full
+const rateLimitScript = `
+local limit, period, cost, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+local updated = tonumber(redis.call('HGET', KEYS[1], 'updated'))
+if tokens == nil or updated == nil then
+	tokens, updated = limit, now
+end
+tokens = math.min(limit, tokens + math.max(0, now - updated) * limit / period)
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('EXPIRE', KEYS[1], math.ceil(period) + 1)
+return {allowed, tostring(tokens)}
+`
+
+type redisRateLimitStore struct {
+	cache scriptCache
+}
+
+func (rrls *redisRateLimitStore) take(key string, rule rateLimitRule, cost float64, now time.Time) (bool, float64, error) {
+	result, err := rrls.cache.Eval(rateLimitScript, []string{key}, rule.limit, rule.period, cost, float64(now.UnixNano())/1e9)
+	if err != nil {
+		return false, 0, err
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected result %v", result)
+	}
+	tokens, err := strconv.ParseFloat(fmt.Sprint(values[1]), 64)
+	if err != nil {
+		return false, 0, err
+	}
+	return fmt.Sprint(values[0]) == "1", tokens, nil
+}
+
+// close does nothing, the buckets expiring in Redis and the cache being closed with the api
+func (rrls *redisRateLimitStore) close() {
+}
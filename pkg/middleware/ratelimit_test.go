@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	properties := map[string]interface{}{
+		"limit":    2,
+		"period":   60,
+		"keyBy":    "apiKey,ip",
+		"limits":   "records:comments:list=3/60,records:*:list=1/60,status:*:*=0",
+		"joinCost": 2,
+	}
+
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	rlMiddle := NewRateLimitMiddleware(responder, properties, nil)
+	router.HandleFunc("/records/{table}", utils.AllowedTest).Methods("GET")
+	router.HandleFunc("/records/{table}/{id}", utils.AllowedTest).Methods("GET")
+	router.HandleFunc("/status/ping", utils.AllowedTest).Methods("GET")
+	router.Use(rlMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tt := []utils.Test{
+		{
+			Name:       "rate_limit_first",
+			Method:     http.MethodGet,
+			Uri:        "/records/posts/1",
+			Want:       `Allowed`,
+			WantHeader: map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "1"},
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "rate_limit_second",
+			Method:     http.MethodGet,
+			Uri:        "/records/posts/2",
+			Want:       `Allowed`,
+			WantHeader: map[string]string{"RateLimit-Remaining": "0"},
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "rate_limit_exceeded",
+			Method:     http.MethodGet,
+			Uri:        "/records/posts/1",
+			Want:       `{"code":1026,"message":"Rate limit exceeded"}`,
+			WantHeader: map[string]string{"Retry-After": "30", "RateLimit-Remaining": "0"},
+			StatusCode: http.StatusTooManyRequests,
+		},
+		{
+			Name:          "rate_limit_other_identity",
+			Method:        http.MethodGet,
+			Uri:           "/records/posts/1",
+			RequestHeader: map[string]string{"X-API-Key": "123456789abc"},
+			Want:          `Allowed`,
+			WantHeader:    map[string]string{"RateLimit-Remaining": "1"},
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:       "rate_limit_list_rule",
+			Method:     http.MethodGet,
+			Uri:        "/records/posts",
+			Want:       `Allowed`,
+			WantHeader: map[string]string{"RateLimit-Limit": "1", "RateLimit-Remaining": "0"},
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "rate_limit_list_rule_exceeded",
+			Method:     http.MethodGet,
+			Uri:        "/records/categories",
+			Want:       `{"code":1026,"message":"Rate limit exceeded"}`,
+			WantHeader: map[string]string{"Retry-After": "60"},
+			StatusCode: http.StatusTooManyRequests,
+		},
+		{
+			Name:       "rate_limit_join_cost",
+			Method:     http.MethodGet,
+			Uri:        "/records/comments?join=posts",
+			Want:       `Allowed`,
+			WantHeader: map[string]string{"RateLimit-Limit": "3", "RateLimit-Remaining": "0"},
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "rate_limit_join_cost_exceeded",
+			Method:     http.MethodGet,
+			Uri:        "/records/comments",
+			Want:       `{"code":1026,"message":"Rate limit exceeded"}`,
+			StatusCode: http.StatusTooManyRequests,
+		},
+		{
+			Name:       "rate_limit_unlimited",
+			Method:     http.MethodGet,
+			Uri:        "/status/ping",
+			Want:       `Allowed`,
+			WantHeader: map[string]string{"RateLimit-Limit": ""},
+			StatusCode: http.StatusOK,
+		},
+	}
+	utils.RunTests(t, ts.URL, tt)
+}
+
+func TestRateLimitBucket(t *testing.T) {
+	store := &memoryRateLimitStore{buckets: map[string]*rateLimitBucket{}}
+	rule := rateLimitRule{limit: 2, period: 60}
+	now := time.Now()
+	for i, step := range []struct {
+		elapsed time.Duration
+		allowed bool
+		tokens  float64
+	}{
+		{0, true, 1},
+		{0, true, 0},
+		{15 * time.Second, false, 0.5},
+		{30 * time.Second, true, 0.5},
+		{10 * time.Minute, true, 1},
+	} {
+		now = now.Add(step.elapsed)
+		allowed, tokens, err := store.take("key", rule, 1, now)
+		if err != nil || allowed != step.allowed || tokens != step.tokens {
+			t.Errorf("Step %d : want %v with %v tokens, got %v with %v tokens (%v)", i, step.allowed, step.tokens, allowed, tokens, err)
+		}
+	}
+}
+
+func TestRateLimitKeyByUser(t *testing.T) {
+	gob.Register(map[string]interface{}{})
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	secret := "rateLimitTestSecretWithEnoughEntropy"
+	jaMiddle := NewJwtAuth(responder, map[string]interface{}{"mode": "optional", "secret": secret})
+	rlMiddle := NewRateLimitMiddleware(responder, map[string]interface{}{"limit": 1, "keyBy": "user,ip"}, nil)
+	router.HandleFunc("/records/{table}", utils.AllowedTest).Methods("GET")
+	// the users are authenticated before the limit
+	router.Use(jaMiddle.Process)
+	router.Use(rlMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	issuer, err := newJwtIssuer("HS256", "", secret)
+	if err != nil {
+		t.Fatalf("Unable to create issuer : %s", err.Error())
+	}
+	bearer := func(sub string) map[string]string {
+		token, err := issuer.sign(map[string]interface{}{"sub": sub})
+		if err != nil {
+			t.Fatalf("Unable to sign token : %s", err.Error())
+		}
+		return map[string]string{"X-Authorization": "Bearer " + token}
+	}
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:          "rate_limit_user",
+			Method:        http.MethodGet,
+			Uri:           "/records/posts",
+			RequestHeader: bearer("1"),
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "rate_limit_user_exceeded",
+			Method:        http.MethodGet,
+			Uri:           "/records/posts",
+			RequestHeader: bearer("1"),
+			Want:          `{"code":1026,"message":"Rate limit exceeded"}`,
+			StatusCode:    http.StatusTooManyRequests,
+		},
+		{
+			Name:          "rate_limit_other_user",
+			Method:        http.MethodGet,
+			Uri:           "/records/posts",
+			RequestHeader: bearer("2"),
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:       "rate_limit_anonymous_ip",
+			Method:     http.MethodGet,
+			Uri:        "/records/posts",
+			Want:       `Allowed`,
+			StatusCode: http.StatusOK,
+		},
+	})
+}
+
+func TestRateLimitReverseProxy(t *testing.T) {
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	rlMiddle := NewRateLimitMiddleware(responder, map[string]interface{}{"limit": 1, "reverseProxy": "1"}, nil)
+	router.HandleFunc("/records/{table}", utils.AllowedTest).Methods("GET")
+	router.Use(rlMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// the addresses sent by the client before the one added by the proxy are ignored
+	utils.RunTests(t, ts.URL, []utils.Test{
+		{
+			Name:          "rate_limit_forwarded",
+			Method:        http.MethodGet,
+			Uri:           "/records/posts",
+			RequestHeader: map[string]string{"X-Forwarded-For": "192.0.2.1, 198.51.100.1"},
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "rate_limit_forwarded_spoofed",
+			Method:        http.MethodGet,
+			Uri:           "/records/posts",
+			RequestHeader: map[string]string{"X-Forwarded-For": "192.0.2.2, 198.51.100.1"},
+			Want:          `{"code":1026,"message":"Rate limit exceeded"}`,
+			StatusCode:    http.StatusTooManyRequests,
+		},
+		{
+			Name:          "rate_limit_forwarded_other_client",
+			Method:        http.MethodGet,
+			Uri:           "/records/posts",
+			RequestHeader: map[string]string{"X-Forwarded-For": "198.51.100.2"},
+			Want:          `Allowed`,
+			StatusCode:    http.StatusOK,
+		},
+	})
+}
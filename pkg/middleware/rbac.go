@@ -0,0 +1,383 @@
+package middleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/sessions"
+	"gopkg.in/yaml.v3"
+)
+
+// rbacOperations are the record operations that can be granted on a table
+var rbacOperations = []string{"list", "read", "create", "update", "delete", "increment"}
+
+// rbacPlaceholder matches the session values used in the row filters, ex : {claims.sub} or {user.id}
+var rbacPlaceholder = regexp.MustCompile(`\{([A-Za-z_]+)(?:\.([^}]+))?\}`)
+
+// rbacPolicy is the content of the policy file : the grants of each role by table name or glob
+type rbacPolicy struct {
+	Roles map[string]struct {
+		Tables map[string]*rbacGrant `yaml:"tables"`
+	} `yaml:"roles"`
+}
+
+type rbacGrant struct {
+	Operations []string `yaml:"operations"`
+	Columns    struct {
+		Allow []string `yaml:"allow"`
+		Deny  []string `yaml:"deny"`
+	} `yaml:"columns"`
+	Filters []string `yaml:"filters"`
+}
+
+// rbacDecision explains the access given to the roles on a table
+type rbacDecision struct {
+	Table         string              `json:"table"`
+	Allowed       bool                `json:"allowed"`
+	GrantedBy     []string            `json:"grantedBy,omitempty"`
+	DeniedColumns []string            `json:"deniedColumns,omitempty"`
+	Filters       map[string][]string `json:"filters,omitempty"`
+	Reasons       []string            `json:"reasons,omitempty"`
+	condition     interface{ database.Condition }
+}
+
+type RbacMiddleware struct {
+	GenericMiddleware
+	reflection *database.ReflectionService
+	policy     *rbacPolicy
+}
+
+func NewRbacMiddleware(responder controller.Responder, properties map[string]interface{}, reflection *database.ReflectionService) *RbacMiddleware {
+	rm := &RbacMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}, reflection: reflection}
+	policy, err := loadRbacPolicy(rm.getStringProperty("policyFile", ""))
+	if err != nil {
+		// without policy, no role is granted anything
+		utils.Log.Error("unable to load rbac policy", "error", err)
+		policy = &rbacPolicy{}
+	}
+	rm.policy = policy
+	return rm
+}
+
+func loadRbacPolicy(fileName string) (*rbacPolicy, error) {
+	if fileName == "" {
+		return nil, fmt.Errorf("no policyFile configured")
+	}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	policy := &rbacPolicy{}
+	if err := yaml.Unmarshal(content, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// getRoles returns the roles of the claims (jwtAuth) and of the user (dbAuth, apiKeyDbAuth), or the default role
+func (gm *GenericMiddleware) getRoles(session *sessions.Session) []string {
+	roles := gm.getUserRoles(session)
+	if len(roles) == 0 {
+		roles = append(roles, gm.getStringProperty("defaultRole", "anonymous"))
+	}
+	return roles
+}
+
+// getUserRoles returns the roles of the claims (jwtAuth) and of the user (dbAuth, apiKeyDbAuth), none if not authenticated
+func (gm *GenericMiddleware) getUserRoles(session *sessions.Session) []string {
+	uniqueRoles := map[string]bool{}
+	sources := map[string]string{
+		"claims":  gm.getStringProperty("rolesClaim", "roles"),
+		"user":    gm.getStringProperty("rolesColumn", "role"),
+		"apiUser": gm.getStringProperty("rolesColumn", "role"),
+	}
+	for sessionKey, field := range sources {
+		values, ok := session.Values[sessionKey].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch v := values[field].(type) {
+		case []interface{}:
+			for _, role := range v {
+				uniqueRoles[fmt.Sprint(role)] = true
+			}
+		case nil:
+		default:
+			for _, role := range strings.FieldsFunc(fmt.Sprint(v), func(c rune) bool { return c == ',' || c == ' ' }) {
+				uniqueRoles[role] = true
+			}
+		}
+	}
+	roles := []string{}
+	for role := range uniqueRoles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// getGrant returns the grant of the role on the table, an exact table name overriding the globs
+func (rm *RbacMiddleware) getGrant(role, tableName string) *rbacGrant {
+	tables := rm.policy.Roles[role].Tables
+	if grant, exists := tables[tableName]; exists {
+		return grant
+	}
+	patterns := []string{}
+	for pattern := range tables {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, tableName); matched {
+			return tables[pattern]
+		}
+	}
+	return nil
+}
+
+func (grant *rbacGrant) allowsOperation(operations []string) bool {
+	for _, granted := range grant.Operations {
+		for _, operation := range operations {
+			if granted == "*" || granted == operation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (grant *rbacGrant) allowsColumn(columnName string) bool {
+	for _, denied := range grant.Columns.Deny {
+		if denied == columnName {
+			return false
+		}
+	}
+	if len(grant.Columns.Allow) == 0 {
+		return true
+	}
+	for _, allowed := range grant.Columns.Allow {
+		if allowed == "*" || allowed == columnName {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveFilter replaces the placeholders of a filter by the session values
+func resolveFilter(filter string, session *sessions.Session) (string, error) {
+	var err error
+	resolved := rbacPlaceholder.ReplaceAllStringFunc(filter, func(placeholder string) string {
+		match := rbacPlaceholder.FindStringSubmatch(placeholder)
+		value := session.Values[match[1]]
+		if match[2] != "" {
+			values, _ := value.(map[string]interface{})
+			value = values[match[2]]
+		}
+		if value == nil {
+			err = fmt.Errorf("value of %s not found", placeholder)
+			return ""
+		}
+		return fmt.Sprint(value)
+	})
+	return resolved, err
+}
+
+// decide computes the access of the roles for one of the operations on the table :
+// the table is allowed if a role grants it, the columns if a granting role allows them
+// and the records of the row filters of all the granting roles are visible
+func (rm *RbacMiddleware) decide(operations []string, tableName string, roles []string, session *sessions.Session) *rbacDecision {
+	decision := &rbacDecision{Table: tableName, condition: database.NewNoCondition()}
+	if !rm.reflection.HasTable(tableName) {
+		decision.Reasons = append(decision.Reasons, fmt.Sprintf("table '%s' not found", tableName))
+		return decision
+	}
+	table := rm.reflection.GetTable(tableName)
+	operationNames := strings.Join(operations, "' or '")
+	grants := map[string]*rbacGrant{}
+	conditions := []interface{ database.Condition }{}
+	unfiltered := false
+	for _, role := range roles {
+		grant := rm.getGrant(role, tableName)
+		if grant == nil {
+			decision.Reasons = append(decision.Reasons, fmt.Sprintf("role '%s' has no grant on table '%s'", role, tableName))
+			continue
+		}
+		if !grant.allowsOperation(operations) {
+			decision.Reasons = append(decision.Reasons, fmt.Sprintf("role '%s' does not grant '%s' on table '%s'", role, operationNames, tableName))
+			continue
+		}
+		var condition interface{ database.Condition } = database.NewNoCondition()
+		filters := []string{}
+		valid := true
+		for _, filter := range grant.Filters {
+			resolved, err := resolveFilter(filter, session)
+			if err != nil {
+				decision.Reasons = append(decision.Reasons, fmt.Sprintf("role '%s' filter '%s' on table '%s' : %s", role, filter, tableName, err.Error()))
+				valid = false
+				break
+			}
+			filterCondition := database.ConditionFromString(table, resolved)
+			if _, none := filterCondition.(*database.NoCondition); none {
+				decision.Reasons = append(decision.Reasons, fmt.Sprintf("role '%s' filter '%s' on table '%s' is invalid", role, filter, tableName))
+				valid = false
+				break
+			}
+			condition = condition.And(filterCondition).(interface{ database.Condition })
+			filters = append(filters, resolved)
+		}
+		// a role with a filter that can not be applied grants nothing
+		if !valid {
+			continue
+		}
+		grants[role] = grant
+		decision.GrantedBy = append(decision.GrantedBy, role)
+		if len(filters) == 0 {
+			unfiltered = true
+		} else {
+			if decision.Filters == nil {
+				decision.Filters = map[string][]string{}
+			}
+			decision.Filters[role] = filters
+			conditions = append(conditions, condition)
+		}
+	}
+	if len(grants) == 0 {
+		return decision
+	}
+	decision.Allowed = true
+	decision.Reasons = nil
+	if !unfiltered {
+		decision.condition = database.OrConditionFromArray(conditions)
+	}
+	for _, columnName := range table.GetColumnNames() {
+		allowed := false
+		for _, grant := range grants {
+			if grant.allowsColumn(columnName) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			decision.DeniedColumns = append(decision.DeniedColumns, columnName)
+		}
+	}
+	return decision
+}
+
+// getRbacOperations returns the operations to be granted for the request
+func getRbacOperations(r *http.Request) []string {
+	switch utils.GetPathSegment(r, 1) {
+	case "openapi":
+		// a table is documented if any record operation is granted
+		return rbacOperations
+	case "geojson":
+		if utils.GetPathSegment(r, 3) != "" {
+			return []string{"read"}
+		}
+		return []string{"list"}
+	case "columns":
+		if r.Method == http.MethodGet {
+			return []string{"reflect"}
+		}
+		return []string{"remodel"}
+	}
+	return []string{utils.GetOperation(r)}
+}
+
+// explain returns the decisions taken for the request given by the "method" and "path" parameters,
+// to the users having a role only and on the tables of their request
+func (rm *RbacMiddleware) explain(w http.ResponseWriter, r *http.Request, session *sessions.Session) {
+	roles := rm.getUserRoles(session)
+	if len(roles) == 0 {
+		rm.Responder.Error(record.AUTHENTICATION_REQUIRED, "", w, "")
+		return
+	}
+	params := r.URL.Query()
+	method := strings.ToUpper(params.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	explained, err := http.NewRequest(method, params.Get("path"), nil)
+	if err != nil || params.Get("path") == "" {
+		rm.Responder.Error(record.HTTP_MESSAGE_NOT_READABLE, "", w, "")
+		return
+	}
+	_, reflection := requestReflection(r, rm.reflection)
+	operations := getRbacOperations(explained)
+	decisions := []*rbacDecision{}
+	allowed := true
+	for _, tableName := range utils.GetTableNames(explained, reflection.GetTableNames()) {
+		if !reflection.HasTable(tableName) {
+			continue
+		}
+		decision := rm.decide(operations, tableName, roles, session)
+		allowed = allowed && decision.Allowed
+		decisions = append(decisions, decision)
+	}
+	rm.Responder.Success(map[string]interface{}{
+		"method":     method,
+		"path":       params.Get("path"),
+		"roles":      roles,
+		"operations": operations,
+		"allowed":    allowed,
+		"tables":     decisions,
+	}, w)
+}
+
+func (rm *RbacMiddleware) Process(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := utils.GetPathSegment(r, 1)
+		session := utils.GetSession(w, r)
+		if path == "rbac" && utils.GetPathSegment(r, 2) == "explain" && r.Method == http.MethodGet && rm.getStringProperty("explain", "false") == "true" {
+			rm.explain(w, r, session)
+			return
+		}
+		if !map[string]bool{"records": true, "geojson": true, "openapi": true, "columns": true}[path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		roles := rm.getRoles(session)
+		operations := getRbacOperations(r)
+		// the decisions are kept in the request, the requests of other users running concurrently
+		r, variables := utils.RequestVariables(r)
+		r, reflection := requestReflection(r, rm.reflection)
+		for _, tableName := range utils.GetTableNames(r, reflection.GetTableNames()) {
+			if !reflection.HasTable(tableName) {
+				continue
+			}
+			decision := rm.decide(operations, tableName, roles, session)
+			if !decision.Allowed {
+				reflection.RemoveTable(tableName)
+				continue
+			}
+			table := reflection.GetTable(tableName)
+			for _, columnName := range decision.DeniedColumns {
+				table.RemoveColumn(columnName)
+			}
+			variables.Set(fmt.Sprintf("rbac.conditions.%s", tableName), decision.condition)
+		}
+		if path == "openapi" {
+			variables.Set("authorization.tableHandler", func(operation, tableName string) bool {
+				return rm.decide([]string{operation}, tableName, roles, session).Allowed
+			})
+			variables.Set("authorization.columnHandler", func(operation, tableName, columnName string) bool {
+				for _, deniedColumn := range rm.decide([]string{operation}, tableName, roles, session).DeniedColumns {
+					if deniedColumn == columnName {
+						return false
+					}
+				}
+				return true
+			})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
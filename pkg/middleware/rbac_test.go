@@ -0,0 +1,290 @@
+package middleware
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+const rbacTestPolicy = `
+roles:
+  anonymous:
+    tables:
+      categories:
+        operations: [list, read]
+  reader:
+    tables:
+      "*":
+        operations: [list, read]
+        columns:
+          deny: [password, api_key]
+  author:
+    tables:
+      comments:
+        operations: [list, read, update]
+        filters:
+          - "post_id,eq,{claims.post}"
+  admin:
+    tables:
+      "*":
+        operations: ["*"]
+`
+
+func TestRbacMiddleware(t *testing.T) {
+	policyFile, err := ioutil.TempFile(os.TempDir(), "gocrudtests-rbac-")
+	if err != nil {
+		t.Fatalf("Cannot create temporary file %s", err.Error())
+	}
+	defer os.Remove(policyFile.Name())
+	if _, err := policyFile.WriteString(rbacTestPolicy); err != nil {
+		t.Fatalf("Cannot write temporary file %s", err.Error())
+	}
+	policyFile.Close()
+
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	gob.Register(map[string]interface{}{})
+	reflection := database.NewReflectionService(db, nil, 0)
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	secret := "rbacTestSecretWithEnoughEntropy"
+	jaMiddle := NewJwtAuth(responder, map[string]interface{}{"mode": "optional", "secret": secret})
+	rbacMiddle := NewRbacMiddleware(responder, map[string]interface{}{"policyFile": policyFile.Name(), "explain": "true"}, reflection)
+	records := record.NewRecordService(db, reflection)
+	controller.NewRecordController(router, responder, records)
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	router.Use(jaMiddle.Process)
+	router.Use(rbacMiddle.Process)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	issuer, err := newJwtIssuer("HS256", "", secret)
+	if err != nil {
+		t.Fatalf("Unable to create issuer : %s", err.Error())
+	}
+	bearer := func(claims map[string]interface{}) map[string]string {
+		token, err := issuer.sign(claims)
+		if err != nil {
+			t.Fatalf("Unable to sign token : %s", err.Error())
+		}
+		return map[string]string{"X-Authorization": "Bearer " + token}
+	}
+	reader := bearer(map[string]interface{}{"sub": "1", "roles": []string{"reader"}})
+	author := bearer(map[string]interface{}{"sub": "2", "roles": "author", "post": 2})
+
+	tt := []utils.Test{
+		{
+			Name:       "rbac_anonymous_read",
+			Method:     http.MethodGet,
+			Uri:        "/records/categories/1",
+			Want:       `{"icon":null,"id":1,"name":"announcement"}`,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "rbac_anonymous_no_grant",
+			Method:     http.MethodGet,
+			Uri:        "/records/users/1",
+			Want:       `{"code":1001,"message":"Table 'users' not found"}`,
+			StatusCode: http.StatusNotFound,
+		},
+		{
+			Name:       "rbac_anonymous_operation_not_granted",
+			Method:     http.MethodDelete,
+			Uri:        "/records/categories/1",
+			Want:       `{"code":1001,"message":"Table 'categories' not found"}`,
+			StatusCode: http.StatusNotFound,
+		},
+		{
+			Name:          "rbac_reader_denied_columns",
+			Method:        http.MethodGet,
+			Uri:           "/records/users/1",
+			Want:          `{"id":1,"location":null,"username":"user1"}`,
+			RequestHeader: reader,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "rbac_admin_columns_restored",
+			Method:        http.MethodGet,
+			Uri:           "/records/users/1",
+			Want:          `{"api_key":"123456789abc","id":1,"location":null,"password":"pass1","username":"user1"}`,
+			RequestHeader: bearer(map[string]interface{}{"roles": "admin"}),
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "rbac_author_row_filter",
+			Method:        http.MethodGet,
+			Uri:           "/records/comments",
+			WantJson:      `{"records":[{"category_id":3,"id":3,"message":"thank you","post_id":2},{"category_id":3,"id":4,"message":"awesome","post_id":2}]}`,
+			RequestHeader: author,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "rbac_author_filtered_record",
+			Method:        http.MethodGet,
+			Uri:           "/records/comments/1",
+			Want:          `{"code":1003,"message":"Record '1' not found"}`,
+			RequestHeader: author,
+			StatusCode:    http.StatusNotFound,
+		},
+		{
+			Name:          "rbac_roles_union",
+			Method:        http.MethodGet,
+			Uri:           "/records/comments?include=id",
+			WantJson:      `{"records":[{"id":1},{"id":2},{"id":3},{"id":4}]}`,
+			RequestHeader: bearer(map[string]interface{}{"roles": "author,reader", "post": 2}),
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:       "rbac_explain_anonymous",
+			Method:     http.MethodGet,
+			Uri:        "/rbac/explain?method=DELETE&path=/records/categories/1",
+			Want:       `{"code":1011,"message":"Authentication required"}`,
+			StatusCode: http.StatusUnauthorized,
+		},
+		{
+			Name:          "rbac_explain_operation",
+			Method:        http.MethodGet,
+			Uri:           "/rbac/explain?method=DELETE&path=/records/categories/1",
+			WantJson:      `{"allowed":false,"method":"DELETE","operations":["delete"],"path":"/records/categories/1","roles":["reader"],"tables":[{"allowed":false,"reasons":["role 'reader' does not grant 'delete' on table 'categories'"],"table":"categories"}]}`,
+			RequestHeader: reader,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "rbac_explain_missing_claim",
+			Method:        http.MethodGet,
+			Uri:           "/rbac/explain?path=/records/comments",
+			WantJson:      `{"allowed":false,"method":"GET","operations":["list"],"path":"/records/comments","roles":["author"],"tables":[{"allowed":false,"reasons":["role 'author' filter 'post_id,eq,{claims.post}' on table 'comments' : value of {claims.post} not found"],"table":"comments"}]}`,
+			RequestHeader: bearer(map[string]interface{}{"roles": "author"}),
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "rbac_explain_allowed",
+			Method:        http.MethodGet,
+			Uri:           "/rbac/explain?path=/records/comments",
+			WantJson:      `{"allowed":true,"method":"GET","operations":["list"],"path":"/records/comments","roles":["author"],"tables":[{"allowed":true,"filters":{"author":["post_id,eq,2"]},"grantedBy":["author"],"table":"comments"}]}`,
+			RequestHeader: author,
+			StatusCode:    http.StatusOK,
+		},
+	}
+	utils.RunTests(t, ts.URL, tt)
+
+	// the explain endpoint is disabled by default and does not report the tables hidden from the request
+	hidingRouter := mux.NewRouter()
+	hidingRouter.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	hidingRouter.Use(jaMiddle.Process)
+	hidingRouter.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, scoped := requestReflection(r, reflection)
+			scoped.RemoveTable("users")
+			next.ServeHTTP(w, r)
+		})
+	})
+	hidingRouter.Use(rbacMiddle.Process)
+	hidingServer := httptest.NewServer(hidingRouter)
+	defer hidingServer.Close()
+	defaultRouter := mux.NewRouter()
+	defaultRouter.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	defaultRouter.Use(jaMiddle.Process)
+	defaultRouter.Use(NewRbacMiddleware(responder, map[string]interface{}{"policyFile": policyFile.Name()}, reflection).Process)
+	defaultServer := httptest.NewServer(defaultRouter)
+	defer defaultServer.Close()
+	utils.RunTests(t, hidingServer.URL, []utils.Test{
+		{
+			Name:          "rbac_explain_hidden_table",
+			Method:        http.MethodGet,
+			Uri:           "/rbac/explain?path=/records/users",
+			WantJson:      `{"allowed":true,"method":"GET","operations":["list"],"path":"/records/users","roles":["reader"],"tables":[]}`,
+			RequestHeader: reader,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "rbac_explain_disabled",
+			Method:        http.MethodGet,
+			Uri:           "/rbac/explain?path=/records/users",
+			Want:          `{"code":1000,"message":"Route '/rbac/explain?path=/records/users' not found"}`,
+			RequestHeader: reader,
+			Server:        defaultServer.URL,
+			StatusCode:    http.StatusNotFound,
+		},
+	})
+
+	// the decisions of concurrent requests apply to their own user only : the requests are held
+	// after the rbac middleware until all of them are processed by it
+	admin := bearer(map[string]interface{}{"roles": "admin"})
+	wants := []struct {
+		header map[string]string
+		want   string
+	}{
+		{author, `{"records":[{"id":3},{"id":4}]}`},
+		{admin, `{"records":[{"id":1},{"id":2},{"id":3},{"id":4}]}`},
+		{reader, `{"records":[{"id":1},{"id":2},{"id":3},{"id":4}]}`},
+	}
+	var arrived int32
+	processed := make(chan struct{})
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("include") == "id" {
+				if atomic.AddInt32(&arrived, 1) == int32(len(wants)) {
+					close(processed)
+				}
+				select {
+				case <-processed:
+				case <-time.After(5 * time.Second):
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	var wg sync.WaitGroup
+	for _, w := range wants {
+		wg.Add(1)
+		go func(header map[string]string, want string) {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/records/comments?include=id", nil)
+			for key, value := range header {
+				req.Header.Set(key, value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("Request failed : %s", err.Error())
+				return
+			}
+			defer resp.Body.Close()
+			if body, _ := ioutil.ReadAll(resp.Body); strings.TrimSpace(string(body)) != want {
+				t.Errorf("Concurrent request : got %s, want %s", body, want)
+			}
+		}(w.header, w.want)
+	}
+	wg.Wait()
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
@@ -3,7 +3,7 @@ package middleware
 import (
 	"bytes"
 	"fmt"
-	"log"
+	"github.com/dranih/go-crud-api/pkg/utils"
 	"net/http"
 	"strconv"
 	"strings"
@@ -34,10 +34,10 @@ func (rm *ReconnectMiddleware) getStringFromHandler(handlerStr string) string {
 					return res.String()
 				}
 			} else {
-				log.Printf("Error : could not execute template %s : %s", handlerStr, err.Error())
+				utils.Log.Error("could not execute template", "handler", handlerStr, "error", err)
 			}
 		} else {
-			log.Printf("Error : could not parse template %s : %s", handlerStr, err.Error())
+			utils.Log.Error("could not parse template", "handler", handlerStr, "error", err)
 		}
 	}
 	return ""
@@ -53,10 +53,10 @@ func (rm *ReconnectMiddleware) getIntFromHandler(handlerStr string) int {
 					return i
 				}
 			} else {
-				log.Printf("Error : could not execute template %s : %s", handlerStr, err.Error())
+				utils.Log.Error("could not execute template", "handler", handlerStr, "error", err)
 			}
 		} else {
-			log.Printf("Error : could not parse template %s : %s", handlerStr, err.Error())
+			utils.Log.Error("could not parse template", "handler", handlerStr, "error", err)
 		}
 	}
 	return 0
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math"
 	"net/http"
 	"strconv"
@@ -19,6 +18,7 @@ import (
 	"github.com/dranih/go-crud-api/pkg/utils"
 
 	"github.com/carmo-evan/strtotime"
+	"github.com/shopspring/decimal"
 )
 
 type SanitationMiddleware struct {
@@ -60,23 +60,23 @@ func (sm *SanitationMiddleware) callHandler(r *http.Request, handler, operation
 						TableName string
 						Column    string
 						Value     interface{}
-					}{Operation: operation, TableName: tableName, Column: columnName, Value: value}
+					}{Operation: operation, TableName: tableName, Column: columnName, Value: templateValue(value)}
 					if err := t.Execute(&res, data); err == nil {
 						var output interface{}
 						output = res.String()
-						if fmt.Sprint(value) == output {
+						if fmt.Sprint(data.Value) == output {
 							output = value
 						}
 						val := sm.sanitizeType(table, column, output)
 						records[i][columnName] = val
 					} else {
-						log.Printf("Error : could not execute template sanitation handler : %s", err.Error())
+						utils.Log.Error("could not execute template sanitation handler", "error", err)
 					}
 				}
 			}
 		}
 	} else {
-		log.Printf("Error : could not parse template sanitation handler : %s", err.Error())
+		utils.Log.Error("could not parse template sanitation handler", "error", err)
 	}
 
 	var body []byte
@@ -86,7 +86,7 @@ func (sm *SanitationMiddleware) callHandler(r *http.Request, handler, operation
 		body, err = json.Marshal(records[0])
 	}
 	if err != nil {
-		log.Printf("Error : could not marshal modified body to string : %s", err.Error())
+		utils.Log.Error("could not marshal modified body to string", "error", err)
 		return r
 	}
 	r.Body = ioutil.NopCloser(bytes.NewReader(body))
@@ -108,6 +108,12 @@ func (sm *SanitationMiddleware) sanitizeType(table *database.ReflectedTable, col
 		switch column.GetType() {
 		case "integer", "bigint":
 			switch t := value.(type) {
+			case json.Number:
+				if v, err := t.Int64(); err == nil {
+					newValue = v
+				} else if v, err := t.Float64(); err == nil {
+					newValue = int64(math.Round(v))
+				}
 			case float64:
 				newValue = int(math.Round(t))
 			case string:
@@ -117,6 +123,10 @@ func (sm *SanitationMiddleware) sanitizeType(table *database.ReflectedTable, col
 			}
 		case "decimal":
 			switch t := value.(type) {
+			case json.Number:
+				if v, err := decimal.NewFromString(t.String()); err == nil {
+					newValue = v.StringFixed(int32(column.GetScale()))
+				}
 			case float64:
 				newValue = utils.NumberFormat(t, column.GetScale(), ".", "")
 			case string:
@@ -125,13 +135,23 @@ func (sm *SanitationMiddleware) sanitizeType(table *database.ReflectedTable, col
 				}
 			}
 		case "float":
-			if t, ok := value.(string); ok {
+			switch t := value.(type) {
+			case json.Number:
+				if v, err := strconv.ParseFloat(t.String(), 32); err == nil {
+					newValue = v
+				}
+			case string:
 				if v, err := strconv.ParseFloat(strings.TrimSpace(t), 32); err == nil {
 					newValue = v
 				}
 			}
 		case "double":
-			if t, ok := value.(string); ok {
+			switch t := value.(type) {
+			case json.Number:
+				if v, err := t.Float64(); err == nil {
+					newValue = v
+				}
+			case string:
 				if v, err := strconv.ParseFloat(strings.TrimSpace(t), 64); err == nil {
 					newValue = v
 				}
@@ -142,6 +162,8 @@ func (sm *SanitationMiddleware) sanitizeType(table *database.ReflectedTable, col
 				newValue = (t == 1)
 			case float32, float64:
 				newValue = (t == 1.0)
+			case json.Number:
+				newValue = (t.String() == "1")
 			case string:
 				if v, err := strconv.ParseBool(strings.TrimSpace(t)); err == nil {
 					newValue = v
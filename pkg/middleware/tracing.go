@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/utils"
+)
+
+// TracingMiddleware starts the server span of the requests, continuing the trace of the W3C traceparent
+// header if given, and returns the traceparent of the span in the response
+type TracingMiddleware struct {
+	GenericMiddleware
+}
+
+func NewTracingMiddleware(responder controller.Responder, properties map[string]interface{}) *TracingMiddleware {
+	return &TracingMiddleware{GenericMiddleware: GenericMiddleware{Responder: responder, Properties: properties}}
+}
+
+func (tm *TracingMiddleware) Process(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := utils.ExtractTraceContext(r.Context(), r.Header)
+		// the span is named after the controller only, the full path being given as attribute
+		ctx, span := utils.StartSpan(ctx, r.Method+" /"+utils.GetPathSegment(r, 1), utils.SpanKindServer,
+			"http.method", r.Method, "http.target", r.URL.Path)
+		if span == nil {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		defer span.Finish()
+		w.Header().Set("traceparent", span.Context.TraceParent())
+		srw := &statusResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(srw, r.WithContext(ctx))
+		statusCode := srw.getStatusCode()
+		span.SetAttribute("http.status_code", strconv.Itoa(statusCode))
+		if statusCode >= 500 {
+			span.SetError(fmt.Errorf("status %d", statusCode))
+		}
+	})
+}
+
+type middlewareSpanKey struct{}
+
+// middlewareSpan is the span of a middleware and the span context to restore for the next handlers
+type middlewareSpan struct {
+	span   *utils.Span
+	parent utils.SpanContext
+	ended  bool
+}
+
+// TraceMiddleware wraps the Process function of a middleware to record a span from the start of the middleware
+// to the call of the next handler, or to its return if it answers the request itself
+func TraceMiddleware(name string, process func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handler := process(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ms, ok := r.Context().Value(middlewareSpanKey{}).(*middlewareSpan); ok && !ms.ended {
+				ms.ended = true
+				ms.span.Finish()
+				// the context of the request can be replaced by the middleware, so only the span is restored
+				r = r.WithContext(utils.ContextWithSpanContext(r.Context(), ms.parent))
+			}
+			next.ServeHTTP(w, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := utils.SpanContextFromContext(r.Context())
+			ctx, span := utils.StartSpan(r.Context(), "middleware "+name, utils.SpanKindInternal)
+			if span == nil {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			defer span.Finish()
+			ctx = context.WithValue(ctx, middlewareSpanKey{}, &middlewareSpan{span: span, parent: parent})
+			handler.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
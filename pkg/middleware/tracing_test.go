@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dranih/go-crud-api/pkg/controller"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/record"
+	"github.com/dranih/go-crud-api/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+type recordingExporter struct {
+	mutex sync.Mutex
+	spans []*utils.Span
+}
+
+func (re *recordingExporter) Export(spans []*utils.Span) error {
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+	re.spans = append(re.spans, spans...)
+	return nil
+}
+
+func (re *recordingExporter) Shutdown() error {
+	return nil
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	db_path := utils.SelectConfig(true)
+	db := database.NewGenericDB(
+		"sqlite",
+		db_path,
+		0,
+		"go-crud-api",
+		nil,
+		nil,
+		"go-crud-api",
+		"go-crud-api")
+	defer db.PDO().CloseConn()
+	reflection := database.NewReflectionService(db, nil, 0)
+	router := mux.NewRouter()
+	responder := controller.NewJsonResponder(false)
+	exporter := &recordingExporter{}
+	utils.SetTracer(utils.NewTracer(exporter, 1))
+	tracingMiddle := NewTracingMiddleware(responder, nil)
+	akamMiddle := NewApiKeyAuth(responder, map[string]interface{}{"mode": "optional", "keys": "123456789abc"})
+	records := record.NewRecordService(db, reflection)
+	controller.NewRecordController(router, responder, records)
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responder.Error(record.ROUTE_NOT_FOUND, r.RequestURI, w, "")
+	})
+	router.Use(tracingMiddle.Process)
+	router.Use(TraceMiddleware("apiKeyAuth", akamMiddle.Process))
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tt := []utils.Test{
+		{
+			Name:          "tracing_read_record",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/1",
+			RequestHeader: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			Want:          `{"icon":null,"id":1,"name":"announcement"}`,
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "tracing_not_sampled",
+			Method:        http.MethodGet,
+			Uri:           "/records/categories/2",
+			RequestHeader: map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"},
+			Want:          `{"icon":null,"id":2,"name":"article"}`,
+			WantHeader:    map[string]string{"traceparent": ""},
+			StatusCode:    http.StatusOK,
+		},
+	}
+	utils.RunTests(t, ts.URL, tt)
+
+	resp, err := http.Get(ts.URL + "/records/categories/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if sc, err := utils.ParseTraceParent(resp.Header.Get("traceparent")); err != nil || !sc.Sampled {
+		t.Errorf("Want a sampled traceparent header, got '%s'", resp.Header.Get("traceparent"))
+	}
+
+	utils.ShutdownTracer()
+	spans := map[string]*utils.Span{}
+	for _, span := range exporter.spans {
+		if hex.EncodeToString(span.Context.TraceId[:]) == "4bf92f3577b34da6a3ce929d0e0e4736" {
+			spans[span.Name] = span
+		}
+		if hex.EncodeToString(span.Context.TraceId[:]) == "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("Span '%s' of a trace not sampled", span.Name)
+		}
+	}
+	server, middle, service, query := spans["GET /records"], spans["middleware apiKeyAuth"], spans["RecordService.Read"], spans["SQL query"]
+	if server == nil || middle == nil || service == nil || query == nil {
+		t.Fatalf("Missing spans, got %v", spans)
+	}
+	if hex.EncodeToString(server.ParentId[:]) != "00f067aa0ba902b7" || server.Kind != utils.SpanKindServer {
+		t.Errorf("Want server span child of the traceparent, got parent %x", server.ParentId)
+	}
+	if middle.ParentId != server.Context.SpanId || service.ParentId != server.Context.SpanId {
+		t.Errorf("Want middleware and service spans children of the server span")
+	}
+	if query.ParentId != service.Context.SpanId || query.Kind != utils.SpanKindClient {
+		t.Errorf("Want query span child of the service span")
+	}
+	if statement := query.Attributes["db.statement"]; !strings.HasPrefix(statement, "SELECT") || strings.Contains(statement, "announcement") {
+		t.Errorf("Want sql text without values, got '%s'", statement)
+	}
+	if server.Attributes["http.status_code"] != "200" {
+		t.Errorf("Want status code attribute 200, got '%s'", server.Attributes["http.status_code"])
+	}
+	if err := os.Remove(db_path); err != nil {
+		panic(err)
+	}
+}
@@ -3,8 +3,8 @@ package middleware
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"log"
 	"math"
 	"net/http"
 	"regexp"
@@ -59,7 +59,7 @@ func (vm *ValidationMiddleware) callHandler(r *http.Request, w http.ResponseWrit
 						Column    *database.ReflectedColumn
 						Value     interface{}
 						Context   []map[string]interface{}
-					}{Operation: operation, TableName: tableName, Column: column, Value: value, Context: records}
+					}{Operation: operation, TableName: tableName, Column: column, Value: templateValue(value), Context: records}
 					if err := t.Execute(&res, data); err == nil {
 						var msg string
 						allowed, _ := strconv.ParseBool(strings.TrimSpace(res.String()))
@@ -72,13 +72,13 @@ func (vm *ValidationMiddleware) callHandler(r *http.Request, w http.ResponseWrit
 							details[columnName] = msg
 						}
 					} else {
-						log.Printf("Error : could not execute template sanitation handler : %s", err.Error())
+						utils.Log.Error("could not execute template sanitation handler", "error", err)
 					}
 				}
 			}
 		}
 	} else {
-		log.Printf("Error : could not parse template sanitation handler : %s", err.Error())
+		utils.Log.Error("could not parse template sanitation handler", "error", err)
 	}
 	if len(details) > 0 {
 		vm.Responder.Error(record.INPUT_VALIDATION_FAILED, tableName, w, details)
@@ -98,6 +98,13 @@ func (vm *ValidationMiddleware) validateType(table *database.ReflectedTable, col
 				return "cannot be null", false
 			}
 		}
+		if number, ok := value.(json.Number); ok {
+			switch column.GetType() {
+			case "integer", "bigint", "decimal", "float", "double":
+				return vm.validateNumber(column, number)
+			}
+			value = templateValue(value)
+		}
 		if v, ok := value.(string); ok {
 			switch column.GetType() {
 			// check for whitespace
@@ -119,26 +126,8 @@ func (vm *ValidationMiddleware) validateType(table *database.ReflectedTable, col
 					return "invalid integer", false
 				}
 			case "decimal":
-				var whole, decimals string
-				if strings.Contains(v, ".") {
-					a := strings.SplitN(strings.TrimLeft(v, "-"), ".", 2)
-					whole = a[0]
-					decimals = a[1]
-				} else {
-					whole = strings.TrimLeft(v, "-")
-					decimals = ""
-				}
-				if _, err := strconv.Atoi(whole); err != nil && len(whole) > 0 {
-					return "invalid decimal", false
-				}
-				if _, err := strconv.Atoi(decimals); err != nil && len(decimals) > 0 {
-					return "invalid decimal", false
-				}
-				if len(whole) > column.GetPrecision()-column.GetScale() {
-					return "decimal too large", false
-				}
-				if len(decimals) > column.GetScale() {
-					return "decimal too precise", false
+				if msg, ok := vm.validateDecimal(column, v); !ok {
+					return msg, false
 				}
 			case "float":
 				if _, err := strconv.ParseFloat(v, 32); err != nil {
@@ -234,6 +223,60 @@ func (vm *ValidationMiddleware) validateType(table *database.ReflectedTable, col
 	return "", true
 }
 
+func (vm *ValidationMiddleware) validateDecimal(column *database.ReflectedColumn, v string) (string, bool) {
+	var whole, decimals string
+	if strings.Contains(v, ".") {
+		a := strings.SplitN(strings.TrimLeft(v, "-"), ".", 2)
+		whole = a[0]
+		decimals = a[1]
+	} else {
+		whole = strings.TrimLeft(v, "-")
+		decimals = ""
+	}
+	if _, err := strconv.Atoi(whole); err != nil && len(whole) > 0 {
+		return "invalid decimal", false
+	}
+	if _, err := strconv.Atoi(decimals); err != nil && len(decimals) > 0 {
+		return "invalid decimal", false
+	}
+	if len(whole) > column.GetPrecision()-column.GetScale() {
+		return "decimal too large", false
+	}
+	if len(decimals) > column.GetScale() {
+		return "decimal too precise", false
+	}
+	return "", true
+}
+
+// validateNumber checks a json number against the numeric column type without losing precision
+func (vm *ValidationMiddleware) validateNumber(column *database.ReflectedColumn, number json.Number) (string, bool) {
+	switch column.GetType() {
+	case "integer":
+		if _, err := strconv.ParseInt(number.String(), 10, 32); err != nil {
+			if f, err := number.Float64(); err != nil || f != float64(int32(f)) {
+				return "invalid integer", false
+			}
+		}
+	case "bigint":
+		if _, err := number.Int64(); err != nil {
+			if f, err := number.Float64(); err != nil || f != math.Trunc(f) || math.Abs(f) >= math.MaxInt64 {
+				return "invalid integer", false
+			}
+		}
+	case "decimal":
+		return vm.validateDecimal(column, number.String())
+	case "float":
+		if _, err := strconv.ParseFloat(number.String(), 32); err != nil {
+			return "invalid float", false
+		}
+	case "double":
+		if _, err := number.Float64(); err != nil {
+			return "invalid float", false
+		}
+	}
+	return "", true
+}
+
 func (vm *ValidationMiddleware) Process(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		operation := utils.GetOperation(r)
@@ -2,7 +2,6 @@ package middleware
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/dranih/go-crud-api/pkg/controller"
@@ -47,11 +46,11 @@ func (xm *xmlResponseWriter) Write(b []byte) (int, error) {
 	}
 	dataMap, err := mxj.NewMapJson(b)
 	if err != nil {
-		log.Printf("Error : %s", err.Error())
+		utils.Log.Error(err.Error())
 		return xm.ResponseWriter.Write(b)
 	}
 	if xmlData, err := dataMap.Xml("root"); err != nil {
-		log.Printf("Error : %s", err.Error())
+		utils.Log.Error(err.Error())
 		return xm.ResponseWriter.Write(b)
 	} else {
 		return xm.ResponseWriter.Write(xmlData)
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type Builder interface {
@@ -64,8 +65,18 @@ func (oab *OpenApiBuilder) Build(r *http.Request) *OpenApiDefinition {
 	if !oab.openapi.Has("servers") {
 		oab.openapi.Set("servers|0|url", oab.getServerUrl(r))
 	}
+	oab.setSecuritySchemes()
 	if oab.records != nil {
-		oab.records.Build()
+		// the tables and the columns denied to the user are removed from the reflection of the request
+		records := *oab.records
+		if reflection := database.ReflectionFromContext(r.Context()); reflection != nil {
+			records.reflection = reflection
+		}
+		variables := utils.VariablesFromContext(r.Context())
+		if variables == nil {
+			variables = utils.VStore
+		}
+		records.Build(variables)
 	}
 	if oab.columns != nil {
 		oab.columns.Build()
@@ -78,3 +89,14 @@ func (oab *OpenApiBuilder) Build(r *http.Request) *OpenApiDefinition {
 	}
 	return oab.openapi
 }
+
+// setSecuritySchemes declares the client certificate authentication of the clientCertAuth middleware
+func (oab *OpenApiBuilder) setSecuritySchemes() {
+	if mode, ok := utils.VStore.Get("clientCertAuth.mode").(string); ok {
+		oab.openapi.Set("components|securitySchemes|mutualTLS|type", "mutualTLS")
+		oab.openapi.Set("components|securitySchemes|mutualTLS|description", "Client certificate of the tls connection")
+		if mode == "required" {
+			oab.openapi.Set("security", []map[string][]string{{"mutualTLS": {}}})
+		}
+	}
+}
@@ -78,10 +78,11 @@ func (oarb *OpenApiRecordsBuilder) getAllTableReferences() map[string][]string {
 	return tableReferences
 }
 
-func (oarb *OpenApiRecordsBuilder) Build() {
+// Build sets the paths and the components of the tables, the operations allowed being read from the variables of the request
+func (oarb *OpenApiRecordsBuilder) Build(variables *utils.VariableStore) {
 	tableNames := oarb.reflection.GetTableNames()
 	for _, tableName := range tableNames {
-		oarb.setPath(tableName)
+		oarb.setPath(variables, tableName)
 	}
 	oarb.openapi.Set("components|responses|pk_integer|description", "inserted primary key value (integer)")
 	oarb.openapi.Set("components|responses|pk_integer|content|application/json|schema|type", "integer")
@@ -98,9 +99,9 @@ func (oarb *OpenApiRecordsBuilder) Build() {
 		if _references, exists := tableReferences[tableName]; exists {
 			references = _references
 		}
-		oarb.setComponentSchema(tableName, references)
-		oarb.setComponentResponse(tableName)
-		oarb.setComponentRequestBody(tableName)
+		oarb.setComponentSchema(variables, tableName, references)
+		oarb.setComponentResponse(variables, tableName)
+		oarb.setComponentRequestBody(variables, tableName)
 	}
 	oarb.setComponentParameters()
 	for index, tableName := range tableNames {
@@ -109,8 +110,8 @@ func (oarb *OpenApiRecordsBuilder) Build() {
 }
 
 //Should try to pass a func as a middleware property to see if this works
-func (oarb *OpenApiRecordsBuilder) isOperationOnTableAllowed(operation, tableName string) bool {
-	if tableHandler := utils.VStore.Get("authorization.tableHandler"); tableHandler == nil {
+func (oarb *OpenApiRecordsBuilder) isOperationOnTableAllowed(variables *utils.VariableStore, operation, tableName string) bool {
+	if tableHandler := variables.Get("authorization.tableHandler"); tableHandler == nil {
 		return true
 	} else {
 		if tableHandlerFunc, ok := tableHandler.(func(string, string) bool); ok {
@@ -121,8 +122,8 @@ func (oarb *OpenApiRecordsBuilder) isOperationOnTableAllowed(operation, tableNam
 	}
 }
 
-func (oarb *OpenApiRecordsBuilder) isOperationOnColumnAllowed(operation, tableName, columnName string) bool {
-	if columnHandler := utils.VStore.Get("authorization.columnHandler"); columnHandler == nil {
+func (oarb *OpenApiRecordsBuilder) isOperationOnColumnAllowed(variables *utils.VariableStore, operation, tableName, columnName string) bool {
+	if columnHandler := variables.Get("authorization.columnHandler"); columnHandler == nil {
 		return true
 	} else {
 		if columnHandlerFunc, ok := columnHandler.(func(string, string, string) bool); ok {
@@ -133,7 +134,7 @@ func (oarb *OpenApiRecordsBuilder) isOperationOnColumnAllowed(operation, tableNa
 	}
 }
 
-func (oarb *OpenApiRecordsBuilder) setPath(tableName string) {
+func (oarb *OpenApiRecordsBuilder) setPath(variables *utils.VariableStore, tableName string) {
 	normalizedTableName := oarb.normalize(tableName)
 	table := oarb.reflection.GetTable(tableName)
 	tableType := table.GetType()
@@ -149,7 +150,7 @@ func (oarb *OpenApiRecordsBuilder) setPath(tableName string) {
 		if tableType != "table" && operation != "list" {
 			continue
 		}
-		if !oarb.isOperationOnTableAllowed(operation, tableName) {
+		if !oarb.isOperationOnTableAllowed(variables, operation, tableName) {
 			continue
 		}
 		var parameters []string
@@ -232,7 +233,7 @@ func (oarb *OpenApiRecordsBuilder) getPattern(column *database.ReflectedColumn)
 	return ""
 }
 
-func (oarb *OpenApiRecordsBuilder) setComponentSchema(tableName string, references []string) {
+func (oarb *OpenApiRecordsBuilder) setComponentSchema(variables *utils.VariableStore, tableName string, references []string) {
 	normalizedTableName := oarb.normalize(tableName)
 	table := oarb.reflection.GetTable(tableName)
 	tableType := table.GetType()
@@ -251,7 +252,7 @@ func (oarb *OpenApiRecordsBuilder) setComponentSchema(tableName string, referenc
 		if tableType == "view" && pkName == "" && operation == "read" {
 			continue
 		}
-		if !oarb.isOperationOnTableAllowed(operation, tableName) {
+		if !oarb.isOperationOnTableAllowed(variables, operation, tableName) {
 			continue
 		}
 
@@ -267,8 +268,9 @@ func (oarb *OpenApiRecordsBuilder) setComponentSchema(tableName string, referenc
 				prefix = fmt.Sprintf("components|schemas|%s-%s", operation, normalizedTableName)
 			}
 			oarb.openapi.Set(fmt.Sprintf("%s|type", prefix), "object")
+			autoColumns := oarb.reflection.GetAutoColumns(tableName)
 			for _, columnName := range table.GetColumnNames() {
-				if !oarb.isOperationOnColumnAllowed(operation, tableName, columnName) {
+				if !oarb.isOperationOnColumnAllowed(variables, operation, tableName, columnName) {
 					continue
 				}
 				column := table.GetColumn(columnName)
@@ -292,12 +294,15 @@ func (oarb *OpenApiRecordsBuilder) setComponentSchema(tableName string, referenc
 				if fk := column.GetFk(); fk != "" {
 					oarb.openapi.Set(fmt.Sprintf("%s|properties|%s|x-references", prefix, columnName), fk)
 				}
+				if _, exists := autoColumns[columnName]; exists {
+					oarb.openapi.Set(fmt.Sprintf("%s|properties|%s|readOnly", prefix, columnName), true)
+				}
 			}
 		}
 	}
 }
 
-func (oarb *OpenApiRecordsBuilder) setComponentResponse(tableName string) {
+func (oarb *OpenApiRecordsBuilder) setComponentResponse(variables *utils.VariableStore, tableName string) {
 	normalizedTableName := oarb.normalize(tableName)
 	table := oarb.reflection.GetTable(tableName)
 	tableType := table.GetType()
@@ -313,7 +318,7 @@ func (oarb *OpenApiRecordsBuilder) setComponentResponse(tableName string) {
 		if tableType != "table" && operation != "list" {
 			continue
 		}
-		if !oarb.isOperationOnTableAllowed(operation, tableName) {
+		if !oarb.isOperationOnTableAllowed(variables, operation, tableName) {
 			continue
 		}
 
@@ -328,7 +333,7 @@ func (oarb *OpenApiRecordsBuilder) setComponentResponse(tableName string) {
 	}
 }
 
-func (oarb *OpenApiRecordsBuilder) setComponentRequestBody(tableName string) {
+func (oarb *OpenApiRecordsBuilder) setComponentRequestBody(variables *utils.VariableStore, tableName string) {
 	normalizedTableName := oarb.normalize(tableName)
 	table := oarb.reflection.GetTable(tableName)
 	tableType := table.GetType()
@@ -336,7 +341,7 @@ func (oarb *OpenApiRecordsBuilder) setComponentRequestBody(tableName string) {
 	if pk != nil {
 		if pkName := pk.GetName(); pkName != "" && tableType == "table" {
 			for operation := range map[string]bool{"create": true, "update": true, "increment": true} {
-				if !oarb.isOperationOnTableAllowed(operation, tableName) {
+				if !oarb.isOperationOnTableAllowed(variables, operation, tableName) {
 					continue
 				}
 				oarb.openapi.Set(fmt.Sprintf("components|requestBodies|%s-%s|description", operation, normalizedTableName), fmt.Sprintf("single %s record", tableName))
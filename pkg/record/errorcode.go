@@ -21,6 +21,7 @@ const METHOD_NOT_ALLOWED = 405
 const CONFLICT = 409
 const UNPROCESSABLE_ENTITY = 422
 const FAILED_DEPENDENCY = 424
+const TOO_MANY_REQUESTS = 429
 const INTERNAL_SERVER_ERROR = 500
 
 const ERROR_NOT_FOUND = 9999
@@ -46,6 +47,11 @@ const ONLY_AJAX_REQUESTS_ALLOWED = 1018
 const PAGINATION_FORBIDDEN = 1019
 const USER_ALREADY_EXIST = 1020
 const PASSWORD_TOO_SHORT = 1021
+const FOREIGN_KEY_VIOLATION = 1022
+const NOT_NULL_VIOLATION = 1023
+const CHECK_VIOLATION = 1024
+const VALUE_TOO_LONG = 1025
+const RATE_LIMIT_EXCEEDED = 1026
 
 func NewErrorCode(code int) *ErrorCode {
 	values := map[int][]interface{}{
@@ -72,6 +78,11 @@ func NewErrorCode(code int) *ErrorCode {
 		1019: {"Pagination forbidden", FORBIDDEN},
 		1020: {"User '%s' already exists", CONFLICT},
 		1021: {"Password too short (<%s characters)", UNPROCESSABLE_ENTITY},
+		1022: {"Foreign key violation", CONFLICT},
+		1023: {"Not null violation", UNPROCESSABLE_ENTITY},
+		1024: {"Check violation", UNPROCESSABLE_ENTITY},
+		1025: {"Value too long", UNPROCESSABLE_ENTITY},
+		1026: {"Rate limit exceeded", TOO_MANY_REQUESTS},
 		9999: {"%s", INTERNAL_SERVER_ERROR},
 	}
 	if _, b := values[code]; !b {
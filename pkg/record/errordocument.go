@@ -2,22 +2,22 @@ package record
 
 import (
 	"encoding/json"
-	"strings"
-
-	mssql "github.com/denisenkom/go-mssqldb"
-	"github.com/go-sql-driver/mysql"
-	"github.com/lib/pq"
-	"github.com/mattn/go-sqlite3"
 )
 
 type ErrorDocument struct {
 	errorCode *ErrorCode
 	argument  string
 	details   interface{}
+	requestId string
 }
 
 func NewErrorDocument(errorCode *ErrorCode, argument string, details interface{}) *ErrorDocument {
-	return &ErrorDocument{errorCode, argument, details}
+	return &ErrorDocument{errorCode, argument, details, ""}
+}
+
+// SetRequestId adds the id of the request to the document, to find it in the logs
+func (ed *ErrorDocument) SetRequestId(requestId string) {
+	ed.requestId = requestId
 }
 
 func (ed *ErrorDocument) GetStatus() int {
@@ -33,14 +33,16 @@ func (ed *ErrorDocument) GetMessage() string {
 }
 
 func (ed *ErrorDocument) Serialize() map[string]interface{} {
-	if ed.details == nil || ed.details == "" {
-		return map[string]interface{}{"code": ed.GetCode(),
-			"message": ed.GetMessage(),
-		}
-	}
-	return map[string]interface{}{"code": ed.GetCode(),
+	document := map[string]interface{}{"code": ed.GetCode(),
 		"message": ed.GetMessage(),
-		"details": ed.details}
+	}
+	if ed.details != nil && ed.details != "" {
+		document["details"] = ed.details
+	}
+	if ed.requestId != "" {
+		document["requestId"] = ed.requestId
+	}
+	return document
 }
 
 // json marshaling for struct ErrorDocument
@@ -49,25 +51,15 @@ func (ed *ErrorDocument) MarshalJSON() ([]byte, error) {
 }
 
 func NewErrorDocumentFromError(err error, debug bool) *ErrorDocument {
-	switch err.(type) {
-	case sqlite3.Error, *pq.Error, *mysql.MySQLError, mssql.Error:
-		if strings.Contains(strings.ToLower(err.Error()), "duplicate") {
-			return NewErrorDocument(NewErrorCode(DUPLICATE_KEY_EXCEPTION), "", "")
-		} else if strings.Contains(strings.ToLower(err.Error()), "unique constraint") {
-			return NewErrorDocument(NewErrorCode(DUPLICATE_KEY_EXCEPTION), "", "")
-		} else if strings.Contains(strings.ToLower(err.Error()), "default value") {
-			return NewErrorDocument(NewErrorCode(DATA_INTEGRITY_VIOLATION), "", "")
-		} else if strings.Contains(strings.ToLower(err.Error()), "allow nulls") {
-			return NewErrorDocument(NewErrorCode(DATA_INTEGRITY_VIOLATION), "", "")
-		} else if strings.Contains(strings.ToLower(err.Error()), "constraint") {
-			return NewErrorDocument(NewErrorCode(DATA_INTEGRITY_VIOLATION), "", "")
-		} else {
-			message := "SQL exception occurred (enable debug mode)"
-			if debug {
-				message = err.Error()
-			}
-			return NewErrorDocument(NewErrorCode(ERROR_NOT_FOUND), message, "")
+	if se := classifySqlError(err); se != nil {
+		return NewErrorDocument(NewErrorCode(se.code), "", se.details())
+	}
+	if isSqlDriverError(err) {
+		message := "SQL exception occurred (enable debug mode)"
+		if debug {
+			message = err.Error()
 		}
+		return NewErrorDocument(NewErrorCode(ERROR_NOT_FOUND), message, "")
 	}
 	return NewErrorDocument(NewErrorCode(ERROR_NOT_FOUND), err.Error(), "")
 }
@@ -3,7 +3,8 @@ package record
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type ListDocument struct {
@@ -33,7 +34,7 @@ func (l *ListDocument) JsonSerialize() string {
 	});*/
 	data, err := json.Marshal(l.Serialize())
 	if err != nil {
-		log.Printf("Marshaling error : %v", err)
+		utils.Log.Error("unable to marshal the list document", "error", err)
 	}
 	return string(data)
 }
@@ -1,9 +1,10 @@
 package record
 
 import (
-	"log"
 	"strconv"
 	"strings"
+
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type PaginationInfo struct{}
@@ -23,7 +24,7 @@ func (pi *PaginationInfo) GetPageOffset(params map[string][]string) int {
 			parts := strings.SplitN(page, ",", 2)
 			parts_int, err := strconv.Atoi(parts[0])
 			if err != nil {
-				log.Printf("Error converting page parameter %v to int\n", parts[0])
+				utils.Log.Warning("invalid page parameter", "page", parts[0])
 				return offset
 			}
 			offset = (parts_int - 1) * pageSize
@@ -41,7 +42,7 @@ func (pi *PaginationInfo) getPageSize(params map[string][]string) int {
 				var err error
 				pageSize, err = strconv.Atoi(parts[1])
 				if err != nil {
-					log.Printf("Error converting page parameter %v to int\n", parts[1])
+					utils.Log.Warning("invalid page size parameter", "size", parts[1])
 					return DEFAULT_PAGE_SIZE
 				}
 			}
@@ -57,7 +58,7 @@ func (pi *PaginationInfo) GetResultSize(params map[string][]string) int {
 			var err error
 			numberOfRows, err = strconv.Atoi(size)
 			if err != nil {
-				log.Printf("Error converting size parameter %v to int\n", size)
+				utils.Log.Warning("invalid size parameter", "size", size)
 				return -1
 			}
 		}
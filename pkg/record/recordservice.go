@@ -1,11 +1,13 @@
 package record
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/dranih/go-crud-api/pkg/utils"
 )
 
 type RecordService struct {
@@ -16,11 +18,35 @@ type RecordService struct {
 	filters    *FilterInfo
 	ordering   *OrderingInfo
 	pagination *PaginationInfo
+	ctx        context.Context
 }
 
 func NewRecordService(db *database.GenericDB, reflection *database.ReflectionService) *RecordService {
 	ci := &database.ColumnIncluder{}
-	return &RecordService{db, reflection, ci, NewRelationJoiner(reflection, ci), &FilterInfo{}, &OrderingInfo{}, &PaginationInfo{}}
+	return &RecordService{db, reflection, ci, NewRelationJoiner(reflection, ci), &FilterInfo{}, &OrderingInfo{}, &PaginationInfo{}, context.Background()}
+}
+
+// WithContext returns a copy of the service whose operations are traced as children of the span of the context,
+// logged with its request id and restricted by the variables and the reflection of the request, the service itself when the context has none
+func (rs *RecordService) WithContext(ctx context.Context) *RecordService {
+	reflection := database.ReflectionFromContext(ctx)
+	if !utils.SpanContextFromContext(ctx).Sampled && utils.RequestIdFromContext(ctx) == "" && utils.VariablesFromContext(ctx) == nil && reflection == nil {
+		return rs
+	}
+	scoped := *rs
+	scoped.ctx = ctx
+	scoped.db = rs.db.WithContext(ctx)
+	if reflection != nil {
+		scoped.reflection = reflection
+		scoped.joiner = NewRelationJoiner(reflection, rs.columns)
+	}
+	return &scoped
+}
+
+// startSpan starts the span of an operation, the returned service being scoped to the span
+func (rs *RecordService) startSpan(operation, tableName string) (*RecordService, *utils.Span) {
+	ctx, span := utils.StartSpan(rs.ctx, "RecordService."+operation, utils.SpanKindInternal, "table", tableName)
+	return rs.WithContext(ctx), span
 }
 
 func (rs *RecordService) sanitizeRecord(tableName string, record interface{}, id string) map[string]interface{} {
@@ -43,7 +69,7 @@ func (rs *RecordService) sanitizeRecord(tableName string, record interface{}, id
 		}
 		return recordMap
 	} else {
-		log.Printf("Unable to assert record type : %T\n", record)
+		utils.Log.Error("unable to assert the record type", "type", fmt.Sprintf("%T", record))
 	}
 	return recordMap
 }
@@ -60,6 +86,14 @@ func (rs *RecordService) BeginTransaction() (*sql.Tx, error) {
 	return rs.db.BeginTransaction()
 }
 
+func (rs *RecordService) GetTransactionRetries() (int, time.Duration) {
+	return rs.db.GetTransactionRetries()
+}
+
+func (rs *RecordService) IsRetryableError(err error) bool {
+	return database.IsRetryableError(err)
+}
+
 func (rs *RecordService) CommitTransaction(tx *sql.Tx) error {
 	return rs.db.CommitTransaction(tx)
 }
@@ -69,13 +103,18 @@ func (rs *RecordService) RollBackTransaction(tx *sql.Tx) error {
 }
 
 func (rs *RecordService) Create(tx *sql.Tx, tableName string, params map[string][]string, record ...interface{}) (interface{}, error) {
+	rs, span := rs.startSpan("Create", tableName)
+	defer span.Finish()
 	recordMap := rs.sanitizeRecord(tableName, record[0], "")
 	table := rs.reflection.GetTable(tableName)
 	columnValues := rs.columns.GetValues(table, true, recordMap, params)
-	return rs.db.CreateSingle(tx, table, columnValues)
+	result, err := rs.db.CreateSingle(tx, table, columnValues)
+	return result, wrapTableError(table, err)
 }
 
 func (rs *RecordService) Read(tx *sql.Tx, tableName string, params map[string][]string, id ...interface{}) (interface{}, error) {
+	rs, span := rs.startSpan("Read", tableName)
+	defer span.Finish()
 	table := rs.reflection.GetTable(tableName)
 	rs.joiner.AddMandatoryColumns(table, &params)
 	columnNames := rs.columns.GetNames(table, true, params)
@@ -88,6 +127,8 @@ func (rs *RecordService) Read(tx *sql.Tx, tableName string, params map[string][]
 }
 
 func (rs *RecordService) Update(tx *sql.Tx, tableName string, params map[string][]string, args ...interface{}) (interface{}, error) {
+	rs, span := rs.startSpan("Update", tableName)
+	defer span.Finish()
 	if len(args) < 2 {
 		return 0, fmt.Errorf("not enought arguments : %v", args)
 	}
@@ -96,15 +137,21 @@ func (rs *RecordService) Update(tx *sql.Tx, tableName string, params map[string]
 	recordMap := rs.sanitizeRecord(tableName, record, id)
 	table := rs.reflection.GetTable(tableName)
 	columnValues := rs.columns.GetValues(table, true, recordMap, params)
-	return rs.db.UpdateSingle(tx, table, columnValues, id)
+	result, err := rs.db.UpdateSingle(tx, table, columnValues, id)
+	return result, wrapTableError(table, err)
 }
 
 func (rs *RecordService) Delete(tx *sql.Tx, tableName string, params map[string][]string, args ...interface{}) (interface{}, error) {
+	rs, span := rs.startSpan("Delete", tableName)
+	defer span.Finish()
 	table := rs.reflection.GetTable(tableName)
-	return rs.db.DeleteSingle(tx, table, fmt.Sprint(args[0]))
+	result, err := rs.db.DeleteSingle(tx, table, fmt.Sprint(args[0]))
+	return result, wrapTableError(table, err)
 }
 
 func (rs *RecordService) Increment(tx *sql.Tx, tableName string, params map[string][]string, args ...interface{}) (interface{}, error) {
+	rs, span := rs.startSpan("Increment", tableName)
+	defer span.Finish()
 	if len(args) < 2 {
 		return 0, fmt.Errorf("not enought arguments : %v", args)
 	}
@@ -113,11 +160,14 @@ func (rs *RecordService) Increment(tx *sql.Tx, tableName string, params map[stri
 	recordMap := rs.sanitizeRecord(tableName, record, id)
 	table := rs.reflection.GetTable(tableName)
 	columnValues := rs.columns.GetValues(table, true, recordMap, params)
-	return rs.db.IncrementSingle(tx, table, columnValues, id)
+	result, err := rs.db.IncrementSingle(tx, table, columnValues, id)
+	return result, wrapTableError(table, err)
 }
 
 // done
 func (rs *RecordService) List(tableName string, params map[string][]string) *ListDocument {
+	rs, span := rs.startSpan("List", tableName)
+	defer span.Finish()
 	table := rs.reflection.GetTable(tableName)
 	rs.joiner.AddMandatoryColumns(table, &params)
 	columnNames := rs.columns.GetNames(table, true, params)
@@ -0,0 +1,254 @@
+package record
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/dranih/go-crud-api/pkg/database"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	mysqlKeyRegexp        = regexp.MustCompile("for key '([^']+)'")
+	mysqlColumnRegexp     = regexp.MustCompile("(?:Column|Field|column) '([^']+)'")
+	mysqlConstraintRegexp = regexp.MustCompile("CONSTRAINT `([^`]+)` FOREIGN KEY \\(`([^`]+)`\\)")
+	mysqlCheckRegexp      = regexp.MustCompile("Check constraint '([^']+)'")
+	pgsqlKeyRegexp        = regexp.MustCompile(`Key \(([^)]+)\)`)
+	sqliteColumnRegexp    = regexp.MustCompile(`constraint failed: (.+)`)
+	sqlsrvConstraintRegex = regexp.MustCompile(`constraint '([^']+)'|constraint "([^"]+)"|unique index '([^']+)'`)
+	sqlsrvColumnRegexp    = regexp.MustCompile(`column '([^']+)'`)
+)
+
+// sqlError is a database error classified from the native driver error code
+type sqlError struct {
+	code       int
+	constraint string
+	columns    []string
+}
+
+// details returns the constraint and the column (or the columns of a composite key) involved in the error,
+// nil if none is known
+func (se *sqlError) details() interface{} {
+	details := map[string]interface{}{}
+	if se.constraint != "" {
+		details["constraint"] = se.constraint
+	}
+	if len(se.columns) == 1 {
+		details["column"] = se.columns[0]
+	} else if len(se.columns) > 1 {
+		details["columns"] = se.columns
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	return details
+}
+
+// tableError keeps the table a database error occurred on, to report the mapped column names
+type tableError struct {
+	table *database.ReflectedTable
+	err   error
+}
+
+func (te *tableError) Error() string {
+	return te.err.Error()
+}
+
+func (te *tableError) Unwrap() error {
+	return te.err
+}
+
+// wrapTableError returns err annotated with the table it occurred on, nil if err is nil
+func wrapTableError(table *database.ReflectedTable, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &tableError{table, err}
+}
+
+// columnName returns the name of the column whose real name is realName
+func (te *tableError) columnName(realName string) string {
+	for _, columnName := range te.table.GetColumnNames() {
+		if te.table.GetColumn(columnName).GetRealName() == realName {
+			return columnName
+		}
+	}
+	return realName
+}
+
+// classifySqlError maps the native error code of the mysql, pgsql, sqlsrv and sqlite drivers to an api error code
+// Returns nil if err is not a driver error or if the code is not a known integrity error
+func classifySqlError(err error) *sqlError {
+	se := classifyDriverError(err)
+	var te *tableError
+	if se != nil && errors.As(err, &te) {
+		for i, column := range se.columns {
+			se.columns[i] = te.columnName(column)
+		}
+	}
+	return se
+}
+
+func classifyDriverError(err error) *sqlError {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return classifyMysqlError(mysqlErr)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return classifyPgsqlError(pqErr)
+	}
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return classifySqlsrvError(mssqlErr)
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return classifySqliteError(sqliteErr)
+	}
+	return nil
+}
+
+func isSqlDriverError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	var pqErr *pq.Error
+	var mssqlErr mssql.Error
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &mysqlErr) || errors.As(err, &pqErr) || errors.As(err, &mssqlErr) || errors.As(err, &sqliteErr)
+}
+
+func classifyMysqlError(err *mysql.MySQLError) *sqlError {
+	switch err.Number {
+	// ER_DUP_ENTRY
+	case 1062:
+		return &sqlError{DUPLICATE_KEY_EXCEPTION, firstSubmatch(mysqlKeyRegexp, err.Message), nil}
+	// ER_ROW_IS_REFERENCED_2, ER_NO_REFERENCED_ROW_2
+	case 1451, 1452:
+		se := &sqlError{code: FOREIGN_KEY_VIOLATION}
+		if matches := mysqlConstraintRegexp.FindStringSubmatch(err.Message); matches != nil {
+			se.constraint, se.columns = matches[1], splitColumns(matches[2])
+		}
+		return se
+	// ER_BAD_NULL_ERROR, ER_NO_DEFAULT_FOR_FIELD
+	case 1048, 1364:
+		return &sqlError{NOT_NULL_VIOLATION, "", splitColumns(firstSubmatch(mysqlColumnRegexp, err.Message))}
+	// ER_CHECK_CONSTRAINT_VIOLATED
+	case 3819:
+		return &sqlError{CHECK_VIOLATION, firstSubmatch(mysqlCheckRegexp, err.Message), nil}
+	// ER_DATA_TOO_LONG
+	case 1406:
+		return &sqlError{VALUE_TOO_LONG, "", splitColumns(firstSubmatch(mysqlColumnRegexp, err.Message))}
+	// ER_ROW_IS_REFERENCED, ER_NO_REFERENCED_ROW, ER_WARN_DATA_OUT_OF_RANGE
+	case 1216, 1217, 1264:
+		return &sqlError{DATA_INTEGRITY_VIOLATION, "", splitColumns(firstSubmatch(mysqlColumnRegexp, err.Message))}
+	}
+	return nil
+}
+
+func classifyPgsqlError(err *pq.Error) *sqlError {
+	column := err.Column
+	if column == "" {
+		// composite keys are reported as "Key (a, b)=(1, 2)", the names needing it being quoted
+		column = strings.Replace(firstSubmatch(pgsqlKeyRegexp, err.Detail), `"`, "", -1)
+	}
+	columns := splitColumns(column)
+	switch err.Code {
+	// unique_violation
+	case "23505":
+		return &sqlError{DUPLICATE_KEY_EXCEPTION, err.Constraint, columns}
+	// foreign_key_violation
+	case "23503":
+		return &sqlError{FOREIGN_KEY_VIOLATION, err.Constraint, columns}
+	// not_null_violation
+	case "23502":
+		return &sqlError{NOT_NULL_VIOLATION, err.Constraint, columns}
+	// check_violation
+	case "23514":
+		return &sqlError{CHECK_VIOLATION, err.Constraint, columns}
+	// string_data_right_truncation
+	case "22001":
+		return &sqlError{VALUE_TOO_LONG, err.Constraint, columns}
+	}
+	// integrity_constraint_violation class
+	if err.Code.Class() == "23" {
+		return &sqlError{DATA_INTEGRITY_VIOLATION, err.Constraint, columns}
+	}
+	return nil
+}
+
+func classifySqlsrvError(err mssql.Error) *sqlError {
+	constraint := firstSubmatch(sqlsrvConstraintRegex, err.Message)
+	switch err.Number {
+	// unique constraint and unique index violations
+	case 2627, 2601:
+		return &sqlError{DUPLICATE_KEY_EXCEPTION, constraint, nil}
+	// foreign key or check constraint conflict, the column named in the message is the referenced one
+	case 547:
+		if strings.Contains(err.Message, "CHECK constraint") {
+			return &sqlError{CHECK_VIOLATION, constraint, nil}
+		}
+		return &sqlError{FOREIGN_KEY_VIOLATION, constraint, nil}
+	// cannot insert the value NULL into column
+	case 515:
+		return &sqlError{NOT_NULL_VIOLATION, "", splitColumns(firstSubmatch(sqlsrvColumnRegexp, err.Message))}
+	// string or binary data would be truncated
+	case 8152, 2628:
+		return &sqlError{VALUE_TOO_LONG, "", splitColumns(firstSubmatch(sqlsrvColumnRegexp, err.Message))}
+	}
+	return nil
+}
+
+func classifySqliteError(err sqlite3.Error) *sqlError {
+	// sqlite names the failing columns as "table.a, table.b" or the check constraint by its name
+	failed := strings.TrimSpace(firstSubmatch(sqliteColumnRegexp, err.Error()))
+	columns := splitColumns(failed)
+	for i, column := range columns {
+		if j := strings.Index(column, "."); j >= 0 {
+			columns[i] = column[j+1:]
+		}
+	}
+	switch err.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return &sqlError{DUPLICATE_KEY_EXCEPTION, "", columns}
+	case sqlite3.ErrConstraintForeignKey:
+		return &sqlError{FOREIGN_KEY_VIOLATION, "", nil}
+	case sqlite3.ErrConstraintNotNull:
+		return &sqlError{NOT_NULL_VIOLATION, "", columns}
+	case sqlite3.ErrConstraintCheck:
+		return &sqlError{CHECK_VIOLATION, failed, nil}
+	}
+	switch err.Code {
+	case sqlite3.ErrTooBig:
+		return &sqlError{VALUE_TOO_LONG, "", nil}
+	case sqlite3.ErrConstraint:
+		return &sqlError{DATA_INTEGRITY_VIOLATION, "", nil}
+	}
+	return nil
+}
+
+// splitColumns returns the columns of a comma separated list, nil if empty
+func splitColumns(list string) []string {
+	if list == "" {
+		return nil
+	}
+	columns := strings.Split(list, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+	return columns
+}
+
+// firstSubmatch returns the first non empty group matched by re in s
+func firstSubmatch(re *regexp.Regexp, s string) string {
+	matches := re.FindStringSubmatch(s)
+	for i := 1; i < len(matches); i++ {
+		if matches[i] != "" {
+			return matches[i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package record
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestClassifySqlError(t *testing.T) {
+	tt := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"mysql duplicate entry", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'user1' for key 'users.username_unique'"},
+			`{"code":1009,"details":{"constraint":"users.username_unique"},"message":"Duplicate key exception"}`},
+		{"mysql foreign key", &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`db`.`posts`, CONSTRAINT `posts_user_id_fkey` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"},
+			`{"code":1022,"details":{"column":"user_id","constraint":"posts_user_id_fkey"},"message":"Foreign key violation"}`},
+		{"mysql not null", &mysql.MySQLError{Number: 1048, Message: "Column 'name' cannot be null"},
+			`{"code":1023,"details":{"column":"name"},"message":"Not null violation"}`},
+		{"mysql check", &mysql.MySQLError{Number: 3819, Message: "Check constraint 'price_positive' is violated."},
+			`{"code":1024,"details":{"constraint":"price_positive"},"message":"Check violation"}`},
+		{"mysql too long", &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'name' at row 1"},
+			`{"code":1025,"details":{"column":"name"},"message":"Value too long"}`},
+		{"mysql out of range", &mysql.MySQLError{Number: 1264, Message: "Out of range value for column 'id' at row 1"},
+			`{"code":1010,"details":{"column":"id"},"message":"Data integrity violation"}`},
+		{"pgsql unique", &pq.Error{Code: "23505", Constraint: "users_username_key", Detail: "Key (username)=(user1) already exists."},
+			`{"code":1009,"details":{"column":"username","constraint":"users_username_key"},"message":"Duplicate key exception"}`},
+		{"pgsql composite unique", &pq.Error{Code: "23505", Constraint: "tags_a_b_key", Detail: "Key (a, b)=(1, 2) already exists."},
+			`{"code":1009,"details":{"columns":["a","b"],"constraint":"tags_a_b_key"},"message":"Duplicate key exception"}`},
+		{"pgsql quoted unique", &pq.Error{Code: "23505", Constraint: "tags_name_uc", Detail: "Key (\"Tag name\")=(go) already exists."},
+			`{"code":1009,"details":{"column":"Tag name","constraint":"tags_name_uc"},"message":"Duplicate key exception"}`},
+		{"pgsql foreign key", &pq.Error{Code: "23503", Constraint: "posts_user_id_fkey", Detail: "Key (user_id)=(99) is not present in table \"users\"."},
+			`{"code":1022,"details":{"column":"user_id","constraint":"posts_user_id_fkey"},"message":"Foreign key violation"}`},
+		{"pgsql not null", &pq.Error{Code: "23502", Column: "name"},
+			`{"code":1023,"details":{"column":"name"},"message":"Not null violation"}`},
+		{"pgsql check", &pq.Error{Code: "23514", Constraint: "price_positive"},
+			`{"code":1024,"details":{"constraint":"price_positive"},"message":"Check violation"}`},
+		{"pgsql too long", &pq.Error{Code: "22001"},
+			`{"code":1025,"message":"Value too long"}`},
+		{"pgsql exclusion", &pq.Error{Code: "23P01", Constraint: "no_overlap"},
+			`{"code":1010,"details":{"constraint":"no_overlap"},"message":"Data integrity violation"}`},
+		{"sqlsrv unique", mssql.Error{Number: 2627, Message: "Violation of UNIQUE KEY constraint 'UQ_users_username'. Cannot insert duplicate key in object 'dbo.users'."},
+			`{"code":1009,"details":{"constraint":"UQ_users_username"},"message":"Duplicate key exception"}`},
+		{"sqlsrv unique index", mssql.Error{Number: 2601, Message: "Cannot insert duplicate key row in object 'dbo.users' with unique index 'IX_users_username'."},
+			`{"code":1009,"details":{"constraint":"IX_users_username"},"message":"Duplicate key exception"}`},
+		{"sqlsrv foreign key", mssql.Error{Number: 547, Message: "The INSERT statement conflicted with the FOREIGN KEY constraint \"FK_posts_users\"."},
+			`{"code":1022,"details":{"constraint":"FK_posts_users"},"message":"Foreign key violation"}`},
+		{"sqlsrv check", mssql.Error{Number: 547, Message: "The INSERT statement conflicted with the CHECK constraint \"CK_price_positive\"."},
+			`{"code":1024,"details":{"constraint":"CK_price_positive"},"message":"Check violation"}`},
+		{"sqlsrv not null", mssql.Error{Number: 515, Message: "Cannot insert the value NULL into column 'name', table 'db.dbo.users'; column does not allow nulls. INSERT fails."},
+			`{"code":1023,"details":{"column":"name"},"message":"Not null violation"}`},
+		{"sqlsrv too long", mssql.Error{Number: 2628, Message: "String or binary data would be truncated in table 'db.dbo.users', column 'name'."},
+			`{"code":1025,"details":{"column":"name"},"message":"Value too long"}`},
+	}
+	for _, tc := range tt {
+		if got := errorDocumentJson(t, tc.err); got != tc.want {
+			t.Errorf("%s : got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestClassifySqliteCompositeKey(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE tags (a integer, b integer, UNIQUE (a, b))`); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(`INSERT INTO tags VALUES (1, 2)`)
+	_, err = db.Exec(`INSERT INTO tags VALUES (1, 2)`)
+	want := `{"code":1009,"details":{"columns":["a","b"]},"message":"Duplicate key exception"}`
+	if got := errorDocumentJson(t, err); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func errorDocumentJson(t *testing.T, err error) string {
+	data, jsonErr := json.Marshal(NewErrorDocumentFromError(err, false))
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	return string(data)
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels
+const (
+	LogLevelDebug = iota
+	LogLevelInfo
+	LogLevelWarning
+	LogLevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warning", "error"}
+
+// Log is the logger of the api, the standard logger being redirected to it by SetLogger
+var Log = NewLogger(os.Stderr, "text", LogLevelInfo)
+
+// Logger writes the records as "json" or "logfmt" lines, or as "text" like the standard logger.
+// The fields of a record are given as key and value pairs, after the message.
+type Logger struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	format string
+	level  int
+}
+
+func NewLogger(writer io.Writer, format string, level int) *Logger {
+	return &Logger{writer: writer, format: strings.ToLower(format), level: level}
+}
+
+// ParseLogLevel returns the level named "debug", "info", "warning" or "error", info if unknown
+func ParseLogLevel(name string) int {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LogLevelDebug
+	case "warning", "warn":
+		return LogLevelWarning
+	case "error":
+		return LogLevelError
+	}
+	return LogLevelInfo
+}
+
+// SetLogger sets the logger of the api and redirects the standard logger to it,
+// so that the messages of the libraries are structured too
+func SetLogger(logger *Logger) {
+	Log = logger
+	log.SetFlags(0)
+	log.SetOutput(logger)
+}
+
+func (l *Logger) Debug(message string, fields ...interface{}) {
+	l.Log(LogLevelDebug, message, fields...)
+}
+
+func (l *Logger) Info(message string, fields ...interface{}) {
+	l.Log(LogLevelInfo, message, fields...)
+}
+
+func (l *Logger) Warning(message string, fields ...interface{}) {
+	l.Log(LogLevelWarning, message, fields...)
+}
+
+func (l *Logger) Error(message string, fields ...interface{}) {
+	l.Log(LogLevelError, message, fields...)
+}
+
+// Fatal writes an error record and exits
+func (l *Logger) Fatal(message string, fields ...interface{}) {
+	l.Log(LogLevelError, message, fields...)
+	os.Exit(1)
+}
+
+// Log writes a record if its level is enabled
+func (l *Logger) Log(level int, message string, fields ...interface{}) {
+	if level < l.level {
+		return
+	}
+	var buffer bytes.Buffer
+	now := time.Now()
+	switch l.format {
+	case "json":
+		buffer.WriteString(`{"time":`)
+		writeJsonValue(&buffer, now.Format(time.RFC3339Nano))
+		buffer.WriteString(`,"level":`)
+		writeJsonValue(&buffer, logLevelNames[level])
+		buffer.WriteString(`,"msg":`)
+		writeJsonValue(&buffer, message)
+		for i := 0; i+1 < len(fields); i += 2 {
+			buffer.WriteString(",")
+			writeJsonValue(&buffer, fmt.Sprint(fields[i]))
+			buffer.WriteString(":")
+			writeJsonValue(&buffer, fields[i+1])
+		}
+		buffer.WriteString("}\n")
+	case "logfmt":
+		fmt.Fprintf(&buffer, "time=%s level=%s msg=%s", now.Format(time.RFC3339Nano), logLevelNames[level], logfmtValue(message))
+		for i := 0; i+1 < len(fields); i += 2 {
+			fmt.Fprintf(&buffer, " %s=%s", fields[i], logfmtValue(fields[i+1]))
+		}
+		buffer.WriteString("\n")
+	default:
+		buffer.WriteString(now.Format("2006/01/02 15:04:05 "))
+		buffer.WriteString([]string{"Debug : ", "", "Warning : ", "Error : "}[level])
+		buffer.WriteString(message)
+		for i := 0; i+1 < len(fields); i += 2 {
+			fmt.Fprintf(&buffer, " %s=%s", fields[i], logfmtValue(fields[i+1]))
+		}
+		buffer.WriteString("\n")
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.writer.Write(buffer.Bytes())
+}
+
+// Write receives the messages of the standard logger, only used by the libraries, at the warning level
+func (l *Logger) Write(p []byte) (int, error) {
+	l.Log(LogLevelWarning, strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+func writeJsonValue(buffer *bytes.Buffer, value interface{}) {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		content, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buffer.Write(content)
+}
+
+// logfmtValue quotes the values holding spaces, quotes or equal signs
+func logfmtValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if text == "" || strings.ContainsAny(text, " \t\r\n\"=") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+type requestIdKey struct{}
+
+// ContextWithRequestId returns a context holding the id of the request, added to the records of its queries
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestIdFromContext returns the id of the request, "" if none
+func RequestIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
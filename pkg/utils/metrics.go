@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics is the registry of the metrics exposed by the status controller
+var Metrics = NewMetricsRegistry()
+
+// DefaultBuckets are the upper bounds in seconds of the duration histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// MetricsRegistry holds counters, gauges and histograms by name and labels, written in the Prometheus text format
+type MetricsRegistry struct {
+	mutex    sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	kind    string
+	help    string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{families: map[string]*metricFamily{}}
+}
+
+// AddCounter increments a counter, labels are given as name and value pairs
+func (mr *MetricsRegistry) AddCounter(name, help string, value float64, labels ...string) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	mr.getSeries(name, "counter", help, nil, labels).value += value
+}
+
+// SetCounter sets the total of a counter maintained elsewhere (ex : the wait count of the database pool)
+func (mr *MetricsRegistry) SetCounter(name, help string, value float64, labels ...string) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	mr.getSeries(name, "counter", help, nil, labels).value = value
+}
+
+// SetGauge sets the current value of a gauge
+func (mr *MetricsRegistry) SetGauge(name, help string, value float64, labels ...string) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	mr.getSeries(name, "gauge", help, nil, labels).value = value
+}
+
+// Observe adds a value to a histogram with the default buckets
+func (mr *MetricsRegistry) Observe(name, help string, value float64, labels ...string) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	series := mr.getSeries(name, "histogram", help, DefaultBuckets, labels)
+	for i, bound := range DefaultBuckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.value += value
+}
+
+func (mr *MetricsRegistry) getSeries(name, kind, help string, buckets []float64, labels []string) *metricSeries {
+	family, exists := mr.families[name]
+	if !exists {
+		family = &metricFamily{kind, help, buckets, map[string]*metricSeries{}}
+		mr.families[name] = family
+	}
+	key := formatLabels(labels)
+	series, exists := family.series[key]
+	if !exists {
+		series = &metricSeries{labels: key, counts: make([]uint64, len(family.buckets))}
+		family.series[key] = series
+	}
+	return series
+}
+
+// formatLabels returns the labels as `name="value",...`, the values being escaped
+func formatLabels(labels []string) string {
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format, sorted by name and labels
+func (mr *MetricsRegistry) WriteText(w io.Writer) error {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	names := []string{}
+	for name := range mr.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		family := mr.families[name]
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+		keys := []string{}
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := family.series[key]
+			if family.kind != "histogram" {
+				fmt.Fprintf(&sb, "%s%s %s\n", name, withLabels(series.labels), formatFloat(series.value))
+				continue
+			}
+			for i, bound := range family.buckets {
+				fmt.Fprintf(&sb, "%s_bucket%s %d\n", name, withLabels(series.labels, `le="`+formatFloat(bound)+`"`), series.counts[i])
+			}
+			fmt.Fprintf(&sb, "%s_bucket%s %d\n", name, withLabels(series.labels, `le="+Inf"`), series.count)
+			fmt.Fprintf(&sb, "%s_sum%s %s\n", name, withLabels(series.labels), formatFloat(series.value))
+			fmt.Fprintf(&sb, "%s_count%s %d\n", name, withLabels(series.labels), series.count)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func withLabels(labels ...string) string {
+	pairs := []string{}
+	for _, label := range labels {
+		if label != "" {
+			pairs = append(pairs, label)
+		}
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -10,6 +10,8 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"net"
@@ -19,20 +21,61 @@ import (
 	"time"
 
 	mxj "github.com/clbanning/mxj/v2"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 )
 
-var store = sessions.NewCookieStore([]byte("4d70ad3e4165e3a1dc158fdc0fc07dc9ae8c8c4ecbd7f8619debebcba5a3710d"))
+// Default store, signed with a random key until the server session configuration is loaded
+var (
+	store       sessions.Store = sessions.NewCookieStore(securecookie.GenerateRandomKey(64))
+	sessionName                = "session"
+)
+
+// SetSessionStore replaces the store and the cookie name of the sessions
+func SetSessionStore(name string, sessionStore sessions.Store) {
+	sessionName = name
+	store = sessionStore
+}
 
 func GetRequestParams(request *http.Request) url.Values {
 	return request.URL.Query()
 }
 
 func GetSession(w http.ResponseWriter, request *http.Request) *sessions.Session {
-	session, _ := store.Get(request, "session")
+	session, _ := store.Get(request, sessionName)
 	return session
 }
 
+// SessionUser returns the name of the user authenticated by the middlewares, read from the userColumn of the
+// dbAuth and apiKeyDbAuth users, the subject of the jwt claims, the basic auth username or the client certificate
+func SessionUser(w http.ResponseWriter, r *http.Request, userColumn string) string {
+	_, user := SessionIdentity(w, r, userColumn)
+	return user
+}
+
+// SessionIdentity returns the middleware that authenticated the user ("dbAuth", "apiKeyDbAuth", "jwtAuth",
+// "basicAuth" or "clientCertAuth") and the name of the user, as returned by SessionUser, so that the users
+// of different sources having the same name are not confused
+func SessionIdentity(w http.ResponseWriter, r *http.Request, userColumn string) (string, string) {
+	session := GetSession(w, r)
+	if user, ok := session.Values["user"].(map[string]interface{}); ok && user[userColumn] != nil {
+		return "dbAuth", fmt.Sprint(user[userColumn])
+	}
+	if user, ok := session.Values["apiUser"].(map[string]interface{}); ok && user[userColumn] != nil {
+		return "apiKeyDbAuth", fmt.Sprint(user[userColumn])
+	}
+	if claims, ok := session.Values["claims"].(map[string]interface{}); ok && claims["sub"] != nil {
+		return "jwtAuth", fmt.Sprint(claims["sub"])
+	}
+	if username, ok := session.Values["username"].(string); ok {
+		return "basicAuth", username
+	}
+	if identity, ok := session.Values["clientCert"].(map[string]interface{}); ok && identity["username"] != nil {
+		return "clientCertAuth", fmt.Sprint(identity["username"])
+	}
+	return "", ""
+}
+
 //GetBodyData tries to get data from body request, as a urlencoded content type or as json by default
 func GetBodyData(r *http.Request) (interface{}, error) {
 	headerContentType := r.Header.Get("Content-Type")
@@ -80,7 +123,7 @@ func GetBodyData(r *http.Request) (interface{}, error) {
 				}
 			}
 		} else {
-			err = json.Unmarshal(b, &jsonMap)
+			err = DecodeJson(b, &jsonMap)
 			if err != nil {
 				return nil, err
 			}
@@ -90,6 +133,19 @@ func GetBodyData(r *http.Request) (interface{}, error) {
 	}
 }
 
+// DecodeJson unmarshals the json b into v, keeping numbers as json.Number to preserve bigint and decimal precision
+func DecodeJson(b []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
+
 func GetBodyMapData(r *http.Request) (map[string]interface{}, error) {
 	if res, err := GetBodyData(r); err != nil {
 		return nil, err
@@ -183,10 +239,48 @@ func getJoinTables(tableName string, parameters map[string][]string) []string {
 	return keys
 }
 
+//LoadCertPool reads the PEM certificates of a CA file
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificate found in " + caFile)
+	}
+	return pool, nil
+}
+
 //CertSetup generate a self signed certificate if https is on and no certificate is provided
 //To be used for development purposes only
-//From https://gist.github.com/shaneutt/5e1995295cff6721c89a71d13a71c251 https://shaneutt.com/blog/golang-ca-and-signed-cert-go/
 func CertSetup(ipAddress string) (serverTLSConf *tls.Config, clientTLSConf *tls.Config, err error) {
+	certPEM, certPrivKeyPEM, caPEM, err := GenerateSelfSignedCert(ipAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverCert, err := tls.X509KeyPair(certPEM, certPrivKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverTLSConf = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+	}
+
+	certpool := x509.NewCertPool()
+	certpool.AppendCertsFromPEM(caPEM)
+	clientTLSConf = &tls.Config{
+		RootCAs: certpool,
+	}
+
+	return
+}
+
+//GenerateSelfSignedCert returns the PEM encoded certificate and key of the server, signed by a generated CA also returned
+//From https://gist.github.com/shaneutt/5e1995295cff6721c89a71d13a71c251 https://shaneutt.com/blog/golang-ca-and-signed-cert-go/
+func GenerateSelfSignedCert(ipAddress string) (certPEMBytes, certPrivKeyPEMBytes, caPEMBytes []byte, err error) {
 	var ips []net.IP
 	if ipAddress != "" {
 		if ip := net.ParseIP(ipAddress); ip != nil {
@@ -219,13 +313,13 @@ func CertSetup(ipAddress string) (serverTLSConf *tls.Config, clientTLSConf *tls.
 	// create our private and public key
 	caPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	// create the CA
 	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, &caPrivKey.PublicKey, caPrivKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	// pem encode
@@ -235,7 +329,7 @@ func CertSetup(ipAddress string) (serverTLSConf *tls.Config, clientTLSConf *tls.
 		Bytes: caBytes,
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	caPrivKeyPEM := new(bytes.Buffer)
@@ -244,7 +338,7 @@ func CertSetup(ipAddress string) (serverTLSConf *tls.Config, clientTLSConf *tls.
 		Bytes: x509.MarshalPKCS1PrivateKey(caPrivKey),
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	// set up our server certificate
@@ -268,12 +362,12 @@ func CertSetup(ipAddress string) (serverTLSConf *tls.Config, clientTLSConf *tls.
 
 	certPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	certBytes, err := x509.CreateCertificate(rand.Reader, cert, ca, &certPrivKey.PublicKey, caPrivKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	certPEM := new(bytes.Buffer)
@@ -282,7 +376,7 @@ func CertSetup(ipAddress string) (serverTLSConf *tls.Config, clientTLSConf *tls.
 		Bytes: certBytes,
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	certPrivKeyPEM := new(bytes.Buffer)
@@ -291,23 +385,8 @@ func CertSetup(ipAddress string) (serverTLSConf *tls.Config, clientTLSConf *tls.
 		Bytes: x509.MarshalPKCS1PrivateKey(certPrivKey),
 	})
 	if err != nil {
-		return nil, nil, err
-	}
-
-	serverCert, err := tls.X509KeyPair(certPEM.Bytes(), certPrivKeyPEM.Bytes())
-	if err != nil {
-		return nil, nil, err
-	}
-
-	serverTLSConf = &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-	}
-
-	certpool := x509.NewCertPool()
-	certpool.AppendCertsFromPEM(caPEM.Bytes())
-	clientTLSConf = &tls.Config{
-		RootCAs: certpool,
+		return nil, nil, nil, err
 	}
 
-	return
+	return certPEM.Bytes(), certPrivKeyPEM.Bytes(), caPEM.Bytes(), nil
 }
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+var errSessionNotFound = errors.New("session not found")
+
+// SessionBackend persists the server side sessions, Load returns an empty string for unknown or expired sessions
+// and Delete the number of deleted sessions (0 if already deleted)
+type SessionBackend interface {
+	Load(id string) (string, error)
+	Save(id, data string, maxAge int) error
+	Delete(id string) (int64, error)
+}
+
+// ServerSessionStore is a sessions.Store keeping the session values in a SessionBackend,
+// the cookie only holds the signed session id so that a deleted session is invalidated everywhere
+type ServerSessionStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	backend SessionBackend
+}
+
+// NewServerSessionStore returns a server side store, the key pairs being used as in sessions.NewCookieStore
+func NewServerSessionStore(backend SessionBackend, keyPairs ...[]byte) *ServerSessionStore {
+	ss := &ServerSessionStore{
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{Path: "/", MaxAge: 86400 * 30},
+		backend: backend,
+	}
+	// The values are not stored in the cookie : no need to limit their length
+	for _, codec := range ss.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxLength(0)
+		}
+	}
+	ss.MaxAge(ss.Options.MaxAge)
+	return ss
+}
+
+func (ss *ServerSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(ss, name)
+}
+
+func (ss *ServerSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(ss, name)
+	options := *ss.Options
+	session.Options = &options
+	session.IsNew = true
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err = securecookie.DecodeMulti(name, cookie.Value, &session.ID, ss.Codecs...); err == nil {
+		if err = ss.load(session); err == nil {
+			session.IsNew = false
+		}
+	}
+	if session.IsNew {
+		// Unknown, expired or deleted session : a new id will be generated
+		session.ID = ""
+	}
+	return session, err
+}
+
+func (ss *ServerSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if _, err := ss.backend.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, ss.Codecs...)
+	if err != nil {
+		return err
+	}
+	if err := ss.backend.Save(session.ID, data, session.Options.MaxAge); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, ss.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// MaxAge sets the maximum age of the sessions and of the cookies
+func (ss *ServerSessionStore) MaxAge(age int) {
+	ss.Options.MaxAge = age
+	for _, codec := range ss.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+func (ss *ServerSessionStore) load(session *sessions.Session) error {
+	data, err := ss.backend.Load(session.ID)
+	if err != nil {
+		return err
+	}
+	if data == "" {
+		return errSessionNotFound
+	}
+	return securecookie.DecodeMulti(session.Name(), data, &session.Values, ss.Codecs...)
+}
+
+// MemorySessionBackend keeps the sessions in memory, they are lost when the server stops
+type MemorySessionBackend struct {
+	mutex     sync.Mutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+}
+
+type memorySession struct {
+	data    string
+	expires time.Time
+}
+
+func NewMemorySessionBackend() *MemorySessionBackend {
+	return &MemorySessionBackend{sessions: map[string]memorySession{}, lastSweep: time.Now()}
+}
+
+func (msb *MemorySessionBackend) Load(id string) (string, error) {
+	msb.mutex.Lock()
+	defer msb.mutex.Unlock()
+	session, exists := msb.sessions[id]
+	if !exists {
+		return "", nil
+	}
+	if time.Now().After(session.expires) {
+		delete(msb.sessions, id)
+		return "", nil
+	}
+	return session.data, nil
+}
+
+func (msb *MemorySessionBackend) Save(id, data string, maxAge int) error {
+	msb.mutex.Lock()
+	defer msb.mutex.Unlock()
+	now := time.Now()
+	// Expired sessions are removed at most once a minute
+	if now.Sub(msb.lastSweep) > time.Minute {
+		for key, session := range msb.sessions {
+			if now.After(session.expires) {
+				delete(msb.sessions, key)
+			}
+		}
+		msb.lastSweep = now
+	}
+	msb.sessions[id] = memorySession{data, now.Add(time.Duration(maxAge) * time.Second)}
+	return nil
+}
+
+func (msb *MemorySessionBackend) Delete(id string) (int64, error) {
+	msb.mutex.Lock()
+	defer msb.mutex.Unlock()
+	if _, exists := msb.sessions[id]; !exists {
+		return 0, nil
+	}
+	delete(msb.sessions, id)
+	return 1, nil
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewSpanExporter returns the exporter "stdout" (one json document per span) or "otlp" (OTLP/HTTP json
+// to a collector, by default http://localhost:4318/v1/traces), nil for "none"
+func NewSpanExporter(exporter, endpoint, serviceName string) (SpanExporter, error) {
+	switch strings.ToLower(exporter) {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return NewStdoutSpanExporter(os.Stdout, serviceName), nil
+	case "otlp":
+		if endpoint == "" {
+			endpoint = "http://localhost:4318/v1/traces"
+		}
+		return NewOtlpSpanExporter(endpoint, serviceName), nil
+	}
+	return nil, fmt.Errorf("unknown span exporter '%s'", exporter)
+}
+
+// StdoutSpanExporter writes the spans as json lines
+type StdoutSpanExporter struct {
+	mutex       sync.Mutex
+	writer      io.Writer
+	serviceName string
+}
+
+func NewStdoutSpanExporter(writer io.Writer, serviceName string) *StdoutSpanExporter {
+	return &StdoutSpanExporter{writer: writer, serviceName: serviceName}
+}
+
+func (sse *StdoutSpanExporter) Export(spans []*Span) error {
+	sse.mutex.Lock()
+	defer sse.mutex.Unlock()
+	encoder := json.NewEncoder(sse.writer)
+	for _, span := range spans {
+		document := map[string]interface{}{
+			"service":    sse.serviceName,
+			"traceId":    hex.EncodeToString(span.Context.TraceId[:]),
+			"spanId":     hex.EncodeToString(span.Context.SpanId[:]),
+			"name":       span.Name,
+			"kind":       map[int]string{SpanKindInternal: "internal", SpanKindServer: "server", SpanKindClient: "client"}[span.Kind],
+			"start":      span.Start.Format(time.RFC3339Nano),
+			"durationMs": float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			"attributes": span.Attributes,
+		}
+		if span.ParentId != [8]byte{} {
+			document["parentSpanId"] = hex.EncodeToString(span.ParentId[:])
+		}
+		if span.Error != "" {
+			document["error"] = span.Error
+		}
+		if err := encoder.Encode(document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sse *StdoutSpanExporter) Shutdown() error {
+	return nil
+}
+
+// OtlpSpanExporter posts the spans to an OpenTelemetry collector with the OTLP/HTTP json encoding
+type OtlpSpanExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+func NewOtlpSpanExporter(endpoint, serviceName string) *OtlpSpanExporter {
+	return &OtlpSpanExporter{endpoint, serviceName, &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (ose *OtlpSpanExporter) Export(spans []*Span) error {
+	payload, err := json.Marshal(ose.getRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := ose.client.Post(ose.endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector %s returned status %d", ose.endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+func (ose *OtlpSpanExporter) Shutdown() error {
+	ose.client.CloseIdleConnections()
+	return nil
+}
+
+// getRequest returns the ExportTraceServiceRequest of the spans, ids being hex encoded as required by OTLP/json
+func (ose *OtlpSpanExporter) getRequest(spans []*Span) map[string]interface{} {
+	otlpSpans := []map[string]interface{}{}
+	for _, span := range spans {
+		keys := []string{}
+		for key := range span.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		attributes := []map[string]interface{}{}
+		for _, key := range keys {
+			attributes = append(attributes, otlpAttribute(key, span.Attributes[key]))
+		}
+		otlpSpan := map[string]interface{}{
+			"traceId":           hex.EncodeToString(span.Context.TraceId[:]),
+			"spanId":            hex.EncodeToString(span.Context.SpanId[:]),
+			"name":              span.Name,
+			"kind":              span.Kind,
+			"startTimeUnixNano": fmt.Sprint(span.Start.UnixNano()),
+			"endTimeUnixNano":   fmt.Sprint(span.End.UnixNano()),
+			"attributes":        attributes,
+		}
+		if span.Context.TraceState != "" {
+			otlpSpan["traceState"] = span.Context.TraceState
+		}
+		if span.ParentId != [8]byte{} {
+			otlpSpan["parentSpanId"] = hex.EncodeToString(span.ParentId[:])
+		}
+		if span.Error != "" {
+			otlpSpan["status"] = map[string]interface{}{"code": 2, "message": span.Error}
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{otlpAttribute("service.name", ose.serviceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/dranih/go-crud-api"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttribute(key, value string) map[string]interface{} {
+	return map[string]interface{}{"key": key, "value": map[string]interface{}{"stringValue": value}}
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path"
@@ -53,15 +52,16 @@ type Test struct {
 	Driver        string
 	Server        string
 	WantJson      string
+	Certificates  []tls.Certificate
 }
 
 func RunTests(t *testing.T, serverUrlHttps string, tests []Test) {
 	for _, tc := range tests {
 		t.Run(tc.Name, func(t *testing.T) {
-			log.Printf("*************** Running test : %s ***************", tc.Name)
+			Log.Info("running test", "name", tc.Name)
 			//Skip test if requireGeo and sqlite
 			if tc.SkipFor != nil && tc.Driver != "" && tc.SkipFor[tc.Driver] {
-				log.Printf("Skipping test %s for driver %s", tc.Name, tc.Driver)
+				Log.Info("skipping test", "name", tc.Name, "driver", tc.Driver)
 			} else {
 				var url string
 				if tc.Server == "" {
@@ -86,6 +86,7 @@ func RunTests(t *testing.T, serverUrlHttps string, tests []Test) {
 					Transport: &http.Transport{
 						TLSClientConfig: &tls.Config{
 							InsecureSkipVerify: true,
+							Certificates:       tc.Certificates,
 						},
 					},
 				}
@@ -153,7 +154,7 @@ func SelectConfig(ignoreEnvVar bool) string {
 	//We create a sqlite db for the tests
 	tmpFile, err := ioutil.TempFile(os.TempDir(), "gocrudtests-")
 	if err != nil {
-		log.Fatal("Cannot create temporary file", err)
+		Log.Fatal("cannot create temporary file", "error", err)
 	}
 	filePath := tmpFile.Name()
 
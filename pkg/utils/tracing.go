@@ -0,0 +1,284 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, as numbered by OpenTelemetry
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+)
+
+// SpanContext identifies a span within a trace, as carried by the W3C traceparent header
+type SpanContext struct {
+	TraceId    [16]byte
+	SpanId     [8]byte
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid returns false for the zero span context (no trace)
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId != [16]byte{} && sc.SpanId != [8]byte{}
+}
+
+// TraceParent returns the W3C traceparent header value
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceId[:]), hex.EncodeToString(sc.SpanId[:]), flags)
+}
+
+// ParseTraceParent reads a W3C traceparent header value
+func ParseTraceParent(value string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+	traceId, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceId) != 16 {
+		return sc, fmt.Errorf("invalid trace id '%s'", parts[1])
+	}
+	spanId, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanId) != 8 {
+		return sc, fmt.Errorf("invalid parent id '%s'", parts[2])
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, fmt.Errorf("invalid trace flags '%s'", parts[3])
+	}
+	copy(sc.TraceId[:], traceId)
+	copy(sc.SpanId[:], spanId)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a context whose spans are children of the span context
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span, the zero span context if none
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if ctx == nil {
+		return SpanContext{}
+	}
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// ExtractTraceContext returns a context continuing the trace of the traceparent and tracestate headers, if valid
+func ExtractTraceContext(ctx context.Context, header http.Header) context.Context {
+	value := header.Get("traceparent")
+	if value == "" {
+		return ctx
+	}
+	sc, err := ParseTraceParent(value)
+	if err != nil {
+		return ctx
+	}
+	sc.TraceState = header.Get("tracestate")
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers of the current span, to propagate the trace
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	header.Set("traceparent", sc.TraceParent())
+	if sc.TraceState != "" {
+		header.Set("tracestate", sc.TraceState)
+	}
+}
+
+// Span is an operation of a trace, recorded when the tracing is enabled and the trace sampled.
+// The methods of a nil span do nothing, so that the code does not depend on the tracing configuration.
+type Span struct {
+	mutex      sync.Mutex
+	tracer     *Tracer
+	Name       string
+	Kind       int
+	Context    SpanContext
+	ParentId   [8]byte
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      string
+	ended      bool
+}
+
+// SetAttribute adds an attribute to the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed with the error message
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span and sends it to the exporter, only the first call is taken into account
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mutex.Unlock()
+	s.tracer.export(s)
+}
+
+// SpanExporter sends the ended spans to a backend
+type SpanExporter interface {
+	Export(spans []*Span) error
+	Shutdown() error
+}
+
+// Tracer creates the spans and sends them by batches to the exporter
+type Tracer struct {
+	exporter    SpanExporter
+	sampleRatio float64
+	spans       chan *Span
+	done        chan bool
+}
+
+var tracer *Tracer
+
+// NewTracer returns a tracer exporting the spans in background, the new traces being sampled with the ratio (0 to 1)
+func NewTracer(exporter SpanExporter, sampleRatio float64) *Tracer {
+	t := &Tracer{exporter, sampleRatio, make(chan *Span, 2048), make(chan bool)}
+	go t.run(512, 5*time.Second)
+	return t
+}
+
+// SetTracer sets the tracer of the spans, nil disables the tracing
+func SetTracer(t *Tracer) {
+	tracer = t
+}
+
+// ShutdownTracer exports the remaining spans and stops the tracer
+func ShutdownTracer() {
+	if tracer == nil {
+		return
+	}
+	t := tracer
+	tracer = nil
+	close(t.spans)
+	<-t.done
+	if err := t.exporter.Shutdown(); err != nil {
+		Log.Error("unable to shutdown the span exporter", "error", err)
+	}
+}
+
+// StartSpan starts a span, child of the current span of the context, attributes are given as key and value pairs.
+// It returns a nil span when the tracing is disabled or the trace not sampled.
+func StartSpan(ctx context.Context, name string, kind int, attributes ...string) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	t := tracer
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceState: parent.TraceState}
+	if parent.IsValid() {
+		if !parent.Sampled {
+			return ctx, nil
+		}
+		sc.TraceId = parent.TraceId
+		sc.Sampled = true
+	} else {
+		rand.Read(sc.TraceId[:])
+		// the trace id being random, its last 8 bytes are used for the sampling decision
+		sc.Sampled = float64(binary.BigEndian.Uint64(sc.TraceId[8:])>>11)/(1<<53) < t.sampleRatio
+	}
+	rand.Read(sc.SpanId[:])
+	ctx = ContextWithSpanContext(ctx, sc)
+	if !sc.Sampled {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, Name: name, Kind: kind, Context: sc, ParentId: parent.SpanId, Start: time.Now(), Attributes: map[string]string{}}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		span.Attributes[attributes[i]] = attributes[i+1]
+	}
+	return ctx, span
+}
+
+func (t *Tracer) export(span *Span) {
+	defer func() {
+		// the channel is closed on shutdown
+		recover()
+	}()
+	select {
+	case t.spans <- span:
+	default:
+		Log.Warning("span queue full, dropping span", "span", span.Name)
+	}
+}
+
+func (t *Tracer) run(batchSize int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := []*Span{}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			Log.Error("unable to export spans", "count", len(batch), "error", err)
+		}
+		batch = []*Span{}
+	}
+	for {
+		select {
+		case span, ok := <-t.spans:
+			if !ok {
+				flush()
+				t.done <- true
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
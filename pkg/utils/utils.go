@@ -5,7 +5,6 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"strconv"
 )
 
@@ -14,7 +13,7 @@ func GzCompress(input string) (string, error) {
 	gzwriter := gzip.NewWriter(&output)
 	_, err := gzwriter.Write([]byte(input))
 	if err != nil {
-		log.Printf("Error compress : %s", err)
+		Log.Error("unable to compress", "error", err)
 		return "", err
 	}
 	gzwriter.Close()
@@ -25,12 +24,12 @@ func GzUncompress(input string) (string, error) {
 	reader := bytes.NewReader([]byte(input))
 	gzreader, err := gzip.NewReader(reader)
 	if err != nil {
-		log.Printf("Error uncompress : %s", err)
+		Log.Error("unable to uncompress", "error", err)
 		return "", err
 	}
 	output, err := ioutil.ReadAll(gzreader)
 	if err != nil {
-		log.Printf("Error uncompress : %s", err)
+		Log.Error("unable to uncompress", "error", err)
 		return "", err
 	}
 	return string(output), nil
@@ -1,19 +1,59 @@
 package utils
 
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
 type VariableStore struct {
+	mutex  sync.RWMutex
 	values map[string]interface{}
+	parent *VariableStore
 }
 
-var VStore = &VariableStore{values: map[string]interface{}{}}
+var VStore = NewVariableStore(nil)
+
+// NewVariableStore returns an empty store, the values not set in it being read from the parent if any
+func NewVariableStore(parent *VariableStore) *VariableStore {
+	return &VariableStore{values: map[string]interface{}{}, parent: parent}
+}
 
 func (vs *VariableStore) Get(key string) interface{} {
+	vs.mutex.RLock()
 	res, exists := vs.values[key]
+	vs.mutex.RUnlock()
 	if exists {
 		return res
 	}
+	if vs.parent != nil {
+		return vs.parent.Get(key)
+	}
 	return nil
 }
 
 func (vs *VariableStore) Set(key string, value interface{}) {
+	vs.mutex.Lock()
+	defer vs.mutex.Unlock()
 	vs.values[key] = value
 }
+
+type variablesContextKey struct{}
+
+// VariablesFromContext returns the store of the request, nil if none
+func VariablesFromContext(ctx context.Context) *VariableStore {
+	if variables, ok := ctx.Value(variablesContextKey{}).(*VariableStore); ok {
+		return variables
+	}
+	return nil
+}
+
+// RequestVariables returns the store of the request, the values set by the middlewares for this request only
+// (ex : the row conditions of the user), the request holding it being returned for the next handlers
+func RequestVariables(r *http.Request) (*http.Request, *VariableStore) {
+	if variables := VariablesFromContext(r.Context()); variables != nil {
+		return r, variables
+	}
+	variables := NewVariableStore(VStore)
+	return r.WithContext(context.WithValue(r.Context(), variablesContextKey{}, variables)), variables
+}